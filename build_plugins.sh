@@ -31,4 +31,14 @@ for file in "$PLUGIN_SRC_DIR"/*.go; do
     ln -sf "$versioned_filename" "$PLUGIN_BUILD_DIR/$base.so"
 done
 
+# Out-of-process (RPC) plugins live in their own directories and are built
+# as plain executables.
+for dir in "$PLUGIN_SRC_DIR"/*/; do
+    [ -d "$dir" ] || continue
+    base=$(basename "$dir")
+
+    echo "Building RPC plugin: $base"
+    go build -o "$PLUGIN_BUILD_DIR/$base" "./$dir"
+done
+
 echo "All plugins have been built and moved to the '$PLUGIN_BUILD_DIR' folder."
\ No newline at end of file
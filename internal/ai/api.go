@@ -2,10 +2,11 @@ package ai
 
 import (
 	"ircbot/internal/ai/tools"
+	botconfig "ircbot/internal/config"
 )
 
-func Initialize() error {
-	if err := InitializeClient(); err != nil {
+func Initialize(cfg *botconfig.Config) error {
+	if err := InitializeClient(cfg); err != nil {
 		return err
 	}
 	
@@ -31,6 +32,7 @@ func Status() map[string]interface{} {
 	return map[string]interface{}{
 		"initialized":        IsInitialized(),
 		"model":              cfg.Model,
+		"providers":          ProviderNames(),
 		"enableTools":        cfg.EnableToolCalls,
 		"enableSummarization": cfg.EnableSummarization,
 		"availableTools":     toolNames,
@@ -1,19 +1,20 @@
 package ai
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"slices"
 	"strings"
 
-	"github.com/sashabaranov/go-openai"
 	"ircbot/internal/ai/tools"
 	botconfig "ircbot/internal/config"
 	"ircbot/internal/logger"
 )
 
-func processToolCalls(message openai.ChatCompletionMessage, currentUser, currentChannel string) []openai.ChatCompletionMessage {
-	var toolResponses []openai.ChatCompletionMessage
+func processToolCalls(message Message, currentUser, identity, currentChannel string, availableTools []ToolDefinition) []Message {
+	var toolResponses []Message
 	
 	// Get full hostmask from owner settings if this is the owner
 	settings, _ := botconfig.LoadSettings()
@@ -27,6 +28,18 @@ func processToolCalls(message openai.ChatCompletionMessage, currentUser, current
 	for _, toolCall := range message.ToolCalls {
 		logger.AIDebugf("Processing tool call: %s", toolCall.Function.Name)
 		
+		// Models sometimes call tools they weren't offered
+		if !slices.ContainsFunc(availableTools, func(tool ToolDefinition) bool { return tool.Name == toolCall.Function.Name }) {
+			logger.Warnf("AI called tool %s, which isn't enabled", toolCall.Function.Name)
+			toolResponses = append(toolResponses, Message{
+				Role:       RoleTool,
+				Content:    "Error: the tool " + toolCall.Function.Name + " is not available",
+				Name:       toolCall.Function.Name,
+				ToolCallID: toolCall.ID,
+			})
+			continue
+		}
+		
 		// Parse the arguments to a map for potential modification
 		args := toolCall.Function.Arguments
 		var argsMap map[string]interface{}
@@ -99,7 +112,10 @@ func processToolCalls(message openai.ChatCompletionMessage, currentUser, current
 			}
 		}
 		
-		toolResponse, err := tools.GetRegistry().ExecuteTool(
+		// Completions the tool requests count for the user
+		toolCtx := withUsageCaller(context.Background(), currentUser, identity, currentChannel, toolCall.Function.Name)
+		toolResponse, err := tools.GetRegistry().ExecuteToolContext(
+			toolCtx,
 			toolCall.Function.Name,
 			args,
 		)
@@ -112,8 +128,8 @@ func processToolCalls(message openai.ChatCompletionMessage, currentUser, current
 				toolCall.Function.Name, len(toolResponse))
 		}
 		
-		toolResponses = append(toolResponses, openai.ChatCompletionMessage{
-			Role:       openai.ChatMessageRoleTool,
+		toolResponses = append(toolResponses, Message{
+			Role:       RoleTool,
 			Content:    toolResponse,
 			Name:       toolCall.Function.Name,
 			ToolCallID: toolCall.ID,
@@ -123,10 +139,8 @@ func processToolCalls(message openai.ChatCompletionMessage, currentUser, current
 	return toolResponses
 }
 
-func createChatRequest(messages []openai.ChatCompletionMessage, availableTools []openai.Tool) openai.ChatCompletionRequest {
-	cfg := GetConfig()
-	
-	request := openai.ChatCompletionRequest{
+func createChatRequest(cfg *Config, messages []Message, availableTools []ToolDefinition) ChatRequest {
+	request := ChatRequest{
 		Model:       MapModelName(cfg.Model),
 		Messages:    messages,
 		Temperature: cfg.Temperature,
@@ -140,10 +154,33 @@ func createChatRequest(messages []openai.ChatCompletionMessage, availableTools [
 	return request
 }
 
-func createToolFallbackResponse(messages []openai.ChatCompletionMessage) string {
+// createCompletion sends a request, streaming the reply's text to onText
+// when it is set, and counts its tokens for the caller in ctx
+func createCompletion(ctx context.Context, provider LLMProvider, request ChatRequest, onText func(string)) (*ChatResponse, error) {
+	var resp *ChatResponse
+	var err error
+	streamer, canStream := provider.(StreamingProvider)
+	if onText != nil && canStream && GetConfig().EnableStreaming {
+		resp, err = streamer.CreateChatCompletionStream(ctx, request, onText)
+	} else {
+		resp, err = provider.CreateChatCompletion(ctx, request)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	model := request.Model
+	if model == "" {
+		model = provider.Model()
+	}
+	recordUsage(ctx, provider.Name(), model, resp.Usage)
+	return resp, nil
+}
+
+func createToolFallbackResponse(messages []Message) string {
 	toolNames := make([]string, 0)
 	for _, msg := range messages {
-		if msg.Role == openai.ChatMessageRoleTool {
+		if msg.Role == RoleTool {
 			toolNames = append(toolNames, msg.Name)
 		}
 	}
@@ -156,28 +193,47 @@ func createToolFallbackResponse(messages []openai.ChatCompletionMessage) string
 }
 
 func ProcessMessage(message string, channelPersonality string, currentChannel string, user string) (string, error) {
-	if !IsInitialized() {
-		return "AI processing is not available (missing OPENAI_API_KEY)", nil
+	return ProcessMessageWithContext(message, "", channelPersonality, currentChannel, user)
+}
+
+// ProcessMessageWithContext answers a message from a user in a channel.
+// The instructions are added to the system prompt for this message only;
+// the message and the reply are remembered in the user's conversation in
+// the channel and sent along with their later messages.
+func ProcessMessageWithContext(message string, instructions string, channelPersonality string, currentChannel string, user string) (string, error) {
+	return StreamMessage(message, instructions, channelPersonality, currentChannel, user, "", nil)
+}
+
+// StreamMessage is ProcessMessageWithContext calling onText with the text
+// of the replies as it is generated, if streaming is enabled and the
+// provider supports it. The text of every completion is streamed, including
+// any the model writes before calling tools; the returned reply is the
+// final one. The tokens count against identity, see UsageIdentity.
+func StreamMessage(message string, instructions string, channelPersonality string, currentChannel string, user string, identity string, onText func(string)) (string, error) {
+	provider, err := GetProvider(currentChannel)
+	if err != nil {
+		return "AI processing is not available (no AI provider is configured)", nil
 	}
 	
-	cfg := GetConfig()
+	cfg := GetChannelConfig(currentChannel)
 	message = strings.TrimSpace(message)
+	userMessage := Message{Role: RoleUser, Content: message}
 	
 	// Add current channel context to the message
 	if currentChannel != "" {
 		// Always include the channel info, even if previously mentioned
 		// This ensures the model has the current context in every message
 		message = message + "\n\nCURRENT CHANNEL: " + currentChannel + 
-			"\nUSER CONTEXT: This is a direct message to you (Jacey) in the IRC channel"
+			"\nUSER CONTEXT: This is a direct message to you (" + botNick() + ") in the IRC channel"
 	}
 	
-	var availableTools []openai.Tool
+	var availableTools []ToolDefinition
 	if cfg.EnableToolCalls {
-		availableTools = tools.GetRegistry().GetOpenAITools()
+		availableTools = toolDefinitions(cfg.Tools)
 	}
 	
 	// Create system prompt with channel personality if provided
-	systemPrompt := cfg.SystemPrompt
+	systemPrompt := renderSystemPrompt(cfg.SystemPrompt)
 	if channelPersonality != "" {
 		systemPrompt = systemPrompt + "\n\nChannel-specific personality: " + channelPersonality
 	}
@@ -190,23 +246,48 @@ func ProcessMessage(message string, channelPersonality string, currentChannel st
 		}
 	}
 	
-	messages := []openai.ChatCompletionMessage{
-		{Role: openai.ChatMessageRoleSystem, Content: systemPrompt},
-		{Role: openai.ChatMessageRoleUser, Content: message},
+	// Earlier turns of the conversation are only kept per user and channel
+	remember := currentChannel != "" && user != ""
+	var history []Message
+	if remember {
+		var summary string
+		history, summary = conversationMessages(currentChannel, user)
+		if summary != "" {
+			systemPrompt = systemPrompt + "\n\nSummary of your earlier conversation with " + user + ": " + summary
+		}
 	}
 	
+	if instructions != "" {
+		systemPrompt = systemPrompt + "\n\n" + instructions
+	}
+	
+	messages := []Message{{Role: RoleSystem, Content: systemPrompt}}
+	messages = append(messages, history...)
+	messages = append(messages, Message{Role: RoleUser, Content: message})
+	// The new turns start after the user message
+	firstNew := len(messages)
+	
+	// Record the exchange once it has a reply
+	defer func() {
+		if remember && len(messages) > firstNew {
+			turns := append([]Message{userMessage}, messages[firstNew:]...)
+			rememberTurns(currentChannel, user, identity, turns)
+		}
+	}()
+	
 	// Initial API call
 	ctx, cancel := CreateContext()
 	defer cancel()
+	ctx = withUsageCaller(ctx, user, identity, currentChannel, "")
 	
-	request := createChatRequest(messages, availableTools)
-	resp, err := GetClient().CreateChatCompletion(ctx, request)
+	request := createChatRequest(cfg, messages, availableTools)
+	resp, err := createCompletion(ctx, provider, request, onText)
 	if err != nil {
-		logger.Errorf("OpenAI API error: %v", err)
+		logger.Errorf("AI provider %s error: %v", provider.Name(), err)
 		return "Sorry, I encountered an error processing your request.", err
 	}
 	
-	aiMessage := resp.Choices[0].Message
+	aiMessage := resp.Message
 	messages = append(messages, aiMessage)
 	
 	// If no tool calls, return the response
@@ -215,24 +296,25 @@ func ProcessMessage(message string, channelPersonality string, currentChannel st
 	}
 	
 	// Process initial tool calls
-	toolResponses := processToolCalls(aiMessage, user, currentChannel)
+	toolResponses := processToolCalls(aiMessage, user, identity, currentChannel, availableTools)
 	messages = append(messages, toolResponses...)
 	
 	// Handle multiple iterations of tool calls
 	const maxIterations = 3
 	for iteration := 0; iteration < maxIterations; iteration++ {
 		ctx, cancel := CreateContext()
+		ctx = withUsageCaller(ctx, user, identity, currentChannel, "")
 		
-		request := createChatRequest(messages, availableTools)
-		resp, err := GetClient().CreateChatCompletion(ctx, request)
+		request := createChatRequest(cfg, messages, availableTools)
+		resp, err := createCompletion(ctx, provider, request, onText)
 		cancel()
 		
 		if err != nil {
-			logger.Errorf("OpenAI API error (iteration %d): %v", iteration, err)
+			logger.Errorf("AI provider %s error (iteration %d): %v", provider.Name(), iteration, err)
 			return "Sorry, I encountered an error processing the tool response.", err
 		}
 		
-		aiMessage := resp.Choices[0].Message
+		aiMessage := resp.Message
 		messages = append(messages, aiMessage)
 		
 		// If more tool calls, process them and continue
@@ -240,7 +322,7 @@ func ProcessMessage(message string, channelPersonality string, currentChannel st
 			logger.Infof("Found %d additional tool calls in iteration %d", 
 				len(aiMessage.ToolCalls), iteration)
 			
-			toolResponses := processToolCalls(aiMessage, user, currentChannel)
+			toolResponses := processToolCalls(aiMessage, user, identity, currentChannel, availableTools)
 			messages = append(messages, toolResponses...)
 			continue
 		}
@@ -2,52 +2,38 @@ package ai
 
 import (
 	"context"
-	"errors"
-	"os"
-	"sync"
 	"time"
 
 	"github.com/sashabaranov/go-openai"
+	"ircbot/internal/ai/tools"
+	botconfig "ircbot/internal/config"
 	"ircbot/internal/logger"
 )
 
-var (
-	apiKey      string
-	client      *openai.Client
-	initialized bool
-	clientMutex sync.Mutex
-
-	ErrMissingAPIKey = errors.New("OpenAI API key not found")
-)
-
 var modelMap = map[string]string{
 	"gpt-4o":  openai.GPT4o,
 	"gpt-4.5": "gpt-4.5-preview",
 }
 
-func InitializeClient() error {
-	clientMutex.Lock()
-	defer clientMutex.Unlock()
-
-	apiKey = os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		logger.Warnf("AI client initialized without API key. AI features will be limited.")
-		initialized = true
-		return ErrMissingAPIKey
+// InitializeClient sets up the AI settings and providers from the bot
+// config and lets the tools use them. The bot runs without AI features if none is usable.
+func InitializeClient(cfg *botconfig.Config) error {
+	ConfigureSettings(cfg)
+	err := ConfigureProviders(cfg)
+	if err != nil {
+		logger.Warnf("AI client initialized without a provider. AI features will be limited: %v", err)
 	}
-
-	client = openai.NewClient(apiKey)
-	logger.Successf("OpenAI client initialized with API key")
-	initialized = true
-	return nil
+	tools.Complete = Complete
+	tools.RecordUsage = func(ctx context.Context, model string, promptTokens, completionTokens int) {
+		recordUsage(ctx, ProviderOpenAI, model, Usage{PromptTokens: promptTokens, CompletionTokens: completionTokens})
+	}
+	return err
 }
 
+// IsInitialized reports whether the default provider is available
 func IsInitialized() bool {
-	return initialized && apiKey != ""
-}
-
-func GetClient() *openai.Client {
-	return client
+	_, err := GetProvider("")
+	return err == nil
 }
 
 func CreateContext() (context.Context, context.CancelFunc) {
@@ -1,12 +1,14 @@
 package ai
 
 import (
-	"fmt"
+	"strings"
 	"sync"
 	"time"
 )
 
 type Config struct {
+	// Model is the model requested from the provider; empty uses the
+	// provider's model
 	Model             string
 	MaxResponseTokens int
 	Temperature       float32
@@ -15,9 +17,16 @@ type Config struct {
 
 	EnableSummarization bool
 	EnableToolCalls     bool
+	EnableStreaming     bool
+	// Tools are the tools the AI may use; empty allows all of them
+	Tools []string
 }
 
-const defaultSystemPromptTemplate = `You are Lolo, a knowledgeable, witty and edgy, and laid-back IRC regular.
+// defaultSystemPromptTemplate is the system prompt; {nick}, {date} and
+// {time} are filled in when a message is sent
+const defaultSystemPromptTemplate = `You are {nick}, a knowledgeable, witty and edgy, and laid-back IRC regular.
+
+The current date is {date} and the time is {time}.
 
 Personality:
 - Speak naturally, casually, with occasional IRC shorthand (np, btw, imo).
@@ -33,7 +42,7 @@ Critical Conversation Rules:
 
 Channel Context:
 - Be aware of the current channel's topic and users.
-- Respond directly when addressed as "Lolo."
+- Respond directly when addressed as "{nick}."
 - Track recent conversation closely for context (especially pronouns and user references).
 - User notes will be automatically included in your context for personalization.
 - Notes can contain user preferences, instructions, or information you should remember.
@@ -72,28 +81,33 @@ User Notes System:
 - You can access and search a user's notes with the list_notes and search_notes tools.
 - Notes help personalize your responses to individual users, so use them effectively.
 
-Remember: You're Lolo, an IRC user engaging naturally while proactively using tools.`
+Remember: You're {nick}, an IRC user engaging naturally while proactively using tools.`
 
 var (
 	config     *Config
 	configOnce sync.Once
 )
 
-func getFormattedSystemPrompt() string {
-	date := time.Now().Format("2006-01-02")
-	timeNow := time.Now().Format("15:04:05")
-	return fmt.Sprintf(defaultSystemPromptTemplate, date, timeNow)
+// renderSystemPrompt fills in the bot's nick and the current date and time
+func renderSystemPrompt(template string) string {
+	now := time.Now()
+	return strings.NewReplacer(
+		"{nick}", botNick(),
+		"{date}", now.Format("2006-01-02"),
+		"{time}", now.Format("15:04:05"),
+	).Replace(template)
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		Model:               "gpt-4.5",
+		Model:               "",
 		MaxResponseTokens:   8000,
 		Temperature:         0.7,
-		SystemPrompt:        getFormattedSystemPrompt(),
+		SystemPrompt:        defaultSystemPromptTemplate,
 		DefaultAPITimeout:   120,
 		EnableSummarization: true,
 		EnableToolCalls:     true,
+		EnableStreaming:     true,
 	}
 }
 
@@ -105,6 +119,8 @@ func GetConfig() *Config {
 }
 
 func SetConfig(newConfig *Config) {
+	// Keep GetConfig from replacing it with the defaults
+	configOnce.Do(func() {})
 	config = newConfig
 }
 
@@ -113,8 +129,9 @@ func UpdateConfig(updater func(*Config)) {
 	updater(cfg)
 }
 
+// RefreshSystemPrompt goes back to the default system prompt
 func RefreshSystemPrompt() {
 	UpdateConfig(func(cfg *Config) {
-		cfg.SystemPrompt = getFormattedSystemPrompt()
+		cfg.SystemPrompt = defaultSystemPromptTemplate
 	})
 }
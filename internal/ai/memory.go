@@ -0,0 +1,304 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"ircbot/internal/logger"
+)
+
+var (
+	// MemoryPath is where the conversations are kept across restarts
+	MemoryPath = "./data/ai_memory.json"
+	// MemoryTokenBudget is roughly how many tokens of earlier turns are sent
+	// with a message. Older turns are folded into a summary.
+	MemoryTokenBudget = 3000
+	// MemoryMaxAge is how long an idle conversation is remembered
+	MemoryMaxAge = 7 * 24 * time.Hour
+)
+
+const (
+	// maxToolResultChars bounds a tool result kept in memory; pages and
+	// logs fetched by tools are rarely needed in full later
+	maxToolResultChars = 2000
+	// maxSummaryChars bounds the summary of older turns
+	maxSummaryChars = 1500
+)
+
+// Conversation is what the AI remembers of its exchanges with one nick in
+// one channel
+type Conversation struct {
+	Channel string    `json:"channel"`
+	Nick    string    `json:"nick"`
+	Summary string    `json:"summary,omitempty"`
+	Turns   []Message `json:"turns"`
+	Updated time.Time `json:"updated"`
+}
+
+// Tokens estimates the tokens the conversation adds to a request
+func (conv *Conversation) Tokens() int {
+	total := estimateTokens(conv.Summary)
+	for _, turn := range conv.Turns {
+		total += turnTokens(turn)
+	}
+	return total
+}
+
+var (
+	memoryMu     sync.Mutex
+	memory       map[string]*Conversation
+	memoryLoaded bool
+	compacting   = make(map[string]bool)
+)
+
+// memoryKey identifies the conversation of a nick in a channel
+func memoryKey(channel, nick string) string {
+	return strings.ToLower(channel) + " " + strings.ToLower(nick)
+}
+
+// estimateTokens guesses the tokens of a text at about four characters each
+func estimateTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	return len(text)/4 + 1
+}
+
+// turnTokens estimates the tokens of a message including its tool calls
+func turnTokens(msg Message) int {
+	total := estimateTokens(msg.Content) + 4
+	for _, call := range msg.ToolCalls {
+		total += estimateTokens(call.Function.Name) + estimateTokens(call.Function.Arguments)
+	}
+	return total
+}
+
+// loadMemory reads the conversations once. Must be called with memoryMu held.
+func loadMemory() {
+	if memoryLoaded {
+		return
+	}
+	memoryLoaded = true
+	memory = make(map[string]*Conversation)
+
+	data, err := os.ReadFile(MemoryPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warnf("Error reading AI memory: %v", err)
+		}
+		return
+	}
+	var conversations []*Conversation
+	if err := json.Unmarshal(data, &conversations); err != nil {
+		logger.Warnf("Invalid AI memory file %s: %v", MemoryPath, err)
+		return
+	}
+	for _, conv := range conversations {
+		if time.Since(conv.Updated) < MemoryMaxAge {
+			memory[memoryKey(conv.Channel, conv.Nick)] = conv
+		}
+	}
+}
+
+// saveMemory writes the conversations. Must be called with memoryMu held.
+func saveMemory() {
+	conversations := make([]*Conversation, 0, len(memory))
+	for _, conv := range memory {
+		conversations = append(conversations, conv)
+	}
+	data, err := json.MarshalIndent(conversations, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(MemoryPath), 0755)
+	}
+	if err == nil {
+		tmp := MemoryPath + ".tmp"
+		if err = os.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, MemoryPath)
+		}
+	}
+	if err != nil {
+		logger.Errorf("Error saving AI memory: %v", err)
+	}
+}
+
+// GetConversation returns a copy of the conversation of a nick in a channel
+func GetConversation(channel, nick string) (Conversation, bool) {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+	loadMemory()
+
+	conv, ok := memory[memoryKey(channel, nick)]
+	if !ok || time.Since(conv.Updated) >= MemoryMaxAge {
+		return Conversation{}, false
+	}
+	copied := *conv
+	copied.Turns = append([]Message(nil), conv.Turns...)
+	return copied, true
+}
+
+// ForgetConversation clears what the AI remembers of a nick in a channel,
+// or of everyone in the channel if nick is empty. Returns how many
+// conversations were cleared.
+func ForgetConversation(channel, nick string) int {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+	loadMemory()
+
+	forgotten := 0
+	for key, conv := range memory {
+		if strings.EqualFold(conv.Channel, channel) && (nick == "" || strings.EqualFold(conv.Nick, nick)) {
+			delete(memory, key)
+			forgotten++
+		}
+	}
+	if forgotten > 0 {
+		saveMemory()
+	}
+	return forgotten
+}
+
+// rememberTurns adds the turns of an exchange to a conversation and folds
+// older turns into the summary once it exceeds the token budget. The
+// summary's tokens count against identity.
+func rememberTurns(channel, nick, identity string, turns []Message) {
+	memoryMu.Lock()
+	loadMemory()
+	key := memoryKey(channel, nick)
+	conv, ok := memory[key]
+	if !ok || time.Since(conv.Updated) >= MemoryMaxAge {
+		conv = &Conversation{Channel: channel, Nick: nick}
+		memory[key] = conv
+	}
+	for _, turn := range turns {
+		if turn.Role == RoleTool && len(turn.Content) > maxToolResultChars {
+			turn.Content = turn.Content[:maxToolResultChars] + "..."
+		}
+		conv.Turns = append(conv.Turns, turn)
+	}
+	conv.Updated = time.Now()
+	saveMemory()
+
+	overBudget := conv.Tokens() > MemoryTokenBudget && !compacting[key]
+	if overBudget {
+		compacting[key] = true
+	}
+	memoryMu.Unlock()
+
+	if overBudget {
+		// Summarizing takes a request of its own, so it doesn't hold up the reply
+		go compactConversation(key, identity)
+	}
+}
+
+// compactConversation summarizes the oldest turns of a conversation until
+// the rest fits in half the token budget. Turns are only split before a
+// user message, so tool results stay with the call that asked for them.
+func compactConversation(key, identity string) {
+	defer func() {
+		memoryMu.Lock()
+		delete(compacting, key)
+		memoryMu.Unlock()
+	}()
+
+	memoryMu.Lock()
+	conv, ok := memory[key]
+	if !ok {
+		memoryMu.Unlock()
+		return
+	}
+	keepFrom := len(conv.Turns)
+	kept := 0
+	for i := len(conv.Turns) - 1; i > 0; i-- {
+		kept += turnTokens(conv.Turns[i])
+		if conv.Turns[i].Role == RoleUser {
+			if kept > MemoryTokenBudget/2 && keepFrom < len(conv.Turns) {
+				break
+			}
+			keepFrom = i
+		}
+	}
+	if keepFrom == 0 || keepFrom == len(conv.Turns) {
+		memoryMu.Unlock()
+		return
+	}
+	older := conv.Turns[:keepFrom]
+	previous := conv.Summary
+	memoryMu.Unlock()
+
+	var transcript strings.Builder
+	if previous != "" {
+		transcript.WriteString("Earlier: " + previous + "\n")
+	}
+	for _, turn := range older {
+		switch {
+		case turn.Role == RoleTool:
+			fmt.Fprintf(&transcript, "Tool %s returned: %s\n", turn.Name, turn.Content)
+		case len(turn.ToolCalls) > 0:
+			for _, call := range turn.ToolCalls {
+				fmt.Fprintf(&transcript, "Assistant called %s with %s\n", call.Function.Name, call.Function.Arguments)
+			}
+			if turn.Content != "" {
+				fmt.Fprintf(&transcript, "Assistant: %s\n", turn.Content)
+			}
+		case turn.Role == RoleAssistant:
+			fmt.Fprintf(&transcript, "Assistant: %s\n", turn.Content)
+		default:
+			fmt.Fprintf(&transcript, "User: %s\n", turn.Content)
+		}
+	}
+
+	// Without a summary the turns are kept; conversationMessages still
+	// leaves the oldest of them out of requests. With summarization turned
+	// off generateSummary would only return a stub of the transcript.
+	if !IsInitialized() || !GetConfig().EnableSummarization {
+		return
+	}
+	ctx := withUsageCaller(context.Background(), conv.Nick, identity, conv.Channel, "memory")
+	summary, err := generateSummary(ctx, transcript.String(), maxSummaryChars)
+	if err != nil {
+		logger.Warnf("Error summarizing AI memory: %v", err)
+		return
+	}
+
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+	// The conversation may have been forgotten or grown meanwhile; only
+	// the turns that were summarized are dropped
+	if current, ok := memory[key]; ok && current == conv && len(conv.Turns) >= keepFrom {
+		conv.Summary = summary
+		conv.Turns = append([]Message(nil), conv.Turns[keepFrom:]...)
+		saveMemory()
+		logger.AIDebugf("Summarized %d turns of the conversation with %s in %s", keepFrom, conv.Nick, conv.Channel)
+	}
+}
+
+// conversationMessages returns the remembered turns to send before a new
+// message, and the summary of older ones
+func conversationMessages(channel, nick string) ([]Message, string) {
+	conv, ok := GetConversation(channel, nick)
+	if !ok {
+		return nil, ""
+	}
+
+	// A compaction may still be running, so the budget is enforced here
+	// too, again only before user messages. The newest exchange is always
+	// kept.
+	start := len(conv.Turns)
+	total := 0
+	for i := len(conv.Turns) - 1; i >= 0; i-- {
+		total += turnTokens(conv.Turns[i])
+		if conv.Turns[i].Role == RoleUser {
+			if total > MemoryTokenBudget && start < len(conv.Turns) {
+				break
+			}
+			start = i
+		}
+	}
+	return conv.Turns[start:], conv.Summary
+}
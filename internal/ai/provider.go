@@ -0,0 +1,315 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"ircbot/internal/ai/tools"
+	botconfig "ircbot/internal/config"
+	"ircbot/internal/logger"
+)
+
+// maxResponseSize bounds the body read from a provider
+const maxResponseSize = 16 << 20
+
+// Provider types for the ai_providers config
+const (
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
+	ProviderOllama    = "ollama"
+)
+
+// LLMProvider is a chat completion backend. Every provider supports tool
+// calls: the tools in the request may be called in the response message,
+// and their results are sent back as RoleTool messages.
+type LLMProvider interface {
+	// Name is the provider's name in the config
+	Name() string
+	// Model is the model used when a request doesn't name one
+	Model() string
+	CreateChatCompletion(ctx context.Context, req ChatRequest) (*ChatResponse, error)
+}
+
+// StreamingProvider is a provider that can send a reply while it is being
+// generated
+type StreamingProvider interface {
+	LLMProvider
+	// CreateChatCompletionStream is CreateChatCompletion calling onText with
+	// each piece of the reply's text as it arrives. The response is the
+	// whole reply, tool calls included.
+	CreateChatCompletionStream(ctx context.Context, req ChatRequest, onText func(string)) (*ChatResponse, error)
+}
+
+// ChatRequest is a chat completion request. An empty Model uses the
+// provider's model.
+type ChatRequest struct {
+	Model       string
+	Messages    []Message
+	Tools       []ToolDefinition
+	Temperature float32
+	MaxTokens   int
+}
+
+// ToolDefinition describes a tool the model may call; Parameters is a
+// JSON schema
+type ToolDefinition struct {
+	Name        string
+	Description string
+	Parameters  any
+}
+
+// ChatResponse is the model's reply and what it cost
+type ChatResponse struct {
+	Message Message
+	Usage   Usage
+}
+
+// Usage counts the tokens of a request
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+var (
+	providers       map[string]LLMProvider
+	defaultProvider string
+	botConfig       *botconfig.Config
+	providersMu     sync.RWMutex
+
+	ErrNoProvider = errors.New("no AI provider is configured")
+)
+
+// newProvider creates the driver for a configured provider. The API key
+// is read from the environment variable named by api_key_env, which
+// defaults to OPENAI_API_KEY for OpenAI itself and ANTHROPIC_API_KEY.
+func newProvider(name string, pc botconfig.AIProviderConfig) (LLMProvider, error) {
+	kind := strings.ToLower(pc.Type)
+	if kind == "" {
+		kind = ProviderOpenAI
+	}
+
+	keyEnv := pc.APIKeyEnv
+	if keyEnv == "" {
+		switch {
+		case kind == ProviderOpenAI && pc.BaseURL == "":
+			keyEnv = "OPENAI_API_KEY"
+		case kind == ProviderAnthropic:
+			keyEnv = "ANTHROPIC_API_KEY"
+		}
+	}
+	apiKey := ""
+	if keyEnv != "" {
+		if apiKey = os.Getenv(keyEnv); apiKey == "" {
+			return nil, fmt.Errorf("%s is not set", keyEnv)
+		}
+	}
+
+	switch kind {
+	case ProviderOpenAI:
+		return newOpenAIProvider(name, apiKey, pc.BaseURL, pc.Model), nil
+	case ProviderAnthropic:
+		return newAnthropicProvider(name, apiKey, pc.BaseURL, pc.Model), nil
+	case ProviderOllama:
+		return newOllamaProvider(name, apiKey, pc.BaseURL, pc.Model), nil
+	}
+	return nil, fmt.Errorf("unknown provider type %q", pc.Type)
+}
+
+// ConfigureProviders sets up the providers in the bot config. Without any,
+// OpenAI is used with the OPENAI_API_KEY from the environment.
+func ConfigureProviders(cfg *botconfig.Config) error {
+	configured := make(map[string]LLMProvider)
+	defaultName := cfg.AIProvider
+
+	if len(cfg.AIProviders) == 0 {
+		defaultName = ProviderOpenAI
+		if key := os.Getenv("OPENAI_API_KEY"); key != "" {
+			configured[ProviderOpenAI] = newOpenAIProvider(ProviderOpenAI, key, os.Getenv("OPENAI_BASE_URL"), "")
+		}
+	}
+	for name, pc := range cfg.AIProviders {
+		p, err := newProvider(name, pc)
+		if err != nil {
+			logger.Warnf("AI provider %s is unavailable: %v", name, err)
+			continue
+		}
+		configured[name] = p
+	}
+	if defaultName == "" && len(configured) == 1 {
+		for name := range configured {
+			defaultName = name
+		}
+	}
+
+	providersMu.Lock()
+	providers = configured
+	defaultProvider = defaultName
+	botConfig = cfg
+	providersMu.Unlock()
+
+	if _, ok := configured[defaultName]; !ok {
+		return fmt.Errorf("%w (default provider %q is unavailable)", ErrNoProvider, defaultName)
+	}
+	logger.Successf("AI providers initialized: %s (default %s)", strings.Join(ProviderNames(), ", "), defaultName)
+	return nil
+}
+
+// ProviderNames returns the names of the available providers
+func ProviderNames() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetProvider returns the provider for a channel: the one named by its
+// "ai_provider" setting, or the default provider
+func GetProvider(channel string) (LLMProvider, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	name := defaultProvider
+	if channel != "" && botConfig != nil {
+		if setting, ok := botconfig.GetChannelSetting(botConfig, channel, "ai_provider", "").(string); ok && setting != "" {
+			if _, exists := providers[setting]; exists {
+				name = setting
+			} else {
+				logger.Warnf("AI provider %s of channel %s is unavailable, using %s", setting, channel, name)
+			}
+		}
+	}
+
+	p, ok := providers[name]
+	if !ok {
+		return nil, ErrNoProvider
+	}
+	return p, nil
+}
+
+// toolDefinitions describes the registered tools for a request, only the
+// enabled ones if any are named
+func toolDefinitions(enabled []string) []ToolDefinition {
+	var definitions []ToolDefinition
+	for _, tool := range tools.GetRegistry().GetAllTools() {
+		if len(enabled) > 0 && !slices.ContainsFunc(enabled, func(name string) bool {
+			return strings.EqualFold(name, tool.Name())
+		}) {
+			continue
+		}
+		definitions = append(definitions, ToolDefinition{
+			Name:        tool.Name(),
+			Description: tool.Description(),
+			Parameters:  tool.Parameters(),
+		})
+	}
+	sort.Slice(definitions, func(i, j int) bool {
+		return definitions[i].Name < definitions[j].Name
+	})
+	return definitions
+}
+
+// Complete sends a single prompt with a system message to the default
+// provider and returns the reply. Its tokens count for the caller in ctx.
+func Complete(ctx context.Context, system, prompt string, temperature float32, maxTokens int) (string, error) {
+	p, err := GetProvider("")
+	if err != nil {
+		return "", err
+	}
+	resp, err := createCompletion(ctx, p, ChatRequest{
+		Model: MapModelName(GetConfig().Model),
+		Messages: []Message{
+			{Role: RoleSystem, Content: system},
+			{Role: RoleUser, Content: prompt},
+		},
+		Temperature: temperature,
+		MaxTokens:   maxTokens,
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	return resp.Message.Content, nil
+}
+
+// postJSON posts a JSON request and decodes the JSON response. A response
+// with an error status is returned as an error with errorMessage's reading
+// of the body.
+func postJSON(ctx context.Context, url string, headers map[string]string, request, response any, errorMessage func([]byte) string) error {
+	resp, err := sendJSON(ctx, url, headers, request, errorMessage)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, response); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
+
+// postStream posts a JSON request like postJSON and calls onLine with each
+// line of the streamed response until it ends or onLine fails
+func postStream(ctx context.Context, url string, headers map[string]string, request any, errorMessage func([]byte) string, onLine func([]byte) error) error {
+	resp, err := sendJSON(ctx, url, headers, request, errorMessage)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxResponseSize)
+	for scanner.Scan() {
+		if err := onLine(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// sendJSON posts a JSON request and returns the response if it has a
+// success status
+func sendJSON(ctx context.Context, url string, headers map[string]string, request any, errorMessage func([]byte) string) (*http.Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+		if msg := errorMessage(data); msg != "" {
+			return nil, fmt.Errorf("%s: %s", resp.Status, msg)
+		}
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return resp, nil
+}
@@ -0,0 +1,298 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	anthropicBaseURL = "https://api.anthropic.com"
+	anthropicVersion = "2023-06-01"
+	// anthropicMaxTokens is used when a request sets no limit, as the
+	// messages API requires one
+	anthropicMaxTokens = 4096
+)
+
+// anthropicProvider talks to an Anthropic-style messages API
+type anthropicProvider struct {
+	name    string
+	model   string
+	apiKey  string
+	baseURL string
+}
+
+func newAnthropicProvider(name, apiKey, baseURL, model string) *anthropicProvider {
+	if baseURL == "" {
+		baseURL = anthropicBaseURL
+	}
+	return &anthropicProvider{
+		name:    name,
+		model:   model,
+		apiKey:  apiKey,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+func (p *anthropicProvider) Name() string {
+	return p.name
+}
+
+func (p *anthropicProvider) Model() string {
+	return p.model
+}
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	Tools       []anthropicTool    `json:"tools,omitempty"`
+	Temperature float32            `json:"temperature"`
+	MaxTokens   int                `json:"max_tokens"`
+	Stream      bool               `json:"stream,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+// anthropicBlock is a text, tool_use or tool_result content block
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	InputSchema any    `json:"input_schema"`
+}
+
+type anthropicResponse struct {
+	Content []anthropicBlock `json:"content"`
+	Usage   struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+type anthropicError struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// messagesRequest converts a request to the API's form
+func (p *anthropicProvider) messagesRequest(req ChatRequest) (anthropicRequest, error) {
+	request := anthropicRequest{
+		Model:       req.Model,
+		Temperature: min(req.Temperature, 1),
+		MaxTokens:   req.MaxTokens,
+	}
+	if request.Model == "" {
+		request.Model = p.model
+	}
+	if request.Model == "" {
+		return request, errors.New("no model is configured")
+	}
+	if request.MaxTokens <= 0 {
+		request.MaxTokens = anthropicMaxTokens
+	}
+
+	var system []string
+	for _, msg := range req.Messages {
+		var role string
+		var blocks []anthropicBlock
+		switch msg.Role {
+		case RoleSystem:
+			system = append(system, msg.Content)
+			continue
+		case RoleTool:
+			// Tool results go back in a user message
+			role = "user"
+			blocks = append(blocks, anthropicBlock{Type: "tool_result", ToolUseID: msg.ToolCallID, Content: msg.Content})
+		case RoleAssistant:
+			role = "assistant"
+			if msg.Content != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				input := json.RawMessage(call.Function.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: input})
+			}
+		default:
+			role = "user"
+			blocks = append(blocks, anthropicBlock{Type: "text", Text: msg.Content})
+		}
+
+		// The API wants user and assistant turns to alternate, so
+		// consecutive messages of one role are merged
+		if n := len(request.Messages); n > 0 && request.Messages[n-1].Role == role {
+			request.Messages[n-1].Content = append(request.Messages[n-1].Content, blocks...)
+		} else if len(blocks) > 0 {
+			request.Messages = append(request.Messages, anthropicMessage{Role: role, Content: blocks})
+		}
+	}
+	request.System = strings.Join(system, "\n\n")
+
+	for _, tool := range req.Tools {
+		request.Tools = append(request.Tools, anthropicTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: tool.Parameters,
+		})
+	}
+	return request, nil
+}
+
+func (p *anthropicProvider) headers() map[string]string {
+	return map[string]string{
+		"x-api-key":         p.apiKey,
+		"anthropic-version": anthropicVersion,
+	}
+}
+
+func anthropicErrorMessage(body []byte) string {
+	var e anthropicError
+	if json.Unmarshal(body, &e) == nil {
+		return e.Error.Message
+	}
+	return ""
+}
+
+// anthropicMessageOf joins the content blocks of a reply into a message
+func anthropicMessageOf(content []anthropicBlock) Message {
+	message := Message{Role: RoleAssistant}
+	var text []string
+	for _, block := range content {
+		switch block.Type {
+		case "text":
+			text = append(text, block.Text)
+		case "tool_use":
+			message.ToolCalls = append(message.ToolCalls, ToolCall{
+				ID:       block.ID,
+				Function: FunctionCall{Name: block.Name, Arguments: string(block.Input)},
+			})
+		}
+	}
+	message.Content = strings.Join(text, "")
+	return message
+}
+
+func (p *anthropicProvider) CreateChatCompletion(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	request, err := p.messagesRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp anthropicResponse
+	if err := postJSON(ctx, p.baseURL+"/v1/messages", p.headers(), request, &resp, anthropicErrorMessage); err != nil {
+		return nil, err
+	}
+	return &ChatResponse{
+		Message: anthropicMessageOf(resp.Content),
+		Usage: Usage{
+			PromptTokens:     resp.Usage.InputTokens,
+			CompletionTokens: resp.Usage.OutputTokens,
+		},
+	}, nil
+}
+
+// anthropicEvent is an event of a streamed reply
+type anthropicEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message struct {
+		Usage struct {
+			InputTokens int `json:"input_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+	ContentBlock anthropicBlock `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+	} `json:"delta"`
+	Usage struct {
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *anthropicProvider) CreateChatCompletionStream(ctx context.Context, req ChatRequest, onText func(string)) (*ChatResponse, error) {
+	request, err := p.messagesRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	request.Stream = true
+
+	var blocks []anthropicBlock
+	// The input of a tool call arrives as pieces of its JSON
+	var inputs []string
+	var usage Usage
+	err = postStream(ctx, p.baseURL+"/v1/messages", p.headers(), request, anthropicErrorMessage, func(line []byte) error {
+		data, ok := bytes.CutPrefix(line, []byte("data:"))
+		if !ok {
+			return nil
+		}
+		var event anthropicEvent
+		if err := json.Unmarshal(bytes.TrimSpace(data), &event); err != nil {
+			return fmt.Errorf("invalid event: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			usage.PromptTokens = event.Message.Usage.InputTokens
+		case "content_block_start":
+			for len(blocks) <= event.Index {
+				blocks = append(blocks, anthropicBlock{})
+				inputs = append(inputs, "")
+			}
+			blocks[event.Index] = event.ContentBlock
+		case "content_block_delta":
+			if event.Index >= len(blocks) {
+				return nil
+			}
+			switch event.Delta.Type {
+			case "text_delta":
+				blocks[event.Index].Text += event.Delta.Text
+				onText(event.Delta.Text)
+			case "input_json_delta":
+				inputs[event.Index] += event.Delta.PartialJSON
+			}
+		case "message_delta":
+			usage.CompletionTokens = event.Usage.OutputTokens
+		case "error":
+			return errors.New(event.Error.Message)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range blocks {
+		if blocks[i].Type == "tool_use" && inputs[i] != "" {
+			blocks[i].Input = json.RawMessage(inputs[i])
+		}
+	}
+	return &ChatResponse{
+		Message: anthropicMessageOf(blocks),
+		Usage:   usage,
+	}, nil
+}
@@ -0,0 +1,223 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const ollamaBaseURL = "http://localhost:11434"
+
+// ollamaProvider talks to the chat API of a local Ollama server
+type ollamaProvider struct {
+	name    string
+	model   string
+	apiKey  string
+	baseURL string
+}
+
+func newOllamaProvider(name, apiKey, baseURL, model string) *ollamaProvider {
+	if baseURL == "" {
+		baseURL = ollamaBaseURL
+	}
+	return &ollamaProvider{
+		name:    name,
+		model:   model,
+		apiKey:  apiKey,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+func (p *ollamaProvider) Name() string {
+	return p.name
+}
+
+func (p *ollamaProvider) Model() string {
+	return p.model
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    []ollamaTool    `json:"tools,omitempty"`
+	Stream   bool            `json:"stream"`
+	Options  ollamaOptions   `json:"options"`
+}
+
+type ollamaOptions struct {
+	Temperature float32 `json:"temperature"`
+	NumPredict  int     `json:"num_predict,omitempty"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+// ollamaToolCall has the arguments as a JSON object rather than a string,
+// and no ID
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Parameters  any    `json:"parameters"`
+	} `json:"function"`
+}
+
+type ollamaResponse struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	Error           string        `json:"error"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+}
+
+// chatRequest converts a request to the API's form
+func (p *ollamaProvider) chatRequest(req ChatRequest) (ollamaRequest, error) {
+	request := ollamaRequest{
+		Model: req.Model,
+		Options: ollamaOptions{
+			Temperature: req.Temperature,
+			NumPredict:  req.MaxTokens,
+		},
+	}
+	if request.Model == "" {
+		request.Model = p.model
+	}
+	if request.Model == "" {
+		return request, errors.New("no model is configured")
+	}
+
+	for _, msg := range req.Messages {
+		m := ollamaMessage{Role: string(msg.Role), Content: msg.Content}
+		if msg.Role == RoleTool {
+			m.ToolName = msg.Name
+		}
+		for _, call := range msg.ToolCalls {
+			var tc ollamaToolCall
+			tc.Function.Name = call.Function.Name
+			tc.Function.Arguments = json.RawMessage(call.Function.Arguments)
+			if !json.Valid(tc.Function.Arguments) {
+				tc.Function.Arguments = json.RawMessage("{}")
+			}
+			m.ToolCalls = append(m.ToolCalls, tc)
+		}
+		request.Messages = append(request.Messages, m)
+	}
+
+	for _, tool := range req.Tools {
+		t := ollamaTool{Type: "function"}
+		t.Function.Name = tool.Name
+		t.Function.Description = tool.Description
+		t.Function.Parameters = tool.Parameters
+		request.Tools = append(request.Tools, t)
+	}
+	return request, nil
+}
+
+func (p *ollamaProvider) headers() map[string]string {
+	headers := map[string]string{}
+	if p.apiKey != "" {
+		headers["Authorization"] = "Bearer " + p.apiKey
+	}
+	return headers
+}
+
+func ollamaErrorMessage(body []byte) string {
+	var e struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &e) == nil {
+		return e.Error
+	}
+	return ""
+}
+
+// addToolCalls adds the tool calls of a reply to a message
+func addToolCalls(message *Message, calls []ollamaToolCall) {
+	for _, call := range calls {
+		message.ToolCalls = append(message.ToolCalls, ToolCall{
+			// Ollama doesn't identify tool calls, but the results refer to them
+			ID:       fmt.Sprintf("call_%d", len(message.ToolCalls)),
+			Function: FunctionCall{Name: call.Function.Name, Arguments: string(call.Function.Arguments)},
+		})
+	}
+}
+
+func (p *ollamaProvider) CreateChatCompletion(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	request, err := p.chatRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp ollamaResponse
+	if err := postJSON(ctx, p.baseURL+"/api/chat", p.headers(), request, &resp, ollamaErrorMessage); err != nil {
+		return nil, err
+	}
+
+	message := Message{Role: RoleAssistant, Content: resp.Message.Content}
+	addToolCalls(&message, resp.Message.ToolCalls)
+	return &ChatResponse{
+		Message: message,
+		Usage: Usage{
+			PromptTokens:     resp.PromptEvalCount,
+			CompletionTokens: resp.EvalCount,
+		},
+	}, nil
+}
+
+func (p *ollamaProvider) CreateChatCompletionStream(ctx context.Context, req ChatRequest, onText func(string)) (*ChatResponse, error) {
+	request, err := p.chatRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	request.Stream = true
+
+	// The reply arrives as one JSON object per line, the last one with
+	// done set and the token counts
+	message := Message{Role: RoleAssistant}
+	var content strings.Builder
+	var usage Usage
+	err = postStream(ctx, p.baseURL+"/api/chat", p.headers(), request, ollamaErrorMessage, func(line []byte) error {
+		if len(strings.TrimSpace(string(line))) == 0 {
+			return nil
+		}
+		var chunk ollamaResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return fmt.Errorf("invalid response: %w", err)
+		}
+		if chunk.Error != "" {
+			return errors.New(chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			onText(chunk.Message.Content)
+		}
+		addToolCalls(&message, chunk.Message.ToolCalls)
+		if chunk.Done {
+			usage = Usage{
+				PromptTokens:     chunk.PromptEvalCount,
+				CompletionTokens: chunk.EvalCount,
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	message.Content = content.String()
+	return &ChatResponse{Message: message, Usage: usage}, nil
+}
@@ -0,0 +1,187 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// defaultOpenAIModel is used when neither the provider nor the AI config
+// names a model
+const defaultOpenAIModel = "gpt-4.5"
+
+// openAIProvider talks to the OpenAI chat completions API, or to any
+// server compatible with it such as llama.cpp or vLLM given a base URL
+type openAIProvider struct {
+	name   string
+	model  string
+	client *openai.Client
+}
+
+func newOpenAIProvider(name, apiKey, baseURL, model string) *openAIProvider {
+	clientConfig := openai.DefaultConfig(apiKey)
+	if baseURL != "" {
+		clientConfig.BaseURL = baseURL
+	}
+	return &openAIProvider{
+		name:   name,
+		model:  model,
+		client: openai.NewClientWithConfig(clientConfig),
+	}
+}
+
+func (p *openAIProvider) Name() string {
+	return p.name
+}
+
+// Model returns the configured model, or the default one
+func (p *openAIProvider) Model() string {
+	if p.model != "" {
+		return p.model
+	}
+	return MapModelName(defaultOpenAIModel)
+}
+
+// chatRequest converts a request to the API's form
+func (p *openAIProvider) chatRequest(req ChatRequest) openai.ChatCompletionRequest {
+	request := openai.ChatCompletionRequest{
+		Model:       req.Model,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+	}
+	if request.Model == "" {
+		request.Model = p.Model()
+	}
+
+	for _, msg := range req.Messages {
+		m := openai.ChatCompletionMessage{
+			Role:       string(msg.Role),
+			Content:    msg.Content,
+			Name:       msg.Name,
+			ToolCallID: msg.ToolCallID,
+		}
+		for _, call := range msg.ToolCalls {
+			m.ToolCalls = append(m.ToolCalls, openai.ToolCall{
+				ID:   call.ID,
+				Type: openai.ToolTypeFunction,
+				Function: openai.FunctionCall{
+					Name:      call.Function.Name,
+					Arguments: call.Function.Arguments,
+				},
+			})
+		}
+		request.Messages = append(request.Messages, m)
+	}
+
+	for _, tool := range req.Tools {
+		request.Tools = append(request.Tools, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	return request
+}
+
+func (p *openAIProvider) CreateChatCompletion(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	resp, err := p.client.CreateChatCompletion(ctx, p.chatRequest(req))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return nil, errors.New("the response has no choices")
+	}
+
+	choice := resp.Choices[0].Message
+	message := Message{
+		Role:    RoleAssistant,
+		Content: choice.Content,
+	}
+	for _, call := range choice.ToolCalls {
+		message.ToolCalls = append(message.ToolCalls, ToolCall{
+			ID: call.ID,
+			Function: FunctionCall{
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			},
+		})
+	}
+	return &ChatResponse{
+		Message: message,
+		Usage: Usage{
+			PromptTokens:     resp.Usage.PromptTokens,
+			CompletionTokens: resp.Usage.CompletionTokens,
+		},
+	}, nil
+}
+
+func (p *openAIProvider) CreateChatCompletionStream(ctx context.Context, req ChatRequest, onText func(string)) (*ChatResponse, error) {
+	request := p.chatRequest(req)
+	request.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	stream, err := p.client.CreateChatCompletionStream(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	var content strings.Builder
+	var calls []ToolCall
+	var usage Usage
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if chunk.Usage != nil {
+			usage = Usage{
+				PromptTokens:     chunk.Usage.PromptTokens,
+				CompletionTokens: chunk.Usage.CompletionTokens,
+			}
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		delta := chunk.Choices[0].Delta
+		if delta.Content != "" {
+			content.WriteString(delta.Content)
+			onText(delta.Content)
+		}
+		// Tool calls arrive in pieces, the arguments a few characters at a
+		// time, identified by their index
+		for _, call := range delta.ToolCalls {
+			i := len(calls) - 1
+			if call.Index != nil {
+				i = *call.Index
+			} else if call.ID != "" || i < 0 {
+				i = len(calls)
+			}
+			for len(calls) <= i {
+				calls = append(calls, ToolCall{})
+			}
+			if call.ID != "" {
+				calls[i].ID = call.ID
+			}
+			calls[i].Function.Name += call.Function.Name
+			calls[i].Function.Arguments += call.Function.Arguments
+		}
+	}
+
+	return &ChatResponse{
+		Message: Message{
+			Role:      RoleAssistant,
+			Content:   content.String(),
+			ToolCalls: calls,
+		},
+		Usage: usage,
+	}, nil
+}
@@ -0,0 +1,419 @@
+package ai
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"ircbot/internal/ai/tools"
+	botconfig "ircbot/internal/config"
+	"ircbot/internal/logger"
+)
+
+// SettingsPath is where the settings changed with !aiconfig are kept
+var SettingsPath = "./data/ai_settings.toml"
+
+// SettingNames are the settings !aiconfig can change. The ones in
+// globalSettings can't be overridden per channel.
+var SettingNames = []string{"model", "temperature", "max_tokens", "tool_calls", "tools", "timeout", "summarization", "streaming"}
+
+var globalSettings = map[string]bool{"timeout": true, "summarization": true, "streaming": true}
+
+var (
+	settingsMu      sync.Mutex
+	fileSettings    botconfig.AIConfig
+	runtimeSettings botconfig.AIConfig
+	botNickname     string
+)
+
+// ConfigureSettings applies the [ai] section of the bot config and the
+// settings changed at runtime
+func ConfigureSettings(cfg *botconfig.Config) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	fileSettings = cfg.AI
+	botNickname = cfg.Nick
+	runtimeSettings = botconfig.AIConfig{}
+	if _, err := os.Stat(SettingsPath); err == nil {
+		if _, err := toml.DecodeFile(SettingsPath, &runtimeSettings); err != nil {
+			logger.Errorf("Error reading AI settings %s: %v", SettingsPath, err)
+		}
+	}
+	applySettings()
+}
+
+// applySettings rebuilds the config from the defaults, the config file and
+// the runtime settings. Must be called with settingsMu held.
+func applySettings() {
+	cfg := DefaultConfig()
+	for _, layer := range []botconfig.AIConfig{fileSettings, runtimeSettings} {
+		applyChannelSettings(cfg, layer.AISettings)
+		if layer.Timeout > 0 {
+			cfg.DefaultAPITimeout = layer.Timeout
+		}
+		if layer.Summarization != nil {
+			cfg.EnableSummarization = *layer.Summarization
+		}
+		if layer.Streaming != nil {
+			cfg.EnableStreaming = *layer.Streaming
+		}
+		if layer.SystemPrompt != "" {
+			cfg.SystemPrompt = layer.SystemPrompt
+		}
+	}
+	SetConfig(cfg)
+}
+
+// applyChannelSettings overrides the settings that are set
+func applyChannelSettings(cfg *Config, settings botconfig.AISettings) {
+	if settings.Model != "" {
+		cfg.Model = settings.Model
+	}
+	if settings.Temperature != nil {
+		cfg.Temperature = float32(*settings.Temperature)
+	}
+	if settings.MaxTokens > 0 {
+		cfg.MaxResponseTokens = settings.MaxTokens
+	}
+	if settings.ToolCalls != nil {
+		cfg.EnableToolCalls = *settings.ToolCalls
+	}
+	if len(settings.Tools) > 0 {
+		cfg.Tools = settings.Tools
+	}
+}
+
+// channelSettings finds the overrides of a channel, ignoring case
+func channelSettings(channels map[string]botconfig.AISettings, channel string) (botconfig.AISettings, bool) {
+	for name, settings := range channels {
+		if strings.EqualFold(name, channel) {
+			return settings, true
+		}
+	}
+	return botconfig.AISettings{}, false
+}
+
+// GetChannelConfig returns the config with a channel's overrides applied
+func GetChannelConfig(channel string) *Config {
+	cfg := *GetConfig()
+	if channel == "" {
+		return &cfg
+	}
+
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	for _, layer := range []botconfig.AIConfig{fileSettings, runtimeSettings} {
+		if settings, ok := channelSettings(layer.Channels, channel); ok {
+			applyChannelSettings(&cfg, settings)
+		}
+	}
+	return &cfg
+}
+
+// GetSetting returns the value of a setting, bot-wide or for a channel,
+// and where it comes from
+func GetSetting(channel, key string) (string, string, error) {
+	key = strings.ToLower(key)
+	if err := checkSettingScope(channel, key); err != nil {
+		return "", "", err
+	}
+
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	if channel != "" {
+		if settings, ok := channelSettings(runtimeSettings.Channels, channel); ok {
+			if value, set := settingValue(nil, &settings, key); set {
+				return value, "set with !aiconfig for " + channel, nil
+			}
+		}
+		if settings, ok := channelSettings(fileSettings.Channels, channel); ok {
+			if value, set := settingValue(nil, &settings, key); set {
+				return value, "config file for " + channel, nil
+			}
+		}
+	}
+	if value, set := settingValue(&runtimeSettings, &runtimeSettings.AISettings, key); set {
+		return value, "set with !aiconfig", nil
+	}
+	if value, set := settingValue(&fileSettings, &fileSettings.AISettings, key); set {
+		return value, "config file", nil
+	}
+
+	defaults := botconfig.AIConfig{}
+	cfg := DefaultConfig()
+	defaults.Model = cfg.Model
+	defaults.Temperature = new(float64)
+	*defaults.Temperature = float64(cfg.Temperature)
+	defaults.MaxTokens = cfg.MaxResponseTokens
+	defaults.ToolCalls = &cfg.EnableToolCalls
+	defaults.Timeout = cfg.DefaultAPITimeout
+	defaults.Summarization = &cfg.EnableSummarization
+	defaults.Streaming = &cfg.EnableStreaming
+	value, set := settingValue(&defaults, &defaults.AISettings, key)
+	if !set {
+		value = "(provider's)"
+		if key == "tools" {
+			value = "(all)"
+		}
+	}
+	return value, "default", nil
+}
+
+// SetSetting changes a setting bot-wide or for a channel, overriding the
+// config file, and saves it
+func SetSetting(channel, key, value string) error {
+	key = strings.ToLower(key)
+	if err := checkSettingScope(channel, key); err != nil {
+		return err
+	}
+	return updateRuntimeSetting(channel, key, value)
+}
+
+// ResetSetting removes a setting changed with SetSetting, going back to the
+// config file's value
+func ResetSetting(channel, key string) error {
+	key = strings.ToLower(key)
+	if err := checkSettingScope(channel, key); err != nil {
+		return err
+	}
+	return updateRuntimeSetting(channel, key, "")
+}
+
+// checkSettingScope checks that a setting exists and can be set for a
+// channel if one is given
+func checkSettingScope(channel, key string) error {
+	for _, name := range SettingNames {
+		if name == key {
+			if channel != "" && globalSettings[key] {
+				return fmt.Errorf("%s can't be set per channel", key)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown setting %s (settings: %s)", key, strings.Join(SettingNames, ", "))
+}
+
+// updateRuntimeSetting sets a runtime setting, or clears it if value is
+// empty, then saves and applies the settings
+func updateRuntimeSetting(channel, key, value string) error {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	updated := runtimeSettings
+	updated.Channels = make(map[string]botconfig.AISettings)
+	for name, settings := range runtimeSettings.Channels {
+		updated.Channels[name] = settings
+	}
+
+	if channel == "" {
+		if err := setSettingValue(&updated, &updated.AISettings, key, value); err != nil {
+			return err
+		}
+	} else {
+		name := channel
+		for existing := range updated.Channels {
+			if strings.EqualFold(existing, channel) {
+				name = existing
+			}
+		}
+		settings := updated.Channels[name]
+		if err := setSettingValue(nil, &settings, key, value); err != nil {
+			return err
+		}
+		if settings.Model == "" && settings.Temperature == nil && settings.MaxTokens == 0 && settings.ToolCalls == nil && len(settings.Tools) == 0 {
+			delete(updated.Channels, name)
+		} else {
+			updated.Channels[name] = settings
+		}
+	}
+
+	if err := saveRuntimeSettings(updated); err != nil {
+		return err
+	}
+	runtimeSettings = updated
+	applySettings()
+	return nil
+}
+
+// saveRuntimeSettings writes the runtime settings to a temporary file and
+// renames it into place, so a crash can't leave a truncated file behind
+func saveRuntimeSettings(settings botconfig.AIConfig) error {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(settings); err != nil {
+		return fmt.Errorf("failed to encode AI settings: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(SettingsPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for AI settings: %w", err)
+	}
+
+	tmp := SettingsPath + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write AI settings file: %w", err)
+	}
+	if err := os.Rename(tmp, SettingsPath); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace AI settings file: %w", err)
+	}
+	return nil
+}
+
+// settingValue formats a setting, reporting whether it is set. all is nil
+// for a channel's settings.
+func settingValue(all *botconfig.AIConfig, settings *botconfig.AISettings, key string) (string, bool) {
+	switch key {
+	case "model":
+		return settings.Model, settings.Model != ""
+	case "temperature":
+		if settings.Temperature != nil {
+			return strconv.FormatFloat(*settings.Temperature, 'f', -1, 64), true
+		}
+	case "max_tokens":
+		return strconv.Itoa(settings.MaxTokens), settings.MaxTokens > 0
+	case "tool_calls":
+		if settings.ToolCalls != nil {
+			return strconv.FormatBool(*settings.ToolCalls), true
+		}
+	case "tools":
+		return strings.Join(settings.Tools, ","), len(settings.Tools) > 0
+	}
+	if all == nil {
+		return "", false
+	}
+	switch key {
+	case "timeout":
+		return strconv.Itoa(all.Timeout), all.Timeout > 0
+	case "summarization":
+		if all.Summarization != nil {
+			return strconv.FormatBool(*all.Summarization), true
+		}
+	case "streaming":
+		if all.Streaming != nil {
+			return strconv.FormatBool(*all.Streaming), true
+		}
+	}
+	return "", false
+}
+
+// setSettingValue parses and sets a setting, or clears it if value is
+// empty. all is nil for a channel's settings.
+func setSettingValue(all *botconfig.AIConfig, settings *botconfig.AISettings, key, value string) error {
+	value = strings.TrimSpace(value)
+	unset := value == ""
+
+	switch key {
+	case "model":
+		settings.Model = value
+	case "temperature":
+		settings.Temperature = nil
+		if !unset {
+			temperature, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("temperature must be a number")
+			}
+			settings.Temperature = &temperature
+		}
+	case "max_tokens":
+		settings.MaxTokens = 0
+		if !unset {
+			maxTokens, err := strconv.Atoi(value)
+			if err != nil || maxTokens <= 0 {
+				return fmt.Errorf("max_tokens must be a positive number")
+			}
+			settings.MaxTokens = maxTokens
+		}
+	case "tool_calls":
+		enabled, err := parseSettingBool(value)
+		if err != nil {
+			return err
+		}
+		settings.ToolCalls = enabled
+	case "tools":
+		settings.Tools = nil
+		if !unset && !strings.EqualFold(value, "all") {
+			toolNames, err := parseToolNames(value)
+			if err != nil {
+				return err
+			}
+			settings.Tools = toolNames
+		}
+	case "timeout":
+		all.Timeout = 0
+		if !unset {
+			timeout, err := strconv.Atoi(value)
+			if err != nil || timeout <= 0 {
+				return fmt.Errorf("timeout must be a positive number of seconds")
+			}
+			all.Timeout = timeout
+		}
+	case "summarization":
+		enabled, err := parseSettingBool(value)
+		if err != nil {
+			return err
+		}
+		all.Summarization = enabled
+	case "streaming":
+		enabled, err := parseSettingBool(value)
+		if err != nil {
+			return err
+		}
+		all.Streaming = enabled
+	}
+	return botconfig.ValidateAISettings(*settings)
+}
+
+// parseSettingBool parses an on/off setting; empty clears it
+func parseSettingBool(value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	switch strings.ToLower(value) {
+	case "true", "on", "yes", "1":
+		enabled := true
+		return &enabled, nil
+	case "false", "off", "no", "0":
+		enabled := false
+		return &enabled, nil
+	}
+	return nil, fmt.Errorf("%q is not on or off", value)
+}
+
+// parseToolNames parses a comma-separated list of registered tools
+func parseToolNames(value string) ([]string, error) {
+	registered := make(map[string]string)
+	for _, tool := range tools.GetRegistry().GetAllTools() {
+		registered[strings.ToLower(tool.Name())] = tool.Name()
+	}
+
+	var names []string
+	for _, name := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		toolName, ok := registered[strings.ToLower(name)]
+		if !ok {
+			known := make([]string, 0, len(registered))
+			for _, n := range registered {
+				known = append(known, n)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown tool %s (tools: %s)", name, strings.Join(known, ", "))
+		}
+		names = append(names, toolName)
+	}
+	return names, nil
+}
+
+// botNick is the name the AI goes by
+func botNick() string {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	if botNickname == "" {
+		return "MBot"
+	}
+	return botNickname
+}
@@ -1,10 +1,11 @@
 package ai
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"time"
 
-	"github.com/sashabaranov/go-openai"
 	"ircbot/internal/logger"
 )
 
@@ -17,6 +18,11 @@ func truncateContent(content string) string {
 }
 
 func GenerateSummary(content string, maxLength int) (string, error) {
+	return generateSummary(context.Background(), content, maxLength)
+}
+
+// generateSummary summarizes content for the caller in ctx
+func generateSummary(parent context.Context, content string, maxLength int) (string, error) {
 	if !IsInitialized() {
 		return "AI summarization not available", nil
 	}
@@ -29,36 +35,20 @@ func GenerateSummary(content string, maxLength int) (string, error) {
 		return truncateContent(content), nil
 	}
 	
-	ctx, cancel := CreateContext()
+	ctx, cancel := context.WithTimeout(parent, time.Duration(GetConfig().DefaultAPITimeout)*time.Second)
 	defer cancel()
 	
 	cfg := GetConfig()
 	
-	resp, err := GetClient().CreateChatCompletion(ctx,
-		openai.ChatCompletionRequest{
-			Model: MapModelName(cfg.Model),
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleSystem,
-					Content: fmt.Sprintf("Summarize the following text in %d characters or less:", maxLength),
-				},
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: content,
-				},
-			},
-			Temperature: cfg.Temperature,
-			MaxTokens:   cfg.MaxResponseTokens,
-		},
-	)
+	summary, err := Complete(ctx,
+		fmt.Sprintf("Summarize the following text in %d characters or less:", maxLength),
+		content, cfg.Temperature, cfg.MaxResponseTokens)
 	
 	if err != nil {
 		logger.Errorf("Summary generation error: %v", err)
 		return truncateContent(content), err
 	}
 	
-	summary := resp.Choices[0].Message.Content
-	
 	if len(summary) > maxLength {
 		summary = summary[:maxLength-3] + "..."
 	}
@@ -14,7 +14,6 @@ import (
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
-	"github.com/sashabaranov/go-openai"
 	"github.com/sashabaranov/go-openai/jsonschema"
 	"ircbot/internal/logger"
 )
@@ -55,8 +54,6 @@ type GoogleSearchTool struct {
 	BaseTool
 	searchEngineID string
 	userAgents     []string
-	openaiAPIKey   string
-	openaiClient   *openai.Client
 }
 
 // NewGoogleSearchTool creates a new web search tool
@@ -87,9 +84,6 @@ func NewGoogleSearchTool() *GoogleSearchTool {
 		searchEngineID = "" // Will be checked during execution
 	}
 
-	// Get OpenAI API key for content summarization
-	openaiAPIKey := os.Getenv("OPENAI_API_KEY")
-
 	// List of modern browser user agents to rotate through
 	userAgents := []string{
 		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.0.0 Safari/537.36",
@@ -99,12 +93,6 @@ func NewGoogleSearchTool() *GoogleSearchTool {
 		"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:124.0) Gecko/20100101 Firefox/124.0",
 	}
 
-	// Create OpenAI client for summarizations
-	var openaiClient *openai.Client
-	if openaiAPIKey != "" {
-		openaiClient = openai.NewClient(openaiAPIKey)
-	}
-
 	return &GoogleSearchTool{
 		BaseTool: BaseTool{
 			ToolName:        "searchWeb",
@@ -113,13 +101,16 @@ func NewGoogleSearchTool() *GoogleSearchTool {
 		},
 		searchEngineID: searchEngineID,
 		userAgents:     userAgents,
-		openaiAPIKey:   openaiAPIKey,
-		openaiClient:   openaiClient,
 	}
 }
 
 // Execute processes the search request and returns the results
 func (t *GoogleSearchTool) Execute(args string) (string, error) {
+	return t.ExecuteContext(context.Background(), args)
+}
+
+// ExecuteContext is Execute summarizing the results within ctx
+func (t *GoogleSearchTool) ExecuteContext(ctx context.Context, args string) (string, error) {
 	var params GoogleSearchArgs
 
 	// Parse and validate arguments
@@ -173,13 +164,13 @@ func (t *GoogleSearchTool) Execute(args string) (string, error) {
 		return "No search results found for the query.", nil
 	}
 
-	// If simple mode is requested or we don't have OpenAI API key, just return the search results
-	if params.Simple || t.openaiClient == nil {
+	// If simple mode is requested or there is no AI provider, just return the search results
+	if params.Simple || Complete == nil {
 		return t.formatSearchResults(searchResults, params.Query, resultCount), nil
 	}
 
 	// For comprehensive mode, fetch and analyze content from the websites
-	allContent, err := t.fetchAndProcessContent(searchResults, params.Query, resultCount)
+	allContent, err := t.fetchAndProcessContent(ctx, searchResults, params.Query, resultCount)
 	if err != nil {
 		logger.Errorf("Error processing search content: %v", err)
 		// Fall back to simple results if content processing fails
@@ -305,7 +296,7 @@ func (t *GoogleSearchTool) performGoogleSearch(query, apiKey string, resultCount
 }
 
 // fetchAndProcessContent fetches content from search results and processes it
-func (t *GoogleSearchTool) fetchAndProcessContent(results *GoogleSearchResponse, query string, resultCount int) (string, error) {
+func (t *GoogleSearchTool) fetchAndProcessContent(ctx context.Context, results *GoogleSearchResponse, query string, resultCount int) (string, error) {
 	// Limit results to process
 	itemsToProcess := results.Items
 	if len(itemsToProcess) > resultCount {
@@ -383,7 +374,7 @@ func (t *GoogleSearchTool) fetchAndProcessContent(results *GoogleSearchResponse,
 	var summaryCount int
 	for i, content := range contents {
 		logger.Debugf("[SearchWeb] Summarizing content %d/%d: %s", i+1, len(contents), content.URL)
-		summary, err := t.summarizeContent(content, query)
+		summary, err := t.summarizeContent(ctx, content, query)
 		if err != nil {
 			logger.Warnf("[SearchWeb] Error summarizing content from %s: %v", content.URL, err)
 			continue
@@ -407,7 +398,7 @@ func (t *GoogleSearchTool) fetchAndProcessContent(results *GoogleSearchResponse,
 
 	// Create a final comprehensive answer
 	logger.Infof("[SearchWeb] Generating final comprehensive answer from %d summaries", len(summaries))
-	finalAnswer, err := t.createFinalAnswer(summaries, query)
+	finalAnswer, err := t.createFinalAnswer(ctx, summaries, query)
 	if err != nil {
 		logger.Errorf("[SearchWeb] Error creating final answer: %v", err)
 		logger.Warnf("[SearchWeb] Falling back to returning raw summaries without synthesis")
@@ -646,18 +637,18 @@ func (t *GoogleSearchTool) extractCleanText(doc *goquery.Document) string {
 	return mainContent
 }
 
-// summarizeContent uses OpenAI to summarize the website content
-func (t *GoogleSearchTool) summarizeContent(content WebsiteContent, query string) (string, error) {
+// summarizeContent uses the AI provider to summarize the website content
+func (t *GoogleSearchTool) summarizeContent(ctx context.Context, content WebsiteContent, query string) (string, error) {
 	logger.Debugf("[SearchWeb:Summarize] Starting summarization for content from: %s", content.URL)
 
-	if t.openaiClient == nil {
-		logger.Errorf("[SearchWeb:Summarize] OpenAI client not initialized")
-		return "", fmt.Errorf("OpenAI client not initialized")
+	if Complete == nil {
+		logger.Errorf("[SearchWeb:Summarize] AI provider not initialized")
+		return "", fmt.Errorf("AI provider not initialized")
 	}
 
 	// Create context with timeout
 	logger.Debugf("[SearchWeb:Summarize] Creating context with 30s timeout")
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Truncate content if necessary to fit within token limits
@@ -683,36 +674,20 @@ func (t *GoogleSearchTool) summarizeContent(content WebsiteContent, query string
 	promptLength := len(prompt)
 	logger.Debugf("[SearchWeb:Summarize] Prompt created (%d characters)", promptLength)
 
-	logger.Debugf("[SearchWeb:Summarize] Sending summarization request to OpenAI API using model: %s",
-		openai.GPT4o)
+	logger.Debugf("[SearchWeb:Summarize] Sending summarization request to the AI provider")
 	startTime := time.Now()
-	resp, err := t.openaiClient.CreateChatCompletion(
-		ctx,
-		openai.ChatCompletionRequest{
-			Model: openai.GPT4o,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleSystem,
-					Content: "You are a helpful assistant that extracts relevant information from website content to answer questions. Focus only on information directly related to the question.",
-				},
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: prompt,
-				},
-			},
-			Temperature: 0.3,
-			MaxTokens:   1000,
-		},
-	)
+	resp, err := Complete(ctx,
+		"You are a helpful assistant that extracts relevant information from website content to answer questions. Focus only on information directly related to the question.",
+		prompt, 0.3, 1000)
 
 	if err != nil {
-		logger.Errorf("[SearchWeb:Summarize] OpenAI API error: %v", err)
-		return "", fmt.Errorf("OpenAI API error: %v", err)
+		logger.Errorf("[SearchWeb:Summarize] AI provider error: %v", err)
+		return "", fmt.Errorf("AI provider error: %v", err)
 	}
 	apiDuration := time.Since(startTime)
-	logger.Debugf("[SearchWeb:Summarize] OpenAI API request completed in %d ms", apiDuration.Milliseconds())
+	logger.Debugf("[SearchWeb:Summarize] AI provider request completed in %d ms", apiDuration.Milliseconds())
 
-	summary := strings.TrimSpace(resp.Choices[0].Message.Content)
+	summary := strings.TrimSpace(resp)
 	logger.Infof("[SearchWeb:Summarize] Successfully summarized content from %s (%d chars → %d chars)",
 		content.URL, originalLength, len(summary))
 
@@ -720,17 +695,17 @@ func (t *GoogleSearchTool) summarizeContent(content WebsiteContent, query string
 }
 
 // createFinalAnswer generates a comprehensive answer from all the summaries
-func (t *GoogleSearchTool) createFinalAnswer(summaries []string, query string) (string, error) {
+func (t *GoogleSearchTool) createFinalAnswer(ctx context.Context, summaries []string, query string) (string, error) {
 	logger.Debugf("[SearchWeb:Synthesize] Starting final answer synthesis from %d summaries", len(summaries))
 
-	if t.openaiClient == nil {
-		logger.Errorf("[SearchWeb:Synthesize] OpenAI client not initialized")
-		return "", fmt.Errorf("OpenAI client not initialized")
+	if Complete == nil {
+		logger.Errorf("[SearchWeb:Synthesize] AI provider not initialized")
+		return "", fmt.Errorf("AI provider not initialized")
 	}
 
 	// Create context with timeout
 	logger.Debugf("[SearchWeb:Synthesize] Creating context with 30s timeout")
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Join summaries and truncate if necessary
@@ -760,36 +735,20 @@ func (t *GoogleSearchTool) createFinalAnswer(summaries []string, query string) (
 	)
 	logger.Debugf("[SearchWeb:Synthesize] Final prompt created (%d characters)", len(prompt))
 
-	logger.Debugf("[SearchWeb:Synthesize] Sending synthesis request to OpenAI API using model: %s",
-		openai.GPT4o)
+	logger.Debugf("[SearchWeb:Synthesize] Sending synthesis request to the AI provider")
 	startTime := time.Now()
-	resp, err := t.openaiClient.CreateChatCompletion(
-		ctx,
-		openai.ChatCompletionRequest{
-			Model: openai.GPT4o,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleSystem,
-					Content: "You are a helpful assistant that synthesizes information from multiple sources to provide accurate, comprehensive answers. Mention when information is conflicting or uncertain. Do not make up information not present in the sources.",
-				},
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: prompt,
-				},
-			},
-			Temperature: 0.3,
-			MaxTokens:   2000,
-		},
-	)
+	resp, err := Complete(ctx,
+		"You are a helpful assistant that synthesizes information from multiple sources to provide accurate, comprehensive answers. Mention when information is conflicting or uncertain. Do not make up information not present in the sources.",
+		prompt, 0.3, 2000)
 
 	if err != nil {
-		logger.Errorf("[SearchWeb:Synthesize] OpenAI API error: %v", err)
-		return "", fmt.Errorf("OpenAI API error: %v", err)
+		logger.Errorf("[SearchWeb:Synthesize] AI provider error: %v", err)
+		return "", fmt.Errorf("AI provider error: %v", err)
 	}
 	apiDuration := time.Since(startTime)
-	logger.Debugf("[SearchWeb:Synthesize] OpenAI API request completed in %d ms", apiDuration.Milliseconds())
+	logger.Debugf("[SearchWeb:Synthesize] AI provider request completed in %d ms", apiDuration.Milliseconds())
 
-	answer := strings.TrimSpace(resp.Choices[0].Message.Content)
+	answer := strings.TrimSpace(resp)
 	logger.Infof("[SearchWeb:Synthesize] Successfully generated final answer (%d chars) from %d summaries",
 		len(answer), len(summaries))
 
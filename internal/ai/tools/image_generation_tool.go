@@ -204,6 +204,11 @@ func NewImageGenerationTool() *ImageGenerationTool {
 
 // Execute processes the tool call with the provided arguments
 func (t *ImageGenerationTool) Execute(args string) (string, error) {
+	return t.ExecuteContext(context.Background(), args)
+}
+
+// ExecuteContext is Execute enhancing prompts within ctx
+func (t *ImageGenerationTool) ExecuteContext(ctx context.Context, args string) (string, error) {
 	var params ImageGenerationArgs
 	err := json.Unmarshal([]byte(args), &params)
 	if err != nil {
@@ -226,7 +231,7 @@ func (t *ImageGenerationTool) Execute(args string) (string, error) {
 	case "dalle":
 		return t.generateDalleImage(params)
 	case "flux":
-		return t.generateFluxImage(params)
+		return t.generateFluxImage(ctx, params)
 	default:
 		return "", fmt.Errorf("invalid provider: %s (must be flux or dalle)", provider)
 	}
@@ -354,7 +359,7 @@ func (t *ImageGenerationTool) generateDalleImage(params ImageGenerationArgs) (st
 }
 
 // generateFluxImage generates an image using the Flux API
-func (t *ImageGenerationTool) generateFluxImage(params ImageGenerationArgs) (string, error) {
+func (t *ImageGenerationTool) generateFluxImage(ctx context.Context, params ImageGenerationArgs) (string, error) {
 	// Check if API key is available
 	if t.bflApiKey == "" {
 		return "", fmt.Errorf("BFL API key not available, Flux image generation is disabled")
@@ -387,12 +392,12 @@ func (t *ImageGenerationTool) generateFluxImage(params ImageGenerationArgs) (str
 		logMessage.WriteString(fmt.Sprintf(", Safety level: %d", params.FluxSafetyLevel))
 	}
 	
-	logger.Infof(logMessage.String())
+	logger.Infof("%s", logMessage.String())
 
 	// Enhance the prompt if requested
 	if params.Enhance {
 		logger.Infof("Enhancing Flux prompt...")
-		enhancedPrompt, err := t.enhanceFluxPrompt(prompt)
+		enhancedPrompt, err := t.enhanceFluxPrompt(ctx, prompt)
 		if err != nil {
 			logger.Errorf("Error enhancing Flux prompt: %v", err)
 			// Continue with original prompt if enhancement fails
@@ -592,8 +597,11 @@ func (t *ImageGenerationTool) pollFluxResult(requestID string) (*FluxGetResultRe
 	return &result, nil
 }
 
+// fluxEnhanceModel is the OpenAI model that enhances Flux prompts
+const fluxEnhanceModel = "gpt-4o"
+
 // enhanceFluxPrompt improves the user's original prompt using the OpenAI API
-func (t *ImageGenerationTool) enhanceFluxPrompt(originalPrompt string) (string, error) {
+func (t *ImageGenerationTool) enhanceFluxPrompt(ctx context.Context, originalPrompt string) (string, error) {
 	// Check if OpenAI API key is available
 	if t.openaiApiKey == "" {
 		return originalPrompt, fmt.Errorf("OpenAI API key not available for prompt enhancement")
@@ -628,9 +636,9 @@ You will only respond with the enhanced prompt, ensuring the modified version is
 	}
 
 	resp, err := client.CreateChatCompletion(
-		context.Background(),
+		ctx,
 		openai.ChatCompletionRequest{
-			Model:     "gpt-4o",
+			Model:     fluxEnhanceModel,
 			Messages:  messages,
 			MaxTokens: 500,
 		},
@@ -639,6 +647,9 @@ You will only respond with the enhanced prompt, ensuring the modified version is
 	if err != nil {
 		return originalPrompt, fmt.Errorf("error enhancing prompt: %v", err)
 	}
+	if RecordUsage != nil {
+		RecordUsage(ctx, fluxEnhanceModel, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
+	}
 
 	enhancedPrompt := strings.TrimSpace(resp.Choices[0].Message.Content)
 	return enhancedPrompt, nil
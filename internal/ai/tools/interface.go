@@ -1,6 +1,8 @@
 package tools
 
 import (
+	"context"
+
 	"github.com/sashabaranov/go-openai"
 	"github.com/sashabaranov/go-openai/jsonschema"
 )
@@ -13,6 +15,14 @@ type Tool interface {
 	ToOpenAITool() openai.Tool
 }
 
+// ContextTool is a tool that takes the context of the AI request it runs
+// for. Tools pass it on to Complete and RecordUsage, so the tokens they use
+// are counted for the user who asked.
+type ContextTool interface {
+	Tool
+	ExecuteContext(ctx context.Context, args string) (string, error)
+}
+
 type BaseTool struct {
 	ToolName        string
 	ToolDescription string
@@ -0,0 +1,89 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"ircbot/internal/channelstate"
+	"ircbot/internal/logger"
+)
+
+// IRCChannelArgs represents the arguments for the getIRCChannelInfo tool
+type IRCChannelArgs struct {
+	ChannelName string `json:"channelName"`
+}
+
+// IRCChannelTool exposes the live channel state (users, modes, topic) to the AI
+type IRCChannelTool struct {
+	BaseTool
+}
+
+// NewIRCChannelTool creates a new channel information tool
+func NewIRCChannelTool() *IRCChannelTool {
+	params := jsonschema.Definition{
+		Type: jsonschema.Object,
+		Properties: map[string]jsonschema.Definition{
+			"channelName": {
+				Type:        jsonschema.String,
+				Description: "IRC channel name (including the # symbol)",
+			},
+		},
+		Required: []string{"channelName"},
+	}
+
+	return &IRCChannelTool{
+		BaseTool: BaseTool{
+			ToolName:        "getIRCChannelInfo",
+			ToolDescription: "Get the current state of an IRC channel the bot is in: topic, channel modes, who is in it and who has operator or voice status, and whether the bot itself has operator status.",
+			ToolParameters:  params,
+		},
+	}
+}
+
+// Execute processes the tool call with the provided arguments
+func (t *IRCChannelTool) Execute(args string) (string, error) {
+	logger.AIDebugf("IRCChannelTool.Execute called with args: %s", args)
+
+	var params IRCChannelArgs
+	if err := json.Unmarshal([]byte(args), &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %v", err)
+	}
+
+	channelName := params.ChannelName
+	if channelName == "" {
+		return "", fmt.Errorf("channel name is required")
+	}
+	if !strings.HasPrefix(channelName, "#") && !strings.HasPrefix(channelName, "&") {
+		channelName = "#" + channelName
+	}
+
+	info, ok := channelstate.GetChannel(channelName)
+	if !ok {
+		return fmt.Sprintf("The bot is not in %s. It is in: %s",
+			channelName, strings.Join(channelstate.GetChannels(), ", ")), nil
+	}
+
+	var result strings.Builder
+	fmt.Fprintf(&result, "Channel: %s\n", info.Name)
+	if info.Topic != "" {
+		fmt.Fprintf(&result, "Topic: %s", info.Topic)
+		if info.TopicSetBy != "" {
+			fmt.Fprintf(&result, " (set by %s on %s)", info.TopicSetBy, info.TopicSetAt.Format("2006-01-02 15:04"))
+		}
+		result.WriteString("\n")
+	}
+	if info.Modes != "" {
+		fmt.Fprintf(&result, "Modes: %s\n", info.Modes)
+	}
+	fmt.Fprintf(&result, "Bot has operator status: %t\n", channelstate.BotHasOp(info.Name))
+
+	nicks := make([]string, 0, len(info.Members))
+	for _, member := range info.Members {
+		nicks = append(nicks, member.Prefix+member.Nick)
+	}
+	fmt.Fprintf(&result, "Users (%d): %s", len(nicks), strings.Join(nicks, " "))
+
+	return result.String(), nil
+}
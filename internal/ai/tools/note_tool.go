@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/sashabaranov/go-openai/jsonschema"
+	"ircbot/internal/events"
 	"ircbot/internal/logger"
 )
 
@@ -263,6 +264,12 @@ func (t *NoteTool) saveNote(user, channel, noteContent string) (string, error) {
 	}
 
 	logger.Infof("Note saved for user %s in channel %s: %s", user, channel, noteContent)
+	events.Publish(events.SourceCore, events.TopicNoteSaved, events.NoteSaved{
+		ID:      newNote.ID,
+		User:    user,
+		Channel: channel,
+		Note:    noteContent,
+	})
 	return fmt.Sprintf("Note saved with ID: %s", newNote.ID), nil
 }
 
@@ -304,6 +311,7 @@ func (t *NoteTool) deleteNote(user, noteID string) (string, error) {
 	}
 
 	logger.Infof("Note with ID %s deleted by user %s", noteID, user)
+	events.Publish(events.SourceCore, events.TopicNoteDeleted, events.NoteDeleted{ID: noteID, User: user})
 	return fmt.Sprintf("Note with ID %s successfully deleted", noteID), nil
 }
 
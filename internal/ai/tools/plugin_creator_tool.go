@@ -3,9 +3,9 @@ package tools
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -30,6 +30,14 @@ type PluginCreatorArgs struct {
 	LoadAfterBuild bool   `json:"loadAfterBuild"`         // Whether to load the plugin after building
 }
 
+// BuildPlugin and LoadPlugin are provided by the plugin system, which this
+// package can't import. BuildPlugin compiles a plugin source and returns its
+// version and build path; LoadPlugin loads a build.
+var (
+	BuildPlugin func(name string, source []byte) (string, string, error)
+	LoadPlugin  func(path string) error
+)
+
 // PluginCreatorTool creates Go plugin source code and builds it into a loadable plugin
 type PluginCreatorTool struct {
 	BaseTool
@@ -431,92 +439,49 @@ func formatStringList(items []string) string {
 	return strings.Join(quoted, ", ")
 }
 
-// buildPlugin builds the plugin directly without using build_plugins.sh
+// buildPlugin builds the plugin the same way build_plugins.sh does
 func buildPlugin(pluginFilename string) (string, error) {
 	// Extract plugin base name without extension for build tag
 	buildTag := strings.TrimSuffix(pluginFilename, filepath.Ext(pluginFilename))
 
 	logger.Debugf("Building plugin with build tag: %s", buildTag)
 
-	// Get plugin version from source file
-	pluginVersion, err := extractPluginVersion(filepath.Join("plugins_src", pluginFilename))
-	if err != nil {
-		// Default to 1.0.0 if version extraction fails
-		pluginVersion = "1.0.0"
-		logger.Warnf("Could not extract plugin version: %v, using default: %s", err, pluginVersion)
-	}
-
-	// Create versioned output filename
-	versionedFilename := fmt.Sprintf("%s_v%s.so", buildTag, pluginVersion)
-	outputPath := filepath.Join("plugins", versionedFilename)
-	symlinkPath := filepath.Join("plugins", buildTag+".so")
-
-	// Build the plugin directly using go build
-	cmd := exec.Command("go", "build", 
-		"-buildmode=plugin", 
-		"-tags", buildTag, 
-		"-o", outputPath,
-		filepath.Join("plugins_src", pluginFilename))
-	
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return string(output), fmt.Errorf("go build failed: %v", err)
-	}
-
-	// Create symlink to the latest version
-	// Remove existing symlink if it exists
-	_ = os.Remove(symlinkPath)
-	err = os.Symlink(versionedFilename, symlinkPath)
-	if err != nil {
-		return string(output) + "\nPlugin built successfully, but failed to create symlink", 
-			fmt.Errorf("symlink creation failed: %v", err)
+	if BuildPlugin == nil {
+		return "", fmt.Errorf("plugin building is not available")
 	}
-
-	return fmt.Sprintf("Plugin built successfully:\n- Created: %s\n- Symlinked to: %s", 
-		outputPath, symlinkPath), nil
-}
-
-// extractPluginVersion extracts the version string from a plugin source file
-func extractPluginVersion(filePath string) (string, error) {
-	// Read the plugin source file
-	content, err := os.ReadFile(filePath)
+	source, err := os.ReadFile(filepath.Join("plugins_src", pluginFilename))
 	if err != nil {
 		return "", fmt.Errorf("failed to read plugin file: %v", err)
 	}
 
-	// Search for the Version function
-	versionPattern := `func\s+\(\w+\s+\*\w+\)\s+Version\(\)\s+string\s+{\s*return\s+"([0-9]+\.[0-9]+\.[0-9]+)"\s*}`
-	re := regexp.MustCompile(versionPattern)
-	matches := re.FindSubmatch(content)
-
-	if len(matches) < 2 {
-		return "", fmt.Errorf("version not found in plugin source")
+	version, outputPath, err := BuildPlugin(buildTag, source)
+	if err != nil {
+		// Compiler messages help fixing the source
+		var buildErr interface{ Lines() []string }
+		if errors.As(err, &buildErr) {
+			return strings.Join(buildErr.Lines(), "\n"), err
+		}
+		return "", err
 	}
 
-	return string(matches[1]), nil
+	return fmt.Sprintf("Plugin built successfully:\n- Version: %s\n- Created: %s\n- Symlinked to: %s",
+		version, outputPath, filepath.Join("plugins", buildTag+".so")), nil
 }
 
 // loadPlugin loads a plugin into the running bot
 func loadPlugin(pluginName string) (string, error) {
-	// Wait a short time for the file system to register the new file
-	time.Sleep(500 * time.Millisecond)
-
 	// Check if the plugin file exists
 	pluginPath := filepath.Join("plugins", pluginName+".so")
 	if _, err := os.Stat(pluginPath); os.IsNotExist(err) {
 		return "", fmt.Errorf("plugin file not found: %s", pluginPath)
 	}
 
-	// This is a convenience function that uses the plugin manager
-	// In a real implementation, you would call the plugin manager's LoadPlugin method
-	cmd := exec.Command("sh", "-c", fmt.Sprintf("echo '!load %s' > data/command_pipe", pluginName))
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return string(output), fmt.Errorf("failed to load plugin: %v", err)
+	if LoadPlugin == nil {
+		return "", fmt.Errorf("plugin loading is not available")
+	}
+	if err := LoadPlugin(pluginPath); err != nil {
+		return "", fmt.Errorf("failed to load plugin: %v", err)
 	}
-
-	// Give the bot a moment to load the plugin
-	time.Sleep(1 * time.Second)
 
 	return fmt.Sprintf("Plugin '%s' loaded successfully", pluginName), nil
 }
@@ -3,6 +3,7 @@
 package tools
 
 import (
+	"context"
 	"fmt"
 	"sync"
 
@@ -99,13 +100,23 @@ func (r *ToolRegistry) GetOpenAITools() []openai.Tool {
 // Arguments should be a JSON string that matches the tool's parameter schema.
 // Returns the tool's output as a string, or an error if execution failed.
 func (r *ToolRegistry) ExecuteTool(name string, args string) (string, error) {
+	return r.ExecuteToolContext(context.Background(), name, args)
+}
+
+// ExecuteToolContext is ExecuteTool passing ctx to tools that take one
+func (r *ToolRegistry) ExecuteToolContext(ctx context.Context, name string, args string) (string, error) {
 	tool, err := r.GetTool(name)
 	if err != nil {
 		return "", err
 	}
 	
 	logger.AIDebugf("Executing tool: %s with args: %s", name, args)
-	result, err := tool.Execute(args)
+	var result string
+	if contextTool, ok := tool.(ContextTool); ok {
+		result, err = contextTool.ExecuteContext(ctx, args)
+	} else {
+		result, err = tool.Execute(args)
+	}
 	if err != nil {
 		logger.Errorf("Tool execution error: %s: %v", name, err)
 		return "", err
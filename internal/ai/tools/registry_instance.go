@@ -33,6 +33,7 @@ func GetRegistry() *ToolRegistry {
 			NewImageGenerationTool(),
 			NewPasteTool(),
 			NewChannelLogTool(),
+			NewIRCChannelTool(),
 			NewErrorLogTool(),
 			NewPluginCreatorTool(),
 			NewPythonDockerTool(),
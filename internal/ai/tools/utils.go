@@ -1,6 +1,8 @@
 package tools
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
@@ -10,6 +12,14 @@ import (
 	"ircbot/internal/logger"
 )
 
+// Complete sends a prompt with a system message to the bot's AI provider and
+// returns the reply. It is set by the ai package, which imports this one.
+var Complete func(ctx context.Context, system, prompt string, temperature float32, maxTokens int) (string, error)
+
+// RecordUsage counts the tokens of a completion a tool requested without
+// Complete. It is set by the ai package.
+var RecordUsage func(ctx context.Context, model string, promptTokens, completionTokens int)
+
 // GetEnvToken returns the first non-empty environment variable value from the provided keys
 func GetEnvToken(keys ...string) string {
 	for _, key := range keys {
@@ -41,8 +51,8 @@ func TruncateString(s string, maxLen int) string {
 // LogAndReturnError logs an error and returns it with a user-friendly message
 func LogAndReturnError(context string, err error) (string, error) {
 	errMsg := fmt.Sprintf("%s: %v", context, err)
-	logger.Errorf(errMsg)
-	return "", fmt.Errorf(errMsg)
+	logger.Errorf("%s", errMsg)
+	return "", errors.New(errMsg)
 }
 
 var multipleNewlines = regexp.MustCompile(`\n{3,}`)
@@ -14,12 +14,16 @@ type Message struct {
 	Content    string      `json:"content"`
 	Name       string      `json:"name,omitempty"`       // For tool messages
 	ToolCallID string      `json:"tool_call_id,omitempty"` // For tool response messages
+	ToolCalls  []ToolCall  `json:"tool_calls,omitempty"` // For assistant messages calling tools
 }
 
 type ToolCall struct {
-	ID       string `json:"id"`
-	Function struct {
-		Name      string `json:"name"`
-		Arguments string `json:"arguments"`
-	} `json:"function"`
-}
\ No newline at end of file
+	ID       string       `json:"id"`
+	Function FunctionCall `json:"function"`
+}
+
+// FunctionCall is the tool a model calls, with its arguments as JSON
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
@@ -0,0 +1,379 @@
+package ai
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	botconfig "ircbot/internal/config"
+	"ircbot/internal/logger"
+	"ircbot/internal/userlevels"
+)
+
+var (
+	// UsagePath is where the token usage records are appended
+	UsagePath = "./data/ai_usage.jsonl"
+	// UsageRetention is how long usage records are kept
+	UsageRetention = 31 * 24 * time.Hour
+)
+
+// defaultPrices are estimated dollars per million tokens of common models,
+// used unless the [ai.prices] config names the model. Models are matched by
+// the longest name they start with.
+var defaultPrices = map[string]botconfig.AIPrice{
+	"gpt-4o":            {Prompt: 2.5, Completion: 10},
+	"gpt-4o-mini":       {Prompt: 0.15, Completion: 0.6},
+	"gpt-4.1":           {Prompt: 2, Completion: 8},
+	"gpt-4.1-mini":      {Prompt: 0.4, Completion: 1.6},
+	"gpt-4.5-preview":   {Prompt: 75, Completion: 150},
+	"claude-3-5-sonnet": {Prompt: 3, Completion: 15},
+	"claude-3-5-haiku":  {Prompt: 0.8, Completion: 4},
+}
+
+// UsageRecord is the tokens one completion used. Tool is the tool that
+// requested it, or empty for the replies themselves. Identity is who the
+// tokens count against, see UsageIdentity.
+type UsageRecord struct {
+	Time             time.Time `json:"time"`
+	Nick             string    `json:"nick,omitempty"`
+	Identity         string    `json:"identity,omitempty"`
+	Channel          string    `json:"channel,omitempty"`
+	Tool             string    `json:"tool,omitempty"`
+	Provider         string    `json:"provider"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+}
+
+// Tokens is the total of the record
+func (r UsageRecord) Tokens() int {
+	return r.PromptTokens + r.CompletionTokens
+}
+
+var (
+	usageMu      sync.Mutex
+	usageRecords []UsageRecord
+	usageLoaded  bool
+)
+
+// usageCaller is who a completion is for
+type usageCaller struct {
+	nick     string
+	identity string
+	channel  string
+	tool     string
+}
+
+type usageCallerKey struct{}
+
+// withUsageCaller marks the completions requested within ctx as used by a
+// nick with an identity in a channel, through a tool if one is named
+func withUsageCaller(ctx context.Context, nick, identity, channel, tool string) context.Context {
+	return context.WithValue(ctx, usageCallerKey{}, usageCaller{nick: nick, identity: identity, channel: channel, tool: tool})
+}
+
+// UsageIdentity returns who a user's tokens count against: their services
+// account if they are logged in, else the user@host of their hostmask, so
+// that changing nick doesn't start a new quota
+func UsageIdentity(nick, hostmask string) string {
+	if account := userlevels.GetNickAccount(nick); account != "" {
+		return "account:" + account
+	}
+	if _, userHost, ok := strings.Cut(hostmask, "!"); ok && userHost != "*@*" {
+		return userHost
+	}
+	return ""
+}
+
+// recordUsage adds the tokens of a completion to the caller in ctx
+func recordUsage(ctx context.Context, provider, model string, usage Usage) {
+	if usage.PromptTokens == 0 && usage.CompletionTokens == 0 {
+		return
+	}
+	caller, _ := ctx.Value(usageCallerKey{}).(usageCaller)
+	record := UsageRecord{
+		Time:             time.Now(),
+		Nick:             caller.nick,
+		Identity:         caller.identity,
+		Channel:          caller.channel,
+		Tool:             caller.tool,
+		Provider:         provider,
+		Model:            model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+	}
+
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	loadUsage()
+	usageRecords = append(usageRecords, record)
+
+	if err := appendUsage(record); err != nil {
+		logger.Errorf("Error saving AI usage: %v", err)
+	}
+}
+
+// appendUsage adds a record to the usage file
+func appendUsage(record UsageRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(UsagePath), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(UsagePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// loadUsage reads the records once, dropping the expired ones from the
+// file. Must be called with usageMu held.
+func loadUsage() {
+	if usageLoaded {
+		return
+	}
+	usageLoaded = true
+
+	file, err := os.Open(UsagePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warnf("Error reading AI usage: %v", err)
+		}
+		return
+	}
+	defer file.Close()
+
+	expired := 0
+	cutoff := time.Now().Add(-UsageRetention)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record UsageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if record.Time.Before(cutoff) {
+			expired++
+			continue
+		}
+		usageRecords = append(usageRecords, record)
+	}
+	if expired > 0 {
+		pruneUsage()
+	}
+}
+
+// pruneUsage rewrites the usage file with the kept records. Must be called
+// with usageMu held.
+func pruneUsage() {
+	tmp := UsagePath + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		logger.Warnf("Error pruning AI usage: %v", err)
+		return
+	}
+	encoder := json.NewEncoder(file)
+	for _, record := range usageRecords {
+		if err = encoder.Encode(record); err != nil {
+			break
+		}
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, UsagePath)
+	}
+	if err != nil {
+		logger.Warnf("Error pruning AI usage: %v", err)
+		os.Remove(tmp)
+	}
+}
+
+// usageSince returns the records since a time that match a filter
+func usageSince(since time.Time, match func(UsageRecord) bool) []UsageRecord {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	loadUsage()
+
+	var records []UsageRecord
+	for _, record := range usageRecords {
+		if !record.Time.Before(since) && match(record) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// startOfDay is when today's quotas started counting
+func startOfDay() time.Time {
+	year, month, day := time.Now().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+}
+
+// totalTokens adds up the tokens of records
+func totalTokens(records []UsageRecord) int {
+	total := 0
+	for _, record := range records {
+		total += record.Tokens()
+	}
+	return total
+}
+
+// quota returns the daily quota configured under a name, 0 if unlimited
+func quota(name string) int {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	for key, value := range fileSettings.Quotas {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return 0
+}
+
+// UserQuota returns the daily token quota of a user level, 0 if unlimited
+func UserQuota(level userlevels.UserLevel) int {
+	return quota(userlevels.LevelName(level))
+}
+
+// ChannelQuota returns the daily token quota of a channel, 0 if unlimited
+func ChannelQuota() int {
+	return quota("channel")
+}
+
+// CheckQuota returns an error if a user of a level, counted by their
+// identity, or the channel has used up today's tokens
+func CheckQuota(nick, identity, channel string, level userlevels.UserLevel) error {
+	if limit := UserQuota(level); limit > 0 && identity != "" {
+		used := totalTokens(usageSince(startOfDay(), func(r UsageRecord) bool {
+			return strings.EqualFold(r.Identity, identity)
+		}))
+		if used >= limit {
+			return fmt.Errorf("%s has used today's AI quota of %d tokens", nick, limit)
+		}
+	}
+	if limit := ChannelQuota(); limit > 0 && strings.HasPrefix(channel, "#") {
+		used := totalTokens(usageSince(startOfDay(), func(r UsageRecord) bool {
+			return strings.EqualFold(r.Channel, channel)
+		}))
+		if used >= limit {
+			return fmt.Errorf("%s has used today's AI quota of %d tokens", channel, limit)
+		}
+	}
+	return nil
+}
+
+// modelPrice finds the price of a model, from the config or the defaults
+func modelPrice(model string) (botconfig.AIPrice, bool) {
+	settingsMu.Lock()
+	prices := fileSettings.Prices
+	settingsMu.Unlock()
+
+	for _, table := range []map[string]botconfig.AIPrice{prices, defaultPrices} {
+		best := ""
+		for name := range table {
+			if strings.HasPrefix(model, name) && len(name) > len(best) {
+				best = name
+			}
+		}
+		if best != "" {
+			return table[best], true
+		}
+	}
+	return botconfig.AIPrice{}, false
+}
+
+// ModelUsage is the tokens a model used and their estimated cost
+type ModelUsage struct {
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+	// Priced is false when the model's price isn't known
+	Priced bool
+}
+
+// UsageReport is the usage of a nick or channel
+type UsageReport struct {
+	Today  int
+	Quota  int
+	Tokens int
+	Cost   float64
+	Models []ModelUsage
+	ByTool map[string]int
+	Since  time.Time
+}
+
+// GetUsageReport reports the usage of a user by their identity, or by nick
+// if the identity isn't known, or of a channel if target starts with #,
+// since the usage records start
+func GetUsageReport(target, identity string, level userlevels.UserLevel) UsageReport {
+	isChannel := strings.HasPrefix(target, "#")
+	match := func(r UsageRecord) bool {
+		switch {
+		case isChannel:
+			return strings.EqualFold(r.Channel, target)
+		case identity != "":
+			return strings.EqualFold(r.Identity, identity)
+		}
+		return strings.EqualFold(r.Nick, target)
+	}
+
+	since := time.Now().Add(-UsageRetention)
+	records := usageSince(since, match)
+	report := UsageReport{
+		ByTool: make(map[string]int),
+		Since:  since,
+	}
+	if isChannel {
+		report.Quota = ChannelQuota()
+	} else {
+		report.Quota = UserQuota(level)
+	}
+
+	today := startOfDay()
+	models := make(map[string]*ModelUsage)
+	for _, record := range records {
+		if !record.Time.Before(today) {
+			report.Today += record.Tokens()
+		}
+		report.Tokens += record.Tokens()
+		if record.Tool != "" {
+			report.ByTool[record.Tool] += record.Tokens()
+		}
+
+		m, ok := models[record.Model]
+		if !ok {
+			m = &ModelUsage{Model: record.Model}
+			_, m.Priced = modelPrice(record.Model)
+			models[record.Model] = m
+		}
+		m.PromptTokens += record.PromptTokens
+		m.CompletionTokens += record.CompletionTokens
+	}
+
+	for _, m := range models {
+		if price, ok := modelPrice(m.Model); ok {
+			m.Cost = (float64(m.PromptTokens)*price.Prompt + float64(m.CompletionTokens)*price.Completion) / 1e6
+		}
+		report.Cost += m.Cost
+		report.Models = append(report.Models, *m)
+	}
+	sort.Slice(report.Models, func(i, j int) bool {
+		return report.Models[i].PromptTokens+report.Models[i].CompletionTokens >
+			report.Models[j].PromptTokens+report.Models[j].CompletionTokens
+	})
+	return report
+}
@@ -0,0 +1,366 @@
+// Package autojoin joins the configured channels once the bot has registered
+// and identified, and gets it back into channels it was kicked from or kept
+// out of by a ban or invite-only mode.
+package autojoin
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/irc.v4"
+	"ircbot/internal"
+	"ircbot/internal/capabilities"
+	"ircbot/internal/channelstate"
+	"ircbot/internal/config"
+	"ircbot/internal/logger"
+)
+
+const (
+	// DefaultJoinWait is how long to wait for identification before joining anyway
+	DefaultJoinWait = 15 * time.Second
+	// DefaultRejoinDelay is how long to wait before rejoining after a kick
+	DefaultRejoinDelay = 5 * time.Second
+
+	// retryBaseDelay and retryMaxDelay bound the backoff for joins refused
+	// because of a ban, invite-only or a full channel
+	retryBaseDelay = 10 * time.Second
+	retryMaxDelay  = 10 * time.Minute
+	// maxJoinRetries is how often a refused join is retried before giving up
+	maxJoinRetries = 8
+)
+
+// identifiedNotices are the NickServ notices that confirm a login
+var identifiedNotices = []string{
+	"you are now identified",
+	"you are now logged in",
+	"password accepted",
+}
+
+// session holds the join state of the current connection
+type session struct {
+	mu         sync.Mutex
+	cfg        *config.Config
+	keys       map[string]string
+	retries    map[string]int
+	abandoned  map[string]bool // channels given up on until the bot is invited
+	registered bool
+	identified bool
+	joined     bool
+	done       chan struct{}
+}
+
+var (
+	current   *session
+	currentMu sync.Mutex
+)
+
+// Setup resets the join state for a new connection
+func Setup(cfg *config.Config) {
+	s := &session{
+		cfg:       cfg,
+		keys:      make(map[string]string),
+		retries:   make(map[string]int),
+		abandoned: make(map[string]bool),
+		done:      make(chan struct{}),
+	}
+	for channel, key := range cfg.ChannelKeys {
+		s.keys[strings.ToLower(channel)] = key
+	}
+
+	currentMu.Lock()
+	previous := current
+	current = s
+	currentMu.Unlock()
+	if previous != nil {
+		close(previous.done)
+	}
+}
+
+// get returns the session of the current connection
+func get() *session {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	return current
+}
+
+// after runs f after d unless the connection has been replaced by then
+func (s *session) after(d time.Duration, f func()) {
+	go func() {
+		select {
+		case <-s.done:
+		case <-time.After(d):
+			f()
+		}
+	}()
+}
+
+// HandleRegistered runs at the end of the MOTD. Channels are joined now if
+// there is nothing to identify with, otherwise once identification is
+// confirmed or the wait runs out.
+func HandleRegistered(c *irc.Client) {
+	s := get()
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.registered = true
+	ready := s.identified || s.cfg.Password == "" || capabilities.SASLAuthenticated()
+	s.mu.Unlock()
+
+	if ready {
+		s.joinAll(c)
+		return
+	}
+
+	wait := DefaultJoinWait
+	if s.cfg.JoinWait > 0 {
+		wait = time.Duration(s.cfg.JoinWait) * time.Second
+	}
+	logger.Infof(">> Waiting up to %s for identification before joining channels", wait)
+	s.after(wait, func() {
+		s.mu.Lock()
+		joined := s.joined
+		s.mu.Unlock()
+		if !joined {
+			logger.Warnf(">> Not identified after %s, joining channels anyway", wait)
+			s.joinAll(c)
+		}
+	})
+}
+
+// HandleIdentified runs when the bot is logged in to its account or its
+// host is cloaked, and joins the channels if registration is complete
+func HandleIdentified(c *irc.Client) {
+	s := get()
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.identified = true
+	registered := s.registered
+	s.mu.Unlock()
+
+	if registered {
+		s.joinAll(c)
+	}
+}
+
+// HandleNotice checks NickServ notices for a login confirmation
+func HandleNotice(c *irc.Client, m *irc.Message) {
+	if m.Prefix == nil || !strings.EqualFold(m.Prefix.Name, "NickServ") {
+		return
+	}
+
+	text := strings.ToLower(m.Trailing())
+	for _, notice := range identifiedNotices {
+		if strings.Contains(text, notice) {
+			HandleIdentified(c)
+			return
+		}
+	}
+}
+
+// joinAll joins the configured channels once per connection
+func (s *session) joinAll(c *irc.Client) {
+	s.mu.Lock()
+	if s.joined {
+		s.mu.Unlock()
+		return
+	}
+	s.joined = true
+	channels := s.cfg.Channels
+	s.mu.Unlock()
+
+	for _, channel := range channels {
+		s.join(c, channel)
+	}
+}
+
+// Join joins a channel, remembering the key (if any) for later rejoins
+func Join(c *irc.Client, channel, key string) error {
+	s := get()
+	if s == nil {
+		return sendJoin(c, channel, key)
+	}
+
+	if key != "" {
+		s.mu.Lock()
+		s.keys[strings.ToLower(channel)] = key
+		s.mu.Unlock()
+	}
+	return s.join(c, channel)
+}
+
+// join sends a JOIN with the channel's known key
+func (s *session) join(c *irc.Client, channel string) error {
+	s.mu.Lock()
+	key := s.keys[strings.ToLower(channel)]
+	s.mu.Unlock()
+	return sendJoin(c, channel, key)
+}
+
+// sendJoin writes the JOIN command and logs the outcome
+func sendJoin(c *irc.Client, channel, key string) error {
+	var err error
+	if key != "" {
+		err = c.Writef("%s %s %s", internal.CMD_JOIN, channel, key)
+	} else {
+		err = c.Writef("%s %s", internal.CMD_JOIN, channel)
+	}
+	if err != nil {
+		logger.Errorf(">> Error joining channel %s: %v", channel, err)
+		return err
+	}
+	logger.Successf(">> Joining channel: %s", channel)
+	return nil
+}
+
+// HandleOwnJoin clears any pending retry once the bot is in the channel
+func HandleOwnJoin(channel string) {
+	stopRetrying(channel)
+}
+
+// HandleOwnPart stops retrying a channel the bot chose to leave
+func HandleOwnPart(channel string) {
+	stopRetrying(channel)
+}
+
+// stopRetrying forgets the pending join retry of a channel
+func stopRetrying(channel string) {
+	s := get()
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	delete(s.retries, strings.ToLower(channel))
+	delete(s.abandoned, strings.ToLower(channel))
+	s.mu.Unlock()
+}
+
+// HandleKick rejoins a channel the bot was kicked from after the configured
+// delay. A negative rejoin_delay disables rejoining.
+func HandleKick(c *irc.Client, m *irc.Message) {
+	s := get()
+	if s == nil || len(m.Params) < 2 || !channelstate.EqualFold(m.Params[1], c.CurrentNick()) {
+		return
+	}
+
+	delay := DefaultRejoinDelay
+	switch {
+	case s.cfg.RejoinDelay < 0:
+		return
+	case s.cfg.RejoinDelay > 0:
+		delay = time.Duration(s.cfg.RejoinDelay) * time.Second
+	}
+
+	channel := m.Params[0]
+	logger.Infof(">> Rejoining %s in %s", channel, delay)
+	s.after(delay, func() {
+		s.join(c, channel)
+	})
+}
+
+// configured reports whether a channel is one of the configured autojoin channels
+func (s *session) configured(channel string) bool {
+	for _, name := range s.cfg.Channels {
+		if strings.EqualFold(name, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleJoinError retries joining a configured channel that refused the bot
+// because of a ban, invite-only or a full channel, backing off between
+// attempts and giving up after maxJoinRetries, until the bot is invited to
+// the channel. With chanserv_recover set,
+// ChanServ is asked to lift the ban or send an invite first. Other channels,
+// such as !join targets, are not retried.
+func HandleJoinError(c *irc.Client, m *irc.Message) {
+	s := get()
+	if s == nil || len(m.Params) < 2 {
+		return
+	}
+	channel := m.Params[1]
+	key := strings.ToLower(channel)
+
+	if !s.configured(channel) {
+		return
+	}
+
+	s.mu.Lock()
+	attempt := s.retries[key]
+	if attempt >= maxJoinRetries {
+		delete(s.retries, key)
+		s.abandoned[key] = true
+		s.mu.Unlock()
+		logger.Warnf(">> Giving up joining %s after %d attempts (%s). The channel is abandoned until the bot is invited or told to !join it",
+			channel, attempt+1, m.Trailing())
+		return
+	}
+	s.retries[key] = attempt + 1
+	s.mu.Unlock()
+
+	if s.cfg.ChanServRecover {
+		var request string
+		switch m.Command {
+		case internal.ERR_BANNEDFROMCHAN:
+			request = "UNBAN " + channel
+		case internal.ERR_INVITEONLYCHAN:
+			request = "INVITE " + channel
+		}
+		if request != "" {
+			logger.Infof(">> Asking ChanServ: %s", request)
+			if err := c.Writef("%s ChanServ :%s", internal.CMD_PRIVMSG, request); err != nil {
+				logger.Errorf(">> Error sending ChanServ request: %v", err)
+			}
+		}
+	}
+
+	delay := retryBaseDelay
+	for i := 0; i < attempt && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+
+	logger.Infof(">> Retrying to join %s in %s", channel, delay)
+	s.after(delay, func() {
+		s.mu.Lock()
+		_, pending := s.retries[key]
+		s.mu.Unlock()
+		if pending {
+			s.join(c, channel)
+		}
+	})
+}
+
+// HandleInvite joins right away when invited to a channel the bot is
+// waiting to get into, e.g. after asking ChanServ for an invite, or has
+// given up on. A refused join starts a new round of retries.
+func HandleInvite(c *irc.Client, m *irc.Message) {
+	s := get()
+	if s == nil || len(m.Params) < 2 || !channelstate.EqualFold(m.Params[0], c.CurrentNick()) {
+		return
+	}
+	channel := m.Params[1]
+	key := strings.ToLower(channel)
+
+	s.mu.Lock()
+	_, pending := s.retries[key]
+	abandoned := s.abandoned[key]
+	delete(s.abandoned, key)
+	s.mu.Unlock()
+
+	if abandoned {
+		logger.Infof(">> Invited to %s, trying to join it again", channel)
+	}
+	if pending || abandoned {
+		s.join(c, channel)
+	}
+}
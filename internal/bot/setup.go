@@ -2,16 +2,27 @@ package bot
 
 import (
 	"gopkg.in/irc.v4"
+	"ircbot/internal/autojoin"
+	"ircbot/internal/capabilities"
+	"ircbot/internal/channelstate"
 	"ircbot/internal/commands"
 	"ircbot/internal/config"
 	"ircbot/internal/handlers"
+	"ircbot/internal/health"
+	"ircbot/internal/logger"
+	"ircbot/internal/nickrecovery"
+	"ircbot/internal/sendqueue"
 	"ircbot/internal/userlevels"
 	"net"
+	"time"
 )
 
 // SetupClient initializes a new IRC client with the provided connection and configuration.
 func SetupClient(conn net.Conn, cfg *config.Config) *irc.Client {
 	userlevels.LoadHostmasks()
+	userlevels.ClearNickAccounts()
+	channelstate.Reset(cfg.Nick)
+	autojoin.Setup(cfg)
 	
 	// Set the config global for command system
 	commands.BotConfig = cfg
@@ -20,9 +31,25 @@ func SetupClient(conn net.Conn, cfg *config.Config) *irc.Client {
 		Nick: cfg.Nick,
 		User: cfg.User,
 		Name: cfg.RealName,
+		EnableISupport: true,
 		Handler: irc.HandlerFunc(func(c *irc.Client, m *irc.Message) {
-			handlers.HandleMessages(c, m, cfg.Password, cfg.Channels)
+			// The lag check doesn't count the time a handler holds up reading
+			defer health.BeginHandling()()
+			handlers.HandleMessages(c, m)
 		}),
 	}
-	return irc.NewClient(conn, clientConfig)
+	client := irc.NewClient(conn, clientConfig)
+
+	// Everything written through the client goes through the flood-controlled send queue
+	sendqueue.Attach(client, conn, cfg.FloodBurst, time.Duration(cfg.FloodDelay)*time.Millisecond)
+
+	// Try the alternate nicks if ours is taken, and win it back later
+	nickrecovery.Setup(client, cfg)
+
+	// Negotiate capabilities (and SASL) before NICK/USER registration completes
+	if err := capabilities.Start(client, cfg); err != nil {
+		logger.Errorf("Failed to start capability negotiation: %v", err)
+	}
+
+	return client
 }
@@ -0,0 +1,372 @@
+// Package capabilities negotiates IRCv3 capabilities (including SASL) with the
+// server and exposes the negotiated set to the rest of the bot.
+package capabilities
+
+import (
+	"encoding/base64"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/irc.v4"
+	"ircbot/internal"
+	"ircbot/internal/config"
+	"ircbot/internal/logger"
+)
+
+// saslChunkSize is the maximum length of a single AUTHENTICATE payload line
+const saslChunkSize = 400
+
+// DefaultCapabilities are requested from every server that advertises them
+var DefaultCapabilities = []string{
+	"message-tags",
+	"server-time",
+	"account-tag",
+	"account-notify",
+	"extended-join",
+	"away-notify",
+	"echo-message",
+	"batch",
+	"multi-prefix",
+	"userhost-in-names",
+	"chghost",
+}
+
+// session tracks capability negotiation and SASL state for the current
+// connection. It is reset every time a new connection is set up.
+type session struct {
+	mu            sync.RWMutex
+	cfg           *config.Config
+	available     map[string]string
+	enabled       map[string]bool
+	negotiating   bool
+	pendingReqs   int
+	saslMechanism string
+	saslStarted   bool
+	saslSucceeded bool
+}
+
+var current = &session{
+	available: make(map[string]string),
+	enabled:   make(map[string]bool),
+}
+
+// Start resets the capability state and sends CAP LS.
+// It must be called before the client registers (i.e. before client.Run)
+// so the server holds registration until CAP END.
+func Start(c *irc.Client, cfg *config.Config) error {
+	current.mu.Lock()
+	current.cfg = cfg
+	current.available = make(map[string]string)
+	current.enabled = make(map[string]bool)
+	current.negotiating = true
+	current.pendingReqs = 0
+	current.saslMechanism = strings.ToUpper(cfg.SASLMechanism)
+	current.saslStarted = false
+	current.saslSucceeded = false
+	current.mu.Unlock()
+
+	logger.SetEchoMessageEnabled(false)
+
+	return c.Writef("%s LS 302", internal.CMD_CAP)
+}
+
+// Enabled reports whether a capability was acknowledged by the server
+func Enabled(name string) bool {
+	current.mu.RLock()
+	defer current.mu.RUnlock()
+	return current.enabled[name]
+}
+
+// List returns the negotiated capabilities in alphabetical order
+func List() []string {
+	current.mu.RLock()
+	defer current.mu.RUnlock()
+
+	names := make([]string, 0, len(current.enabled))
+	for name := range current.enabled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Available returns the value the server advertised for a capability, and
+// whether it was advertised at all
+func Available(name string) (string, bool) {
+	current.mu.RLock()
+	defer current.mu.RUnlock()
+	value, ok := current.available[name]
+	return value, ok
+}
+
+// SASLAuthenticated reports whether SASL authentication succeeded on this connection
+func SASLAuthenticated() bool {
+	current.mu.RLock()
+	defer current.mu.RUnlock()
+	return current.saslSucceeded
+}
+
+// MarkRegistered marks negotiation as finished once the server has
+// completed registration (RPL_WELCOME)
+func MarkRegistered() {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+	current.negotiating = false
+}
+
+// wanted returns the capabilities the bot wants for this connection
+func (s *session) wanted() []string {
+	wanted := append([]string{}, DefaultCapabilities...)
+	if s.saslMechanism != "" {
+		wanted = append(wanted, "sasl")
+	}
+	return wanted
+}
+
+// request sends a CAP REQ for every wanted capability the server offers that
+// isn't already enabled. Returns false if nothing needed requesting.
+func (s *session) request(c *irc.Client, offered []string) bool {
+	var request []string
+	for _, name := range s.wanted() {
+		if s.enabled[name] {
+			continue
+		}
+		for _, candidate := range offered {
+			if candidate == name {
+				request = append(request, name)
+				break
+			}
+		}
+	}
+
+	if len(request) == 0 {
+		return false
+	}
+
+	logger.Infof(">> Requesting capabilities: %s", strings.Join(request, " "))
+	if err := c.Writef("%s REQ :%s", internal.CMD_CAP, strings.Join(request, " ")); err != nil {
+		logger.Errorf(">> Error requesting capabilities: %v", err)
+		return false
+	}
+	s.pendingReqs++
+	return true
+}
+
+// finishRequests continues registration once every REQ has been answered,
+// running SASL first when it was negotiated
+func (s *session) finishRequests(c *irc.Client) {
+	if !s.negotiating || s.pendingReqs > 0 {
+		return
+	}
+
+	if s.enabled["sasl"] && s.saslMechanism != "" && !s.saslStarted {
+		s.startSASL(c)
+		return
+	}
+
+	if s.saslMechanism != "" && !s.enabled["sasl"] {
+		logger.Warnf(">> Server does not support SASL, falling back to NickServ")
+	}
+	s.end(c)
+}
+
+// end sends CAP END once, letting registration continue
+func (s *session) end(c *irc.Client) {
+	if !s.negotiating {
+		return
+	}
+	s.negotiating = false
+
+	if err := c.Writef("%s END", internal.CMD_CAP); err != nil {
+		logger.Errorf(">> Error ending capability negotiation: %v", err)
+	}
+}
+
+// HandleCap processes CAP LS/ACK/NAK/NEW/DEL messages from the server
+func HandleCap(c *irc.Client, m *irc.Message) {
+	if len(m.Params) < 2 {
+		return
+	}
+
+	current.mu.Lock()
+	defer current.mu.Unlock()
+
+	subcommand := strings.ToUpper(m.Params[1])
+	switch subcommand {
+	case "LS":
+		// CAP * LS * :caps... marks a continuation line in CAP 302
+		more := len(m.Params) > 3 && m.Params[2] == "*"
+		for _, token := range strings.Fields(m.Trailing()) {
+			name, value, _ := strings.Cut(token, "=")
+			current.available[name] = value
+		}
+		if more || !current.negotiating {
+			return
+		}
+
+		offered := make([]string, 0, len(current.available))
+		for name := range current.available {
+			offered = append(offered, name)
+		}
+		current.request(c, offered)
+		current.finishRequests(c)
+
+	case "ACK":
+		for _, name := range strings.Fields(m.Trailing()) {
+			if strings.HasPrefix(name, "-") {
+				delete(current.enabled, strings.TrimPrefix(name, "-"))
+				continue
+			}
+			current.enabled[name] = true
+		}
+		logger.Successf(">> Capabilities acknowledged: %s", m.Trailing())
+		logger.SetEchoMessageEnabled(current.enabled["echo-message"])
+
+		if current.pendingReqs > 0 {
+			current.pendingReqs--
+		}
+		current.finishRequests(c)
+
+	case "NAK":
+		logger.Warnf(">> Capabilities rejected by server: %s", m.Trailing())
+		if current.pendingReqs > 0 {
+			current.pendingReqs--
+		}
+
+		// A REQ is accepted or rejected as a whole, so retry the
+		// capabilities one by one to keep the ones the server allows
+		rejected := strings.Fields(m.Trailing())
+		if len(rejected) > 1 {
+			for _, name := range rejected {
+				if err := c.Writef("%s REQ :%s", internal.CMD_CAP, name); err != nil {
+					logger.Errorf(">> Error requesting capability %s: %v", name, err)
+					continue
+				}
+				current.pendingReqs++
+			}
+		}
+		current.finishRequests(c)
+
+	case "NEW":
+		// cap-notify: the server started offering new capabilities
+		var offered []string
+		for _, token := range strings.Fields(m.Trailing()) {
+			name, value, _ := strings.Cut(token, "=")
+			current.available[name] = value
+			offered = append(offered, name)
+		}
+		logger.Infof(">> Server offers new capabilities: %s", m.Trailing())
+		current.request(c, offered)
+
+	case "DEL":
+		for _, name := range strings.Fields(m.Trailing()) {
+			delete(current.available, name)
+			delete(current.enabled, name)
+		}
+		logger.Warnf(">> Server removed capabilities: %s", m.Trailing())
+		logger.SetEchoMessageEnabled(current.enabled["echo-message"])
+
+	default:
+		logger.Infof(">> CAP %s: %s", subcommand, m.Trailing())
+	}
+}
+
+// startSASL begins authentication with the configured mechanism if the
+// server advertises it
+func (s *session) startSASL(c *irc.Client) {
+	s.saslStarted = true
+
+	if mechs := s.available["sasl"]; mechs != "" {
+		supported := false
+		for _, mech := range strings.Split(mechs, ",") {
+			if strings.EqualFold(mech, s.saslMechanism) {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			logger.Warnf(">> Server does not support SASL %s (offers %s), falling back to NickServ",
+				s.saslMechanism, mechs)
+			s.end(c)
+			return
+		}
+	}
+
+	logger.Infof(">> Authenticating with SASL %s...", s.saslMechanism)
+	if err := c.Writef("%s %s", internal.CMD_AUTHENTICATE, s.saslMechanism); err != nil {
+		logger.Errorf(">> Error starting SASL authentication: %v", err)
+		s.end(c)
+	}
+}
+
+// HandleAuthenticate answers the server's AUTHENTICATE challenge
+func HandleAuthenticate(c *irc.Client, m *irc.Message) {
+	if len(m.Params) < 1 || m.Params[0] != "+" {
+		return
+	}
+
+	current.mu.RLock()
+	mechanism := current.saslMechanism
+	cfg := current.cfg
+	current.mu.RUnlock()
+
+	if cfg == nil {
+		return
+	}
+
+	switch mechanism {
+	case "EXTERNAL":
+		// The identity comes from the TLS client certificate
+		if err := c.Writef("%s +", internal.CMD_AUTHENTICATE); err != nil {
+			logger.Errorf(">> Error sending SASL EXTERNAL response: %v", err)
+		}
+	case "PLAIN":
+		account := cfg.SASLUsername
+		if account == "" {
+			account = cfg.Nick
+		}
+		payload := base64.StdEncoding.EncodeToString([]byte(account + "\x00" + account + "\x00" + cfg.Password))
+		sendAuthenticatePayload(c, payload)
+	}
+}
+
+// sendAuthenticatePayload splits a base64 payload into AUTHENTICATE lines.
+// A payload that is an exact multiple of the chunk size ends with "+".
+func sendAuthenticatePayload(c *irc.Client, payload string) {
+	for len(payload) >= saslChunkSize {
+		if err := c.Writef("%s %s", internal.CMD_AUTHENTICATE, payload[:saslChunkSize]); err != nil {
+			logger.Errorf(">> Error sending SASL payload: %v", err)
+			return
+		}
+		payload = payload[saslChunkSize:]
+	}
+
+	if payload == "" {
+		payload = "+"
+	}
+	if err := c.Writef("%s %s", internal.CMD_AUTHENTICATE, payload); err != nil {
+		logger.Errorf(">> Error sending SASL payload: %v", err)
+	}
+}
+
+// HandleSASLResult finishes negotiation after the server reports the SASL outcome
+func HandleSASLResult(c *irc.Client, m *irc.Message) {
+	current.mu.Lock()
+	defer current.mu.Unlock()
+
+	switch m.Command {
+	case internal.RPL_SASLSUCCESS:
+		current.saslSucceeded = true
+		logger.Successf(">> SASL authentication successful")
+	case internal.RPL_SASLMECHS:
+		if len(m.Params) > 1 {
+			logger.Warnf(">> Server SASL mechanisms: %s", m.Params[1])
+		}
+		return
+	default:
+		logger.Errorf(">> SASL authentication failed (%s): %s, falling back to NickServ", m.Command, m.Trailing())
+	}
+
+	current.end(c)
+}
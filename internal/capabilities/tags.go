@@ -0,0 +1,41 @@
+package capabilities
+
+import (
+	"time"
+
+	"gopkg.in/irc.v4"
+)
+
+// MessageTime returns when a message was sent according to its server-time
+// tag, falling back to the local receive time when the tag is missing
+func MessageTime(m *irc.Message) time.Time {
+	if m != nil {
+		if value, ok := m.Tags["time"]; ok && value != "" {
+			if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+				return t.Local()
+			}
+		}
+	}
+	return time.Now()
+}
+
+// MessageAccount returns the services account of the sender from the
+// account-tag, or an empty string if the sender is not logged in
+func MessageAccount(m *irc.Message) string {
+	if m == nil {
+		return ""
+	}
+	account := m.Tags["account"]
+	if account == "*" {
+		return ""
+	}
+	return account
+}
+
+// MessageBatch returns the batch reference a message belongs to, if any
+func MessageBatch(m *irc.Message) string {
+	if m == nil {
+		return ""
+	}
+	return m.Tags["batch"]
+}
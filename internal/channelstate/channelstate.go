@@ -0,0 +1,390 @@
+// Package channelstate keeps track of the channels the bot is in: their
+// members and status modes, channel modes and topics. The state is built
+// from NAMES, WHO/WHOX, JOIN, PART, KICK, QUIT, NICK, MODE and TOPIC, using
+// the PREFIX, CHANMODES and CASEMAPPING values from RPL_ISUPPORT.
+package channelstate
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Member is a user in a channel along with their channel status
+type Member struct {
+	Nick string
+	// Modes holds the status mode characters (e.g. "ov"), highest first
+	Modes string
+	// Prefix is the symbol of the highest status (e.g. "@"), or empty
+	Prefix string
+}
+
+// ChannelInfo is a snapshot of a channel's state
+type ChannelInfo struct {
+	Name       string
+	Topic      string
+	TopicSetBy string
+	TopicSetAt time.Time
+	// Modes is the channel mode string with its parameters, e.g. "+ntl 50"
+	Modes   string
+	Members []Member
+}
+
+// UserInfo is what is known about a user sharing a channel with the bot
+type UserInfo struct {
+	Nick    string
+	User    string
+	Host    string
+	Account string
+	Away    bool
+}
+
+// Hostmask returns the user's nick!user@host, with * for unknown parts
+func (u UserInfo) Hostmask() string {
+	user, host := u.User, u.Host
+	if user == "" {
+		user = "*"
+	}
+	if host == "" {
+		host = "*"
+	}
+	return u.Nick + "!" + user + "@" + host
+}
+
+type channel struct {
+	name       string
+	topic      string
+	topicSetBy string
+	topicSetAt time.Time
+	modes      map[byte]string
+	members    map[string]*member
+	// namesPending is set while a NAMES reply is being received, so the
+	// first RPL_NAMREPLY replaces the member list instead of adding to it
+	namesPending bool
+}
+
+type member struct {
+	nick  string
+	modes map[byte]bool
+}
+
+type user struct {
+	nick    string
+	user    string
+	host    string
+	account string
+	away    bool
+}
+
+type tracker struct {
+	mu sync.RWMutex
+
+	me string
+
+	// From RPL_ISUPPORT
+	casemapping   string
+	prefixModes   string
+	prefixSymbols string
+	listModes     string // CHANMODES type A, always take a parameter
+	paramModes    string // CHANMODES type B, always take a parameter
+	setParamModes string // CHANMODES type C, take a parameter when set
+
+	channels map[string]*channel
+	users    map[string]*user
+}
+
+var state = newTracker("")
+
+// newTracker creates a tracker with the defaults servers assume when they
+// don't send PREFIX/CHANMODES/CASEMAPPING
+func newTracker(nick string) *tracker {
+	return &tracker{
+		me:            nick,
+		casemapping:   "rfc1459",
+		prefixModes:   "ov",
+		prefixSymbols: "@+",
+		listModes:     "beI",
+		paramModes:    "k",
+		setParamModes: "l",
+		channels:      make(map[string]*channel),
+		users:         make(map[string]*user),
+	}
+}
+
+// Reset forgets all state, e.g. when a new connection is set up
+func Reset(nick string) {
+	t := newTracker(nick)
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.me = t.me
+	state.casemapping = t.casemapping
+	state.prefixModes = t.prefixModes
+	state.prefixSymbols = t.prefixSymbols
+	state.listModes = t.listModes
+	state.paramModes = t.paramModes
+	state.setParamModes = t.setParamModes
+	state.channels = t.channels
+	state.users = t.users
+}
+
+// fold normalizes a nick or channel name according to the server's CASEMAPPING
+func (t *tracker) fold(name string) string {
+	if t.casemapping == "ascii" {
+		return strings.ToLower(name)
+	}
+
+	strict := t.casemapping == "strict-rfc1459"
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		case r == '[':
+			return '{'
+		case r == ']':
+			return '}'
+		case r == '\\':
+			return '|'
+		case r == '~' && !strict:
+			return '^'
+		}
+		return r
+	}, name)
+}
+
+// EqualFold reports whether two nicks or channel names are the same under
+// the server's CASEMAPPING
+func EqualFold(a, b string) bool {
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+	return state.fold(a) == state.fold(b)
+}
+
+// isMe reports whether nick is the bot's own nick. Callers must hold mu.
+func (t *tracker) isMe(nick string) bool {
+	return t.me != "" && t.fold(nick) == t.fold(t.me)
+}
+
+// getUser returns the user record for nick, creating it if needed.
+// Callers must hold the write lock.
+func (t *tracker) getUser(nick string) *user {
+	key := t.fold(nick)
+	u, ok := t.users[key]
+	if !ok {
+		u = &user{nick: nick}
+		t.users[key] = u
+	}
+	return u
+}
+
+// forgetUserIfGone drops the user record once they share no channel with
+// the bot. Callers must hold the write lock.
+func (t *tracker) forgetUserIfGone(nick string) {
+	key := t.fold(nick)
+	for _, ch := range t.channels {
+		if _, ok := ch.members[key]; ok {
+			return
+		}
+	}
+	delete(t.users, key)
+}
+
+// sortedModes returns a member's status modes ordered from highest to lowest
+func (t *tracker) sortedModes(m *member) string {
+	var modes strings.Builder
+	for i := 0; i < len(t.prefixModes); i++ {
+		if m.modes[t.prefixModes[i]] {
+			modes.WriteByte(t.prefixModes[i])
+		}
+	}
+	return modes.String()
+}
+
+// symbolFor returns the prefix symbol of a status mode
+func (t *tracker) symbolFor(mode byte) string {
+	if i := strings.IndexByte(t.prefixModes, mode); i >= 0 && i < len(t.prefixSymbols) {
+		return t.prefixSymbols[i : i+1]
+	}
+	return ""
+}
+
+// GetChannels returns the names of the channels the bot is in
+func GetChannels() []string {
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+
+	names := make([]string, 0, len(state.channels))
+	for _, ch := range state.channels {
+		names = append(names, ch.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetChannel returns a snapshot of a channel the bot is in
+func GetChannel(name string) (ChannelInfo, bool) {
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+
+	ch, ok := state.channels[state.fold(name)]
+	if !ok {
+		return ChannelInfo{}, false
+	}
+
+	return ChannelInfo{
+		Name:       ch.name,
+		Topic:      ch.topic,
+		TopicSetBy: ch.topicSetBy,
+		TopicSetAt: ch.topicSetAt,
+		Modes:      state.modeString(ch),
+		Members:    state.members(ch),
+	}, true
+}
+
+// GetChannelUsers returns the members of a channel sorted by status, then nick
+func GetChannelUsers(name string) []Member {
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+
+	ch, ok := state.channels[state.fold(name)]
+	if !ok {
+		return nil
+	}
+	return state.members(ch)
+}
+
+// members builds the sorted member list of a channel. Callers must hold mu.
+func (t *tracker) members(ch *channel) []Member {
+	members := make([]Member, 0, len(ch.members))
+	for _, m := range ch.members {
+		modes := t.sortedModes(m)
+		prefix := ""
+		if modes != "" {
+			prefix = t.symbolFor(modes[0])
+		}
+		members = append(members, Member{Nick: m.nick, Modes: modes, Prefix: prefix})
+	}
+
+	rank := func(m Member) int {
+		if m.Modes == "" {
+			return len(t.prefixModes)
+		}
+		return strings.IndexByte(t.prefixModes, m.Modes[0])
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if rank(members[i]) != rank(members[j]) {
+			return rank(members[i]) < rank(members[j])
+		}
+		return t.fold(members[i].Nick) < t.fold(members[j].Nick)
+	})
+	return members
+}
+
+// modeString formats a channel's modes as "+modes params". Callers must hold mu.
+func (t *tracker) modeString(ch *channel) string {
+	if len(ch.modes) == 0 {
+		return ""
+	}
+
+	flags := make([]byte, 0, len(ch.modes))
+	for mode := range ch.modes {
+		flags = append(flags, mode)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i] < flags[j] })
+
+	var params []string
+	for _, mode := range flags {
+		if ch.modes[mode] != "" {
+			params = append(params, ch.modes[mode])
+		}
+	}
+
+	result := "+" + string(flags)
+	if len(params) > 0 {
+		result += " " + strings.Join(params, " ")
+	}
+	return result
+}
+
+// IsOnChannel reports whether nick is in the channel
+func IsOnChannel(channelName, nick string) bool {
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+
+	ch, ok := state.channels[state.fold(channelName)]
+	if !ok {
+		return false
+	}
+	_, ok = ch.members[state.fold(nick)]
+	return ok
+}
+
+// HasStatus reports whether nick has the given status mode (e.g. 'o', 'v')
+// in the channel
+func HasStatus(channelName, nick string, mode byte) bool {
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+
+	ch, ok := state.channels[state.fold(channelName)]
+	if !ok {
+		return false
+	}
+	m, ok := ch.members[state.fold(nick)]
+	return ok && m.modes[mode]
+}
+
+// IsOp reports whether nick has operator status or higher (e.g. +a, +q)
+// in the channel
+func IsOp(channelName, nick string) bool {
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+	return state.isOp(channelName, nick)
+}
+
+// isOp implements IsOp. Callers must hold mu.
+func (t *tracker) isOp(channelName, nick string) bool {
+	ch, ok := t.channels[t.fold(channelName)]
+	if !ok {
+		return false
+	}
+	m, ok := ch.members[t.fold(nick)]
+	if !ok {
+		return false
+	}
+
+	opRank := strings.IndexByte(t.prefixModes, 'o')
+	for mode := range m.modes {
+		rank := strings.IndexByte(t.prefixModes, mode)
+		if rank >= 0 && (opRank < 0 || rank <= opRank) {
+			return true
+		}
+	}
+	return false
+}
+
+// BotHasOp reports whether the bot has operator status in the channel
+func BotHasOp(channelName string) bool {
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+	return state.isOp(channelName, state.me)
+}
+
+// GetUser returns what is known about a user sharing a channel with the bot
+func GetUser(nick string) (UserInfo, bool) {
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+
+	u, ok := state.users[state.fold(nick)]
+	if !ok {
+		return UserInfo{}, false
+	}
+	return UserInfo{Nick: u.nick, User: u.user, Host: u.host, Account: u.account, Away: u.away}, true
+}
+
+// CurrentNick returns the bot's nick as seen by the tracker
+func CurrentNick() string {
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+	return state.me
+}
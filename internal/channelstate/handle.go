@@ -0,0 +1,441 @@
+package channelstate
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/irc.v4"
+	"ircbot/internal"
+)
+
+// WhoxToken identifies the replies to the bot's own WHOX queries
+const WhoxToken = "391"
+
+// WhoReply is a parsed RPL_WHOREPLY or RPL_WHOSPCRPL line
+type WhoReply struct {
+	Channel string
+	User    string
+	Host    string
+	Nick    string
+	Flags   string
+	// Account is empty if the user isn't logged in or WHOX isn't available
+	Account string
+
+	// fromWhox is set when the reply carries account information
+	fromWhox bool
+}
+
+// RequestChannelInfo asks the server for the modes of a channel the bot
+// just joined and for its members, using WHOX when the server supports it
+// so accounts are included
+func RequestChannelInfo(c *irc.Client, channelName string) error {
+	if err := c.Writef("%s %s", internal.CMD_MODE, channelName); err != nil {
+		return err
+	}
+
+	if c.ISupport != nil && c.ISupport.IsEnabled("WHOX") {
+		return c.Writef("WHO %s %%tcuhnfa,%s", channelName, WhoxToken)
+	}
+	return c.Writef("WHO %s", channelName)
+}
+
+// ParseWhoxReply parses a reply to the bot's WHOX query:
+// 354 <me> <token> <channel> <user> <host> <nick> <flags> <account>
+func ParseWhoxReply(m *irc.Message) (WhoReply, bool) {
+	if m.Command != internal.RPL_WHOSPCRPL || len(m.Params) < 8 || m.Params[1] != WhoxToken {
+		return WhoReply{}, false
+	}
+
+	account := m.Params[7]
+	// WHOX reports "0" for users that aren't logged in
+	if account == "0" {
+		account = ""
+	}
+
+	return WhoReply{
+		Channel: m.Params[2],
+		User:    m.Params[3],
+		Host:    m.Params[4],
+		Nick:    m.Params[5],
+		Flags:   m.Params[6],
+		Account: account,
+
+		fromWhox: true,
+	}, true
+}
+
+// parseWhoReply parses a standard WHO reply:
+// 352 <me> <channel> <user> <host> <server> <nick> <flags> :<hopcount> <realname>
+func parseWhoReply(m *irc.Message) (WhoReply, bool) {
+	if len(m.Params) < 7 {
+		return WhoReply{}, false
+	}
+	return WhoReply{
+		Channel: m.Params[1],
+		User:    m.Params[2],
+		Host:    m.Params[3],
+		Nick:    m.Params[5],
+		Flags:   m.Params[6],
+	}, true
+}
+
+// Handle updates the channel state from a message received from the server
+func Handle(m *irc.Message) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	switch m.Command {
+	case internal.RPL_WELCOME:
+		if len(m.Params) > 0 {
+			state.me = m.Params[0]
+		}
+	case internal.RPL_ISUPPORT:
+		state.handleISupport(m)
+	case internal.CMD_JOIN:
+		state.handleJoin(m)
+	case internal.CMD_PART:
+		if m.Prefix != nil && len(m.Params) > 0 {
+			state.removeMember(m.Params[0], m.Prefix.Name)
+		}
+	case internal.CMD_KICK:
+		if len(m.Params) > 1 {
+			state.removeMember(m.Params[0], m.Params[1])
+		}
+	case internal.CMD_QUIT:
+		if m.Prefix != nil {
+			state.handleQuit(m.Prefix.Name)
+		}
+	case internal.CMD_NICK:
+		if m.Prefix != nil && len(m.Params) > 0 {
+			state.handleNick(m.Prefix.Name, m.Params[0])
+		}
+	case internal.CMD_MODE:
+		if len(m.Params) > 1 {
+			state.applyModes(m.Params[0], m.Params[1], m.Params[2:])
+		}
+	case internal.RPL_CHANNELMODEIS:
+		// 324 <me> <channel> <modes> [params...]
+		if len(m.Params) > 2 {
+			if ch, ok := state.channels[state.fold(m.Params[1])]; ok {
+				ch.modes = make(map[byte]string)
+			}
+			state.applyModes(m.Params[1], m.Params[2], m.Params[3:])
+		}
+	case internal.CMD_TOPIC:
+		if len(m.Params) < 1 || m.Prefix == nil {
+			break
+		}
+		if ch, ok := state.channels[state.fold(m.Params[0])]; ok {
+			ch.topic = m.Trailing()
+			ch.topicSetBy = m.Prefix.Name
+			ch.topicSetAt = time.Now()
+		}
+	case internal.RPL_TOPIC:
+		if len(m.Params) > 2 {
+			if ch, ok := state.channels[state.fold(m.Params[1])]; ok {
+				ch.topic = m.Trailing()
+			}
+		}
+	case internal.RPL_NOTOPIC:
+		if len(m.Params) > 1 {
+			if ch, ok := state.channels[state.fold(m.Params[1])]; ok {
+				ch.topic = ""
+			}
+		}
+	case internal.RPL_TOPICWHOTIME:
+		if len(m.Params) > 3 {
+			if ch, ok := state.channels[state.fold(m.Params[1])]; ok {
+				ch.topicSetBy = strings.Split(m.Params[2], "!")[0]
+				if ts, err := strconv.ParseInt(m.Params[3], 10, 64); err == nil {
+					ch.topicSetAt = time.Unix(ts, 0)
+				}
+			}
+		}
+	case internal.RPL_NAMREPLY:
+		state.handleNames(m)
+	case internal.RPL_ENDOFNAMES:
+		if len(m.Params) > 1 {
+			if ch, ok := state.channels[state.fold(m.Params[1])]; ok {
+				ch.namesPending = false
+			}
+		}
+	case internal.RPL_WHOREPLY:
+		if reply, ok := parseWhoReply(m); ok {
+			state.applyWhoReply(reply)
+		}
+	case internal.RPL_WHOSPCRPL:
+		if reply, ok := ParseWhoxReply(m); ok {
+			state.applyWhoReply(reply)
+		}
+	case internal.CMD_ACCOUNT:
+		if m.Prefix != nil && len(m.Params) > 0 {
+			if u, ok := state.users[state.fold(m.Prefix.Name)]; ok {
+				u.account = strings.TrimPrefix(m.Params[0], "*")
+			}
+		}
+	case internal.CMD_AWAY:
+		if m.Prefix != nil {
+			if u, ok := state.users[state.fold(m.Prefix.Name)]; ok {
+				u.away = m.Trailing() != ""
+			}
+		}
+	case internal.CMD_CHGHOST:
+		// CHGHOST <new user> <new host>
+		if m.Prefix != nil && len(m.Params) > 1 {
+			if u, ok := state.users[state.fold(m.Prefix.Name)]; ok {
+				u.user, u.host = m.Params[0], m.Params[1]
+			}
+		}
+	}
+}
+
+// handleISupport reads PREFIX, CHANMODES and CASEMAPPING. Callers must hold the write lock.
+func (t *tracker) handleISupport(m *irc.Message) {
+	if len(m.Params) < 2 {
+		return
+	}
+
+	for _, param := range m.Params[1 : len(m.Params)-1] {
+		key, value, _ := strings.Cut(param, "=")
+		switch key {
+		case "PREFIX":
+			// PREFIX=(qaohv)~&@%+
+			modes, symbols, ok := strings.Cut(strings.TrimPrefix(value, "("), ")")
+			if ok && len(modes) == len(symbols) {
+				t.prefixModes = modes
+				t.prefixSymbols = symbols
+			}
+		case "CHANMODES":
+			// CHANMODES=A,B,C,D
+			types := strings.Split(value, ",")
+			if len(types) >= 3 {
+				t.listModes = types[0]
+				t.paramModes = types[1]
+				t.setParamModes = types[2]
+			}
+		case "CASEMAPPING":
+			t.casemapping = strings.ToLower(value)
+		}
+	}
+}
+
+// handleJoin adds a member to a channel, creating the channel when the bot
+// itself joins. Callers must hold the write lock.
+func (t *tracker) handleJoin(m *irc.Message) {
+	if m.Prefix == nil || len(m.Params) < 1 {
+		return
+	}
+
+	nick := m.Prefix.Name
+	key := t.fold(m.Params[0])
+	ch, ok := t.channels[key]
+	if !ok {
+		if !t.isMe(nick) {
+			return
+		}
+		ch = &channel{
+			name:    m.Params[0],
+			modes:   make(map[byte]string),
+			members: make(map[string]*member),
+		}
+		t.channels[key] = ch
+	}
+
+	ch.members[t.fold(nick)] = &member{nick: nick, modes: make(map[byte]bool)}
+
+	u := t.getUser(nick)
+	if m.Prefix.User != "" {
+		u.user = m.Prefix.User
+	}
+	if m.Prefix.Host != "" {
+		u.host = m.Prefix.Host
+	}
+	// extended-join: JOIN <channel> <account> :<realname>
+	if len(m.Params) > 2 {
+		u.account = strings.TrimPrefix(m.Params[1], "*")
+	}
+}
+
+// removeMember removes a nick from a channel, or the whole channel when it
+// is the bot leaving. Callers must hold the write lock.
+func (t *tracker) removeMember(channelName, nick string) {
+	key := t.fold(channelName)
+	ch, ok := t.channels[key]
+	if !ok {
+		return
+	}
+
+	if t.isMe(nick) {
+		delete(t.channels, key)
+		for _, m := range ch.members {
+			t.forgetUserIfGone(m.nick)
+		}
+		return
+	}
+
+	delete(ch.members, t.fold(nick))
+	t.forgetUserIfGone(nick)
+}
+
+// handleQuit removes a nick from every channel. Callers must hold the write lock.
+func (t *tracker) handleQuit(nick string) {
+	key := t.fold(nick)
+	for _, ch := range t.channels {
+		delete(ch.members, key)
+	}
+	delete(t.users, key)
+}
+
+// handleNick renames a nick everywhere. Callers must hold the write lock.
+func (t *tracker) handleNick(oldNick, newNick string) {
+	if t.isMe(oldNick) {
+		t.me = newNick
+	}
+
+	oldKey, newKey := t.fold(oldNick), t.fold(newNick)
+	for _, ch := range t.channels {
+		if m, ok := ch.members[oldKey]; ok {
+			delete(ch.members, oldKey)
+			m.nick = newNick
+			ch.members[newKey] = m
+		}
+	}
+	if u, ok := t.users[oldKey]; ok {
+		delete(t.users, oldKey)
+		u.nick = newNick
+		t.users[newKey] = u
+	}
+}
+
+// applyModes applies a channel MODE change, using PREFIX and CHANMODES to
+// know which modes take a parameter. Callers must hold the write lock.
+func (t *tracker) applyModes(target, modes string, params []string) {
+	ch, ok := t.channels[t.fold(target)]
+	if !ok {
+		// User modes, or a channel we're not in
+		return
+	}
+
+	adding := true
+	nextParam := func() string {
+		if len(params) == 0 {
+			return ""
+		}
+		param := params[0]
+		params = params[1:]
+		return param
+	}
+
+	for i := 0; i < len(modes); i++ {
+		mode := modes[i]
+		switch {
+		case mode == '+':
+			adding = true
+		case mode == '-':
+			adding = false
+		case strings.IndexByte(t.prefixModes, mode) >= 0:
+			nick := nextParam()
+			if m, ok := ch.members[t.fold(nick)]; ok {
+				if adding {
+					m.modes[mode] = true
+				} else {
+					delete(m.modes, mode)
+				}
+			}
+		case strings.IndexByte(t.listModes, mode) >= 0:
+			// Ban/exception lists aren't tracked, only their parameter consumed
+			nextParam()
+		case strings.IndexByte(t.paramModes, mode) >= 0:
+			param := nextParam()
+			if adding {
+				ch.modes[mode] = param
+			} else {
+				delete(ch.modes, mode)
+			}
+		case strings.IndexByte(t.setParamModes, mode) >= 0:
+			if adding {
+				ch.modes[mode] = nextParam()
+			} else {
+				delete(ch.modes, mode)
+			}
+		default:
+			if adding {
+				ch.modes[mode] = ""
+			} else {
+				delete(ch.modes, mode)
+			}
+		}
+	}
+}
+
+// handleNames adds the members listed in RPL_NAMREPLY, including their
+// status prefixes (multi-prefix) and hostmasks (userhost-in-names):
+// 353 <me> <symbol> <channel> :[prefix]nick[!user@host] ...
+// Callers must hold the write lock.
+func (t *tracker) handleNames(m *irc.Message) {
+	if len(m.Params) < 4 {
+		return
+	}
+
+	ch, ok := t.channels[t.fold(m.Params[2])]
+	if !ok {
+		return
+	}
+
+	if !ch.namesPending {
+		ch.namesPending = true
+		ch.members = make(map[string]*member)
+	}
+
+	for _, entry := range strings.Fields(m.Trailing()) {
+		modes := make(map[byte]bool)
+		for len(entry) > 0 {
+			i := strings.IndexByte(t.prefixSymbols, entry[0])
+			if i < 0 {
+				break
+			}
+			modes[t.prefixModes[i]] = true
+			entry = entry[1:]
+		}
+
+		nick, userhost, _ := strings.Cut(entry, "!")
+		if nick == "" {
+			continue
+		}
+		ch.members[t.fold(nick)] = &member{nick: nick, modes: modes}
+
+		u := t.getUser(nick)
+		if userName, host, ok := strings.Cut(userhost, "@"); ok {
+			u.user, u.host = userName, host
+		}
+	}
+}
+
+// applyWhoReply updates a user's details and status from a WHO/WHOX reply.
+// Callers must hold the write lock.
+func (t *tracker) applyWhoReply(reply WhoReply) {
+	u := t.getUser(reply.Nick)
+	u.user = reply.User
+	u.host = reply.Host
+	if reply.fromWhox {
+		u.account = reply.Account
+	}
+	// Flags are H (here) or G (gone), optionally * for opers, then prefixes
+	u.away = strings.HasPrefix(reply.Flags, "G")
+
+	ch, ok := t.channels[t.fold(reply.Channel)]
+	if !ok {
+		return
+	}
+	m, ok := ch.members[t.fold(reply.Nick)]
+	if !ok {
+		m = &member{nick: reply.Nick, modes: make(map[byte]bool)}
+		ch.members[t.fold(reply.Nick)] = m
+	}
+	for i := 0; i < len(reply.Flags); i++ {
+		if j := strings.IndexByte(t.prefixSymbols, reply.Flags[i]); j >= 0 {
+			m.modes[t.prefixModes[j]] = true
+		}
+	}
+}
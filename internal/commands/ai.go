@@ -6,9 +6,13 @@ import (
 	"ircbot/internal"
 	"ircbot/internal/ai"
 	"ircbot/internal/ai/tools"
+	"ircbot/internal/channelstate"
 	"ircbot/internal/config"
+	"ircbot/internal/events"
 	"ircbot/internal/logger"
+	"ircbot/internal/userlevels"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
@@ -16,21 +20,40 @@ import (
 // HandleAIResponse processes an AI query and sends the response to IRC
 // This function is exported so it can be used from other packages
 func HandleAIResponse(c *irc.Client, channel string, nick string, question string, replyTarget string) {
+	HandleAIResponseWithContext(c, channel, nick, nick, question, "", replyTarget)
+}
+
+// HandleAIResponseWithContext is HandleAIResponse with recent channel
+// lines the AI may draw on, which aren't kept in its conversation memory.
+// The hostmask decides which daily token quota applies, and the tokens
+// count against the nick's account or the hostmask's user@host.
+func HandleAIResponseWithContext(c *irc.Client, channel string, nick string, hostmask string, question string, channelContext string, replyTarget string) {
 	// Log that we're processing an AI request (to AI log file instead of error.log)
 	logger.AIDebugf("Processing AI request from %s in %s: %s", nick, channel, question)
 
-	// Format the question with context - improved prompt format
-	formattedQuestion := "Reply to this user query in a direct conversational style. Don't mention yourself in third person or explain what you're doing. Keep your response concise and don't add unnecessary follow-up questions at the end.\n\n" +
-		"USER QUERY: " + question + "\n\n" +
-		"CURRENT CHANNEL: " + channel
+	identity := ai.UsageIdentity(nick, hostmask)
+	if err := ai.CheckQuota(nick, identity, channel, userlevels.GetEffectiveLevel(channel, hostmask)); err != nil {
+		logger.AIDebugf("Refused AI request from %s in %s: %v", nick, channel, err)
+		c.Writef("%s %s :Sorry, %v. It resets at midnight.", internal.CMD_PRIVMSG, replyTarget, err)
+		return
+	}
+
+	// Instructions for this reply, kept out of the remembered question
+	instructions := "Reply to the user query in a direct conversational style. Don't mention yourself in third person or explain what you're doing. Keep your response concise and don't add unnecessary follow-up questions at the end."
+	if channelContext != "" {
+		instructions += "\n\nCHANNEL CONTEXT (for your awareness only, don't reference this directly):\n" + channelContext
+	}
 
 	var response string
 	var err error
 
+	// The reply is sent as it's generated
+	stream := newAIReplyStream(c, replyTarget)
+
 	// Special case handling for "use your channel log tool"
-	if strings.HasPrefix(strings.ToLower(formattedQuestion), "use your channel log tool") {
+	if strings.HasPrefix(strings.ToLower(question), "use your channel log tool") {
 		// Extract channel name from the command
-		channelName := extractChannelName(formattedQuestion)
+		channelName := extractChannelName(question)
 		if channelName == "" && channel != c.CurrentNick() {
 			// Default to current channel if no channel specified
 			channelName = channel
@@ -39,6 +62,7 @@ func HandleAIResponse(c *irc.Client, channel string, nick string, question strin
 		if channelName != "" {
 			response, err = handleChannelLogTool(channelName)
 			if err != nil {
+				stream.stop()
 				c.Writef("%s %s :Error accessing channel logs: %v", internal.CMD_PRIVMSG, replyTarget, err)
 				return
 			}
@@ -57,120 +81,308 @@ func HandleAIResponse(c *irc.Client, channel string, nick string, question strin
 		}
 		
 		// Normal AI processing for other queries with channel personality
-		response, err = ai.ProcessMessage(formattedQuestion, channelPersonality, channel, nick)
+		response, err = ai.StreamMessage(question, instructions, channelPersonality, channel, nick, identity, stream.write)
 	}
 
 	if err != nil {
+		stream.stop()
 		logger.Errorf("Error processing AI request: %v", err)
 		c.Writef("%s %s :Error processing AI request: %v", internal.CMD_PRIVMSG, replyTarget, err)
 		return
 	}
 
 	// Handle empty responses
-	if response == "" {
+	if response == "" && !stream.received() {
+		stream.stop()
 		logger.Warnf("Received empty response from AI processing")
 		errorMsg := "The AI completed your request but didn't provide a text response."
 		c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, errorMsg)
-		// Log the bot's error message
-		logger.LogBotChannelMessage(replyTarget, c.CurrentNick(), errorMsg)
 		return
 	}
 
-	// Sanitize the response for IRC
-	cleanedResponses := sanitizeForIRC(response)
-	if len(cleanedResponses) == 0 {
-		logger.Warnf("Sanitized response is empty")
-		emptyMsg := "The AI completed your request but returned an empty response."
-		c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, emptyMsg)
-		// Log the bot's error message
-		logger.LogBotChannelMessage(replyTarget, c.CurrentNick(), emptyMsg)
+	// Send what's left of the reply, or all of it if it wasn't streamed
+	response = stream.finish(response)
+
+	events.Publish(events.SourceCore, events.TopicAIResponse, events.AIResponse{
+		Channel:  channel,
+		Nick:     nick,
+		Question: question,
+		Response: response,
+	})
+
+	// Log that we've completed processing
+	logger.AIDebugf("Completed AI request for %s in %s", nick, channel)
+}
+
+func aiCmd(c *irc.Client, m *irc.Message, args []string) {
+	channel := m.Params[0]
+	nick := m.Prefix.Name
+	replyTarget := m.Params[0]
+	if replyTarget == c.CurrentNick() {
+		replyTarget = m.Prefix.Name
+	}
+
+	if len(args) == 0 {
+		usage := "Usage: !ai <your question or message> | !ai usage [nick|#channel] | !ai forget [nick|all] | !ai context [nick]"
+		c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, usage)
 		return
 	}
 
-	// Constants for IRC response
-	const maxIRCMessages = 3
-	messageDelay := 800 * time.Millisecond
-
-	// If response is longer than maxIRCMessages, use paste service
-	if len(cleanedResponses) > maxIRCMessages {
-		pasteURL, pasteErr := PasteService(response)
-
-		if pasteErr != nil {
-			// If paste service fails, fallback to truncated IRC messages
-			logger.Warnf("Paste service error: %v", pasteErr)
-			
-			// First message
-			firstMsg := cleanedResponses[0]
-			c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, firstMsg)
-			// Log the bot's message
-			logger.LogBotChannelMessage(replyTarget, c.CurrentNick(), firstMsg)
-
-			// Send some of the remaining messages with delay
-			for i := 1; i < len(cleanedResponses) && i < maxIRCMessages; i++ {
-				time.Sleep(messageDelay)
-				nextMsg := cleanedResponses[i]
-				c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, nextMsg)
-				// Log each of the bot's messages
-				logger.LogBotChannelMessage(replyTarget, c.CurrentNick(), nextMsg)
-			}
+	// Anyone can see their own usage
+	if len(args) == 1 && strings.EqualFold(args[0], "usage") {
+		aiUsage(c, channel, nick, m.Prefix.String(), nil, replyTarget)
+		return
+	}
 
-			// Add note about truncation
-			if len(cleanedResponses) > maxIRCMessages {
-				time.Sleep(messageDelay)
-				truncMsg := fmt.Sprintf("(Response truncated, %d more parts omitted)", 
-					len(cleanedResponses)-maxIRCMessages)
-				c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, truncMsg)
-				// Log the truncation notice
-				logger.LogBotChannelMessage(replyTarget, c.CurrentNick(), truncMsg)
+	// Admins can manage the AI's memory and see anyone's usage; anyone
+	// else is asking a question
+	if len(args) <= 2 && userlevels.HasPermission(m.Prefix.String(), userlevels.Admin) {
+		switch strings.ToLower(args[0]) {
+		case "forget":
+			aiForget(c, channel, nick, args[1:], replyTarget)
+			return
+		case "context":
+			aiContext(c, channel, nick, args[1:], replyTarget)
+			return
+		case "usage":
+			aiUsage(c, channel, nick, m.Prefix.String(), args[1:], replyTarget)
+			return
+		}
+	}
+
+	question := strings.Join(args, " ")
+
+	// Use the shared AI response handler
+	HandleAIResponseWithContext(c, channel, nick, m.Prefix.String(), question, "", replyTarget)
+}
+
+// aiUsage reports the tokens a nick (by default the caller) or a channel
+// used, their estimated cost and what's left of today's quota
+func aiUsage(c *irc.Client, channel, nick, hostmask string, args []string, replyTarget string) {
+	target := nick
+	identity := ai.UsageIdentity(nick, hostmask)
+	level := userlevels.GetEffectiveLevel(channel, hostmask)
+	showQuota := true
+	if len(args) > 0 {
+		target, identity = args[0], ""
+		if !strings.HasPrefix(target, "#") {
+			// Another user's quota follows the level of their hostmask, which
+			// is only known if they share a channel with the bot. Without it
+			// their usage is looked up by nick and the quota is left out.
+			showQuota = false
+			if user, ok := channelstate.GetUser(target); ok && user.User != "" && user.Host != "" {
+				identity = ai.UsageIdentity(user.Nick, user.Hostmask())
+				level = userlevels.GetEffectiveLevel(channel, user.Hostmask())
+				showQuota = true
 			}
+		}
+	}
+
+	report := ai.GetUsageReport(target, identity, level)
+	if !showQuota {
+		report.Quota = 0
+	}
+	if report.Tokens == 0 {
+		c.Writef("%s %s :No AI usage recorded for %s since %s", internal.CMD_PRIVMSG, replyTarget,
+			target, report.Since.Format("2006-01-02"))
+		return
+	}
+
+	today := fmt.Sprintf("%d tokens today", report.Today)
+	if report.Quota > 0 {
+		today = fmt.Sprintf("%d of %d tokens today", report.Today, report.Quota)
+	}
+	summary := fmt.Sprintf("AI usage of %s: %s, %d tokens since %s, about $%.2f",
+		target, today, report.Tokens, report.Since.Format("2006-01-02"), report.Cost)
+
+	var models []string
+	unpriced := false
+	for _, m := range report.Models {
+		if m.Priced {
+			models = append(models, fmt.Sprintf("%s %d+%d tokens $%.2f", m.Model, m.PromptTokens, m.CompletionTokens, m.Cost))
 		} else {
-			// Send summary and paste URL
-			pasteMsg := fmt.Sprintf("My full response at %s", pasteURL)
-			c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, pasteMsg)
-			// Log the paste URL message
-			logger.LogBotChannelMessage(replyTarget, c.CurrentNick(), pasteMsg)
+			models = append(models, fmt.Sprintf("%s %d+%d tokens (no price)", m.Model, m.PromptTokens, m.CompletionTokens))
+			unpriced = true
 		}
-	} else {
-		// For shorter responses, send directly to IRC with delay between messages
-		firstMsg := cleanedResponses[0]
-		c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, firstMsg)
-		// Log the bot's first message
-		logger.LogBotChannelMessage(replyTarget, c.CurrentNick(), firstMsg)
-
-		// Send remaining messages with delay
-		for i := 1; i < len(cleanedResponses); i++ {
-			time.Sleep(messageDelay)
-			nextMsg := cleanedResponses[i]
-			c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, nextMsg)
-			// Log each of the bot's messages
-			logger.LogBotChannelMessage(replyTarget, c.CurrentNick(), nextMsg)
+	}
+	if unpriced {
+		summary += " (some models unpriced)"
+	}
+
+	topTools := make([]string, 0, len(report.ByTool))
+	for tool := range report.ByTool {
+		topTools = append(topTools, tool)
+	}
+	sort.Slice(topTools, func(i, j int) bool {
+		return report.ByTool[topTools[i]] > report.ByTool[topTools[j]]
+	})
+	if len(topTools) > 3 {
+		topTools = topTools[:3]
+	}
+	for i, tool := range topTools {
+		topTools[i] = fmt.Sprintf("%s %d", tool, report.ByTool[tool])
+	}
+
+	c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, summary)
+	c.Writef("%s %s :By model (prompt+completion): %s", internal.CMD_PRIVMSG, replyTarget, strings.Join(models, ", "))
+	if len(topTools) > 0 {
+		c.Writef("%s %s :Top tools: %s", internal.CMD_PRIVMSG, replyTarget, strings.Join(topTools, ", "))
+	}
+}
+
+// aiForget clears the AI's conversation with a nick (by default the
+// caller) in this channel, or with everyone for "all"
+func aiForget(c *irc.Client, channel, nick string, args []string, replyTarget string) {
+	target := nick
+	if len(args) > 0 {
+		target = args[0]
+	}
+
+	if strings.EqualFold(target, "all") {
+		count := ai.ForgetConversation(channel, "")
+		c.Writef("%s %s :Forgot %d conversation(s) in %s", internal.CMD_PRIVMSG, replyTarget, count, channel)
+		return
+	}
+
+	if ai.ForgetConversation(channel, target) == 0 {
+		c.Writef("%s %s :I don't remember a conversation with %s here", internal.CMD_PRIVMSG, replyTarget, target)
+		return
+	}
+	logger.Infof("%s cleared the AI conversation with %s in %s", nick, target, channel)
+	c.Writef("%s %s :Forgot my conversation with %s", internal.CMD_PRIVMSG, replyTarget, target)
+}
+
+// aiContext shows what the AI remembers of its conversation with a nick
+// (by default the caller) in this channel
+func aiContext(c *irc.Client, channel, nick string, args []string, replyTarget string) {
+	target := nick
+	if len(args) > 0 {
+		target = args[0]
+	}
+
+	conv, ok := ai.GetConversation(channel, target)
+	if !ok {
+		c.Writef("%s %s :I don't remember a conversation with %s here", internal.CMD_PRIVMSG, replyTarget, target)
+		return
+	}
+
+	var lines []string
+	lines = append(lines, fmt.Sprintf("Conversation with %s in %s: %d turns, about %d tokens, last active %s",
+		conv.Nick, conv.Channel, len(conv.Turns), conv.Tokens(), conv.Updated.Format("2006-01-02 15:04")))
+	if conv.Summary != "" {
+		lines = append(lines, "Summary: "+conv.Summary)
+	}
+	for _, turn := range conv.Turns {
+		switch {
+		case turn.Role == ai.RoleTool:
+			lines = append(lines, fmt.Sprintf("[tool %s] %s", turn.Name, turn.Content))
+		case len(turn.ToolCalls) > 0:
+			for _, call := range turn.ToolCalls {
+				lines = append(lines, fmt.Sprintf("[%s calls %s] %s", turn.Role, call.Function.Name, call.Function.Arguments))
+			}
+		default:
+			lines = append(lines, fmt.Sprintf("[%s] %s", turn.Role, turn.Content))
 		}
 	}
 
-	// Log that we've completed processing
-	logger.AIDebugf("Completed AI request for %s in %s", nick, channel)
+	// The whole conversation rarely fits in a few IRC lines
+	if len(lines) > 3 {
+		pasteURL, err := PasteService(strings.Join(lines, "\n"))
+		if err == nil {
+			c.Writef("%s %s :%s - %s", internal.CMD_PRIVMSG, replyTarget, lines[0], pasteURL)
+			return
+		}
+		logger.Warnf("Paste service error: %v", err)
+		lines = lines[:1]
+	}
+	for _, line := range lines {
+		for _, part := range sanitizeForIRC(line) {
+			c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, part)
+		}
+	}
 }
 
-func aiCmd(c *irc.Client, m *irc.Message, args []string) {
-	channel := m.Params[0]
-	nick := m.Prefix.Name
+// aiConfigCmd shows and changes the AI settings, bot-wide or for a channel.
+// Changes override the [ai] section of config.toml and are kept across
+// restarts.
+func aiConfigCmd(c *irc.Client, m *irc.Message, args []string) {
 	replyTarget := m.Params[0]
 	if replyTarget == c.CurrentNick() {
 		replyTarget = m.Prefix.Name
 	}
+	usage := "Usage: !aiconfig [#channel] get [key] | set <key> <value> | reset <key>"
+
+	channel := ""
+	if len(args) > 0 && strings.HasPrefix(args[0], "#") {
+		channel = args[0]
+		args = args[1:]
+	}
+	scope := "bot-wide"
+	if channel != "" {
+		scope = "for " + channel
+	}
 
 	if len(args) == 0 {
-		usage := "Usage: !ai <your question or message>"
 		c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, usage)
-		// Log the bot's message
-		logger.LogBotChannelMessage(replyTarget, c.CurrentNick(), usage)
 		return
 	}
 
-	question := strings.Join(args, " ")
+	switch strings.ToLower(args[0]) {
+	case "get":
+		keys := ai.SettingNames
+		if len(args) > 1 {
+			keys = []string{args[1]}
+		}
+		var values []string
+		for _, key := range keys {
+			value, source, err := ai.GetSetting(channel, key)
+			if err != nil {
+				// Listing everything for a channel skips the bot-wide settings
+				if len(args) > 1 {
+					c.Writef("%s %s :Error: %v", internal.CMD_PRIVMSG, replyTarget, err)
+					return
+				}
+				continue
+			}
+			values = append(values, fmt.Sprintf("%s=%s (%s)", key, value, source))
+		}
+		for _, line := range sanitizeForIRC(fmt.Sprintf("AI settings %s: %s", scope, strings.Join(values, ", "))) {
+			c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, line)
+		}
 
-	// Use the shared AI response handler
-	HandleAIResponse(c, channel, nick, question, replyTarget)
+	case "set":
+		if len(args) < 3 {
+			c.Writef("%s %s :Usage: !aiconfig [#channel] set <key> <value>", internal.CMD_PRIVMSG, replyTarget)
+			return
+		}
+		key := args[1]
+		value := strings.Join(args[2:], " ")
+
+		if err := ai.SetSetting(channel, key, value); err != nil {
+			c.Writef("%s %s :Error: %v", internal.CMD_PRIVMSG, replyTarget, err)
+			return
+		}
+		logger.Infof("%s set AI setting %s=%s %s", m.Prefix.Name, key, value, scope)
+		c.Writef("%s %s :Set AI setting '%s=%s' %s", internal.CMD_PRIVMSG, replyTarget, key, value, scope)
+
+	case "reset":
+		if len(args) < 2 {
+			c.Writef("%s %s :Usage: !aiconfig [#channel] reset <key>", internal.CMD_PRIVMSG, replyTarget)
+			return
+		}
+		key := args[1]
+
+		if err := ai.ResetSetting(channel, key); err != nil {
+			c.Writef("%s %s :Error: %v", internal.CMD_PRIVMSG, replyTarget, err)
+			return
+		}
+		value, source, _ := ai.GetSetting(channel, key)
+		logger.Infof("%s reset AI setting %s %s", m.Prefix.Name, key, scope)
+		c.Writef("%s %s :Reset AI setting %s %s, now %s (%s)", internal.CMD_PRIVMSG, replyTarget, key, scope, value, source)
+
+	default:
+		c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, usage)
+	}
 }
 
 // Note: sanitizeForIRC is already defined in helpers.go
@@ -242,8 +454,6 @@ func personalityCmd(c *irc.Client, m *irc.Message, args []string) {
 	if BotConfig == nil {
 		errorMsg := "Bot configuration is not available"
 		c.Writef("%s %s :%s", internal.CMD_PRIVMSG, m.Params[0], errorMsg)
-		// Log the bot's message
-		logger.LogBotChannelMessage(m.Params[0], c.CurrentNick(), errorMsg)
 		return
 	}
 
@@ -262,14 +472,10 @@ func personalityCmd(c *irc.Client, m *irc.Message, args []string) {
 			logger.Errorf("Error saving channel settings: %v", err)
 			errorMsg := fmt.Sprintf("Error saving channel settings: %v", err)
 			c.Writef("%s %s :%s", internal.CMD_PRIVMSG, channel, errorMsg)
-			// Log the bot's error message
-			logger.LogBotChannelMessage(channel, c.CurrentNick(), errorMsg)
 			return
 		}
 		successMsg := "Channel personality has been cleared"
 		c.Writef("%s %s :%s", internal.CMD_PRIVMSG, channel, successMsg)
-		// Log the bot's message
-		logger.LogBotChannelMessage(channel, c.CurrentNick(), successMsg)
 		return
 	}
 
@@ -282,13 +488,9 @@ func personalityCmd(c *irc.Client, m *irc.Message, args []string) {
 		logger.Errorf("Error saving channel settings: %v", err)
 		errorMsg := fmt.Sprintf("Error saving channel settings: %v", err)
 		c.Writef("%s %s :%s", internal.CMD_PRIVMSG, channel, errorMsg)
-		// Log the bot's error message
-		logger.LogBotChannelMessage(channel, c.CurrentNick(), errorMsg)
 		return
 	}
 
 	successMsg := "Channel personality has been set"
 	c.Writef("%s %s :%s", internal.CMD_PRIVMSG, channel, successMsg)
-	// Log the bot's message
-	logger.LogBotChannelMessage(channel, c.CurrentNick(), successMsg)
 }
\ No newline at end of file
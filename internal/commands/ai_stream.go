@@ -0,0 +1,182 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/irc.v4"
+	"ircbot/internal"
+	"ircbot/internal/logger"
+)
+
+// maxIRCMessages is how many messages an AI reply may take in the channel;
+// longer replies go to the paste service
+const maxIRCMessages = 3
+
+var (
+	// aiAnsweringDelay is how long the AI may take to start replying before
+	// the bot says it's working on it
+	aiAnsweringDelay = 5 * time.Second
+	// aiStreamChunk is how much text is gathered before it's sent, so a
+	// reply isn't spread over many short messages
+	aiStreamChunk = 350
+	// aiStreamInterval is how long text is gathered at most
+	aiStreamInterval = 2 * time.Second
+)
+
+// aiReplyStream sends an AI reply to IRC while it is being generated, a few
+// complete sentences at a time
+type aiReplyStream struct {
+	c           *irc.Client
+	replyTarget string
+	indicator   *time.Timer
+
+	mu        sync.Mutex
+	text      strings.Builder
+	pending   string
+	sent      int
+	pasting   bool
+	lastFlush time.Time
+	stopped   bool
+}
+
+func newAIReplyStream(c *irc.Client, replyTarget string) *aiReplyStream {
+	s := &aiReplyStream{
+		c:           c,
+		replyTarget: replyTarget,
+		lastFlush:   time.Now(),
+	}
+	s.indicator = time.AfterFunc(aiAnsweringDelay, s.answering)
+	return s
+}
+
+// answering tells the channel a slow reply is on its way
+func (s *aiReplyStream) answering() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stopped && s.text.Len() == 0 {
+		s.c.Writef("%s %s :answering…", internal.CMD_PRIVMSG, s.replyTarget)
+	}
+}
+
+// received reports whether any text has arrived
+func (s *aiReplyStream) received() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.text.Len() > 0
+}
+
+// stop ends the stream without sending what's left of it
+func (s *aiReplyStream) stop() {
+	s.indicator.Stop()
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+}
+
+// write adds text to the reply, sending the complete sentences once enough
+// of them have gathered
+func (s *aiReplyStream) write(text string) {
+	s.indicator.Stop()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.text.WriteString(text)
+	s.pending += text
+	if s.pasting || s.stopped {
+		return
+	}
+
+	end := sentenceEnd(s.pending)
+	if end == 0 || (end < aiStreamChunk && time.Since(s.lastFlush) < aiStreamInterval) {
+		return
+	}
+	if s.send(sanitizeForIRC(s.pending[:end])) {
+		s.pending = s.pending[end:]
+	}
+}
+
+// send writes lines to IRC, unless they'd take the reply over
+// maxIRCMessages. From then on the reply is gathered for the paste service.
+// Must be called with mu held.
+func (s *aiReplyStream) send(lines []string) bool {
+	if s.sent+len(lines) > maxIRCMessages {
+		s.pasting = true
+		return false
+	}
+	for _, line := range lines {
+		s.c.Writef("%s %s :%s", internal.CMD_PRIVMSG, s.replyTarget, line)
+	}
+	s.sent += len(lines)
+	s.lastFlush = time.Now()
+	return true
+}
+
+// finish sends the rest of the reply, or the whole response if nothing was
+// streamed, and returns the reply's full text
+func (s *aiReplyStream) finish(response string) string {
+	s.indicator.Stop()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+
+	if s.text.Len() == 0 {
+		s.text.WriteString(response)
+		s.pending = response
+	}
+	full := s.text.String()
+
+	lines := sanitizeForIRC(s.pending)
+	if !s.pasting {
+		if s.sent == 0 && len(lines) == 0 {
+			logger.Warnf("Sanitized response is empty")
+			s.c.Writef("%s %s :%s", internal.CMD_PRIVMSG, s.replyTarget, "The AI completed your request but returned an empty response.")
+			return full
+		}
+		if s.send(lines) {
+			return full
+		}
+	}
+
+	pasteURL, err := PasteService(full)
+	if err == nil {
+		s.c.Writef("%s %s :My full response at %s", internal.CMD_PRIVMSG, s.replyTarget, pasteURL)
+		return full
+	}
+
+	// Without the paste service, the reply is cut short
+	logger.Warnf("Paste service error: %v", err)
+	for len(lines) > 0 && s.sent < maxIRCMessages {
+		s.c.Writef("%s %s :%s", internal.CMD_PRIVMSG, s.replyTarget, lines[0])
+		lines = lines[1:]
+		s.sent++
+	}
+	if len(lines) > 0 {
+		s.c.Writef("%s %s :%s", internal.CMD_PRIVMSG, s.replyTarget,
+			fmt.Sprintf("(Response truncated, %d more parts omitted)", len(lines)))
+	}
+	return full
+}
+
+// sentenceEnd returns where the last complete sentence or line of text
+// ends, or 0 if there is none yet. Code blocks aren't split, as they're
+// cleaned up for IRC as a whole.
+func sentenceEnd(text string) int {
+	end := 0
+	for i := 0; i < len(text)-1; i++ {
+		switch text[i] {
+		case '\n':
+			end = i + 1
+		case '.', '!', '?':
+			if text[i+1] == ' ' || text[i+1] == '\n' {
+				end = i + 1
+			}
+		}
+	}
+	for end > 0 && strings.Count(text[:end], "```")%2 == 1 {
+		end = sentenceEnd(text[:strings.LastIndex(text[:end], "```")])
+	}
+	return end
+}
@@ -2,11 +2,26 @@ package commands
 
 import (
 	"strings"
+	"time"
 	
 	"gopkg.in/irc.v4"
-	"ircbot/internal/logger"
+	"ircbot/internal"
+	"ircbot/internal/autojoin"
+	"ircbot/internal/channelstate"
+	"ircbot/internal/health"
+	"ircbot/internal/userlevels"
 )
 
+// requireOp checks that the bot has operator status in the channel before
+// sending a command the server would reject, and tells the user if it doesn't
+func requireOp(c *irc.Client, channel string) bool {
+	if channelstate.BotHasOp(channel) {
+		return true
+	}
+	c.Writef("PRIVMSG %s :I need operator status in %s to do that.", channel, channel)
+	return false
+}
+
 // Basic IRC Commands
 // Channel Operator Commands
 func opCmd(c *irc.Client, m *irc.Message, args []string) {
@@ -14,6 +29,9 @@ func opCmd(c *irc.Client, m *irc.Message, args []string) {
 		c.Writef("PRIVMSG %s :Usage: !op <nick>", m.Params[0])
 		return
 	}
+	if !requireOp(c, m.Params[0]) {
+		return
+	}
 	target := args[0]
 	c.Write("MODE " + m.Params[0] + " +o " + target)
 }
@@ -23,6 +41,9 @@ func deopCmd(c *irc.Client, m *irc.Message, args []string) {
 		c.Writef("PRIVMSG %s :Usage: !deop <nick>", m.Params[0])
 		return
 	}
+	if !requireOp(c, m.Params[0]) {
+		return
+	}
 	target := args[0]
 	c.Write("MODE " + m.Params[0] + " -o " + target)
 }
@@ -32,6 +53,9 @@ func voiceCmd(c *irc.Client, m *irc.Message, args []string) {
 		c.Writef("PRIVMSG %s :Usage: !voice <nick>", m.Params[0])
 		return
 	}
+	if !requireOp(c, m.Params[0]) {
+		return
+	}
 	target := args[0]
 	c.Write("MODE " + m.Params[0] + " +v " + target)
 }
@@ -41,6 +65,9 @@ func devoiceCmd(c *irc.Client, m *irc.Message, args []string) {
 		c.Writef("PRIVMSG %s :Usage: !devoice <nick>", m.Params[0])
 		return
 	}
+	if !requireOp(c, m.Params[0]) {
+		return
+	}
 	target := args[0]
 	c.Write("MODE " + m.Params[0] + " -v " + target)
 }
@@ -50,8 +77,16 @@ func kickCmd(c *irc.Client, m *irc.Message, args []string) {
 		c.Writef("PRIVMSG %s :Usage: !kick <nick> [reason]", m.Params[0])
 		return
 	}
+	if !requireOp(c, m.Params[0]) {
+		return
+	}
 
 	target := args[0]
+	if !channelstate.IsOnChannel(m.Params[0], target) {
+		c.Writef("PRIVMSG %s :%s is not in %s", m.Params[0], target, m.Params[0])
+		return
+	}
+
 	reason := "Kicked by " + m.Prefix.Name
 	if len(args) > 1 {
 		reason = strings.Join(args[1:], " ")
@@ -65,6 +100,9 @@ func banCmd(c *irc.Client, m *irc.Message, args []string) {
 		c.Writef("PRIVMSG %s :Usage: !ban <nick|hostmask>", m.Params[0])
 		return
 	}
+	if !requireOp(c, m.Params[0]) {
+		return
+	}
 
 	target := args[0]
 	// If it's a nick and not a hostmask, add wildcards
@@ -80,6 +118,9 @@ func unbanCmd(c *irc.Client, m *irc.Message, args []string) {
 		c.Writef("PRIVMSG %s :Usage: !unban <nick|hostmask>", m.Params[0])
 		return
 	}
+	if !requireOp(c, m.Params[0]) {
+		return
+	}
 
 	target := args[0]
 	// If it's a nick and not a hostmask, add wildcards
@@ -95,6 +136,9 @@ func muteCmd(c *irc.Client, m *irc.Message, args []string) {
 		c.Writef("PRIVMSG %s :Usage: !mute <nick|hostmask>", m.Params[0])
 		return
 	}
+	if !requireOp(c, m.Params[0]) {
+		return
+	}
 
 	target := args[0]
 	// If it's a nick and not a hostmask, add wildcards
@@ -110,6 +154,9 @@ func unmuteCmd(c *irc.Client, m *irc.Message, args []string) {
 		c.Writef("PRIVMSG %s :Usage: !unmute <nick|hostmask>", m.Params[0])
 		return
 	}
+	if !requireOp(c, m.Params[0]) {
+		return
+	}
 
 	target := args[0]
 	// If it's a nick and not a hostmask, add wildcards
@@ -137,8 +184,14 @@ func inviteCmd(c *irc.Client, m *irc.Message, args []string) {
 		}
 		channel = args[1]
 	} else if len(args) > 1 {
-		// If a channel was specified as second argument
+		// If a channel was specified as second argument. A channel grant
+		// only counts in its own channel.
 		channel = args[1]
+		required := commandRegistry["invite"].RequiredLevel
+		if !strings.EqualFold(channel, m.Params[0]) && !userlevels.HasChannelPermission(channel, m.Prefix.String(), required) {
+			c.Writef("PRIVMSG %s :Access denied. Inviting to %s requires %s level there.", m.Params[0], channel, userlevels.LevelName(required))
+			return
+		}
 	}
 
 	c.Writef("INVITE %s %s", target, channel)
@@ -177,12 +230,12 @@ func joinCmd(c *irc.Client, m *irc.Message, args []string) {
 
 	channel := args[0]
 
+	// The key is remembered so the bot can rejoin after a kick
+	key := ""
 	if len(args) > 1 {
-		key := args[1]
-		c.Writef("JOIN %s %s", channel, key)
-	} else {
-		c.Writef("JOIN %s", channel)
+		key = args[1]
 	}
+	autojoin.Join(c, channel, key)
 }
 
 func partCmd(c *irc.Client, m *irc.Message, args []string) {
@@ -222,41 +275,23 @@ func sayCmd(c *irc.Client, m *irc.Message, args []string) {
 	if len(args) < 1 {
 		usageMsg := "Usage: !say <message>"
 		c.Writef("PRIVMSG %s :%s", m.Params[0], usageMsg)
-		// Log the bot's message if it's a channel
-		if strings.HasPrefix(m.Params[0], "#") {
-			logger.LogBotChannelMessage(m.Params[0], c.CurrentNick(), usageMsg)
-		}
 		return
 	}
 
 	message := strings.Join(args, " ")
 	c.Writef("PRIVMSG %s :%s", m.Params[0], message)
-	
-	// Log the bot's message if it's a channel
-	if strings.HasPrefix(m.Params[0], "#") {
-		logger.LogBotChannelMessage(m.Params[0], c.CurrentNick(), message)
-	}
 }
 
 func msgCmd(c *irc.Client, m *irc.Message, args []string) {
 	if len(args) < 2 {
 		usageMsg := "Usage: !msg <target> <message>"
 		c.Writef("PRIVMSG %s :%s", m.Params[0], usageMsg)
-		// Log the bot's message if it's a channel
-		if strings.HasPrefix(m.Params[0], "#") {
-			logger.LogBotChannelMessage(m.Params[0], c.CurrentNick(), usageMsg)
-		}
 		return
 	}
 
 	target := args[0]
 	message := strings.Join(args[1:], " ")
 	c.Writef("PRIVMSG %s :%s", target, message)
-	
-	// Log the bot's message if the target is a channel
-	if strings.HasPrefix(target, "#") {
-		logger.LogBotChannelMessage(target, c.CurrentNick(), message)
-	}
 }
 
 func noticeCmd(c *irc.Client, m *irc.Message, args []string) {
@@ -274,21 +309,12 @@ func actionCmd(c *irc.Client, m *irc.Message, args []string) {
 	if len(args) < 1 {
 		usageMsg := "Usage: !action <message>"
 		c.Writef("PRIVMSG %s :%s", m.Params[0], usageMsg)
-		// Log the bot's message if it's a channel
-		if strings.HasPrefix(m.Params[0], "#") {
-			logger.LogBotChannelMessage(m.Params[0], c.CurrentNick(), usageMsg)
-		}
 		return
 	}
 
 	action := strings.Join(args, " ")
 	// CTCP ACTION format
 	c.Writef("PRIVMSG %s :\x01ACTION %s\x01", m.Params[0], action)
-	
-	// Log the bot's action if it's a channel
-	if strings.HasPrefix(m.Params[0], "#") {
-		logger.LogChannelAction(m.Params[0], c.CurrentNick(), action)
-	}
 }
 
 // Information Commands
@@ -299,9 +325,32 @@ func whoisCmd(c *irc.Client, m *irc.Message, args []string) {
 	}
 
 	target := args[0]
+	replyTarget := m.Params[0]
+	if replyTarget == c.CurrentNick() {
+		replyTarget = m.Prefix.Name
+	}
+	expectWhois(target, replyTarget)
 	c.Writef("WHOIS %s", target)
 }
 
+// statusCmd shows the connection health: server, lag, uptime and reconnects
+func statusCmd(c *irc.Client, m *irc.Message, args []string) {
+	replyTarget := m.Params[0]
+	if replyTarget == c.CurrentNick() {
+		replyTarget = m.Prefix.Name
+	}
+
+	stats := health.Get()
+	lag := "unknown"
+	if stats.Lag > 0 {
+		lag = stats.Lag.Round(time.Millisecond).String()
+	}
+
+	c.Writef("%s %s :Server: %s (connected %s) | Lag: %s | Uptime: %s | Reconnects: %d",
+		internal.CMD_PRIVMSG, replyTarget, stats.Server, formatDuration(stats.ConnectionUptime()),
+		lag, formatDuration(stats.Uptime()), stats.Reconnects)
+}
+
 func modeCmd(c *irc.Client, m *irc.Message, args []string) {
 	if len(args) < 1 {
 		c.Writef("PRIVMSG %s :Usage: !mode <target> [modes]", m.Params[0])
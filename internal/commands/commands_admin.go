@@ -24,50 +24,35 @@ func setLevelCmd(c *irc.Client, m *irc.Message, args []string) {
 	}
 
 	if len(args) < 2 {
-		c.Writef("%s %s :Usage: !setlevel <user> <level>", internal.CMD_PRIVMSG, replyTarget)
+		c.Writef("%s %s :Usage: !setlevel [#channel] <nick|mask|account:name> <level>", internal.CMD_PRIVMSG, replyTarget)
 		c.Writef("%s %s :Available levels: owner, admin, regular, badboy, ignored", internal.CMD_PRIVMSG, replyTarget)
 		return
 	}
 
+	if strings.HasPrefix(args[0], "#") || strings.HasPrefix(args[0], "&") {
+		setChannelLevelCmd(c, m, replyTarget, args)
+		return
+	}
+
 	target := args[0]
 	levelStr := strings.ToLower(args[1])
 
-	currentLevel := userlevels.Regular
-
-	allHostmasks := userlevels.GetAllHostmasks()
-	for hostmask, level := range allHostmasks {
-		if strings.HasPrefix(hostmask, target+"!") {
-			if level > currentLevel {
-				currentLevel = level
-			}
-		}
+	// A target containing ! or @ is a (wildcard) mask and is stored as given
+	isMask := strings.ContainsAny(target, "!@")
+	probe := target
+	if !isMask {
+		probe = target + "!*@*"
 	}
 
+	currentLevel, matchedBy := userlevels.MatchUserLevel(probe)
 	currentLevelName := userlevels.LevelName(currentLevel)
-
-	var level userlevels.UserLevel
-	var validLevel bool
-
-	switch levelStr {
-	case "owner":
-		level = userlevels.Owner
-		validLevel = true
-	case "admin":
-		level = userlevels.Admin
-		validLevel = true
-	case "regular", "user", "normal":
-		level = userlevels.Regular
-		validLevel = true
-	case "badboy", "bad":
-		level = userlevels.BadBoy
-		validLevel = true
-	case "ignored", "ignore":
-		level = userlevels.Ignored
-		validLevel = true
-	default:
-		validLevel = false
+	if matchedBy != "" {
+		currentLevelName += " (matched " + matchedBy + ")"
 	}
 
+	allHostmasks := userlevels.GetAllHostmasks()
+
+	level, validLevel := parseLevelName(levelStr)
 	if !validLevel {
 		c.Writef("%s %s :Unknown level: %s. Available levels: owner, admin, regular, badboy, ignored",
 			internal.CMD_PRIVMSG, replyTarget, levelStr)
@@ -80,6 +65,11 @@ func setLevelCmd(c *irc.Client, m *irc.Message, args []string) {
 		return
 	}
 
+	if strings.HasPrefix(strings.ToLower(target), userlevels.AccountPrefix) {
+		setAccountLevel(c, replyTarget, target[len(userlevels.AccountPrefix):], level)
+		return
+	}
+
 	var targetHostmasks []string
 	for hostmask := range allHostmasks {
 		if strings.HasPrefix(hostmask, target+"!") {
@@ -87,10 +77,16 @@ func setLevelCmd(c *irc.Client, m *irc.Message, args []string) {
 		}
 	}
 
-	if len(targetHostmasks) == 0 {
+	if isMask {
+		userlevels.SetUserLevelByHostmask(target, level)
+	} else if account := userlevels.GetNickAccount(target); account != "" && len(targetHostmasks) == 0 {
+		// A logged in nick is granted by account, which can't be taken over
+		userlevels.SetUserLevel(target, level)
+
+		c.Writef("%s %s :%s is logged in as %s. Setting the level of %s%s.",
+			internal.CMD_PRIVMSG, replyTarget, target, account, userlevels.AccountPrefix, account)
+	} else if len(targetHostmasks) == 0 {
 		wildcardHostmask := target + "!*@*"
-		userlevels.SetUserLevelByHostmask(wildcardHostmask, level)
-		
 		userlevels.SetUserLevel(target, level)
 		
 		c.Writef("%s %s :No hostmask found for user %s. Setting wildcard hostmask (%s).",
@@ -111,6 +107,123 @@ func setLevelCmd(c *irc.Client, m *irc.Message, args []string) {
 
 	c.Writef("%s %s :User %s level changed from %s to %s",
 		internal.CMD_PRIVMSG, replyTarget, target, currentLevelName, userlevels.LevelName(level))
+
+	if isMask {
+		warnNickGrant(c, replyTarget, target)
+	} else if userlevels.GetNickAccount(target) == "" {
+		warnNickGrant(c, replyTarget, target+"!*@*")
+	}
+}
+
+// warnNickGrant explains that a grant by nick alone only applies while the
+// nick is logged in to the account named after it, which a grouped nick never is
+func warnNickGrant(c *irc.Client, replyTarget, mask string) {
+	if !userlevels.NickGrantNeedsAccount(mask) {
+		return
+	}
+	nick := strings.Split(mask, "!")[0]
+	c.Writef("%s %s :Note: this server reports accounts, so %s only applies while %s is logged in to the account %s. For a grouped nick use !setlevel account:<name> instead.",
+		internal.CMD_PRIVMSG, replyTarget, mask, nick, nick)
+}
+
+// parseLevelName converts a level name given to !setlevel into a UserLevel
+func parseLevelName(levelStr string) (userlevels.UserLevel, bool) {
+	switch strings.ToLower(levelStr) {
+	case "owner":
+		return userlevels.Owner, true
+	case "admin":
+		return userlevels.Admin, true
+	case "regular", "user", "normal":
+		return userlevels.Regular, true
+	case "badboy", "bad":
+		return userlevels.BadBoy, true
+	case "ignored", "ignore":
+		return userlevels.Ignored, true
+	default:
+		return userlevels.Regular, false
+	}
+}
+
+// setChannelLevelCmd handles !setlevel #channel <nick|mask|account:name> <level|remove>,
+// which grants a level that only applies within that channel
+func setChannelLevelCmd(c *irc.Client, m *irc.Message, replyTarget string, args []string) {
+	if len(args) < 3 {
+		c.Writef("%s %s :Usage: !setlevel #channel <nick|mask|account:name> <level|remove>", internal.CMD_PRIVMSG, replyTarget)
+		c.Writef("%s %s :Available channel levels: admin, regular, badboy, ignored", internal.CMD_PRIVMSG, replyTarget)
+		return
+	}
+
+	if !userlevels.HasPermission(m.Prefix.String(), userlevels.Owner) {
+		c.Writef("%s %s :You need owner privileges to change user levels.", internal.CMD_PRIVMSG, replyTarget)
+		return
+	}
+
+	channel := args[0]
+	mask := args[1]
+	levelStr := strings.ToLower(args[2])
+
+	// Bare nicks are stored as nick!*@*, masks and accounts as given
+	if !strings.ContainsAny(mask, "!@") && !strings.HasPrefix(strings.ToLower(mask), userlevels.AccountPrefix) {
+		mask += "!*@*"
+	}
+
+	if levelStr == "remove" || levelStr == "delete" {
+		if !userlevels.RemoveChannelUserLevel(channel, mask) {
+			c.Writef("%s %s :No level set for %s in %s", internal.CMD_PRIVMSG, replyTarget, mask, channel)
+			return
+		}
+		if err := userlevels.SaveHostmasks(); err != nil {
+			logger.Errorf("Failed to save channel user levels: %v", err)
+			c.Writef("%s %s :Error saving user levels: %v", internal.CMD_PRIVMSG, replyTarget, err)
+			return
+		}
+		c.Writef("%s %s :Removed channel level for %s in %s", internal.CMD_PRIVMSG, replyTarget, mask, channel)
+		return
+	}
+
+	level, validLevel := parseLevelName(levelStr)
+	if !validLevel || level == userlevels.Owner {
+		c.Writef("%s %s :Unknown channel level: %s. Available channel levels: admin, regular, badboy, ignored",
+			internal.CMD_PRIVMSG, replyTarget, levelStr)
+		return
+	}
+
+	userlevels.SetChannelUserLevel(channel, mask, level)
+
+	if err := userlevels.SaveHostmasks(); err != nil {
+		logger.Errorf("Failed to save channel user levels: %v", err)
+		c.Writef("%s %s :Error saving user levels: %v", internal.CMD_PRIVMSG, replyTarget, err)
+		return
+	}
+
+	c.Writef("%s %s :%s is now %s in %s",
+		internal.CMD_PRIVMSG, replyTarget, mask, userlevels.LevelName(level), channel)
+	warnNickGrant(c, replyTarget, mask)
+}
+
+// setAccountLevel grants a level to a services account, which applies to
+// whoever is logged in to it regardless of nick or hostmask
+func setAccountLevel(c *irc.Client, replyTarget, account string, level userlevels.UserLevel) {
+	if account == "" {
+		c.Writef("%s %s :Usage: !setlevel account:<name> <level>", internal.CMD_PRIVMSG, replyTarget)
+		return
+	}
+
+	currentLevelName := "none"
+	if currentLevel, ok := userlevels.GetAccountLevel(account); ok {
+		currentLevelName = userlevels.LevelName(currentLevel)
+	}
+
+	userlevels.SetUserLevelByAccount(account, level)
+
+	if err := userlevels.SaveHostmasks(); err != nil {
+		logger.Errorf("Failed to save account levels: %v", err)
+		c.Writef("%s %s :Error saving user levels: %v", internal.CMD_PRIVMSG, replyTarget, err)
+		return
+	}
+
+	c.Writef("%s %s :Account %s level changed from %s to %s",
+		internal.CMD_PRIVMSG, replyTarget, account, currentLevelName, userlevels.LevelName(level))
 }
 
 func restartCmd(c *irc.Client, m *irc.Message, args []string) {
@@ -5,20 +5,23 @@ import (
 )
 
 func init() {
+	// Channel commands count channel grants in the channel they are given
+	// in; every other command needs the global level
+
 	// Channel Operator Commands - Admin level
-	RegisterCommand("op", "Give channel operator status to a user", userlevels.Admin, opCmd)
-	RegisterCommand("deop", "Remove channel operator status from a user", userlevels.Admin, deopCmd)
-	RegisterCommand("voice", "Give voice status to a user in a channel", userlevels.Admin, voiceCmd)
-	RegisterCommand("devoice", "Remove voice status from a user in a channel", userlevels.Admin, devoiceCmd)
-	RegisterCommand("kick", "Kick a user from the channel", userlevels.Admin, kickCmd)
-	RegisterCommand("ban", "Ban a user or hostmask from the channel", userlevels.Admin, banCmd)
-	RegisterCommand("unban", "Remove a ban on a user or hostmask", userlevels.Admin, unbanCmd)
-	RegisterCommand("mute", "Mute a user or hostmask in the channel", userlevels.Admin, muteCmd)
-	RegisterCommand("unmute", "Unmute a user or hostmask in the channel", userlevels.Admin, unmuteCmd)
+	RegisterChannelCommand("op", "Give channel operator status to a user", userlevels.Admin, opCmd)
+	RegisterChannelCommand("deop", "Remove channel operator status from a user", userlevels.Admin, deopCmd)
+	RegisterChannelCommand("voice", "Give voice status to a user in a channel", userlevels.Admin, voiceCmd)
+	RegisterChannelCommand("devoice", "Remove voice status from a user in a channel", userlevels.Admin, devoiceCmd)
+	RegisterChannelCommand("kick", "Kick a user from the channel", userlevels.Admin, kickCmd)
+	RegisterChannelCommand("ban", "Ban a user or hostmask from the channel", userlevels.Admin, banCmd)
+	RegisterChannelCommand("unban", "Remove a ban on a user or hostmask", userlevels.Admin, unbanCmd)
+	RegisterChannelCommand("mute", "Mute a user or hostmask in the channel", userlevels.Admin, muteCmd)
+	RegisterChannelCommand("unmute", "Unmute a user or hostmask in the channel", userlevels.Admin, unmuteCmd)
 
 	// Channel Commands - Admin level
-	RegisterCommand("invite", "Invite a user to a channel", userlevels.Admin, inviteCmd)
-	RegisterCommand("topic", "View or change the channel topic", userlevels.Admin, topicCmd)
+	RegisterChannelCommand("invite", "Invite a user to a channel", userlevels.Admin, inviteCmd)
+	RegisterChannelCommand("topic", "View or change the channel topic", userlevels.Admin, topicCmd)
 	RegisterCommand("join", "Make the bot join a channel", userlevels.Admin, joinCmd)
 	RegisterCommand("part", "Make the bot leave a channel", userlevels.Admin, partCmd)
 
@@ -26,20 +29,21 @@ func init() {
 	RegisterCommand("nick", "Change the bot's nickname", userlevels.Owner, nickCmd)
 
 	// Message Commands - Regular level and Admin level
-	RegisterCommand("say", "Make the bot say something in the current channel", userlevels.Regular, sayCmd)
+	RegisterChannelCommand("say", "Make the bot say something in the current channel", userlevels.Regular, sayCmd)
 	RegisterCommand("msg", "Send a private message to a user or channel", userlevels.Admin, msgCmd)
 	RegisterCommand("notice", "Send a notice to a user or channel", userlevels.Admin, noticeCmd)
-	RegisterCommand("action", "Make the bot perform an action (/me) in the channel", userlevels.Regular, actionCmd)
+	RegisterChannelCommand("action", "Make the bot perform an action (/me) in the channel", userlevels.Regular, actionCmd)
 
 	// Information Commands - Regular level
 	RegisterCommand("whois", "Get information about a user", userlevels.Regular, whoisCmd)
+	RegisterCommand("status", "Show connection status: server, lag, uptime and reconnects", userlevels.Regular, statusCmd)
 	RegisterCommand("mode", "View or set modes for a user or channel", userlevels.Admin, modeCmd)
 
 	// Regular user group commands
 	RegisterCommand("help", "Show available commands", userlevels.Regular, helpCmd)
 	RegisterCommand("test", "Test command", userlevels.Regular, testCommand)
-	RegisterCommand("ai", "Ask a question to the AI assistant", userlevels.Regular, aiCmd)
-	RegisterCommand("personality", "Set a channel-specific personality for the AI", userlevels.Admin, personalityCmd)
+	RegisterCommand("ai", "Ask a question to the AI assistant. !ai usage shows your token usage (admins: !ai usage [nick|#channel], !ai forget [nick|all], !ai context [nick])", userlevels.Regular, aiCmd)
+	RegisterChannelCommand("personality", "Set a channel-specific personality for the AI", userlevels.Admin, personalityCmd)
 	RegisterCommand("note", "Manage personal notes for AI interactions", userlevels.Regular, noteCommand)
 
 	// Admin user group commands
@@ -47,8 +51,12 @@ func init() {
 	RegisterCommand("plugins", "List all available plugins", userlevels.Regular, listPlugins)
 	RegisterCommand("load", "Load a plugin. Usage: !load <pluginName>", userlevels.Admin, loadPluginCmd)
 	RegisterCommand("unload", "Unload a plugin. Usage: !unload <pluginName>", userlevels.Admin, unloadPluginCmd)
-	RegisterCommand("load-online", "Download and load a plugin from URL. Usage: !load-online <URL>", userlevels.Admin, loadOnlinePluginCmd)
+	RegisterCommand("plugin", "Show, roll back or pin plugin versions. Usage: !plugin versions|rollback|pin|unpin <name> [version]", userlevels.Admin, pluginCmd)
+	RegisterCommand("load-online", "Download, verify and load a plugin from an allowed URL. Usage: !load-online <URL> [sha256:<checksum>] | confirm | cancel | history [plugin] | rollback <id>", userlevels.Admin, loadOnlinePluginCmd)
 	RegisterCommand("channel", "Manage channel-specific settings", userlevels.Admin, channelCmd)
+	RegisterCommand("tasks", "List scheduled plugin tasks. Usage: !tasks [cancel <id>]", userlevels.Admin, tasksCmd)
+	RegisterCommand("pluginconfig", "Show or change plugin settings. Usage: !pluginconfig <plugin> list|get <key>|set <key> <value>", userlevels.Admin, pluginConfigCmd)
+	RegisterCommand("aiconfig", "Show or change AI settings. Usage: !aiconfig [#channel] get [key] | set <key> <value> | reset <key>", userlevels.Admin, aiConfigCmd)
 
 	// Owner user group commands
 	RegisterCommand("setlevel", "Set a user's level. Usage: !setlevel <user> <level>", userlevels.Owner, setLevelCmd)
@@ -1,23 +1,25 @@
 package commands
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/irc.v4"
 	"ircbot/internal"
+	"ircbot/internal/installer"
 	"ircbot/internal/logger"
 	"ircbot/internal/plugin"
+	"ircbot/internal/scheduler"
 	"ircbot/internal/userlevels"
+	"ircbot/pkg/api"
 )
 
 func listPlugins(c *irc.Client, m *irc.Message, args []string) {
@@ -48,7 +50,8 @@ func listPlugins(c *irc.Client, m *irc.Message, args []string) {
 	basePluginMap := make(map[string]pluginStatus)
 
 	for _, file := range files {
-		if filepath.Ext(file.Name()) == ".so" {
+		isRPC := plugin.IsRPCPluginFile(filepath.Join(pluginDir, file.Name()))
+		if filepath.Ext(file.Name()) == ".so" || isRPC {
 			filename := file.Name()
 
 			baseName := filename
@@ -121,6 +124,27 @@ func listPlugins(c *irc.Client, m *irc.Message, args []string) {
 			return
 		}
 	}
+
+	// Report commands that more than one plugin, or a plugin and the bot, provide
+	for _, collision := range plugin.GetCommandCollisions() {
+		c.Writef("%s %s :[!] %s", internal.CMD_PRIVMSG, replyTarget, collision)
+	}
+
+	// Report plugins that have been failing
+	for _, h := range plugin.GetPluginHealth() {
+		if h.Panics == 0 && h.Timeouts == 0 && h.Dropped == 0 {
+			continue
+		}
+		status := "[!]"
+		if h.Disabled {
+			status = "[✗] disabled,"
+		}
+		message := fmt.Sprintf("%s %s: %d panics, %d timeouts, %d dropped", status, h.Name, h.Panics, h.Timeouts, h.Dropped)
+		if h.LastError != "" {
+			message += fmt.Sprintf(" - last error %s ago: %s", formatDuration(time.Since(h.LastErrorAt)), h.LastError)
+		}
+		c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, message)
+	}
 }
 
 func loadPluginCmd(c *irc.Client, m *irc.Message, args []string) {
@@ -138,6 +162,10 @@ func loadPluginCmd(c *irc.Client, m *irc.Message, args []string) {
 	pluginName = strings.TrimSuffix(pluginName, ".so")
 
 	pluginPath := filepath.Join("./plugins", pluginName+".so")
+	// Out-of-process plugins are plain executables without an extension
+	if rpcPath := filepath.Join("./plugins", pluginName); plugin.IsRPCPluginFile(rpcPath) {
+		pluginPath = rpcPath
+	}
 	if _, err := os.Stat(pluginPath); os.IsNotExist(err) {
 		c.Writef("%s %s :Plugin file %s.so not found. Available plugins:", internal.CMD_PRIVMSG, replyTarget, pluginName)
 
@@ -149,6 +177,8 @@ func loadPluginCmd(c *irc.Client, m *irc.Message, args []string) {
 					if !strings.Contains(file.Name(), "_v") {
 						availablePlugins = append(availablePlugins, strings.TrimSuffix(file.Name(), ".so"))
 					}
+				} else if plugin.IsRPCPluginFile(filepath.Join("./plugins", file.Name())) {
+					availablePlugins = append(availablePlugins, file.Name())
 				}
 			}
 			if len(availablePlugins) > 0 {
@@ -237,6 +267,35 @@ func reloadPluginsCmd(c *irc.Client, m *irc.Message, args []string) {
 	}
 }
 
+// pendingInstallTimeout is how long a fetched plugin waits for confirmation
+const pendingInstallTimeout = 10 * time.Minute
+
+// pendingInstall is a verified plugin source waiting for !load-online confirm
+type pendingInstall struct {
+	source  *installer.Source
+	expires time.Time
+}
+
+var (
+	// pendingInstalls holds the fetched sources by the hostmask that asked for them
+	pendingInstalls   = make(map[string]*pendingInstall)
+	pendingInstallsMu sync.Mutex
+
+	// installMu is held while a plugin is built and loaded
+	installMu sync.Mutex
+)
+
+// installPolicy returns the configured rules for online plugin sources
+func installPolicy() *installer.Policy {
+	policy := &installer.Policy{}
+	if BotConfig != nil {
+		policy.AllowedDomains = BotConfig.PluginAllowedDomains
+		policy.TrustedKeys = BotConfig.PluginTrustedKeys
+		policy.Timeout = time.Duration(BotConfig.PluginDownloadTimeout) * time.Second
+	}
+	return policy
+}
+
 func loadOnlinePluginCmd(c *irc.Client, m *irc.Message, args []string) {
 	replyTarget := m.Params[0]
 	if replyTarget == c.CurrentNick() {
@@ -250,172 +309,503 @@ func loadOnlinePluginCmd(c *irc.Client, m *irc.Message, args []string) {
 	}
 
 	if len(args) < 1 {
-		c.Writef("%s %s :Usage: !load-online <plugin URL>", internal.CMD_PRIVMSG, replyTarget)
+		c.Writef("%s %s :Usage: !load-online <https URL> [sha256:<checksum>] | confirm | cancel | history [plugin] | rollback <id>", internal.CMD_PRIVMSG, replyTarget)
 		return
 	}
 
-	pluginURL := args[0]
+	// Downloads and builds take a while, so they run in the background
+	// and report back when done
+	switch strings.ToLower(args[0]) {
+	case "confirm":
+		go confirmOnlinePlugin(c, m, replyTarget)
+	case "cancel":
+		pendingInstallsMu.Lock()
+		_, exists := pendingInstalls[hostmask]
+		delete(pendingInstalls, hostmask)
+		pendingInstallsMu.Unlock()
+		if exists {
+			c.Writef("%s %s :Plugin installation cancelled", internal.CMD_PRIVMSG, replyTarget)
+		} else {
+			c.Writef("%s %s :No plugin installation is waiting for confirmation", internal.CMD_PRIVMSG, replyTarget)
+		}
+	case "history":
+		pluginName := ""
+		if len(args) > 1 {
+			pluginName = args[1]
+		}
+		onlinePluginHistory(c, replyTarget, pluginName)
+	case "rollback":
+		if len(args) < 2 {
+			c.Writef("%s %s :Usage: !load-online rollback <installation id>", internal.CMD_PRIVMSG, replyTarget)
+			return
+		}
+		go rollbackOnlinePlugin(c, m, replyTarget, args[1])
+	default:
+		checksum := ""
+		if len(args) > 1 {
+			checksum = args[1]
+		}
+		go fetchOnlinePlugin(c, m, replyTarget, args[0], checksum)
+	}
+}
 
-	if !strings.HasPrefix(pluginURL, "http://") && !strings.HasPrefix(pluginURL, "https://") {
-		c.Writef("%s %s :Invalid URL. Must start with http:// or https://", internal.CMD_PRIVMSG, replyTarget)
+// fetchOnlinePlugin downloads and verifies a plugin source, shows how it
+// differs from the installed source and waits for confirmation
+func fetchOnlinePlugin(c *irc.Client, m *irc.Message, replyTarget, pluginURL, checksum string) {
+	policy := installPolicy()
+	if len(policy.AllowedDomains) == 0 {
+		c.Writef("%s %s :Online plugin installation is disabled: no plugin_allowed_domains are configured", internal.CMD_PRIVMSG, replyTarget)
+		return
+	}
+	if _, err := policy.CheckURL(pluginURL); err != nil {
+		c.Writef("%s %s :%v", internal.CMD_PRIVMSG, replyTarget, err)
 		return
 	}
 
-	urlPath := strings.Split(pluginURL, "/")
-	fileName := urlPath[len(urlPath)-1]
-	pluginName := strings.TrimSuffix(fileName, ".go")
+	c.Writef("%s %s :Downloading plugin from %s...", internal.CMD_PRIVMSG, replyTarget, pluginURL)
 
-	tempDir := filepath.Join(os.TempDir(), fmt.Sprintf("mbot_plugin_%d", time.Now().UnixNano()))
-	err := os.MkdirAll(tempDir, 0755)
+	src, err := policy.Fetch(pluginURL, checksum)
 	if err != nil {
-		logger.Errorf("Error creating temp directory: %v", err)
-		c.Writef("%s %s :Error creating temp directory: %v", internal.CMD_PRIVMSG, replyTarget, err)
+		logger.Errorf("Rejected plugin from %s: %v", pluginURL, err)
+		c.Writef("%s %s :Plugin rejected: %v", internal.CMD_PRIVMSG, replyTarget, err)
 		return
 	}
-	defer func(path string) {
-		err := os.RemoveAll(path)
-		if err != nil {
-			logger.Warnf("Error removing temp directory: %v", err)
-		}
-	}(tempDir)
 
-	tempFilePath := filepath.Join(tempDir, fileName)
+	installedPath := filepath.Join("./plugins_src", src.FileName)
+	installed, err := os.ReadFile(installedPath)
+	if err != nil && !os.IsNotExist(err) {
+		logger.Warnf("Error reading installed source %s: %v", installedPath, err)
+	}
 
-	c.Writef("%s %s :Downloading plugin from %s...", internal.CMD_PRIVMSG, replyTarget, pluginURL)
+	c.Writef("%s %s :Fetched %s (sha256:%s), verified by %s", internal.CMD_PRIVMSG, replyTarget,
+		src.FileName, src.SHA256, src.Verification)
 
-	resp, err := http.Get(pluginURL)
-	if err != nil {
-		logger.Errorf("Error downloading plugin: %v", err)
-		c.Writef("%s %s :Error downloading plugin: %v", internal.CMD_PRIVMSG, replyTarget, err)
+	diff, stat := installer.Diff(installedPath, src.URL, installed, src.Data)
+	switch {
+	case installed == nil:
+		c.Writef("%s %s :New plugin, %d lines", internal.CMD_PRIVMSG, replyTarget, stat.Added)
+	case stat.Added == 0 && stat.Removed == 0:
+		c.Writef("%s %s :Identical to the installed source", internal.CMD_PRIVMSG, replyTarget)
+	default:
+		c.Writef("%s %s :Changes to the installed source: +%d -%d lines", internal.CMD_PRIVMSG, replyTarget, stat.Added, stat.Removed)
+	}
+	if stat.Added > 0 || stat.Removed > 0 {
+		showPluginDiff(c, replyTarget, diff)
+	}
+
+	pendingInstallsMu.Lock()
+	pendingInstalls[m.Prefix.String()] = &pendingInstall{source: src, expires: time.Now().Add(pendingInstallTimeout)}
+	pendingInstallsMu.Unlock()
+
+	c.Writef("%s %s :Review the source, then type !load-online confirm within %s to build and load it, or !load-online cancel",
+		internal.CMD_PRIVMSG, replyTarget, formatDuration(pendingInstallTimeout))
+}
+
+// showPluginDiff posts a diff to the paste service, or shows its start
+// when the paste service is unavailable
+func showPluginDiff(c *irc.Client, replyTarget, diff string) {
+	pasteURL, err := PasteService(diff)
+	if err == nil {
+		c.Writef("%s %s :Diff: %s", internal.CMD_PRIVMSG, replyTarget, pasteURL)
 		return
 	}
-	defer resp.Body.Close()
+	logger.Warnf("Paste service error: %v", err)
+
+	const maxLines = 10
+	lines := strings.Split(strings.TrimSuffix(diff, "\n"), "\n")
+	for i, line := range lines {
+		if i == maxLines {
+			c.Writef("%s %s :(%d more diff lines omitted)", internal.CMD_PRIVMSG, replyTarget, len(lines)-maxLines)
+			break
+		}
+		c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, limitOutput(line, 400))
+	}
+}
+
+// confirmOnlinePlugin builds, loads and records the plugin the user fetched
+func confirmOnlinePlugin(c *irc.Client, m *irc.Message, replyTarget string) {
+	hostmask := m.Prefix.String()
+	pendingInstallsMu.Lock()
+	pending, exists := pendingInstalls[hostmask]
+	delete(pendingInstalls, hostmask)
+	pendingInstallsMu.Unlock()
 
-	if resp.StatusCode != http.StatusOK {
-		logger.Errorf("Error downloading plugin: HTTP %d", resp.StatusCode)
-		c.Writef("%s %s :Error downloading plugin: HTTP %d", internal.CMD_PRIVMSG, replyTarget, resp.StatusCode)
+	if !exists || time.Now().After(pending.expires) {
+		c.Writef("%s %s :No plugin installation is waiting for confirmation", internal.CMD_PRIVMSG, replyTarget)
 		return
 	}
 
-	out, err := os.Create(tempFilePath)
+	src := pending.source
+	installOnlinePlugin(c, replyTarget, installer.Installation{
+		Plugin:       src.Name(),
+		FileName:     src.FileName,
+		URL:          src.URL,
+		SHA256:       src.SHA256,
+		Verification: src.Verification,
+		InstalledBy:  hostmask,
+	}, src.Data)
+}
+
+// rollbackOnlinePlugin builds and loads the archived source of an earlier installation
+func rollbackOnlinePlugin(c *irc.Client, m *irc.Message, replyTarget, idArg string) {
+	id, err := strconv.Atoi(strings.TrimPrefix(idArg, "#"))
 	if err != nil {
-		logger.Errorf("Error creating temp file: %v", err)
-		c.Writef("%s %s :Error creating temp file: %v", internal.CMD_PRIVMSG, replyTarget, err)
+		c.Writef("%s %s :Invalid installation ID: %s", internal.CMD_PRIVMSG, replyTarget, idArg)
 		return
 	}
 
-	_, err = io.Copy(out, resp.Body)
-	out.Close()
+	inst, exists, err := installer.Get(id)
 	if err != nil {
-		logger.Errorf("Error writing plugin source: %v", err)
-		c.Writef("%s %s :Error writing plugin source: %v", internal.CMD_PRIVMSG, replyTarget, err)
+		c.Writef("%s %s :Error reading install ledger: %v", internal.CMD_PRIVMSG, replyTarget, err)
+		return
+	}
+	if !exists {
+		c.Writef("%s %s :No installation #%d", internal.CMD_PRIVMSG, replyTarget, id)
 		return
 	}
 
-	fileContent, err := os.ReadFile(tempFilePath)
+	source, err := installer.ArchivedSource(inst)
 	if err != nil {
-		logger.Errorf("Error reading plugin source: %v", err)
-		c.Writef("%s %s :Error reading plugin source: %v", internal.CMD_PRIVMSG, replyTarget, err)
+		logger.Errorf("Cannot roll back to installation #%d: %v", id, err)
+		c.Writef("%s %s :Cannot roll back to installation #%d: %v", internal.CMD_PRIVMSG, replyTarget, id, err)
 		return
 	}
 
-	hasBuildTag := strings.Contains(string(fileContent), "//go:build")
+	installOnlinePlugin(c, replyTarget, installer.Installation{
+		Plugin:       inst.Plugin,
+		FileName:     inst.FileName,
+		URL:          inst.URL,
+		SHA256:       inst.SHA256,
+		Verification: fmt.Sprintf("archived source of #%d", inst.ID),
+		InstalledBy:  m.Prefix.String(),
+		RollbackOf:   inst.ID,
+	}, source)
+}
 
-	if !hasBuildTag {
-		buildTagContent := fmt.Sprintf("//go:build %s\n// +build %s\n\n%s",
-			pluginName, pluginName, string(fileContent))
+// installOnlinePlugin builds and loads a verified source, saves it in
+// plugins_src and records the installation in the ledger
+func installOnlinePlugin(c *irc.Client, replyTarget string, inst installer.Installation, source []byte) {
+	// One installation at a time, so builds and ledger entries don't interleave
+	installMu.Lock()
+	defer installMu.Unlock()
+
+	c.Writef("%s %s :Compiling plugin %s...", internal.CMD_PRIVMSG, replyTarget, inst.Plugin)
+
+	version, err := buildOnlinePlugin(inst.FileName, source)
+	var loaded *plugin.AlreadyLoadedError
+	if errors.As(err, &loaded) {
+		logger.Infof("Plugin %s version %s is already loaded from the same source", loaded.Name, loaded.Version)
+		c.Writef("%s %s :Plugin %s version %s is already loaded from the same source, nothing to do",
+			internal.CMD_PRIVMSG, replyTarget, loaded.Name, loaded.Version)
+		return
+	}
+	if err != nil {
+		logger.Errorf("Error installing plugin %s: %v", inst.Plugin, err)
+		c.Writef("%s %s :Error installing plugin %s: %s", internal.CMD_PRIVMSG, replyTarget, inst.Plugin, limitOutput(err.Error(), 300))
+		return
+	}
+	inst.Version = version
 
-		err = os.WriteFile(tempFilePath, []byte(buildTagContent), 0644)
-		if err != nil {
-			logger.Errorf("Error adding build tags: %v", err)
-			c.Writef("%s %s :Error adding build tags: %v", internal.CMD_PRIVMSG, replyTarget, err)
-			return
-		}
+	if err := os.MkdirAll("./plugins_src", 0755); err != nil {
+		logger.Warnf("Error creating plugins_src directory: %v", err)
+	} else if err := os.WriteFile(filepath.Join("./plugins_src", inst.FileName), source, 0644); err != nil {
+		logger.Warnf("Error saving plugin source: %v", err)
 	}
 
-	c.Writef("%s %s :Compiling plugin %s...", internal.CMD_PRIVMSG, replyTarget, pluginName)
+	inst, err = installer.Record(inst, source)
+	if err != nil {
+		logger.Errorf("Error recording installation of plugin %s: %v", inst.Plugin, err)
+		c.Writef("%s %s :Plugin %s version %s loaded, but the installation could not be recorded: %v",
+			internal.CMD_PRIVMSG, replyTarget, inst.Plugin, version, err)
+		return
+	}
 
-	err = os.MkdirAll("./plugins", 0755)
+	logger.Successf("Plugin %s version %s installed by %s from %s (sha256:%s, %s), installation #%d",
+		inst.Plugin, version, inst.InstalledBy, inst.URL, inst.SHA256, inst.Verification, inst.ID)
+	c.Writef("%s %s :Plugin %s version %s loaded successfully! (installation #%d)",
+		internal.CMD_PRIVMSG, replyTarget, inst.Plugin, version, inst.ID)
+}
+
+// buildOnlinePlugin compiles a plugin source into ./plugins/name_vX.so,
+// points name.so at it and loads it. Returns the plugin's version.
+func buildOnlinePlugin(fileName string, source []byte) (string, error) {
+	version, path, err := plugin.BuildGoPlugin(strings.TrimSuffix(fileName, ".go"), source, "./plugins")
 	if err != nil {
-		logger.Errorf("Error creating plugins directory: %v", err)
-		c.Writef("%s %s :Error creating plugins directory: %v", internal.CMD_PRIVMSG, replyTarget, err)
+		return "", err
+	}
+	if err := plugin.LoadBuild(path); err != nil {
+		return "", fmt.Errorf("error loading plugin: %w", err)
+	}
+	return version, nil
+}
+
+// onlinePluginHistory lists the recent installations from the ledger
+func onlinePluginHistory(c *irc.Client, replyTarget, pluginName string) {
+	installs, err := installer.History(pluginName)
+	if err != nil {
+		c.Writef("%s %s :Error reading install ledger: %v", internal.CMD_PRIVMSG, replyTarget, err)
+		return
+	}
+	if len(installs) == 0 {
+		c.Writef("%s %s :No plugin installations recorded", internal.CMD_PRIVMSG, replyTarget)
 		return
 	}
 
-	fileContent = bytes.TrimSpace(fileContent)
-	version := "1.0.0"
-	versionRegex := `func\s+\(\w+\s+\*?\w+\)\s+Version\(\)\s+string\s+{\s*return\s+"([^"]+)"`
-	re := regexp.MustCompile(versionRegex)
-	matches := re.FindStringSubmatch(string(fileContent))
-	if len(matches) > 1 {
-		version = matches[1]
+	const maxEntries = 10
+	if len(installs) > maxEntries {
+		c.Writef("%s %s :Showing the last %d of %d installations", internal.CMD_PRIVMSG, replyTarget, maxEntries, len(installs))
+		installs = installs[len(installs)-maxEntries:]
 	}
+	for _, inst := range installs {
+		by, _, _ := strings.Cut(inst.InstalledBy, "!")
+		line := fmt.Sprintf("#%d %s %s sha256:%.12s by %s on %s (%s)", inst.ID, inst.Plugin, inst.Version,
+			inst.SHA256, by, inst.InstalledAt.Format("2006-01-02 15:04"), inst.Verification)
+		c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, line)
+	}
+}
 
-	versionedFilename := fmt.Sprintf("%s_v%s.so", pluginName, version)
-	outputPath := filepath.Join("./plugins", versionedFilename)
+func limitOutput(output string, maxLen int) string {
+	if len(output) <= maxLen {
+		return output
+	}
+	return output[:maxLen] + "..."
+}
 
-	standardPath := filepath.Join("./plugins", pluginName+".so")
+// tasksCmd lists the scheduled tasks or cancels one
+func tasksCmd(c *irc.Client, m *irc.Message, args []string) {
+	replyTarget := m.Params[0]
+	if replyTarget == c.CurrentNick() {
+		replyTarget = m.Prefix.Name
+	}
 
-	cmd := exec.Command("go", "build", "-tags", pluginName, "-buildmode=plugin", "-o", outputPath, tempFilePath)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		logger.Errorf("Error compiling plugin: %v\n%s", err, output)
-		c.Writef("%s %s :Error compiling plugin: %v\n%s", internal.CMD_PRIVMSG, replyTarget, err, limitOutput(string(output), 100))
+	if len(args) > 0 {
+		if strings.ToLower(args[0]) != "cancel" || len(args) < 2 {
+			c.Writef("%s %s :Usage: !tasks [cancel <id>]", internal.CMD_PRIVMSG, replyTarget)
+			return
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+		if err != nil {
+			c.Writef("%s %s :Invalid task ID: %s", internal.CMD_PRIVMSG, replyTarget, args[1])
+			return
+		}
+		info, exists := scheduler.Get(id)
+		if !exists || !scheduler.Cancel(id) {
+			c.Writef("%s %s :No task #%d", internal.CMD_PRIVMSG, replyTarget, id)
+			return
+		}
+		logger.Infof("%s cancelled task #%d %s/%s", m.Prefix.Name, id, info.Owner, info.Name)
+		c.Writef("%s %s :Cancelled task #%d %s/%s", internal.CMD_PRIVMSG, replyTarget, id, info.Owner, info.Name)
 		return
 	}
 
-	if _, err := os.Stat(standardPath); err == nil {
-		os.Remove(standardPath)
+	tasks := scheduler.List()
+	if len(tasks) == 0 {
+		c.Writef("%s %s :No scheduled tasks", internal.CMD_PRIVMSG, replyTarget)
+		return
 	}
-	err = os.Symlink(versionedFilename, standardPath)
-	if err != nil {
-		logger.Warnf("Error creating symlink for plugin %s: %v", pluginName, err)
+
+	c.Writef("%s %s :Scheduled tasks (%d):", internal.CMD_PRIVMSG, replyTarget, len(tasks))
+	for _, t := range tasks {
+		line := fmt.Sprintf("#%d %s/%s (%s)", t.ID, t.Owner, t.Name, t.Schedule)
+		if !t.Next.IsZero() {
+			line += fmt.Sprintf(" - next in %s", formatDuration(time.Until(t.Next)))
+		}
+		if t.LastRun.IsZero() {
+			line += ", not run yet"
+		} else {
+			result := "ok"
+			if t.LastError != "" {
+				result = "error: " + t.LastError
+			}
+			line += fmt.Sprintf(", last run %s ago (%s), %d runs", formatDuration(time.Since(t.LastRun)), result, t.Runs)
+		}
+		c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, line)
 	}
+}
 
-	c.Writef("%s %s :Loading plugin %s version %s...", internal.CMD_PRIVMSG, replyTarget, pluginName, version)
+// pluginConfigCmd shows and changes the settings in a plugin's config.toml
+func pluginConfigCmd(c *irc.Client, m *irc.Message, args []string) {
+	replyTarget := m.Params[0]
+	if replyTarget == c.CurrentNick() {
+		replyTarget = m.Prefix.Name
+	}
 
-	err = plugin.LoadPlugin(outputPath)
-	if err != nil {
-		logger.Errorf("Error loading plugin %s: %v", pluginName, err)
-		c.Writef("%s %s :Error loading plugin %s: %v", internal.CMD_PRIVMSG, replyTarget, pluginName, err)
+	if len(args) < 2 {
+		c.Writef("%s %s :Usage: !pluginconfig <plugin> list|get <key>|set <key> <value>", internal.CMD_PRIVMSG, replyTarget)
 		return
 	}
 
-	err = os.MkdirAll("./plugins_src", 0755)
-	if err != nil {
-		logger.Warnf("Error creating plugins_src directory: %v", err)
-	} else {
-		srcPath := filepath.Join("./plugins_src", fileName)
-		err = copyFile(tempFilePath, srcPath)
+	pluginName := args[0]
+	helper := api.GetPluginConfigHelper(pluginName)
+
+	switch strings.ToLower(args[1]) {
+	case "list":
+		values, err := helper.Values()
 		if err != nil {
-			logger.Warnf("Error saving plugin source: %v", err)
+			c.Writef("%s %s :%v", internal.CMD_PRIVMSG, replyTarget, err)
+			return
+		}
+		if len(values) == 0 {
+			c.Writef("%s %s :Plugin %s has no settings", internal.CMD_PRIVMSG, replyTarget, pluginName)
+			return
 		}
-	}
 
-	logger.Successf("Plugin %s version %s loaded successfully from URL %s", pluginName, version, pluginURL)
-	c.Writef("%s %s :Plugin %s version %s loaded successfully!", internal.CMD_PRIVMSG, replyTarget, pluginName, version)
-}
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
 
-func limitOutput(output string, maxLen int) string {
-	if len(output) <= maxLen {
-		return output
+		c.Writef("%s %s :Settings of %s:", internal.CMD_PRIVMSG, replyTarget, pluginName)
+		for _, key := range keys {
+			c.Writef("%s %s :%s = %v", internal.CMD_PRIVMSG, replyTarget, key, values[key])
+		}
+
+	case "get":
+		if len(args) < 3 {
+			c.Writef("%s %s :Usage: !pluginconfig <plugin> get <key>", internal.CMD_PRIVMSG, replyTarget)
+			return
+		}
+		values, err := helper.Values()
+		if err != nil {
+			c.Writef("%s %s :%v", internal.CMD_PRIVMSG, replyTarget, err)
+			return
+		}
+		value, ok := values[args[2]]
+		if !ok {
+			c.Writef("%s %s :Plugin %s has no setting %s", internal.CMD_PRIVMSG, replyTarget, pluginName, args[2])
+			return
+		}
+		c.Writef("%s %s :%s = %v", internal.CMD_PRIVMSG, replyTarget, args[2], value)
+
+	case "set":
+		if len(args) < 4 {
+			c.Writef("%s %s :Usage: !pluginconfig <plugin> set <key> <value>", internal.CMD_PRIVMSG, replyTarget)
+			return
+		}
+		key := args[2]
+		value := strings.Join(args[3:], " ")
+
+		if err := helper.Set(key, value); err != nil {
+			c.Writef("%s %s :Error: %v", internal.CMD_PRIVMSG, replyTarget, err)
+			return
+		}
+		logger.Infof("%s set %s=%s for plugin %s", m.Prefix.Name, key, value, pluginName)
+
+		if plugin.NotifyConfigChange(pluginName) {
+			c.Writef("%s %s :Set '%s=%s' for plugin %s", internal.CMD_PRIVMSG, replyTarget, key, value, pluginName)
+		} else {
+			c.Writef("%s %s :Set '%s=%s' for plugin %s (not loaded, applies on next load)", internal.CMD_PRIVMSG, replyTarget, key, value, pluginName)
+		}
+
+	default:
+		c.Writef("%s %s :Unknown pluginconfig subcommand: %s", internal.CMD_PRIVMSG, replyTarget, args[1])
 	}
-	return output[:maxLen] + "..."
 }
 
-func copyFile(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
-		return err
+// pluginCmd shows the builds of a plugin and rolls back or pins versions
+func pluginCmd(c *irc.Client, m *irc.Message, args []string) {
+	replyTarget := m.Params[0]
+	if replyTarget == c.CurrentNick() {
+		replyTarget = m.Prefix.Name
 	}
-	defer in.Close()
 
-	out, err := os.Create(dst)
-	if err != nil {
-		return err
+	if len(args) < 2 {
+		c.Writef("%s %s :Usage: !plugin versions|rollback|pin|unpin <name> [version]", internal.CMD_PRIVMSG, replyTarget)
+		return
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, in)
-	return err
+	name := args[1]
+	version := ""
+	if len(args) > 2 {
+		version = strings.TrimPrefix(args[2], "v")
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "versions":
+		base, versions, err := plugin.ListVersions("./plugins", name)
+		if err != nil {
+			c.Writef("%s %s :Error: %v", internal.CMD_PRIVMSG, replyTarget, err)
+			return
+		}
+		if len(versions) == 0 {
+			c.Writef("%s %s :No versioned builds of plugin %s", internal.CMD_PRIVMSG, replyTarget, base)
+			return
+		}
+
+		c.Writef("%s %s :Builds of %s (%d):", internal.CMD_PRIVMSG, replyTarget, base, len(versions))
+		for _, v := range versions {
+			line := fmt.Sprintf("%s built %s", v.Version, v.Built.Format("2006-01-02 15:04"))
+			var marks []string
+			if v.Current {
+				marks = append(marks, "loaded")
+			}
+			if v.Latest {
+				marks = append(marks, "latest")
+			}
+			if v.Pinned {
+				marks = append(marks, "pinned")
+			}
+			if len(marks) > 0 {
+				line += " [" + strings.Join(marks, ", ") + "]"
+			}
+			c.Writef("%s %s :%s", internal.CMD_PRIVMSG, replyTarget, line)
+		}
+
+	case "rollback":
+		from, to, err := plugin.RollbackPlugin("./plugins", name, version)
+		if err != nil {
+			logger.Errorf("Rollback of plugin %s failed: %v", name, err)
+			c.Writef("%s %s :Rollback failed: %v", internal.CMD_PRIVMSG, replyTarget, err)
+			return
+		}
+		if from == "" {
+			from = "not loaded"
+		}
+		c.Writef("%s %s :Plugin %s rolled back from %s to %s and pinned; !plugin unpin %s returns to the latest build",
+			internal.CMD_PRIVMSG, replyTarget, name, from, to, name)
+
+	case "pin":
+		base, versions, err := plugin.ListVersions("./plugins", name)
+		if err != nil {
+			c.Writef("%s %s :Error: %v", internal.CMD_PRIVMSG, replyTarget, err)
+			return
+		}
+		if version == "" {
+			for _, v := range versions {
+				if v.Current {
+					version = v.Version
+				}
+			}
+		}
+		found := false
+		for _, v := range versions {
+			found = found || v.Version == version
+		}
+		if !found {
+			c.Writef("%s %s :No build of plugin %s version %s", internal.CMD_PRIVMSG, replyTarget, base, version)
+			return
+		}
+		if err := plugin.PinVersion(base, version); err != nil {
+			c.Writef("%s %s :Error pinning plugin %s: %v", internal.CMD_PRIVMSG, replyTarget, base, err)
+			return
+		}
+		c.Writef("%s %s :Plugin %s pinned to version %s", internal.CMD_PRIVMSG, replyTarget, base, version)
+
+	case "unpin":
+		// The base is resolved even when the plugin has no builds left
+		base, _, _ := plugin.ListVersions("./plugins", name)
+		unpinned, err := plugin.UnpinVersion(base)
+		switch {
+		case err != nil:
+			c.Writef("%s %s :Error unpinning plugin %s: %v", internal.CMD_PRIVMSG, replyTarget, base, err)
+		case !unpinned:
+			c.Writef("%s %s :Plugin %s is not pinned", internal.CMD_PRIVMSG, replyTarget, base)
+		default:
+			c.Writef("%s %s :Plugin %s unpinned; the latest build is loaded on the next !reload or restart", internal.CMD_PRIVMSG, replyTarget, base)
+		}
+
+	default:
+		c.Writef("%s %s :Unknown plugin subcommand: %s", internal.CMD_PRIVMSG, replyTarget, args[0])
+	}
 }
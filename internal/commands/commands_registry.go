@@ -7,6 +7,7 @@ import (
 	"gopkg.in/irc.v4"
 	"ircbot/internal/config"
 	"ircbot/internal/logger"
+	"ircbot/internal/plugin"
 	"ircbot/internal/security"
 	"ircbot/internal/userlevels"
 )
@@ -18,6 +19,10 @@ type Command struct {
 	Description   string
 	Handler       CommandFunc
 	RequiredLevel userlevels.UserLevel
+	// ChannelLocal commands act on the channel they are given in, so a
+	// channel grant counts for them there. Any other command needs the
+	// global level, wherever it is given.
+	ChannelLocal bool
 }
 
 var commandRegistry = make(map[string]Command)
@@ -31,6 +36,24 @@ func RegisterCommand(name string, description string, requiredLevel userlevels.U
 	}
 }
 
+// RegisterChannelCommand registers a command that acts on the channel it
+// is given in
+func RegisterChannelCommand(name string, description string, requiredLevel userlevels.UserLevel, handler CommandFunc) {
+	RegisterCommand(name, description, requiredLevel, handler)
+	cmd := commandRegistry[name]
+	cmd.ChannelLocal = true
+	commandRegistry[name] = cmd
+}
+
+// Scope returns the channel whose grants count for the command when it is
+// given in channel, or "" if only the global level does
+func (cmd Command) Scope(channel string) string {
+	if cmd.ChannelLocal {
+		return channel
+	}
+	return ""
+}
+
 func GetCommand(name string) (Command, bool) {
 	cmd, exists := commandRegistry[name]
 	return cmd, exists
@@ -62,8 +85,14 @@ func HandleCommand(c *irc.Client, m *irc.Message) {
 	userNick := m.Prefix.Name
 	hostmask := m.Prefix.String()
 	
+	// Resolve the user's level from the global and the channel scope
+	channel := ""
+	if isChannelMsg {
+		channel = replyTarget
+	}
+
 	// Check if the user is already ignored
-	userLevel := userlevels.GetUserLevelByHostmask(hostmask)
+	userLevel := userlevels.GetEffectiveLevel(channel, hostmask)
 	if userLevel == userlevels.Ignored {
 		// Don't process commands from ignored users
 		logger.Debugf("Ignored command from %s: %s", userNick, cmdText)
@@ -78,13 +107,13 @@ func HandleCommand(c *irc.Client, m *irc.Message) {
 			userNick, commandCount, baseCommand)
 		
 		// If this is their first offense, warn them
-		if security.GlobalMessageTracker.AddWarning(hostmask) {
+		if security.GlobalMessageTracker.AddWarning(hostmask, "command spam") {
 			// If they've hit the warning threshold, auto-ignore them
 			logger.Warnf("User %s (%s) has been automatically ignored for command spam", 
 				userNick, hostmask)
 			
 			// Automatically set them to ignored level
-			userlevels.SetUserLevel(hostmask, userlevels.Ignored)
+			userlevels.SetUserLevelByHostmask(hostmask, userlevels.Ignored)
 			userlevels.SaveHostmasks()
 			
 			// Notify the user about the auto-ignore
@@ -127,15 +156,21 @@ func HandleCommand(c *irc.Client, m *irc.Message) {
 		}
 	}
 	
-	// Check if command exists
+	// Find the built-in or plugin command and the level it requires.
+	// Built-in commands take precedence over plugin commands, which are
+	// always checked against the global level.
+	requiredLevel := userlevels.Regular
+	scope := ""
 	cmd, exists := GetCommand(baseCommand)
-	if !exists {
-		// Try plugin commands
+	if exists {
+		requiredLevel = cmd.RequiredLevel
+		scope = cmd.Scope(channel)
+	} else if info, ok := plugin.FindPluginCommand(baseCommand); ok {
+		requiredLevel = userlevels.UserLevel(info.Level)
+	} else {
+		// Legacy plugins handle their commands in OnMessage
 		if CheckPluginCommands != nil {
-			handled := CheckPluginCommands(c, m, baseCommand, args)
-			if handled {
-				return
-			}
+			CheckPluginCommands(c, m, baseCommand, args)
 		}
 		return
 	}
@@ -149,11 +184,11 @@ func HandleCommand(c *irc.Client, m *irc.Message) {
 	}
 	
 	// Check user permission level
-	if !userlevels.HasPermission(hostmask, cmd.RequiredLevel) {
-		requiredLevel := userlevels.LevelName(cmd.RequiredLevel)
-		userLevelName := userlevels.LevelName(userLevel)
+	if !userlevels.HasChannelPermission(scope, hostmask, requiredLevel) {
+		requiredLevelName := userlevels.LevelName(requiredLevel)
+		userLevelName := userlevels.LevelName(userlevels.GetEffectiveLevel(scope, hostmask))
 		
-		if cmd.RequiredLevel == userlevels.Owner {
+		if requiredLevel == userlevels.Owner {
 			err := c.Writef("PRIVMSG %s :Access denied. Command '%s' requires owner access.", 
 				replyTarget, baseCommand)
 			if err != nil {
@@ -161,7 +196,7 @@ func HandleCommand(c *irc.Client, m *irc.Message) {
 			}
 		} else {
 			err := c.Writef("PRIVMSG %s :Access denied. Command '%s' requires %s level. Your level: %s", 
-				replyTarget, baseCommand, requiredLevel, userLevelName)
+				replyTarget, baseCommand, requiredLevelName, userLevelName)
 			if err != nil {
 				return
 			}
@@ -170,5 +205,11 @@ func HandleCommand(c *irc.Client, m *irc.Message) {
 	}
 	
 	// Execute the command
+	if !exists {
+		if CheckPluginCommands != nil {
+			CheckPluginCommands(c, m, baseCommand, args)
+		}
+		return
+	}
 	cmd.Handler(c, m, args)
 }
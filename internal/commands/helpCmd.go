@@ -12,12 +12,17 @@ import (
 
 func helpCmd(c *irc.Client, m *irc.Message, args []string) {
 	hostmask := m.Prefix.String()
-	userLevel := userlevels.GetUserLevelByHostmask(hostmask)
 
+	// In a channel, list the channel commands by the level the user has
+	// there and the others by their global level
+	channel := ""
 	replyTarget := m.Params[0]
 	if replyTarget == c.CurrentNick() {
 		replyTarget = m.Prefix.Name
+	} else {
+		channel = replyTarget
 	}
+	userLevel := userlevels.GetEffectiveLevel("", hostmask)
 
 	var availableCommands []string
 
@@ -29,7 +34,7 @@ func helpCmd(c *irc.Client, m *irc.Message, args []string) {
 
 	for _, name := range commandNames {
 		cmd := commandRegistry[name]
-		if userLevel >= cmd.RequiredLevel {
+		if userlevels.GetEffectiveLevel(cmd.Scope(channel), hostmask) >= cmd.RequiredLevel {
 			availableCommands = append(availableCommands, fmt.Sprintf("!%s (%s) - %s",
 				name, userlevels.LevelName(cmd.RequiredLevel), cmd.Description))
 		}
@@ -39,14 +44,24 @@ func helpCmd(c *irc.Client, m *irc.Message, args []string) {
 	if len(pluginList) > 0 {
 		var pluginCommands []string
 
+		for _, info := range plugin.GetPluginCommandInfo() {
+			// Built-in commands take precedence over plugin commands
+			if _, builtin := commandRegistry[info.Command]; builtin {
+				continue
+			}
+			level := userlevels.UserLevel(info.Level)
+			if userLevel < level {
+				continue
+			}
+			entry := fmt.Sprintf("!%s (%s, Plugin: %s)", info.Command, userlevels.LevelName(level), info.Plugin)
+			if info.Description != "" {
+				entry += " - " + info.Description
+			}
+			pluginCommands = append(pluginCommands, entry)
+		}
+
 		for _, p := range pluginList {
-			if cmdHandler, ok := p.(plugin.CommandHandler); ok {
-				commands := cmdHandler.GetCommands()
-				for _, cmd := range commands {
-					pluginCommands = append(pluginCommands, fmt.Sprintf("!%s (Plugin: %s)",
-						cmd, p.Name()))
-				}
-			} else {
+			if _, ok := p.(plugin.CommandHandler); !ok {
 				if commands, ok := plugin.GetLegacyPluginCommands(p.Name()); ok && len(commands) > 0 {
 					for _, cmd := range commands {
 						pluginCommands = append(pluginCommands, fmt.Sprintf("!%s (Legacy Plugin: %s)",
@@ -233,3 +233,24 @@ func cleanURLFormatting(text string) string {
 
 	return text
 }
+
+// formatDuration formats a duration as days, hours, minutes and seconds,
+// e.g. "2d 3h 4m 5s", leaving out leading zero units
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	days := int(d / (24 * time.Hour))
+	hours := int(d/time.Hour) % 24
+	minutes := int(d/time.Minute) % 60
+	seconds := int(d/time.Second) % 60
+
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh %dm %ds", days, hours, minutes, seconds)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm %ds", hours, minutes, seconds)
+	case minutes > 0:
+		return fmt.Sprintf("%dm %ds", minutes, seconds)
+	default:
+		return fmt.Sprintf("%ds", seconds)
+	}
+}
@@ -0,0 +1,81 @@
+package commands
+
+import (
+	"strings"
+	"sync"
+
+	"gopkg.in/irc.v4"
+	"ircbot/internal"
+	"ircbot/internal/userlevels"
+)
+
+var (
+	// pendingWhois maps lowercased nicks queried with !whois to where the
+	// answer should be sent
+	pendingWhois   = make(map[string]string)
+	pendingWhoisMu sync.Mutex
+)
+
+// expectWhois remembers that a WHOIS reply for nick should be reported to replyTarget
+func expectWhois(nick, replyTarget string) {
+	pendingWhoisMu.Lock()
+	defer pendingWhoisMu.Unlock()
+	pendingWhois[strings.ToLower(nick)] = replyTarget
+}
+
+// HandleWhoisUser reports the user's hostmask and bot level, including the
+// entry that granted it, for a pending !whois:
+// 311 <me> <nick> <user> <host> * :<realname>
+func HandleWhoisUser(c *irc.Client, m *irc.Message) {
+	if len(m.Params) < 4 {
+		return
+	}
+	nick := m.Params[1]
+
+	pendingWhoisMu.Lock()
+	replyTarget, ok := pendingWhois[strings.ToLower(nick)]
+	delete(pendingWhois, strings.ToLower(nick))
+	pendingWhoisMu.Unlock()
+	if !ok {
+		return
+	}
+
+	hostmask := nick + "!" + m.Params[2] + "@" + m.Params[3]
+	// Asked in a channel, report the level the user has in that channel
+	channel := ""
+	if strings.HasPrefix(replyTarget, "#") || strings.HasPrefix(replyTarget, "&") {
+		channel = replyTarget
+	}
+	level, matchedBy := userlevels.MatchEffectiveLevel(channel, hostmask)
+
+	reason := "default"
+	if matchedBy != "" {
+		reason = "matched " + matchedBy
+	}
+	account := userlevels.GetNickAccount(nick)
+	if account == "" {
+		account = "unknown"
+	}
+
+	c.Writef("%s %s :%s is %s (%s) - account: %s - level: %s (%s)",
+		internal.CMD_PRIVMSG, replyTarget, nick, hostmask, m.Trailing(), account, userlevels.LevelName(level), reason)
+}
+
+// HandleWhoisEnd reports a !whois for a nick that isn't online:
+// 318 <me> <nick> :End of /WHOIS list
+func HandleWhoisEnd(c *irc.Client, m *irc.Message) {
+	if len(m.Params) < 2 {
+		return
+	}
+	nick := m.Params[1]
+
+	pendingWhoisMu.Lock()
+	replyTarget, ok := pendingWhois[strings.ToLower(nick)]
+	delete(pendingWhois, strings.ToLower(nick))
+	pendingWhoisMu.Unlock()
+	if !ok {
+		return
+	}
+
+	c.Writef("%s %s :No such nick: %s", internal.CMD_PRIVMSG, replyTarget, nick)
+}
@@ -14,14 +14,143 @@ type ChannelConfig struct {
 	Settings map[string]interface{}   `toml:"settings"`
 }
 
+// AIProviderConfig is an LLM backend: Type is "openai" (also any server
+// compatible with it, given a BaseURL), "anthropic" or "ollama". The API
+// key is read from the environment variable named by APIKeyEnv.
+type AIProviderConfig struct {
+	Type      string `toml:"type"`
+	BaseURL   string `toml:"base_url"`
+	APIKeyEnv string `toml:"api_key_env"`
+	Model     string `toml:"model"`
+}
+
+// AISettings are the AI settings a channel can override; unset ones are
+// inherited. Tools lists the tools the AI may use, all of them when empty.
+type AISettings struct {
+	Model       string   `toml:"model,omitempty"`
+	Temperature *float64 `toml:"temperature,omitempty"`
+	MaxTokens   int      `toml:"max_tokens,omitempty"`
+	ToolCalls   *bool    `toml:"tool_calls,omitempty"`
+	Tools       []string `toml:"tools,omitempty"`
+}
+
+// AIConfig is the [ai] section: the settings for every channel, the ones
+// that only apply to the whole bot, and the overrides of channels. The
+// system prompt may use {nick}, {date} and {time}.
+type AIConfig struct {
+	AISettings
+	Timeout       int                   `toml:"timeout,omitempty"`
+	Summarization *bool                 `toml:"summarization,omitempty"`
+	Streaming     *bool                 `toml:"streaming,omitempty"`
+	SystemPrompt  string                `toml:"system_prompt,omitempty"`
+	Channels      map[string]AISettings `toml:"channels,omitempty"`
+
+	// Quotas are daily token limits for each user by level name
+	// ("badboy", "regular", "admin", "owner") and for each channel
+	// ("channel"); missing or zero is unlimited. Prices are the dollars per
+	// million tokens of models, for the cost estimates of !ai usage.
+	Quotas map[string]int     `toml:"quotas,omitempty"`
+	Prices map[string]AIPrice `toml:"prices,omitempty"`
+}
+
+// AIPrice is what a model costs in dollars per million tokens
+type AIPrice struct {
+	Prompt     float64 `toml:"prompt"`
+	Completion float64 `toml:"completion"`
+}
+
 type Config struct {
 	Server   string   `toml:"server"`
+	// Servers are tried in turn when a connection fails; server is added first
+	Servers  []string `toml:"servers"`
 	Nick     string   `toml:"nick"`
+	// AltNicks are tried in order when the nick is taken while connecting
+	AltNicks []string `toml:"alt_nicks"`
 	User     string   `toml:"user"`
 	RealName string   `toml:"real_name"`
 	Password string   `toml:"password"`
 	Channels []string `toml:"channels"`
+	// ChannelKeys maps channel names to the key needed to join them
+	ChannelKeys map[string]string `toml:"channel_keys"`
 	ChannelSettings map[string]ChannelConfig `toml:"channel_settings"`
+
+	// TLS settings for the server connection
+	TLS        bool   `toml:"tls"`
+	TLSVerify  bool   `toml:"tls_verify"`
+	ClientCert string `toml:"client_cert"`
+	ClientKey  string `toml:"client_key"`
+
+	// SASL authentication (PLAIN or EXTERNAL), negotiated before registration.
+	// SASLUsername defaults to the nick when empty.
+	SASLMechanism string `toml:"sasl_mechanism"`
+	SASLUsername  string `toml:"sasl_username"`
+
+	// Flood control for outgoing lines: FloodBurst lines can be sent back to
+	// back, then one more every FloodDelay milliseconds. Zero uses the defaults.
+	FloodBurst int `toml:"flood_burst"`
+	FloodDelay int `toml:"flood_delay_ms"`
+
+	// Reconnect backoff in seconds: the delay starts at ReconnectDelay and
+	// doubles after every failed attempt up to ReconnectMaxDelay
+	ReconnectDelay    int `toml:"reconnect_delay"`
+	ReconnectMaxDelay int `toml:"reconnect_max_delay"`
+
+	// Lag check in seconds: a PING is sent every PingInterval and the bot
+	// reconnects when no PONG arrives within PingTimeout
+	PingInterval int `toml:"ping_interval"`
+	PingTimeout  int `toml:"ping_timeout"`
+
+	// Channel joins: JoinWait is how many seconds to wait for identification
+	// before joining anyway, RejoinDelay how many seconds to wait before
+	// rejoining after a kick (negative disables rejoining), and ChanServRecover
+	// asks ChanServ for an UNBAN or INVITE when a join is refused
+	JoinWait        int  `toml:"join_wait"`
+	RejoinDelay     int  `toml:"rejoin_delay"`
+	ChanServRecover bool `toml:"chanserv_recover"`
+
+	// Plugin installation with !load-online: sources are only downloaded
+	// over https from PluginAllowedDomains (including their subdomains),
+	// within PluginDownloadTimeout seconds, and must match a given SHA-256
+	// checksum or carry a minisign signature by one of PluginTrustedKeys
+	PluginAllowedDomains  []string `toml:"plugin_allowed_domains"`
+	PluginTrustedKeys     []string `toml:"plugin_trusted_keys"`
+	PluginDownloadTimeout int      `toml:"plugin_download_timeout"`
+
+	// PluginKeepVersions is how many versioned builds of each plugin are
+	// kept in the plugin directory (default 5, negative keeps all)
+	PluginKeepVersions int `toml:"plugin_keep_versions"`
+
+	// PluginDevMode rebuilds and loads a plugin whenever its source in
+	// plugins_src changes; build errors go to PluginDevReport, a channel
+	// or nick
+	PluginDevMode   bool   `toml:"plugin_dev_mode"`
+	PluginDevReport string `toml:"plugin_dev_report"`
+
+	// AIProviders are the LLM backends by name. AIProvider is the one used
+	// unless a channel's "ai_provider" setting names another; it may be
+	// left out when there is only one. Without any providers, OpenAI is
+	// used with OPENAI_API_KEY.
+	AIProvider  string                      `toml:"ai_provider"`
+	AIProviders map[string]AIProviderConfig `toml:"ai_providers"`
+
+	// AI is the model and prompt configuration; !aiconfig changes are
+	// kept separately and take precedence
+	AI AIConfig `toml:"ai"`
+}
+
+// ServerList returns the servers to connect to, in order, without duplicates
+func (cfg *Config) ServerList() []string {
+	var servers []string
+	seen := make(map[string]bool)
+	for _, server := range append([]string{cfg.Server}, cfg.Servers...) {
+		server = strings.TrimSpace(server)
+		if server == "" || seen[server] {
+			continue
+		}
+		seen[server] = true
+		servers = append(servers, server)
+	}
+	return servers
 }
 
 type HostmaskEntry struct {
@@ -29,19 +158,36 @@ type HostmaskEntry struct {
 	Level    int    `toml:"level"`
 }
 
+// AccountEntry grants a level to a services (NickServ) account
+type AccountEntry struct {
+	Account string `toml:"account"`
+	Level   int    `toml:"level"`
+}
+
+// ChannelHostmaskEntry grants a level within a single channel to a hostmask
+// (or to "account:<name>")
+type ChannelHostmaskEntry struct {
+	Channel  string `toml:"channel"`
+	Hostmask string `toml:"hostmask"`
+	Level    int    `toml:"level"`
+}
+
 type Settings struct {
 	OwnerVerified  bool            `toml:"owner_verified"`
 	OwnerPasshash  string          `toml:"owner_passhash"`
 	OwnerHostmask  string          `toml:"owner_hostmask"`
 	HostmaskLevels bool            `toml:"hostmask_levels"`
 	Hostmasks      []HostmaskEntry `toml:"hostmasks"`
+	Accounts       []AccountEntry  `toml:"accounts"`
+
+	ChannelHostmasks []ChannelHostmaskEntry `toml:"channel_hostmasks"`
 }
 
 // ValidateConfig checks if all required configuration fields are properly set
 func ValidateConfig(cfg *Config) error {
 	var missingFields []string
 
-	if cfg.Server == "" {
+	if len(cfg.ServerList()) == 0 {
 		missingFields = append(missingFields, "server")
 	}
 	if cfg.Nick == "" {
@@ -54,8 +200,77 @@ func ValidateConfig(cfg *Config) error {
 		missingFields = append(missingFields, "real_name")
 	}
 
-	if cfg.Server != "" && !strings.Contains(cfg.Server, ":") {
-		return fmt.Errorf("server address does not contain a port (format should be host:port)")
+	for _, server := range cfg.ServerList() {
+		if !strings.Contains(server, ":") {
+			return fmt.Errorf("server address %s does not contain a port (format should be host:port)", server)
+		}
+	}
+
+	if cfg.ClientKey != "" && cfg.ClientCert == "" {
+		return fmt.Errorf("client_key is set but client_cert is missing")
+	}
+
+	if cfg.FloodBurst < 0 || cfg.FloodDelay < 0 {
+		return fmt.Errorf("flood_burst and flood_delay_ms must not be negative")
+	}
+
+	if cfg.ReconnectDelay < 0 || cfg.ReconnectMaxDelay < 0 || cfg.PingInterval < 0 || cfg.PingTimeout < 0 {
+		return fmt.Errorf("reconnect and ping settings must not be negative")
+	}
+
+	if cfg.PluginDownloadTimeout < 0 {
+		return fmt.Errorf("plugin_download_timeout must not be negative")
+	}
+
+	for name, provider := range cfg.AIProviders {
+		switch strings.ToLower(provider.Type) {
+		case "", "openai", "anthropic", "ollama":
+		default:
+			return fmt.Errorf("ai provider %s has unsupported type %q (use openai, anthropic or ollama)", name, provider.Type)
+		}
+	}
+	if cfg.AIProvider != "" {
+		if _, ok := cfg.AIProviders[cfg.AIProvider]; !ok {
+			return fmt.Errorf("ai_provider %q is not one of the ai_providers", cfg.AIProvider)
+		}
+	} else if len(cfg.AIProviders) > 1 {
+		return fmt.Errorf("ai_provider must name the default of the ai_providers")
+	}
+
+	if err := ValidateAISettings(cfg.AI.AISettings); err != nil {
+		return fmt.Errorf("ai: %w", err)
+	}
+	for channel, settings := range cfg.AI.Channels {
+		if err := ValidateAISettings(settings); err != nil {
+			return fmt.Errorf("ai channel %s: %w", channel, err)
+		}
+	}
+	if cfg.AI.Timeout < 0 {
+		return fmt.Errorf("ai timeout must not be negative")
+	}
+	for name, quota := range cfg.AI.Quotas {
+		switch strings.ToLower(name) {
+		case "badboy", "regular", "admin", "owner", "channel":
+		default:
+			return fmt.Errorf("ai quota %q is not a user level or \"channel\"", name)
+		}
+		if quota < 0 {
+			return fmt.Errorf("ai quota %s must not be negative", name)
+		}
+	}
+
+	switch strings.ToUpper(cfg.SASLMechanism) {
+	case "":
+	case "PLAIN":
+		if cfg.Password == "" {
+			return fmt.Errorf("sasl_mechanism PLAIN requires a password")
+		}
+	case "EXTERNAL":
+		if !cfg.TLS || cfg.ClientCert == "" {
+			return fmt.Errorf("sasl_mechanism EXTERNAL requires tls and a client_cert")
+		}
+	default:
+		return fmt.Errorf("unsupported sasl_mechanism %q (use PLAIN or EXTERNAL)", cfg.SASLMechanism)
 	}
 
 	if len(missingFields) > 0 {
@@ -65,8 +280,20 @@ func ValidateConfig(cfg *Config) error {
 	return nil
 }
 
+// ValidateAISettings checks that AI settings are in range
+func ValidateAISettings(settings AISettings) error {
+	if settings.Temperature != nil && (*settings.Temperature < 0 || *settings.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2")
+	}
+	if settings.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must not be negative")
+	}
+	return nil
+}
+
 func LoadConfig(path string) (*Config, error) {
-	var cfg Config
+	// Certificate verification stays on unless explicitly disabled
+	cfg := Config{TLSVerify: true}
 	if _, err := toml.DecodeFile(path, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to decode config file %s: %w", path, err)
 	}
@@ -2,6 +2,7 @@ package connection
 
 import (
 	"context"
+	"math/rand"
 	"net"
 	"time"
 
@@ -10,39 +11,168 @@ import (
 	"ircbot/internal/bot"
 	"ircbot/internal/config"
 	"ircbot/internal/handlers"
+	"ircbot/internal/health"
 	"ircbot/internal/logger"
 )
 
-// EstablishConnection attempts to connect to the IRC server with the provided configuration
-func EstablishConnection(ctx context.Context, cfg *config.Config, initialOwnerNick string, isFirstRun bool) (*irc.Client, error) {
+// stableAfter is how long a connection must last before a disconnect no
+// longer counts as a failed attempt for the backoff
+const stableAfter = 2 * time.Minute
+
+// Run keeps the bot connected until ctx is cancelled. Servers from the
+// configuration are tried in turn, waiting with exponential backoff and
+// jitter between failed attempts.
+func Run(ctx context.Context, cfg *config.Config, initialOwnerNick string, isFirstRun bool) {
+	servers := cfg.ServerList()
+	serverIndex := 0
+	failures := 0
+
+	for {
+		// Check for shutdown before attempting a new connection
+		select {
+		case <-ctx.Done():
+			logger.Infof("Exiting connection loop due to shutdown signal.")
+			return
+		default:
+		}
+
+		server := servers[serverIndex]
+		logger.Infof("Attempting to connect to IRC server at %s...", server)
+
+		stable := false
+		client, conn, err := EstablishConnection(ctx, cfg, server, initialOwnerNick, isFirstRun)
+		if err != nil {
+			logger.Errorf("Failed to connect to %s: %v", server, err)
+		} else {
+			// Only ask the owner for verification on the first connection
+			isFirstRun = false
+
+			connectedAt := time.Now()
+			if !runClient(ctx, client, conn) {
+				return
+			}
+			stable = time.Since(connectedAt) >= stableAfter
+		}
+
+		if stable {
+			// The connection was healthy, so start over with the same server
+			failures = 0
+		} else {
+			// Move on to the next server after a failed attempt
+			failures++
+			serverIndex = (serverIndex + 1) % len(servers)
+		}
+
+		delay := backoff(cfg, failures)
+		logger.Warnf("Reconnecting in %s...", delay.Round(time.Second))
+		select {
+		case <-ctx.Done():
+			return // Exit if shutdown was requested during sleep
+		case <-time.After(delay):
+		}
+	}
+}
+
+// runClient runs the client until it disconnects or ctx is cancelled.
+// Returns false when the bot is shutting down.
+func runClient(ctx context.Context, client *irc.Client, conn net.Conn) bool {
+	runErrCh := make(chan error, 1)
+	go func() {
+		runErrCh <- client.Run()
+	}()
+
+	defer health.RecordDisconnected()
+
+	// Wait until either the client stops or a shutdown is requested
+	select {
+	case <-ctx.Done():
+		logger.Infof("Shutdown requested, closing connection.")
+		if err := conn.Close(); err != nil {
+			logger.Errorf("Error closing connection: %v", err)
+		}
+
+		// Wait for client.Run to terminate and log any errors
+		if err := <-runErrCh; err != nil {
+			logger.Errorf("client.Run terminated with error: %v", err)
+		} else {
+			logger.Infof("client.Run terminated cleanly")
+		}
+		return false
+	case err := <-runErrCh:
+		if err != nil {
+			logger.Errorf("IRC client disconnected: %v", err)
+		}
+	}
+
+	// Close the connection after client.Run exits
+	if err := conn.Close(); err != nil {
+		logger.Debugf("Error closing connection: %v", err)
+	}
+	return true
+}
+
+// backoff returns the delay before the next attempt: the base delay doubled
+// for every consecutive failure after the first, capped, with up to half of
+// it randomized so many bots don't reconnect in lockstep
+func backoff(cfg *config.Config, failures int) time.Duration {
+	base := time.Duration(orDefault(cfg.ReconnectDelay, internal.DEFAULT_RECONNECT_DELAY)) * time.Second
+	maxDelay := time.Duration(orDefault(cfg.ReconnectMaxDelay, internal.DEFAULT_RECONNECT_MAX_DELAY)) * time.Second
+
+	delay := base
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// orDefault returns value, or def when value is not set
+func orDefault(value, def int) int {
+	if value <= 0 {
+		return def
+	}
+	return value
+}
+
+// EstablishConnection connects to server and sets up the IRC client and its
+// lag check. The returned connection must be closed by the caller.
+func EstablishConnection(ctx context.Context, cfg *config.Config, server string, initialOwnerNick string, isFirstRun bool) (*irc.Client, net.Conn, error) {
 	connectionTimeout := time.Duration(internal.DEFAULT_CONNECT_TIMEOUT) * time.Second
-	
+
 	// Use DialContext so that dialing can be canceled with a timeout
 	connectCtx, connectCancel := context.WithTimeout(ctx, connectionTimeout)
 	defer connectCancel()
-	
-	dialer := net.Dialer{}
-	conn, err := dialer.DialContext(connectCtx, "tcp", cfg.Server)
+
+	conn, err := Dial(connectCtx, cfg, server)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	
+
 	// Setup and run the IRC client.
 	client := bot.SetupClient(conn, cfg)
-	
+	health.RecordConnected(server)
+
+	pingInterval := time.Duration(orDefault(cfg.PingInterval, internal.DEFAULT_PING_INTERVAL)) * time.Second
+	pingTimeout := time.Duration(orDefault(cfg.PingTimeout, internal.DEFAULT_PING_TIMEOUT)) * time.Second
+	health.StartLagCheck(client, conn, pingInterval, pingTimeout)
+
 	// If this is the first run, schedule a verification request to the owner
 	if isFirstRun {
 		go scheduleOwnerVerification(client, initialOwnerNick)
 	}
-	
-	return client, nil
+
+	return client, conn, nil
 }
 
 // scheduleOwnerVerification waits for the bot to connect and then sends a verification request
 func scheduleOwnerVerification(client *irc.Client, initialOwnerNick string) {
 	// Wait 10 seconds to ensure the bot has connected and joined channels
 	time.Sleep(10 * time.Second)
-	
+
 	// Make sure we have a valid owner nickname
 	if initialOwnerNick != "" {
 		logger.Infof("Starting owner verification with nickname: %s", initialOwnerNick)
@@ -50,4 +180,4 @@ func scheduleOwnerVerification(client *irc.Client, initialOwnerNick string) {
 	} else {
 		logger.Errorf("Owner nickname is empty, unable to send verification request")
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,58 @@
+package connection
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"ircbot/internal/config"
+)
+
+// Dial opens the transport to an IRC server, wrapping it in TLS when the
+// configuration asks for it.
+func Dial(ctx context.Context, cfg *config.Config, server string) (net.Conn, error) {
+	dialer := net.Dialer{}
+	if !cfg.TLS {
+		return dialer.DialContext(ctx, "tcp", server)
+	}
+
+	tlsConfig, err := buildTLSConfig(cfg, server)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsDialer := tls.Dialer{NetDialer: &dialer, Config: tlsConfig}
+	return tlsDialer.DialContext(ctx, "tcp", server)
+}
+
+// buildTLSConfig creates the TLS settings for the server connection,
+// including the client certificate used for CertFP / SASL EXTERNAL.
+func buildTLSConfig(cfg *config.Config, server string) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server address %s: %w", server, err)
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: !cfg.TLSVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if cfg.ClientCert != "" {
+		// A single PEM file may hold both the certificate and the key
+		keyFile := cfg.ClientKey
+		if keyFile == "" {
+			keyFile = cfg.ClientCert
+		}
+
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -8,6 +8,9 @@ const (
 	RPL_MYINFO            = "004"
 	RPL_ISUPPORT          = "005"
 	RPL_USERHOST          = "302"
+	RPL_ISON              = "303"
+	RPL_WHOREPLY          = "352"
+	RPL_WHOSPCRPL         = "354"
 	RPL_ENDOFWHO          = "315"
 	RPL_WHOISUSER         = "311"
 	RPL_WHOISSERVER       = "312"
@@ -42,19 +45,32 @@ const (
 	RPL_MOTDSTART         = "375"
 	RPL_MOTD              = "372"
 	RPL_ENDOFMOTD         = "376"
+	ERR_NOMOTD            = "422"
 	
 	// Authentication
 	RPL_LOGGEDIN          = "900"
+	RPL_LOGGEDOUT         = "901"
+	ERR_NICKLOCKED        = "902"
 	RPL_SASLSUCCESS       = "903"
+	ERR_SASLFAIL          = "904"
+	ERR_SASLTOOLONG       = "905"
+	ERR_SASLABORTED       = "906"
+	ERR_SASLALREADY       = "907"
+	RPL_SASLMECHS         = "908"
 	RPL_UMODEIS           = "221"
 	RPL_HOSTHIDDEN        = "396"
 
+	// MONITOR
+	RPL_MONONLINE         = "730"
+	RPL_MONOFFLINE        = "731"
+
 	// Errors
 	ERR_NOSUCHNICK        = "401"
 	ERR_NOSUCHCHANNEL     = "403"
 	ERR_CANNOTSENDTOCHAN  = "404"
 	ERR_TOOMANYCHANNELS   = "405"
 	ERR_NICKNAMEINUSE     = "433"
+	ERR_UNAVAILRESOURCE   = "437"
 	ERR_BANNEDFROMCHAN    = "474"
 	ERR_CHANNELISFULL     = "471"
 	ERR_INVITEONLYCHAN    = "473"
@@ -74,6 +90,14 @@ const (
 	CMD_TOPIC             = "TOPIC"
 	CMD_MODE              = "MODE"
 	CMD_ERROR             = "ERROR"
+	CMD_CAP               = "CAP"
+	CMD_AUTHENTICATE      = "AUTHENTICATE"
+	CMD_ACCOUNT           = "ACCOUNT"
+	CMD_AWAY              = "AWAY"
+	CMD_BATCH             = "BATCH"
+	CMD_CHGHOST           = "CHGHOST"
+	CMD_ISON              = "ISON"
+	CMD_MONITOR           = "MONITOR"
 )
 
 const (
@@ -82,7 +106,11 @@ const (
 	DEFAULT_CONFIG_PATH   = "./data/config.toml"
 	DEFAULT_SETTINGS_PATH = "./data/settings.toml"
 	DEFAULT_PLUGINS_PATH  = "./plugins"
+	DEFAULT_PLUGINS_SRC_PATH = "./plugins_src"
 	
 	DEFAULT_RECONNECT_DELAY = 5
+	DEFAULT_RECONNECT_MAX_DELAY = 300
 	DEFAULT_CONNECT_TIMEOUT = 30
+	DEFAULT_PING_INTERVAL = 60
+	DEFAULT_PING_TIMEOUT = 30
 )
\ No newline at end of file
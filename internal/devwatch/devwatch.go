@@ -0,0 +1,311 @@
+// Package devwatch is the plugin development mode: it watches the plugin
+// sources for changes, rebuilds a changed plugin, loads the new build and
+// reports compile errors on IRC.
+package devwatch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/irc.v4"
+	"ircbot/internal"
+	"ircbot/internal/logger"
+	"ircbot/internal/plugin"
+)
+
+const (
+	// PollInterval is how often the sources are checked for changes. A
+	// change is built once the files have stayed the same for a full
+	// interval, so an editor's save in several steps is built only once.
+	PollInterval = time.Second
+
+	// maxReportLines bounds the compiler messages sent to IRC; the log
+	// has all of them
+	maxReportLines = 5
+)
+
+// source is a plugin source: a .go file of a Go plugin, or the package
+// directory of an RPC plugin
+type source struct {
+	name string
+	path string
+	dir  bool
+	// stamp changes whenever a file of the source is written
+	stamp string
+}
+
+// watcher holds the state of the sources between polls
+type watcher struct {
+	srcDir    string
+	pluginDir string
+	stamps    map[string]string
+	hashes    map[string]string
+	dirty     map[string]bool
+}
+
+var (
+	mu       sync.Mutex
+	client   *irc.Client
+	reportTo string
+	stop     chan struct{}
+)
+
+// Start watches srcDir and builds changed plugins into pluginDir. Compile
+// and load errors are sent to target, a channel or nick, if it is set.
+// The sources as they are now are taken to be built already.
+func Start(srcDir, pluginDir, target string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if stop != nil {
+		close(stop)
+	}
+	stop = make(chan struct{})
+	reportTo = target
+
+	w := &watcher{
+		srcDir:    srcDir,
+		pluginDir: pluginDir,
+		stamps:    make(map[string]string),
+		hashes:    make(map[string]string),
+		dirty:     make(map[string]bool),
+	}
+	for _, src := range w.scan() {
+		w.stamps[src.name] = src.stamp
+		if hash, err := hashSource(src); err == nil {
+			w.hashes[src.name] = hash
+		}
+	}
+
+	logger.Infof("Plugin dev mode: watching %s for changes", srcDir)
+	go w.run(stop)
+}
+
+// Stop stops watching the sources
+func Stop() {
+	mu.Lock()
+	defer mu.Unlock()
+	if stop != nil {
+		close(stop)
+		stop = nil
+	}
+}
+
+// HandleRegistered remembers the client of a new connection for reports
+func HandleRegistered(c *irc.Client) {
+	mu.Lock()
+	client = c
+	mu.Unlock()
+}
+
+// run polls the sources until stopped
+func (w *watcher) run(done chan struct{}) {
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			w.poll()
+		}
+	}
+}
+
+// poll builds the sources that changed and have settled since
+func (w *watcher) poll() {
+	for _, src := range w.scan() {
+		previous := w.stamps[src.name]
+		w.stamps[src.name] = src.stamp
+		if src.stamp != previous {
+			w.dirty[src.name] = true
+			continue
+		}
+		if w.dirty[src.name] {
+			delete(w.dirty, src.name)
+			w.rebuild(src)
+		}
+	}
+}
+
+// scan returns the plugin sources in the source directory
+func (w *watcher) scan() []source {
+	entries, err := os.ReadDir(w.srcDir)
+	if err != nil {
+		logger.Warnf("Plugin dev mode: cannot read %s: %v", w.srcDir, err)
+		return nil
+	}
+
+	var sources []source
+	for _, entry := range entries {
+		path := filepath.Join(w.srcDir, entry.Name())
+		switch {
+		case entry.IsDir():
+			stamp, err := dirStamp(path)
+			if err != nil || stamp == "" {
+				continue
+			}
+			sources = append(sources, source{name: entry.Name(), path: path, dir: true, stamp: stamp})
+		case filepath.Ext(entry.Name()) == ".go":
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			sources = append(sources, source{
+				name:  strings.TrimSuffix(entry.Name(), ".go"),
+				path:  path,
+				stamp: fileStamp(info),
+			})
+		}
+	}
+	return sources
+}
+
+// fileStamp describes a file's last write
+func fileStamp(info fs.FileInfo) string {
+	return fmt.Sprintf("%d/%d", info.ModTime().UnixNano(), info.Size())
+}
+
+// dirStamp describes the last writes of the Go files in a package
+// directory, or returns "" if it has none
+func dirStamp(dir string) (string, error) {
+	var stamps []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isPackageFile(path) {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		stamps = append(stamps, path+"@"+fileStamp(info))
+		return nil
+	})
+	sort.Strings(stamps)
+	return strings.Join(stamps, ","), err
+}
+
+// isPackageFile reports whether a file is part of an RPC plugin's build
+func isPackageFile(path string) bool {
+	name := filepath.Base(path)
+	return filepath.Ext(name) == ".go" || name == "go.mod" || name == "go.sum"
+}
+
+// hashSource returns the SHA-256 of a source's contents
+func hashSource(src source) (string, error) {
+	if !src.dir {
+		data, err := os.ReadFile(src.path)
+		if err != nil {
+			return "", err
+		}
+		return plugin.SourceHash(data), nil
+	}
+
+	h := sha256.New()
+	err := filepath.WalkDir(src.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isPackageFile(path) {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s %d\n", path, len(data))
+		h.Write(data)
+		return nil
+	})
+	return hex.EncodeToString(h.Sum(nil)), err
+}
+
+// rebuild builds and loads a changed source, unless its contents are the
+// same as before or were just built by something else, like !load-online
+func (w *watcher) rebuild(src source) {
+	hash, err := hashSource(src)
+	if err != nil {
+		logger.Warnf("Plugin dev mode: cannot read %s: %v", src.path, err)
+		return
+	}
+	if hash == w.hashes[src.name] {
+		return
+	}
+	w.hashes[src.name] = hash
+
+	var version, path string
+	if src.dir {
+		logger.Infof("Plugin dev mode: %s changed, building RPC plugin %s", src.path, src.name)
+		path, err = plugin.BuildRPCPlugin(src.path, w.pluginDir)
+	} else {
+		data, readErr := os.ReadFile(src.path)
+		if readErr != nil {
+			logger.Warnf("Plugin dev mode: cannot read %s: %v", src.path, readErr)
+			return
+		}
+		if plugin.BuiltSource(src.name) == plugin.SourceHash(data) {
+			return
+		}
+		logger.Infof("Plugin dev mode: %s changed, building plugin %s", src.path, src.name)
+		version, path, err = plugin.BuildGoPlugin(src.name, data, w.pluginDir)
+	}
+	if err != nil {
+		reportBuildError(src, err)
+		return
+	}
+
+	err = plugin.LoadBuild(path)
+	var loaded *plugin.AlreadyLoadedError
+	if errors.As(err, &loaded) {
+		report(fmt.Sprintf("Plugin %s is already loaded from the contents of %s", src.name, src.path))
+		return
+	}
+	if err != nil {
+		logger.Errorf("Plugin dev mode: error loading %s: %v", path, err)
+		report(fmt.Sprintf("Plugin %s was built but failed to load: %v", src.name, err))
+		return
+	}
+	if version != "" {
+		report(fmt.Sprintf("Plugin %s rebuilt from %s and loaded (version %s)", src.name, src.path, version))
+	} else {
+		report(fmt.Sprintf("Plugin %s rebuilt from %s and loaded", src.name, src.path))
+	}
+}
+
+// reportBuildError reports the first compiler messages of a failed build
+func reportBuildError(src source, err error) {
+	var buildErr *plugin.BuildError
+	if !errors.As(err, &buildErr) {
+		logger.Errorf("Plugin dev mode: error building %s: %v", src.path, err)
+		report(fmt.Sprintf("Build of %s failed: %v", src.path, err))
+		return
+	}
+
+	lines := buildErr.Lines()
+	report(fmt.Sprintf("Build of %s failed:", src.path))
+	for i, line := range lines {
+		if i == maxReportLines {
+			report(fmt.Sprintf("... and %d more, see the log", len(lines)-i))
+			break
+		}
+		report(line)
+	}
+}
+
+// report sends a line to the report target, if there is one and the bot
+// is connected
+func report(line string) {
+	logger.Infof("Plugin dev mode: %s", line)
+	mu.Lock()
+	c, target := client, reportTo
+	mu.Unlock()
+	if c == nil || target == "" {
+		return
+	}
+	c.Writef("%s %s :%s", internal.CMD_PRIVMSG, target, line)
+}
@@ -0,0 +1,227 @@
+// Package events is a publish/subscribe bus for events above the level of
+// raw IRC messages, such as "user auto-ignored" or "plugin loaded". The
+// bot's subsystems publish the core topics below; plugins subscribe to them
+// and publish topics of their own. Every subscription belongs to an owner,
+// normally a plugin, so that all of an owner's subscriptions can be dropped
+// together when it is unloaded.
+package events
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ircbot/internal/logger"
+)
+
+// Core topics, published by the bot itself
+const (
+	TopicUserAutoIgnored = "user.auto_ignored"
+	TopicAIResponse      = "ai.response"
+	TopicNoteSaved       = "note.saved"
+	TopicNoteDeleted     = "note.deleted"
+	TopicPluginLoaded    = "plugin.loaded"
+	TopicPluginUnloaded  = "plugin.unloaded"
+)
+
+// SourceCore is the source of events published by the bot itself
+const SourceCore = "core"
+
+// reservedPrefixes are the topic namespaces only the bot may publish to
+var reservedPrefixes = []string{"user.", "ai.", "note.", "plugin."}
+
+// UserAutoIgnored is the data of TopicUserAutoIgnored
+type UserAutoIgnored struct {
+	Nick     string `json:"nick"`
+	Hostmask string `json:"hostmask"`
+	// Reason is "message spam", "private message spam" or "command spam"
+	Reason   string `json:"reason"`
+	Warnings int    `json:"warnings"`
+}
+
+// AIResponse is the data of TopicAIResponse
+type AIResponse struct {
+	Channel  string `json:"channel"`
+	Nick     string `json:"nick"`
+	Question string `json:"question"`
+	Response string `json:"response"`
+}
+
+// NoteSaved is the data of TopicNoteSaved
+type NoteSaved struct {
+	ID      string `json:"id"`
+	User    string `json:"user"`
+	Channel string `json:"channel"`
+	Note    string `json:"note"`
+}
+
+// NoteDeleted is the data of TopicNoteDeleted
+type NoteDeleted struct {
+	ID   string `json:"id"`
+	User string `json:"user"`
+}
+
+// PluginLoaded is the data of TopicPluginLoaded
+type PluginLoaded struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// PluginUnloaded is the data of TopicPluginUnloaded
+type PluginUnloaded struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Event is a published event
+type Event struct {
+	Topic string
+	// Source is SourceCore or the name of the publishing plugin
+	Source string
+	Time   time.Time
+	// Data is one of the types above for core topics, or whatever the
+	// publishing plugin sent
+	Data interface{}
+}
+
+// Handler receives events
+type Handler func(Event)
+
+// Subscription describes a subscription
+type Subscription struct {
+	ID      int
+	Owner   string
+	Pattern string
+}
+
+type subscription struct {
+	Subscription
+	handler Handler
+}
+
+var (
+	subscriptions = make(map[int]*subscription)
+	nextID        int
+	mu            sync.Mutex
+)
+
+// Deliver runs a handler for an event. The plugin manager replaces it to
+// run plugin handlers on the plugin's worker; by default every handler
+// runs in its own goroutine.
+var Deliver = func(owner, topic string, f func()) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Errorf("Event handler of %s for %s panicked: %v", owner, topic, r)
+			}
+		}()
+		f()
+	}()
+}
+
+// Subscribe calls handler for every event whose topic matches pattern: a
+// topic, a prefix ending in ".*" such as "note.*", or "*" for everything
+func Subscribe(owner, pattern string, handler Handler) (int, error) {
+	if handler == nil {
+		return 0, fmt.Errorf("subscription has no handler")
+	}
+	if pattern == "" {
+		return 0, fmt.Errorf("topic must not be empty")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	nextID++
+	subscriptions[nextID] = &subscription{
+		Subscription: Subscription{ID: nextID, Owner: owner, Pattern: pattern},
+		handler:      handler,
+	}
+	logger.Debugf("%s subscribed to %s", owner, pattern)
+	return nextID, nil
+}
+
+// Unsubscribe removes a subscription. Returns false if there is none.
+func Unsubscribe(id int) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	_, ok := subscriptions[id]
+	delete(subscriptions, id)
+	return ok
+}
+
+// UnsubscribeOwner removes all subscriptions of an owner and returns how
+// many there were
+func UnsubscribeOwner(owner string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	count := 0
+	for id, s := range subscriptions {
+		if s.Owner == owner {
+			delete(subscriptions, id)
+			count++
+		}
+	}
+	return count
+}
+
+// Subscriptions returns all subscriptions, ordered by ID
+func Subscriptions() []Subscription {
+	mu.Lock()
+	list := make([]Subscription, 0, len(subscriptions))
+	for _, s := range subscriptions {
+		list = append(list, s.Subscription)
+	}
+	mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Publish sends an event to its subscribers without waiting for them
+func Publish(source, topic string, data interface{}) {
+	e := Event{Topic: topic, Source: source, Time: time.Now(), Data: data}
+
+	mu.Lock()
+	var matching []*subscription
+	for _, s := range subscriptions {
+		if matches(s.Pattern, topic) {
+			matching = append(matching, s)
+		}
+	}
+	mu.Unlock()
+
+	for _, s := range matching {
+		handler := s.handler
+		Deliver(s.Owner, topic, func() { handler(e) })
+	}
+}
+
+// PublishFrom publishes an event for a plugin, which may not use the
+// topics reserved for the bot
+func PublishFrom(plugin, topic string, data interface{}) error {
+	if topic == "" || strings.Contains(topic, "*") {
+		return fmt.Errorf("invalid topic %q", topic)
+	}
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(topic, prefix) {
+			return fmt.Errorf("topic %s is reserved for the bot", topic)
+		}
+	}
+	Publish(plugin, topic, data)
+	return nil
+}
+
+// matches reports whether a topic matches a subscription pattern
+func matches(pattern, topic string) bool {
+	if pattern == "*" || pattern == topic {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasSuffix(prefix, ".") && strings.HasPrefix(topic, prefix)
+	}
+	return false
+}
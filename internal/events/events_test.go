@@ -0,0 +1,113 @@
+package events
+
+import (
+	"testing"
+
+	"ircbot/internal/logger"
+)
+
+// deliverInline runs handlers on the publishing goroutine for the rest of the test
+func deliverInline(t *testing.T) {
+	t.Helper()
+	logger.SetLogDir(t.TempDir())
+	saved := Deliver
+	Deliver = func(owner, topic string, f func()) { f() }
+	t.Cleanup(func() { Deliver = saved })
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{"*", "note.saved", true},
+		{"*", "anything", true},
+		{"note.saved", "note.saved", true},
+		{"note.saved", "note.deleted", false},
+		{"note.*", "note.saved", true},
+		{"note.*", "note.a.b", true},
+		{"note.*", "notes.x", false},
+		{"note.*", "note", false},
+		{"note*", "notes.x", false},
+		{"note", "note.saved", false},
+	}
+
+	for _, tt := range tests {
+		if got := matches(tt.pattern, tt.topic); got != tt.want {
+			t.Errorf("matches(%q, %q) = %v, want %v", tt.pattern, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestPublishFromReservedTopics(t *testing.T) {
+	deliverInline(t)
+
+	var got []string
+	id, err := Subscribe("test-reserved", "*", func(e Event) {
+		got = append(got, e.Source+" "+e.Topic)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Unsubscribe(id) })
+
+	tests := []struct {
+		topic   string
+		wantErr bool
+	}{
+		{"note.saved", true},
+		{"user.anything", true},
+		{"ai.response", true},
+		{"plugin.loaded", true},
+		{"", true},
+		{"weather.*", true},
+		{"notes.x", false},
+		{"weather.updated", false},
+	}
+
+	for _, tt := range tests {
+		err := PublishFrom("myplugin", tt.topic, nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("PublishFrom(%q) error = %v, want error %v", tt.topic, err, tt.wantErr)
+		}
+	}
+
+	want := []string{"myplugin notes.x", "myplugin weather.updated"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
+
+func TestUnsubscribeOwner(t *testing.T) {
+	deliverInline(t)
+
+	count := make(map[string]int)
+	subscribe := func(owner, pattern string) {
+		t.Helper()
+		if _, err := Subscribe(owner, pattern, func(Event) { count[owner]++ }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	subscribe("test-a", "x.one")
+	subscribe("test-a", "x.*")
+	subscribe("test-b", "x.one")
+	t.Cleanup(func() { UnsubscribeOwner("test-b") })
+
+	if n := UnsubscribeOwner("test-a"); n != 2 {
+		t.Errorf("UnsubscribeOwner(test-a) = %d, want 2", n)
+	}
+	if n := UnsubscribeOwner("test-a"); n != 0 {
+		t.Errorf("second UnsubscribeOwner(test-a) = %d, want 0", n)
+	}
+	for _, s := range Subscriptions() {
+		if s.Owner == "test-a" {
+			t.Errorf("subscription %+v of test-a is still listed", s)
+		}
+	}
+
+	Publish(SourceCore, "x.one", nil)
+	if count["test-a"] != 0 || count["test-b"] != 1 {
+		t.Errorf("deliveries = %v, want test-b only", count)
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"gopkg.in/irc.v4"
+	"ircbot/internal"
+	"ircbot/internal/capabilities"
+	"ircbot/internal/channelstate"
+	"ircbot/internal/userlevels"
+)
+
+// trackAccount records which services account the sender of a message is
+// logged in to, so permissions can be granted by account instead of nick
+func trackAccount(c *irc.Client, m *irc.Message) {
+	if m.Prefix == nil || m.Prefix.User == "" {
+		return
+	}
+	nick := m.Prefix.Name
+
+	switch m.Command {
+	case internal.CMD_ACCOUNT: // account-notify
+		if len(m.Params) > 0 {
+			userlevels.SetNickAccount(nick, m.Params[0])
+		}
+		return
+	case internal.CMD_JOIN:
+		// extended-join: JOIN #channel account :realname
+		if capabilities.Enabled("extended-join") && len(m.Params) > 1 {
+			userlevels.SetNickAccount(nick, m.Params[1])
+			return
+		}
+	case internal.CMD_NICK:
+		if len(m.Params) > 0 {
+			userlevels.RenameNick(nick, m.Params[0])
+		}
+		return
+	case internal.CMD_QUIT:
+		userlevels.ForgetNick(nick)
+		return
+	}
+
+	// With account-tag every message from a logged in user carries the account
+	if capabilities.Enabled("account-tag") {
+		userlevels.SetNickAccount(nick, capabilities.MessageAccount(m))
+	}
+}
+
+// handleWhoxReply records the accounts from the WHOX query sent when the
+// bot joins a channel
+func handleWhoxReply(m *irc.Message) {
+	if reply, ok := channelstate.ParseWhoxReply(m); ok {
+		userlevels.SetNickAccount(reply.Nick, reply.Account)
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"bufio"
 	"fmt"
+	"ircbot/internal/capabilities"
 	"ircbot/internal/commands"
 	"ircbot/internal/config"
 	"ircbot/internal/logger"
@@ -21,9 +22,9 @@ func HandleChannelMessage(c *irc.Client, m *irc.Message) {
 	userNick := m.Prefix.Name
 	channel := m.Params[0]
 	
-	// Check if user is ignored - if so, don't process any interactions
+	// Check if user is ignored here - if so, don't process any interactions
 	hostmask := m.Prefix.String()
-	userLevel := userlevels.GetUserLevelByHostmask(hostmask)
+	userLevel := userlevels.GetEffectiveLevel(channel, hostmask)
 	if userLevel == userlevels.Ignored {
 		// Still log the message but don't interact with ignored users
 		logger.ChanMsgf("%s | %s (IGNORED): %s", channel, userNick, message)
@@ -38,13 +39,13 @@ func HandleChannelMessage(c *irc.Client, m *irc.Message) {
 			userNick, channel, messageCount)
 		
 		// If this is their first offense, warn them
-		if security.GlobalMessageTracker.AddWarning(hostmask) {
+		if security.GlobalMessageTracker.AddWarning(hostmask, "message spam") {
 			// If they've hit the warning threshold, auto-ignore them
 			logger.Warnf("User %s (%s) has been automatically ignored for spam", 
 				userNick, hostmask)
 			
 			// Automatically set them to ignored level
-			userlevels.SetUserLevel(hostmask, userlevels.Ignored)
+			userlevels.SetUserLevelByHostmask(hostmask, userlevels.Ignored)
 			userlevels.SaveHostmasks()
 			
 			// Notify the channel about the auto-ignore
@@ -81,7 +82,7 @@ func HandleChannelMessage(c *irc.Client, m *irc.Message) {
 	hasURL := CheckForURL(message)
 
 	// Log all channel messages to file
-	logger.LogChannelMessage(channel, userNick, message)
+	logger.LogChannelMessageAt(capabilities.MessageTime(m), channel, userNick, message)
 
 	switch {
 	case isBotMentioned:
@@ -113,16 +114,9 @@ func HandleChannelMessage(c *irc.Client, m *irc.Message) {
 				logger.Errorf("Failed to get channel context: %v", err)
 			}
 
-			// Format the final message with context, making it clearer to the AI
-			contextualQuestion := fmt.Sprintf(
-				"Reply to the user query below in a direct conversational style. Don't reference yourself in third person. Keep your response concise and match the brevity of the user's message. Don't add follow-up questions unless absolutely necessary.\n\n"+
-				"USER QUERY: %s\n\n"+
-				"CURRENT CHANNEL: %s\n\n"+
-				"CHANNEL CONTEXT (for your awareness only, don't reference this directly):\n%s",
-				originalQuestion, channel, channelContext)
-
-			// Use AI with the contextual message
-			commands.HandleAIResponse(c, channel, userNick, contextualQuestion, channel)
+			// Use AI with the channel context; only the question itself is
+			// remembered in the conversation with the user
+			commands.HandleAIResponseWithContext(c, channel, userNick, hostmask, originalQuestion, channelContext, channel)
 		} else {
 			// Log the mention but don't respond if AI is disabled
 			logger.Debugf("Ignored mention in %s from %s (AI for mentions disabled)", channel, userNick)
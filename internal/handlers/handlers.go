@@ -3,11 +3,17 @@ package handlers
 import (
 	"fmt"
 	"strings"
-	"time"
 
 	"gopkg.in/irc.v4"
 	"ircbot/internal"
+	"ircbot/internal/autojoin"
+	"ircbot/internal/capabilities"
+	"ircbot/internal/channelstate"
+	"ircbot/internal/commands"
+	"ircbot/internal/devwatch"
+	"ircbot/internal/health"
 	"ircbot/internal/logger"
+	"ircbot/internal/nickrecovery"
 	"ircbot/internal/plugin"
 	"ircbot/internal/userlevels"
 )
@@ -92,18 +98,24 @@ func HandlePing(c *irc.Client, m *irc.Message) {
 }
 
 // HandleMessages processes incoming messages and dispatches them to the appropriate handlers.
-func HandleMessages(c *irc.Client, m *irc.Message, password string, channels []string) {
+func HandleMessages(c *irc.Client, m *irc.Message) {
+	channelstate.Handle(m)
+	trackAccount(c, m)
+
+	// With echo-message the server sends our own messages back; log them
+	// but never treat them as commands, mentions or plugin events
+	if isOwnEcho(c, m) {
+		handleOwnEcho(m)
+		return
+	}
+
 	switch m.Command {
 	// Connection Registration
 	case internal.RPL_WELCOME: // 001
 		logger.Successf(">> Welcome message received: %s", m.Trailing())
-		if password != "" {
-			if err := c.Writef("%s NickServ :IDENTIFY %s", internal.CMD_PRIVMSG, password); err != nil {
-				logger.Errorf(">> Error identifying with NickServ: %v", err)
-			} else {
-				logger.Successf(">> Identifying with NickServ...")
-			}
-		}
+		capabilities.MarkRegistered()
+		// Falls back to NickServ when SASL was not used or did not succeed
+		nickrecovery.HandleWelcome(c, m)
 	case internal.RPL_YOURHOST, internal.RPL_CREATED, internal.RPL_MYINFO, internal.RPL_ISUPPORT:
 		logger.Infof(">> Server Info: %s", m.Trailing())
 
@@ -123,42 +135,63 @@ func HandleMessages(c *irc.Client, m *irc.Message, password string, channels []s
 		logger.Bluef(">> MOTD Start: %s", m.Trailing())
 	case internal.RPL_MOTD:
 		logger.Bluef(">> MOTD: %s", m.Trailing())
-	case internal.RPL_ENDOFMOTD:
+	case internal.RPL_ENDOFMOTD, internal.ERR_NOMOTD:
 		userlevels.LoadHostmasks()
 		logger.Infof(">> User levels loaded from settings")
 		logger.Bluef(">> End of MOTD")
 
-		// Delay channel join to allow proper identification
-		time.Sleep(5 * time.Second)
-		for _, channel := range channels {
-			if err := c.Writef("%s %s", internal.CMD_JOIN, channel); err != nil {
-				logger.Errorf(">> Error joining channel %s: %v", channel, err)
-			} else {
-				logger.Successf(">> Joining channel: %s", channel)
-			}
-		}
+		// Channels are joined once the bot has identified, if it needs to
+		autojoin.HandleRegistered(c)
+		devwatch.HandleRegistered(c)
+
+	// Capability negotiation and SASL
+	case internal.CMD_CAP:
+		capabilities.HandleCap(c, m)
+	case internal.CMD_AUTHENTICATE:
+		capabilities.HandleAuthenticate(c, m)
+	case internal.RPL_SASLSUCCESS, internal.ERR_SASLFAIL, internal.ERR_SASLTOOLONG,
+		internal.ERR_SASLABORTED, internal.ERR_SASLALREADY, internal.ERR_NICKLOCKED, internal.RPL_SASLMECHS:
+		capabilities.HandleSASLResult(c, m)
 
 	// PING/PONG
 	case internal.CMD_PING:
 		HandlePing(c, m)
 	case internal.CMD_PONG:
-		logger.Successf(">> PONG received from server: %s", m.Trailing())
+		if health.HandlePong(m) {
+			logger.Debugf(">> Lag check PONG received, lag %s", health.Get().Lag)
+		} else {
+			logger.Successf(">> PONG received from server: %s", m.Trailing())
+		}
 
 	// Standard IRC Commands
 	case internal.CMD_JOIN:
 		channel := m.Params[0]
 		nickname := m.Prefix.Name
-		logger.Infof(">> %s joined %s", nickname, channel)
-		logger.LogChannelEvent(channel, nickname + " has joined the channel")
+		// extended-join adds the account name (or "*") after the channel
+		if len(m.Params) > 1 && m.Params[1] != "*" {
+			logger.Infof(">> %s (account: %s) joined %s", nickname, m.Params[1], channel)
+		} else {
+			logger.Infof(">> %s joined %s", nickname, channel)
+		}
+		logger.LogChannelEventAt(capabilities.MessageTime(m), channel, nickname + " has joined the channel")
+		if nickname == c.CurrentNick() {
+			autojoin.HandleOwnJoin(channel)
+			if err := channelstate.RequestChannelInfo(c, channel); err != nil {
+				logger.Errorf(">> Error requesting channel info for %s: %v", channel, err)
+			}
+		}
 	case internal.CMD_PART:
 		channel := m.Params[0]
 		nickname := m.Prefix.Name
 		reason := m.Trailing()
 		logger.Infof(">> %s left %s: %s", nickname, channel, reason)
+		if nickname == c.CurrentNick() {
+			autojoin.HandleOwnPart(channel)
+		}
 		if reason != "" {
-			logger.LogChannelEvent(channel, nickname + " has left the channel (" + reason + ")")
+			logger.LogChannelEventAt(capabilities.MessageTime(m), channel, nickname + " has left the channel (" + reason + ")")
 		} else {
-			logger.LogChannelEvent(channel, nickname + " has left the channel")
+			logger.LogChannelEventAt(capabilities.MessageTime(m), channel, nickname + " has left the channel")
 		}
 	case internal.CMD_KICK:
 		channel := m.Params[0]
@@ -166,21 +199,27 @@ func HandleMessages(c *irc.Client, m *irc.Message, password string, channels []s
 		kicker := m.Prefix.Name
 		reason := m.Trailing()
 		logger.Warnf(">> %s was kicked from %s by %s: %s", kickedUser, channel, kicker, reason)
-		logger.LogChannelEvent(channel, kickedUser + " was kicked by " + kicker + " (" + reason + ")")
+		logger.LogChannelEventAt(capabilities.MessageTime(m), channel, kickedUser + " was kicked by " + kicker + " (" + reason + ")")
+		autojoin.HandleKick(c, m)
 	case internal.CMD_QUIT:
 		logger.Infof(">> %s quit: %s", m.Prefix.Name, m.Trailing())
 	case internal.CMD_NICK:
 		logger.Infof(">> %s changed their nickname to %s", m.Prefix.Name, m.Trailing())
+		if m.Trailing() == c.CurrentNick() {
+			nickrecovery.HandleNick(c, m)
+		}
 	case internal.CMD_INVITE:
 		logger.Infof(">> %s invited %s to %s", m.Prefix.Name, m.Params[0], m.Trailing())
+		autojoin.HandleInvite(c, m)
 	case internal.CMD_TOPIC:
 		channel := m.Params[0]
 		nickname := m.Prefix.Name
 		topic := m.Trailing()
 		logger.Infof(">> %s changed the topic of %s to: %s", nickname, channel, topic)
-		logger.LogChannelEvent(channel, nickname + " changed the topic to: " + topic)
+		logger.LogChannelEventAt(capabilities.MessageTime(m), channel, nickname + " changed the topic to: " + topic)
 	case internal.CMD_NOTICE:
 		logger.Infof(">> NOTICE from %s: %s", m.Prefix.Name, m.Trailing())
+		autojoin.HandleNotice(c, m)
 	case internal.CMD_PRIVMSG:
 		if len(m.Params) > 0 && m.Params[0] == c.CurrentNick() {
 			// This is a private message to the bot
@@ -200,6 +239,24 @@ func HandleMessages(c *irc.Client, m *irc.Message, password string, channels []s
 	case internal.CMD_ERROR:
 		logger.Errorf(">> ERROR: %s", m.Trailing())
 
+	// IRCv3 notifications
+	case internal.CMD_ACCOUNT: // account-notify
+		if len(m.Params) > 0 && m.Params[0] != "*" {
+			logger.Infof(">> %s is now logged in as %s", m.Prefix.Name, m.Params[0])
+		} else {
+			logger.Infof(">> %s logged out", m.Prefix.Name)
+		}
+	case internal.CMD_AWAY: // away-notify
+		if m.Trailing() != "" {
+			logger.Whitef(">> %s is away: %s", m.Prefix.Name, m.Trailing())
+		} else {
+			logger.Whitef(">> %s is back", m.Prefix.Name)
+		}
+	case internal.CMD_BATCH:
+		if len(m.Params) > 0 {
+			logger.Debugf(">> Batch %s", strings.Join(m.Params, " "))
+		}
+
 	// Mode changes
 	case internal.CMD_MODE:
 		if len(m.Params) > 1 {
@@ -230,22 +287,45 @@ func HandleMessages(c *irc.Client, m *irc.Message, password string, channels []s
 		logger.LogChannelEvent(channel, "Topic set by " + setter + " at " + timeStamp)
 	case internal.RPL_NAMREPLY:
 		logger.Infof(">> Users in %s: %s", m.Params[2], m.Trailing())
+	case internal.RPL_WHOISUSER:
+		commands.HandleWhoisUser(c, m)
+	case internal.RPL_ENDOFWHOIS:
+		commands.HandleWhoisEnd(c, m)
+	case internal.RPL_WHOSPCRPL:
+		handleWhoxReply(m)
+	case internal.RPL_WHOREPLY, internal.RPL_ENDOFWHO:
+		// Consumed by the channel state tracker
+	case internal.RPL_CHANNELMODEIS:
+		if len(m.Params) > 2 {
+			logger.Infof(">> Modes for %s: %s", m.Params[1], strings.Join(m.Params[2:], " "))
+		}
 	case internal.RPL_ENDOFNAMES:
 		logger.Infof(">> End of /NAMES list for %s", m.Params[1])
 	case internal.RPL_LOGGEDIN:
 		logger.Successf(">> You are now logged in as %s", m.Params[2])
+		autojoin.HandleIdentified(c)
+	case internal.RPL_ISON:
+		nickrecovery.HandleIson(c, m)
+	case internal.RPL_MONOFFLINE:
+		nickrecovery.HandleMonitorOffline(c, m)
+	case internal.RPL_MONONLINE:
+		// The primary nick is still in use; wait for RPL_MONOFFLINE
 	case internal.RPL_HOSTHIDDEN:
 		logger.Successf(">> Your hidden host is now: %s", m.Params[1])
+		autojoin.HandleIdentified(c)
 
 	// Error Replies
-	case internal.ERR_NICKNAMEINUSE:
-		logger.Errorf(">> Nickname %s is already in use. Please choose a different one.", c.CurrentNick())
+	case internal.ERR_NICKNAMEINUSE, internal.ERR_UNAVAILRESOURCE:
+		nickrecovery.HandleNickInUse(c, m)
 	case internal.ERR_BANNEDFROMCHAN:
 		logger.Errorf(">> You are banned from the channel %s", m.Params[1])
+		autojoin.HandleJoinError(c, m)
 	case internal.ERR_CHANNELISFULL:
 		logger.Errorf(">> Channel %s is full", m.Params[1])
+		autojoin.HandleJoinError(c, m)
 	case internal.ERR_INVITEONLYCHAN:
 		logger.Errorf(">> Cannot join channel %s (invite-only)", m.Params[1])
+		autojoin.HandleJoinError(c, m)
 	case internal.ERR_BADCHANNELKEY:
 		logger.Errorf(">> Cannot join channel %s (bad key)", m.Params[1])
 	case internal.ERR_CHANOPRIVSNEEDED:
@@ -258,64 +338,122 @@ func HandleMessages(c *irc.Client, m *irc.Message, password string, channels []s
 	dispatchToPlugins(c, m)
 }
 
-// dispatchToPlugins routes messages to the appropriate plugin handlers
+// isOwnEcho reports whether a PRIVMSG/NOTICE is the server echoing back
+// something the bot sent itself (echo-message)
+func isOwnEcho(c *irc.Client, m *irc.Message) bool {
+	if m.Command != internal.CMD_PRIVMSG && m.Command != internal.CMD_NOTICE {
+		return false
+	}
+	if m.Prefix == nil || !capabilities.Enabled("echo-message") {
+		return false
+	}
+	return strings.EqualFold(m.Prefix.Name, c.CurrentNick())
+}
+
+// handleOwnEcho logs the bot's own channel messages using the server-time
+// of the echo, so the log matches what the channel actually saw
+func handleOwnEcho(m *irc.Message) {
+	if m.Command != internal.CMD_PRIVMSG || len(m.Params) < 1 {
+		return
+	}
+
+	target := m.Params[0]
+	message := m.Trailing()
+	// Actions are logged when they are sent
+	if strings.HasPrefix(message, "\x01") {
+		return
+	}
+	if strings.HasPrefix(target, "#") || strings.HasPrefix(target, "&") {
+		logger.LogChannelMessageAt(capabilities.MessageTime(m), target, m.Prefix.Name, message)
+	}
+}
+
+// dispatchToPlugins routes messages to the appropriate plugin handlers.
+// The handlers run on each plugin's worker so a slow or crashing plugin
+// can't hold up the bot.
 func dispatchToPlugins(c *irc.Client, m *irc.Message) {
+	if fromIgnoredUser(c, m) {
+		return
+	}
+	mentioned := m.Command == internal.CMD_PRIVMSG && containsNick(c.CurrentNick(), m.Trailing())
+
 	for _, plug := range plugin.GetPluginList() {
-		// Always call the generic handler
-		plug.OnMessage(c, m)
+		plug := plug
+		plugin.Dispatch(plug, m.Command, func() {
+			callPluginHandlers(plug, c, m, mentioned)
+		})
+	}
+}
 
-		// Call specialized handlers based on command type
-		switch m.Command {
-		case internal.CMD_PRIVMSG:
-			if handler, ok := plug.(plugin.PrivMsgHandler); ok {
-				handler.OnPrivMsg(c, m)
-			}
-			if containsNick(c.CurrentNick(), m.Trailing()) {
-				if handler, ok := plug.(plugin.NickMentionHandler); ok {
-					handler.OnNickMention(c, m)
-				}
-			}
-		case internal.CMD_KICK:
-			if handler, ok := plug.(plugin.KickHandler); ok {
-				handler.OnKick(c, m)
-			}
-		case internal.CMD_JOIN:
-			if handler, ok := plug.(plugin.JoinHandler); ok {
-				handler.OnJoin(c, m)
-			}
-		case internal.CMD_PART:
-			if handler, ok := plug.(plugin.PartHandler); ok {
-				handler.OnPart(c, m)
-			}
-		case internal.CMD_QUIT:
-			if handler, ok := plug.(plugin.QuitHandler); ok {
-				handler.OnQuit(c, m)
-			}
-		case internal.CMD_NICK:
-			if handler, ok := plug.(plugin.NickHandler); ok {
-				handler.OnNickChange(c, m)
-			}
-		case internal.CMD_INVITE:
-			if handler, ok := plug.(plugin.InviteHandler); ok {
-				handler.OnInvite(c, m)
-			}
-		case internal.CMD_TOPIC, internal.RPL_TOPIC, internal.RPL_TOPICWHOTIME:
-			if handler, ok := plug.(plugin.TopicChangeHandler); ok {
-				handler.OnTopicChange(c, m)
-			}
-		case internal.CMD_NOTICE:
-			if handler, ok := plug.(plugin.NoticeHandler); ok {
-				handler.OnNotice(c, m)
-			}
-		case internal.CMD_ERROR:
-			if handler, ok := plug.(plugin.ErrorHandler); ok {
-				handler.OnError(c, m)
-			}
-		case internal.CMD_MODE:
-			if handler, ok := plug.(plugin.ModeHandler); ok {
-				handler.OnMode(c, m)
+// fromIgnoredUser reports whether a message or notice comes from a user
+// who is ignored where it was sent
+func fromIgnoredUser(c *irc.Client, m *irc.Message) bool {
+	if (m.Command != internal.CMD_PRIVMSG && m.Command != internal.CMD_NOTICE) || m.Prefix == nil || len(m.Params) == 0 {
+		return false
+	}
+	channel := ""
+	if m.Params[0] != c.CurrentNick() {
+		channel = m.Params[0]
+	}
+	return userlevels.GetEffectiveLevel(channel, m.Prefix.String()) == userlevels.Ignored
+}
+
+// callPluginHandlers calls the handlers of a plugin for a message
+func callPluginHandlers(plug plugin.Plugin, c *irc.Client, m *irc.Message, mentioned bool) {
+	// Always call the generic handler
+	plug.OnMessage(c, m)
+
+	// Call specialized handlers based on command type
+	switch m.Command {
+	case internal.CMD_PRIVMSG:
+		if handler, ok := plug.(plugin.PrivMsgHandler); ok {
+			handler.OnPrivMsg(c, m)
+		}
+		if mentioned {
+			if handler, ok := plug.(plugin.NickMentionHandler); ok {
+				handler.OnNickMention(c, m)
 			}
 		}
+	case internal.CMD_KICK:
+		if handler, ok := plug.(plugin.KickHandler); ok {
+			handler.OnKick(c, m)
+		}
+	case internal.CMD_JOIN:
+		if handler, ok := plug.(plugin.JoinHandler); ok {
+			handler.OnJoin(c, m)
+		}
+	case internal.CMD_PART:
+		if handler, ok := plug.(plugin.PartHandler); ok {
+			handler.OnPart(c, m)
+		}
+	case internal.CMD_QUIT:
+		if handler, ok := plug.(plugin.QuitHandler); ok {
+			handler.OnQuit(c, m)
+		}
+	case internal.CMD_NICK:
+		if handler, ok := plug.(plugin.NickHandler); ok {
+			handler.OnNickChange(c, m)
+		}
+	case internal.CMD_INVITE:
+		if handler, ok := plug.(plugin.InviteHandler); ok {
+			handler.OnInvite(c, m)
+		}
+	case internal.CMD_TOPIC, internal.RPL_TOPIC, internal.RPL_TOPICWHOTIME:
+		if handler, ok := plug.(plugin.TopicChangeHandler); ok {
+			handler.OnTopicChange(c, m)
+		}
+	case internal.CMD_NOTICE:
+		if handler, ok := plug.(plugin.NoticeHandler); ok {
+			handler.OnNotice(c, m)
+		}
+	case internal.CMD_ERROR:
+		if handler, ok := plug.(plugin.ErrorHandler); ok {
+			handler.OnError(c, m)
+		}
+	case internal.CMD_MODE:
+		if handler, ok := plug.(plugin.ModeHandler); ok {
+			handler.OnMode(c, m)
+		}
 	}
 }
 
@@ -14,8 +14,9 @@ func HandlePrivateMessage(c *irc.Client, m *irc.Message) {
 	userNick := m.Prefix.Name
 	hostmask := m.Prefix.String()
 	
-	// Check if user is ignored - if so, don't process any interactions
-	userLevel := userlevels.GetUserLevelByHostmask(hostmask)
+	// Check if user is ignored - if so, don't process any interactions.
+	// A private message has no channel, so only the global level counts.
+	userLevel := userlevels.GetEffectiveLevel("", hostmask)
 	if userLevel == userlevels.Ignored {
 		// Just log the message without responding
 		logger.LogPrivateMessage(userNick, "FROM (IGNORED)", message)
@@ -30,13 +31,13 @@ func HandlePrivateMessage(c *irc.Client, m *irc.Message) {
 			userNick, messageCount)
 		
 		// If this is their first offense, warn them
-		if security.GlobalMessageTracker.AddWarning(hostmask) {
+		if security.GlobalMessageTracker.AddWarning(hostmask, "private message spam") {
 			// If they've hit the warning threshold, auto-ignore them
 			logger.Warnf("User %s (%s) has been automatically ignored for private message spam", 
 				userNick, hostmask)
 			
 			// Automatically set them to ignored level
-			userlevels.SetUserLevel(hostmask, userlevels.Ignored)
+			userlevels.SetUserLevelByHostmask(hostmask, userlevels.Ignored)
 			userlevels.SaveHostmasks()
 			
 			// Notify the user about the auto-ignore
@@ -24,12 +24,10 @@ func handleSetLevelCommand(c *irc.Client, m *irc.Message, message string) {
 	userNick := m.Prefix.Name
 	parts := strings.Fields(message)
 
-	if len(parts) != 3 {
-		response := "Invalid format. Use: !setlevel <hostmask> <level>"
+	if len(parts) != 3 || strings.EqualFold(parts[1], userlevels.AccountPrefix) {
+		response := "Invalid format. Use: !setlevel <hostmask|account:name> <level>"
 		if err := c.Writef("%s %s :%s", internal.CMD_PRIVMSG, userNick, response); err != nil {
 			logger.Errorf("Failed to send invalid format message to %s: %v", userNick, err)
-		} else {
-			logger.LogPrivateMessage(userNick, "TO", response)
 		}
 		return
 	}
@@ -51,21 +49,21 @@ func handleSetLevelCommand(c *irc.Client, m *irc.Message, message string) {
 		response := "Invalid level. Use: owner, admin, regular, or badboy"
 		if err := c.Writef("%s %s :%s", internal.CMD_PRIVMSG, userNick, response); err != nil {
 			logger.Errorf("Failed to send invalid level message to %s: %v", userNick, err)
-		} else {
-			logger.LogPrivateMessage(userNick, "TO", response)
 		}
 		return
 	}
 
-	userlevels.SetUserLevelByHostmask(targetHostmask, level)
+	if strings.HasPrefix(strings.ToLower(targetHostmask), userlevels.AccountPrefix) {
+		userlevels.SetUserLevelByAccount(targetHostmask[len(userlevels.AccountPrefix):], level)
+	} else {
+		userlevels.SetUserLevelByHostmask(targetHostmask, level)
+	}
 
 	if err := userlevels.SaveHostmasks(); err != nil {
 		logger.Errorf("Failed to save hostmask levels: %v", err)
 		response := "Error saving hostmask levels: " + err.Error()
 		if err := c.Writef("%s %s :%s", internal.CMD_PRIVMSG, userNick, response); err != nil {
 			logger.Errorf("Failed to send error message to %s: %v", userNick, err)
-		} else {
-			logger.LogPrivateMessage(userNick, "TO", response)
 		}
 		return
 	}
@@ -73,8 +71,6 @@ func handleSetLevelCommand(c *irc.Client, m *irc.Message, message string) {
 	response := "User level for " + targetHostmask + " set to " + levelStr
 	if err := c.Writef("%s %s :%s", internal.CMD_PRIVMSG, userNick, response); err != nil {
 		logger.Errorf("Failed to send confirmation to %s: %v", userNick, err)
-	} else {
-		logger.LogPrivateMessage(userNick, "TO", response)
 	}
 	logger.Successf("User level for %s set to %s by owner", targetHostmask, levelStr)
 }
@@ -84,8 +80,6 @@ func handleUnauthorizedCommand(c *irc.Client, userNick, hostmask string) {
 	response := "You are not authorized to use bot commands."
 	if err := c.Writef("%s %s :%s", internal.CMD_PRIVMSG, userNick, response); err != nil {
 		logger.Errorf("Failed to send unauthorized command warning to %s: %v", userNick, err)
-	} else {
-		logger.LogPrivateMessage(userNick, "TO", response)
 	}
 }
 
@@ -93,7 +87,5 @@ func sendDefaultResponse(c *irc.Client, userNick string) {
 	response := "I am just a bot, And i am only answering in channels right now.."
 	if err := c.Writef("%s %s :%s", internal.CMD_PRIVMSG, userNick, response); err != nil {
 		logger.Errorf("Failed to respond to private message from %s: %v", userNick, err)
-	} else {
-		logger.LogPrivateMessage(userNick, "TO", response)
 	}
 }
\ No newline at end of file
@@ -8,7 +8,6 @@ import (
 	"ircbot/internal/security"
 	"ircbot/internal/userlevels"
 	"strings"
-	"time"
 )
 
 var PendingVerifications = make(map[string]bool)
@@ -59,11 +58,7 @@ func SendOwnerVerificationRequest(c *irc.Client, ownerNick string) {
 		if err != nil {
 			logger.Errorf("Failed to send verification message to owner %s at index %d: %v", ownerNick, i, err)
 			return
-		} else {
-			// Log the outgoing message
-			logger.LogPrivateMessage(ownerNick, "TO", msg)
 		}
-		time.Sleep(300 * time.Millisecond)
 	}
 
 	logger.Successf("Sent verification request to owner: %s", ownerNick)
@@ -81,8 +76,6 @@ func handleOwnerVerification(c *irc.Client, ownerNick, message string, m *irc.Me
 			errMsg := "Verification failed due to an internal error. Please restart the bot."
 			if err := c.Writef("%s %s :%s", internal.CMD_PRIVMSG, ownerNick, errMsg); err != nil {
 				logger.Errorf("Failed to send internal error message to %s: %v", ownerNick, err)
-			} else {
-				logger.LogPrivateMessage(ownerNick, "TO", errMsg)
 			}
 			return
 		}
@@ -107,8 +100,6 @@ func handleOwnerVerification(c *irc.Client, ownerNick, message string, m *irc.Me
 			errMsg := "Verification failed due to an error saving settings. Please restart the bot."
 			if err := c.Writef("%s %s :%s", internal.CMD_PRIVMSG, ownerNick, errMsg); err != nil {
 				logger.Errorf("Failed to send settings error message to %s: %v", ownerNick, err)
-			} else {
-				logger.LogPrivateMessage(ownerNick, "TO", errMsg)
 			}
 			return
 		}
@@ -137,11 +128,7 @@ func handleOwnerVerification(c *irc.Client, ownerNick, message string, m *irc.Me
 			}
 			if err := c.Writef("%s %s :%s", internal.CMD_PRIVMSG, ownerNick, msg); err != nil {
 				logger.Errorf("Failed to send success message to owner %s at index %d: %v", ownerNick, i, err)
-			} else {
-				// Log the outgoing message
-				logger.LogPrivateMessage(ownerNick, "TO", msg)
 			}
-			time.Sleep(300 * time.Millisecond)
 		}
 
 		logger.Successf("Owner %s successfully verified with hostmask: %s", ownerNick, hostmask)
@@ -164,11 +151,7 @@ func handleOwnerVerification(c *irc.Client, ownerNick, message string, m *irc.Me
 			}
 			if err := c.Writef("%s %s :%s", internal.CMD_PRIVMSG, ownerNick, msg); err != nil {
 				logger.Errorf("Failed to send failure message to owner %s at index %d: %v", ownerNick, i, err)
-			} else {
-				// Log the outgoing message
-				logger.LogPrivateMessage(ownerNick, "TO", msg)
 			}
-			time.Sleep(300 * time.Millisecond)
 		}
 
 		logger.Warnf("Owner verification attempt failed for %s", ownerNick)
@@ -0,0 +1,232 @@
+// Package health keeps statistics about the connection to the IRC server and
+// measures the lag to it. The lag check sends a PING at a fixed interval and
+// closes the connection when the PONG does not arrive in time, so the
+// reconnect loop can move on instead of waiting on a dead socket. The PONG
+// is read by the same loop that runs the message handlers, so time spent in
+// a handler counts neither towards the timeout nor the lag.
+package health
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/irc.v4"
+	"ircbot/internal"
+	"ircbot/internal/logger"
+)
+
+// lagTokenPrefix marks the PINGs sent by the lag check
+const lagTokenPrefix = "lag-"
+
+// Stats is a snapshot of the connection statistics
+type Stats struct {
+	// Server is the address of the current (or last) server
+	Server      string
+	Connected   bool
+	StartedAt   time.Time
+	ConnectedAt time.Time
+	// Lag is the round trip time of the last answered PING, zero if unknown
+	Lag        time.Duration
+	LastPongAt time.Time
+	// Reconnects counts the connections made after the first one
+	Reconnects int
+}
+
+// Uptime returns how long the bot has been running
+func (s Stats) Uptime() time.Duration {
+	return time.Since(s.StartedAt)
+}
+
+// ConnectionUptime returns how long the current connection has been up
+func (s Stats) ConnectionUptime() time.Duration {
+	if !s.Connected {
+		return 0
+	}
+	return time.Since(s.ConnectedAt)
+}
+
+var (
+	mu          sync.Mutex
+	stats       = Stats{StartedAt: time.Now()}
+	connections int
+
+	// pingToken is the outstanding lag PING and pingSentAt when it was sent
+	pingToken  string
+	pingSentAt time.Time
+	// pingBusy is busyTime when the lag PING was sent
+	pingBusy  time.Duration
+	checkDone chan struct{}
+
+	// busySince is when the running handler started, zero if none is, and
+	// busyTotal the time handlers ran before it
+	busySince time.Time
+	busyTotal time.Duration
+)
+
+// Get returns the current connection statistics
+func Get() Stats {
+	mu.Lock()
+	defer mu.Unlock()
+	return stats
+}
+
+// RecordConnected marks the start of a new connection to server
+func RecordConnected(server string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	connections++
+	stats.Server = server
+	stats.Connected = true
+	stats.ConnectedAt = time.Now()
+	stats.Lag = 0
+	stats.LastPongAt = time.Time{}
+	stats.Reconnects = connections - 1
+}
+
+// RecordDisconnected marks the current connection as gone and stops its
+// lag check
+func RecordDisconnected() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	stats.Connected = false
+	stopCheck()
+}
+
+// stopCheck ends the running lag check. Callers must hold mu.
+func stopCheck() {
+	if checkDone != nil {
+		close(checkDone)
+		checkDone = nil
+	}
+	pingToken = ""
+}
+
+// BeginHandling marks the read loop as busy with a message until the
+// returned function is called
+func BeginHandling() func() {
+	mu.Lock()
+	busySince = time.Now()
+	mu.Unlock()
+
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if !busySince.IsZero() {
+			busyTotal += time.Since(busySince)
+			busySince = time.Time{}
+		}
+	}
+}
+
+// busyTime returns how long handlers have kept the read loop busy in
+// total. Callers must hold mu.
+func busyTime() time.Duration {
+	if busySince.IsZero() {
+		return busyTotal
+	}
+	return busyTotal + time.Since(busySince)
+}
+
+// sincePing returns how long the read loop has been free to read the PONG
+// since the lag PING was sent. Callers must hold mu.
+func sincePing() time.Duration {
+	return time.Since(pingSentAt) - (busyTime() - pingBusy)
+}
+
+// StartLagCheck sends a PING every interval and closes conn when no PONG
+// arrives within timeout, not counting the time handlers are busy. Any
+// previous check is stopped.
+func StartLagCheck(c *irc.Client, conn io.Closer, interval, timeout time.Duration) {
+	done := make(chan struct{})
+
+	mu.Lock()
+	stopCheck()
+	checkDone = done
+	mu.Unlock()
+
+	go lagLoop(c, conn, interval, timeout, done)
+}
+
+// lagLoop runs the lag check of one connection
+func lagLoop(c *irc.Client, conn io.Closer, interval, timeout time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		token := lagTokenPrefix + strconv.FormatInt(time.Now().UnixNano(), 10)
+		mu.Lock()
+		pingToken = token
+		pingSentAt = time.Now()
+		pingBusy = busyTime()
+		mu.Unlock()
+
+		if err := c.Writef("%s :%s", internal.CMD_PING, token); err != nil {
+			logger.Errorf("Failed to send lag check PING: %v", err)
+			return
+		}
+
+		if !waitForPong(conn, token, timeout, done) {
+			return
+		}
+	}
+}
+
+// waitForPong waits until the lag PING with token is answered and reports
+// whether it was. The connection is closed once the read loop was free for
+// timeout without seeing the PONG; a handler that runs longer only delays
+// the reading of it.
+func waitForPong(conn io.Closer, token string, timeout time.Duration, done chan struct{}) bool {
+	wait := timeout
+	for {
+		select {
+		case <-done:
+			return false
+		case <-time.After(wait):
+		}
+
+		mu.Lock()
+		answered := pingToken != token
+		idle := sincePing()
+		mu.Unlock()
+		if answered {
+			return true
+		}
+		if idle >= timeout {
+			logger.Errorf("No PONG from server in %s, reconnecting", timeout)
+			conn.Close()
+			return false
+		}
+		wait = timeout - idle
+	}
+}
+
+// HandlePong records the lag when the PONG answers the lag check. It reports
+// whether the PONG was one of ours.
+func HandlePong(m *irc.Message) bool {
+	token := m.Trailing()
+	if !strings.HasPrefix(token, lagTokenPrefix) {
+		return false
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if token != pingToken {
+		return true
+	}
+
+	stats.Lag = sincePing()
+	stats.LastPongAt = time.Now()
+	pingToken = ""
+	return true
+}
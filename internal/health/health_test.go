@@ -0,0 +1,106 @@
+package health
+
+import (
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/irc.v4"
+	"ircbot/internal/logger"
+)
+
+// closeRecorder is a connection that only notes being closed
+type closeRecorder struct {
+	closed atomic.Bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+// startTestCheck runs a lag check over a client whose lines go nowhere
+func startTestCheck(t *testing.T, interval, timeout time.Duration) *closeRecorder {
+	t.Helper()
+	logger.SetLogDir(t.TempDir())
+	rw := struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{strings.NewReader(""), io.Discard, io.NopCloser(nil)}
+	c := irc.NewClient(rw, irc.ClientConfig{Nick: "test"})
+	conn := &closeRecorder{}
+	StartLagCheck(c, conn, interval, timeout)
+	t.Cleanup(RecordDisconnected)
+	return conn
+}
+
+// currentPing waits for a lag PING and returns its token
+func currentPing(t *testing.T) string {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		token := pingToken
+		mu.Unlock()
+		if token != "" {
+			return token
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("no lag PING was sent")
+	return ""
+}
+
+// pong is the server's answer to a lag PING
+func pong(token string) *irc.Message {
+	return &irc.Message{Command: "PONG", Params: []string{"irc.example.com", token}}
+}
+
+func TestLagCheckClosesWithoutPong(t *testing.T) {
+	conn := startTestCheck(t, 10*time.Millisecond, 30*time.Millisecond)
+	currentPing(t)
+
+	time.Sleep(100 * time.Millisecond)
+	if !conn.closed.Load() {
+		t.Error("the connection was kept open without a PONG")
+	}
+}
+
+func TestLagCheckIgnoresBusyHandlers(t *testing.T) {
+	conn := startTestCheck(t, 10*time.Millisecond, 50*time.Millisecond)
+	token := currentPing(t)
+
+	// A handler keeps the read loop busy for longer than the timeout
+	end := BeginHandling()
+	time.Sleep(150 * time.Millisecond)
+	if conn.closed.Load() {
+		t.Fatal("the connection was closed while a handler was busy")
+	}
+	end()
+
+	// The PONG read right after it has waited behind the handler
+	done := BeginHandling()
+	if !HandlePong(pong(token)) {
+		t.Fatal("the lag PONG was not recognized")
+	}
+	done()
+	if lag := Get().Lag; lag <= 0 || lag >= 100*time.Millisecond {
+		t.Errorf("lag = %s, want the time not spent in handlers", lag)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if conn.closed.Load() {
+		t.Error("the connection was closed after the PONG")
+	}
+}
+
+func TestHandlePongIgnoresOtherPongs(t *testing.T) {
+	if HandlePong(pong("12345")) {
+		t.Error("a PONG without the lag prefix was taken for the lag check's")
+	}
+	if !HandlePong(pong(lagTokenPrefix + "stale")) {
+		t.Error("a stale lag PONG was not recognized")
+	}
+}
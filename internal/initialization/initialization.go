@@ -7,8 +7,10 @@ import (
 	
 	"ircbot/internal"
 	"ircbot/internal/ai"
+	"ircbot/internal/ai/tools"
 	"ircbot/internal/commands"
 	"ircbot/internal/config"
+	"ircbot/internal/devwatch"
 	"ircbot/internal/logger"
 	"ircbot/internal/plugin"
 	"ircbot/internal/setup"
@@ -20,10 +22,6 @@ func Initialize() (*config.Config, string, bool, error) {
 		return nil, "", false, err
 	}
 
-	if err := ai.InitializeClient(); err != nil {
-		return nil, "", false, err
-	}
-
 	configPath := os.Getenv("CONFIG_PATH")
 	if configPath == "" {
 		configPath = internal.DEFAULT_CONFIG_PATH
@@ -42,6 +40,9 @@ func Initialize() (*config.Config, string, bool, error) {
 		logger.Infof("Channel settings loaded successfully")
 	}
 
+	// The bot runs without AI features if no provider is usable
+	ai.InitializeClient(cfg)
+
 	initialOwnerNick, isFirstRun := initializeOwnerAndSecurity()
 	
 	// Share the bot config with the commands package
@@ -50,7 +51,7 @@ func Initialize() (*config.Config, string, bool, error) {
 	
 	initializeCommandSystem()
 	
-	initializePlugins()
+	initializePlugins(cfg)
 	
 	return cfg, initialOwnerNick, isFirstRun, nil
 }
@@ -85,10 +86,17 @@ func initializeOwnerAndSecurity() (string, bool) {
 
 func initializeCommandSystem() {
 	commands.CheckPluginCommands = plugin.HandlePluginCommand
+	plugin.IsBuiltinCommand = func(name string) bool {
+		_, exists := commands.GetCommand(name)
+		return exists
+	}
 }
 
-func initializePlugins() {
+func initializePlugins(cfg *config.Config) {
 	plugin.GetPluginList = plugin.GetPluginObjects
+	if cfg.PluginKeepVersions != 0 {
+		plugin.KeepVersions = cfg.PluginKeepVersions
+	}
 	
 	pluginsPath := os.Getenv("PLUGINS_PATH")
 	if pluginsPath == "" {
@@ -102,4 +110,14 @@ func initializePlugins() {
 	} else {
 		logger.Successf("Successfully loaded %d plugins from %s", pluginsLoaded, pluginsPath)
 	}
+
+	// The AI plugin creator builds and loads through the plugin system
+	tools.BuildPlugin = func(name string, source []byte) (string, string, error) {
+		return plugin.BuildGoPlugin(name, source, pluginsPath)
+	}
+	tools.LoadPlugin = plugin.LoadBuild
+
+	if cfg.PluginDevMode {
+		devwatch.Start(internal.DEFAULT_PLUGINS_SRC_PATH, pluginsPath, cfg.PluginDevReport)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,151 @@
+package installer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxDiffCells bounds the work of a line diff; larger changes are shown
+// as a replacement of the whole differing region
+const maxDiffCells = 4 << 20
+
+// DiffStat counts the lines a change adds and removes
+type DiffStat struct {
+	Added   int
+	Removed int
+}
+
+// Diff returns a unified diff from old to new without context lines, and
+// how many lines were added and removed
+func Diff(oldName, newName string, old, new []byte) (string, DiffStat) {
+	a := splitLines(old)
+	b := splitLines(new)
+
+	// Lines shared at the start and end don't need the full diff
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ops := diffLines(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+
+	var stat DiffStat
+	oldLine, newLine := prefix+1, prefix+1
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+
+		// A hunk is a run of removed and added lines
+		j := i
+		var removed, added []string
+		for j < len(ops) && ops[j].kind != ' ' {
+			if ops[j].kind == '-' {
+				removed = append(removed, ops[j].line)
+			} else {
+				added = append(added, ops[j].line)
+			}
+			j++
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldLine, len(removed)), hunkRange(newLine, len(added)))
+		for _, line := range removed {
+			out.WriteString("-" + line + "\n")
+		}
+		for _, line := range added {
+			out.WriteString("+" + line + "\n")
+		}
+		stat.Removed += len(removed)
+		stat.Added += len(added)
+		oldLine += len(removed)
+		newLine += len(added)
+		i = j
+	}
+	return out.String(), stat
+}
+
+// hunkRange formats the line range of a hunk; an empty range names the
+// line before it, as in diff -u
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start--
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// diffOp is one line of a diff: ' ' kept, '-' removed or '+' added
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines computes a line diff from the longest common subsequence
+func diffLines(a, b []string) []diffOp {
+	if len(a)*len(b) > maxDiffCells {
+		var ops []diffOp
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return ops
+	}
+
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// splitLines splits text into lines without their line endings
+func splitLines(data []byte) []string {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
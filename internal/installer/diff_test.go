@@ -0,0 +1,67 @@
+package installer
+
+import "testing"
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+		stat     DiffStat
+	}{
+		{
+			name: "new file",
+			old:  "",
+			new:  "package main\n\nfunc main() {}\n",
+			want: "@@ -0,0 +1,3 @@\n+package main\n+\n+func main() {}\n",
+			stat: DiffStat{Added: 3},
+		},
+		{
+			name: "identical",
+			old:  "a\nb\nc\n",
+			new:  "a\nb\nc\n",
+			want: "",
+		},
+		{
+			name: "line endings only",
+			old:  "a\r\nb\r\n",
+			new:  "a\nb",
+			want: "",
+		},
+		{
+			name: "changed line",
+			old:  "a\nb\nc\n",
+			new:  "a\nB\nc\n",
+			want: "@@ -2,1 +2,1 @@\n-b\n+B\n",
+			stat: DiffStat{Added: 1, Removed: 1},
+		},
+		{
+			name: "added and removed lines",
+			old:  "a\nb\nc\nd\ne\n",
+			new:  "a\nc\nd\nd2\ne\n",
+			want: "@@ -2,1 +1,0 @@\n-b\n@@ -4,0 +4,1 @@\n+d2\n",
+			stat: DiffStat{Added: 1, Removed: 1},
+		},
+		{
+			name: "emptied file",
+			old:  "a\nb\n",
+			new:  "",
+			want: "@@ -1,2 +0,0 @@\n-a\n-b\n",
+			stat: DiffStat{Removed: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		var old []byte
+		if tt.old != "" {
+			old = []byte(tt.old)
+		}
+		got, stat := Diff("old.go", "new.go", old, []byte(tt.new))
+		if want := "--- old.go\n+++ new.go\n" + tt.want; got != want {
+			t.Errorf("%s: Diff =\n%s\nwant\n%s", tt.name, got, want)
+		}
+		if stat != tt.stat {
+			t.Errorf("%s: stat = %+v, want %+v", tt.name, stat, tt.stat)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Package installer fetches plugin sources for !load-online. A source is
+// only downloaded from an allowed domain and only accepted with a matching
+// SHA-256 checksum or a signature by a trusted key. Every installation is
+// recorded in a ledger together with a copy of the source, so that any
+// installed version can be built again later.
+package installer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTimeout is how long a download may take when none is configured
+	DefaultTimeout = 30 * time.Second
+	// MaxSourceSize is the largest plugin source that is downloaded
+	MaxSourceSize = 1 << 20
+	// maxSignatureSize is the largest signature file that is downloaded
+	maxSignatureSize = 4 << 10
+)
+
+// validFileName matches the plugin source names that can be installed; the
+// name also becomes the plugin's build tag
+var validFileName = regexp.MustCompile(`^[A-Za-z0-9_]+\.go$`)
+
+// Policy is what the installer accepts
+type Policy struct {
+	// AllowedDomains are the hosts plugins may be downloaded from; a domain
+	// also allows its subdomains
+	AllowedDomains []string
+	// TrustedKeys are minisign public keys or base64 ed25519 public keys
+	TrustedKeys []string
+	// Timeout limits each download
+	Timeout time.Duration
+}
+
+// Source is a downloaded and verified plugin source
+type Source struct {
+	URL      string
+	FileName string
+	Data     []byte
+	SHA256   string
+	// Verification says how the source was verified, e.g. "sha256" or
+	// "signature by key 1A2B3C4D5E6F7A8B"
+	Verification string
+}
+
+// Name returns the plugin name taken from the file name
+func (s *Source) Name() string {
+	return strings.TrimSuffix(s.FileName, ".go")
+}
+
+// CheckURL checks that a URL points to a Go file on an allowed domain
+func (p *Policy) CheckURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme != "https" {
+		return nil, errors.New("plugins can only be downloaded over https")
+	}
+	if u.User != nil {
+		return nil, errors.New("URLs with credentials are not allowed")
+	}
+	if !p.allowed(u.Hostname()) {
+		return nil, fmt.Errorf("%s is not an allowed plugin source", u.Hostname())
+	}
+	if !validFileName.MatchString(path.Base(u.Path)) {
+		return nil, errors.New("URL must point to a .go file named with letters, digits and underscores")
+	}
+	return u, nil
+}
+
+// allowed reports whether host is one of the allowed domains or below one
+func (p *Policy) allowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, domain := range p.AllowedDomains {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "."))
+		if domain != "" && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch downloads a plugin source and verifies it. With a checksum (hex
+// SHA-256, optionally prefixed with "sha256:") the source must match it;
+// without one it must come with a minisign signature at URL + ".minisig"
+// made by one of the trusted keys.
+func (p *Policy) Fetch(rawURL, checksum string) (*Source, error) {
+	u, err := p.CheckURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := p.download(u.String(), MaxSourceSize)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	src := &Source{
+		URL:      u.String(),
+		FileName: path.Base(u.Path),
+		Data:     data,
+		SHA256:   hex.EncodeToString(sum[:]),
+	}
+
+	if checksum != "" {
+		want := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(checksum), "sha256:"))
+		if want != src.SHA256 {
+			return nil, fmt.Errorf("checksum mismatch: got sha256:%s", src.SHA256)
+		}
+		src.Verification = "sha256"
+		return src, nil
+	}
+
+	if len(p.TrustedKeys) == 0 {
+		return nil, errors.New("no trusted signing keys are configured, give the source's sha256 checksum")
+	}
+	sigURL := *u
+	sigURL.Path += ".minisig"
+	sig, err := p.download(sigURL.String(), maxSignatureSize)
+	if err != nil {
+		return nil, fmt.Errorf("no signature found, give the source's sha256 checksum: %w", err)
+	}
+	keyID, err := verifySignature(p.TrustedKeys, data, sig)
+	if err != nil {
+		return nil, err
+	}
+	src.Verification = "signature by key " + keyID
+	return src, nil
+}
+
+// download fetches a URL, refusing redirects off the allowed domains and
+// bodies larger than limit
+func (p *Policy) download(rawURL string, limit int64) ([]byte, error) {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "https" || !p.allowed(req.URL.Hostname()) {
+				return fmt.Errorf("redirect to %s is not allowed", req.URL.Host)
+			}
+			return nil
+		},
+	}
+
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: HTTP %d", rawURL, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s is larger than %d bytes", rawURL, limit)
+	}
+	return data, nil
+}
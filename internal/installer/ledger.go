@@ -0,0 +1,139 @@
+package installer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LedgerPath is where installations are recorded; the installed sources
+// are kept in the directory next to it
+var LedgerPath = "./data/plugin_installs.json"
+
+// Installation is a plugin source that was built and loaded
+type Installation struct {
+	ID           int       `json:"id"`
+	Plugin       string    `json:"plugin"`
+	Version      string    `json:"version"`
+	FileName     string    `json:"file_name"`
+	URL          string    `json:"url"`
+	SHA256       string    `json:"sha256"`
+	Verification string    `json:"verification"`
+	InstalledBy  string    `json:"installed_by"`
+	InstalledAt  time.Time `json:"installed_at"`
+	// RollbackOf is the ID of the installation this one restored, if any
+	RollbackOf int `json:"rollback_of,omitempty"`
+}
+
+var ledgerMu sync.Mutex
+
+// sourcesDir returns the directory holding the installed sources
+func sourcesDir() string {
+	return filepath.Join(filepath.Dir(LedgerPath), "plugin_installs")
+}
+
+// loadLedger reads the ledger. Must be called with ledgerMu held.
+func loadLedger() ([]Installation, error) {
+	data, err := os.ReadFile(LedgerPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var installs []Installation
+	if err := json.Unmarshal(data, &installs); err != nil {
+		return nil, fmt.Errorf("invalid install ledger %s: %w", LedgerPath, err)
+	}
+	return installs, nil
+}
+
+// Record adds an installation to the ledger and archives its source.
+// The ID and time are filled in.
+func Record(inst Installation, source []byte) (Installation, error) {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	if err := os.MkdirAll(sourcesDir(), 0755); err != nil {
+		return inst, err
+	}
+	archive := filepath.Join(sourcesDir(), inst.SHA256+".go")
+	if _, err := os.Stat(archive); os.IsNotExist(err) {
+		if err := os.WriteFile(archive, source, 0644); err != nil {
+			return inst, err
+		}
+	}
+
+	installs, err := loadLedger()
+	if err != nil {
+		return inst, err
+	}
+	inst.ID = 1
+	if len(installs) > 0 {
+		inst.ID = installs[len(installs)-1].ID + 1
+	}
+	inst.InstalledAt = time.Now()
+	installs = append(installs, inst)
+
+	data, err := json.MarshalIndent(installs, "", "  ")
+	if err != nil {
+		return inst, err
+	}
+	tmp := LedgerPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return inst, err
+	}
+	return inst, os.Rename(tmp, LedgerPath)
+}
+
+// History returns the installations of a plugin, or of all plugins if
+// plugin is empty, oldest first
+func History(plugin string) ([]Installation, error) {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	installs, err := loadLedger()
+	if err != nil || plugin == "" {
+		return installs, err
+	}
+	var matching []Installation
+	for _, inst := range installs {
+		if inst.Plugin == plugin {
+			matching = append(matching, inst)
+		}
+	}
+	return matching, nil
+}
+
+// Get returns an installation by ID
+func Get(id int) (Installation, bool, error) {
+	installs, err := History("")
+	if err != nil {
+		return Installation{}, false, err
+	}
+	for _, inst := range installs {
+		if inst.ID == id {
+			return inst, true, nil
+		}
+	}
+	return Installation{}, false, nil
+}
+
+// ArchivedSource returns the source of an installation, checking that it
+// still has the recorded hash
+func ArchivedSource(inst Installation) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(sourcesDir(), inst.SHA256+".go"))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != inst.SHA256 {
+		return nil, fmt.Errorf("archived source of installation #%d has been modified", inst.ID)
+	}
+	return data, nil
+}
@@ -0,0 +1,125 @@
+package installer
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// trustedKey is a public key that may sign plugins
+type trustedKey struct {
+	// id is the minisign key ID, or nil for a bare ed25519 key
+	id  []byte
+	key ed25519.PublicKey
+}
+
+// parseKey parses a minisign public key (the base64 line of a .pub file)
+// or a base64 ed25519 public key
+func parseKey(s string) (trustedKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return trustedKey{}, fmt.Errorf("invalid key %q: %w", s, err)
+	}
+	switch {
+	case len(raw) == ed25519.PublicKeySize:
+		return trustedKey{key: raw}, nil
+	case len(raw) == 2+8+ed25519.PublicKeySize && string(raw[:2]) == "Ed":
+		return trustedKey{id: raw[2:10], key: raw[10:]}, nil
+	}
+	return trustedKey{}, fmt.Errorf("invalid key %q: not a minisign or ed25519 public key", s)
+}
+
+// minisig is a parsed minisign signature file
+type minisig struct {
+	algorithm      string
+	keyID          []byte
+	signature      []byte
+	trustedComment string
+	globalSig      []byte
+}
+
+// parseMinisig parses the four lines of a minisign signature:
+// untrusted comment, signature, trusted comment and global signature
+func parseMinisig(data []byte) (*minisig, error) {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], "untrusted comment:") {
+		return nil, errors.New("invalid signature file")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != 2+8+ed25519.SignatureSize {
+		return nil, errors.New("invalid signature")
+	}
+	comment, ok := strings.CutPrefix(lines[2], "trusted comment: ")
+	if !ok {
+		return nil, errors.New("invalid signature file: missing trusted comment")
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return nil, errors.New("invalid global signature")
+	}
+
+	return &minisig{
+		algorithm:      string(raw[:2]),
+		keyID:          raw[2:10],
+		signature:      raw[10:],
+		trustedComment: comment,
+		globalSig:      global,
+	}, nil
+}
+
+// verifySignature checks a minisign signature of data against the trusted
+// keys and returns the ID of the key that made it
+func verifySignature(keys []string, data, sigFile []byte) (string, error) {
+	sig, err := parseMinisig(sigFile)
+	if err != nil {
+		return "", err
+	}
+
+	var message []byte
+	switch sig.algorithm {
+	case "Ed":
+		message = data
+	case "ED":
+		// Pre-hashed signatures sign the BLAKE2b-512 hash of the file
+		sum := blake2b.Sum512(data)
+		message = sum[:]
+	default:
+		return "", fmt.Errorf("unsupported signature algorithm %q", sig.algorithm)
+	}
+
+	for _, s := range keys {
+		key, err := parseKey(s)
+		if err != nil {
+			return "", err
+		}
+		if key.id != nil && !bytes.Equal(key.id, sig.keyID) {
+			continue
+		}
+		if !ed25519.Verify(key.key, message, sig.signature) {
+			continue
+		}
+		// The global signature covers the trusted comment as well
+		global := append(append([]byte(nil), sig.signature...), sig.trustedComment...)
+		if !ed25519.Verify(key.key, global, sig.globalSig) {
+			return "", errors.New("invalid signature: trusted comment was altered")
+		}
+		return strings.ToUpper(hex.EncodeToString(reverse(sig.keyID))), nil
+	}
+	return "", errors.New("source is not signed by a trusted key")
+}
+
+// reverse returns b in reverse order; minisign shows key IDs little-endian
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
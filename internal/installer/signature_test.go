@@ -0,0 +1,133 @@
+package installer
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// testKey is a minisign key pair made for a test
+type testKey struct {
+	id   []byte
+	pub  ed25519.PublicKey
+	priv ed25519.PrivateKey
+}
+
+// newTestKey generates a key pair with the given key ID
+func newTestKey(t *testing.T, id string) testKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{id: []byte(id), pub: pub, priv: priv}
+}
+
+// minisignKey returns the key as the base64 line of a minisign .pub file
+func (k testKey) minisignKey() string {
+	raw := append(append([]byte("Ed"), k.id...), k.pub...)
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// bareKey returns the key as a base64 ed25519 public key
+func (k testKey) bareKey() string {
+	return base64.StdEncoding.EncodeToString(k.pub)
+}
+
+// sign makes a minisign signature file for data; algorithm "ED" signs the
+// BLAKE2b-512 hash of data
+func (k testKey) sign(algorithm string, data []byte, comment string) []byte {
+	message := data
+	if algorithm == "ED" {
+		sum := blake2b.Sum512(data)
+		message = sum[:]
+	}
+	sig := ed25519.Sign(k.priv, message)
+	global := ed25519.Sign(k.priv, append(append([]byte(nil), sig...), comment...))
+	raw := append(append([]byte(algorithm), k.id...), sig...)
+	return []byte(fmt.Sprintf("untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(raw), comment, base64.StdEncoding.EncodeToString(global)))
+}
+
+func TestVerifySignature(t *testing.T) {
+	signer := newTestKey(t, "\x01\x02\x03\x04\x05\x06\x07\x08")
+	other := newTestKey(t, "\x11\x12\x13\x14\x15\x16\x17\x18")
+	data := []byte("package main\n\nfunc main() {}\n")
+	tampered := []byte("package main\n\nfunc main() { panic(1) }\n")
+	const comment = "timestamp:1700000000\tfile:hello.go"
+	const signerID = "0807060504030201"
+
+	// alterComment rewrites the trusted comment and leaves both signatures
+	alterComment := func(sig []byte) []byte {
+		return []byte(strings.Replace(string(sig), "file:hello.go", "file:other.go", 1))
+	}
+
+	tests := []struct {
+		name    string
+		keys    []string
+		data    []byte
+		sig     []byte
+		wantID  string
+		wantErr string
+	}{
+		{"Ed valid", []string{signer.minisignKey()}, data, signer.sign("Ed", data, comment), signerID, ""},
+		{"ED valid", []string{signer.minisignKey()}, data, signer.sign("ED", data, comment), signerID, ""},
+		{"bare key", []string{signer.bareKey()}, data, signer.sign("Ed", data, comment), signerID, ""},
+		{"second trusted key", []string{other.minisignKey(), signer.minisignKey()}, data, signer.sign("ED", data, comment), signerID, ""},
+		{"Ed tampered", []string{signer.minisignKey()}, tampered, signer.sign("Ed", data, comment), "", "not signed by a trusted key"},
+		{"ED tampered", []string{signer.minisignKey()}, tampered, signer.sign("ED", data, comment), "", "not signed by a trusted key"},
+		{"Ed wrong key", []string{other.minisignKey()}, data, signer.sign("Ed", data, comment), "", "not signed by a trusted key"},
+		{"ED wrong key", []string{other.bareKey()}, data, signer.sign("ED", data, comment), "", "not signed by a trusted key"},
+		{"Ed altered comment", []string{signer.minisignKey()}, data, alterComment(signer.sign("Ed", data, comment)), "", "trusted comment was altered"},
+		{"ED altered comment", []string{signer.minisignKey()}, data, alterComment(signer.sign("ED", data, comment)), "", "trusted comment was altered"},
+		{"unknown algorithm", []string{signer.minisignKey()}, data, signer.sign("Xx", data, comment), "", "unsupported signature algorithm"},
+		{"invalid key", []string{"not base64!"}, data, signer.sign("Ed", data, comment), "", "invalid key"},
+		{"not a signature", []string{signer.minisignKey()}, data, []byte("hello\n"), "", "invalid signature file"},
+	}
+
+	for _, tt := range tests {
+		id, err := verifySignature(tt.keys, tt.data, tt.sig)
+		if tt.wantErr == "" {
+			if err != nil || id != tt.wantID {
+				t.Errorf("%s: verifySignature = %q, %v, want %q", tt.name, id, err, tt.wantID)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: verifySignature error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseMinisig(t *testing.T) {
+	key := newTestKey(t, "abcdefgh")
+	valid := string(key.sign("Ed", []byte("data"), "comment"))
+	lines := strings.Split(valid, "\n")
+
+	tests := []struct {
+		name string
+		sig  string
+	}{
+		{"too short", strings.Join(lines[:3], "\n")},
+		{"no untrusted comment", "comment\n" + strings.Join(lines[1:], "\n")},
+		{"bad signature", strings.Join([]string{lines[0], "AAAA", lines[2], lines[3]}, "\n")},
+		{"no trusted comment", strings.Join([]string{lines[0], lines[1], "comment", lines[3]}, "\n")},
+		{"bad global signature", strings.Join([]string{lines[0], lines[1], lines[2], "AAAA"}, "\n")},
+	}
+
+	if _, err := parseMinisig([]byte(valid)); err != nil {
+		t.Fatalf("parseMinisig of a valid signature: %v", err)
+	}
+	if _, err := parseMinisig([]byte(strings.ReplaceAll(valid, "\n", "\r\n"))); err != nil {
+		t.Errorf("parseMinisig with CRLF line endings: %v", err)
+	}
+	for _, tt := range tests {
+		if _, err := parseMinisig([]byte(tt.sig)); err == nil {
+			t.Errorf("%s: parseMinisig succeeded, want an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Package kvstore is a small persistent key-value store shared by the bot
+// and its plugins. Keys live in namespaces (one per plugin), values can
+// expire, and every change happens in a transaction that is written to disk
+// atomically before it becomes visible.
+package kvstore
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPath is where the bot's store is kept
+const DefaultPath = "./data/store.json"
+
+// ErrReadOnly is returned when writing in a read-only transaction
+var ErrReadOnly = errors.New("transaction is read-only")
+
+// entry is a stored value
+type entry struct {
+	Value     []byte     `json:"value"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return e.ExpiresAt != nil && !now.Before(*e.ExpiresAt)
+}
+
+// Store is a key-value store saved in a single JSON file
+type Store struct {
+	path string
+	mu   sync.RWMutex
+	data map[string]map[string]entry
+}
+
+var (
+	defaultStore *Store
+	defaultErr   error
+	defaultOnce  sync.Once
+)
+
+// Default returns the bot's store, opening it on first use
+func Default() (*Store, error) {
+	defaultOnce.Do(func() {
+		defaultStore, defaultErr = Open(DefaultPath)
+	})
+	return defaultStore, defaultErr
+}
+
+// Open loads the store from path, starting empty if the file doesn't exist
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, data: make(map[string]map[string]entry)}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &s.data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Tx is a transaction on one namespace. Writes are only visible to the
+// transaction until it is committed.
+type Tx struct {
+	store    *Store
+	ns       string
+	writable bool
+	now      time.Time
+	// writes holds the pending changes; a nil entry is a delete
+	writes map[string]*entry
+}
+
+// View runs fn in a read-only transaction
+func (s *Store) View(ns string, fn func(tx *Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn(&Tx{store: s, ns: ns, now: time.Now()})
+}
+
+// Update runs fn in a read-write transaction. The changes are saved if fn
+// returns nil and discarded otherwise. Updates are serialized.
+func (s *Store) Update(ns string, fn func(tx *Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &Tx{store: s, ns: ns, writable: true, now: time.Now(), writes: make(map[string]*entry)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.writes) == 0 {
+		return nil
+	}
+	return s.commit(tx)
+}
+
+// commit applies the writes of a transaction and saves the store, undoing
+// the writes if the store can't be saved. Must be called with s.mu held.
+func (s *Store) commit(tx *Tx) error {
+	bucket := s.data[tx.ns]
+	previous := make(map[string]entry, len(bucket))
+	for k, v := range bucket {
+		previous[k] = v
+	}
+
+	if bucket == nil {
+		bucket = make(map[string]entry)
+		s.data[tx.ns] = bucket
+	}
+	for key, e := range tx.writes {
+		if e == nil {
+			delete(bucket, key)
+		} else {
+			bucket[key] = *e
+		}
+	}
+
+	if err := s.save(tx.now); err != nil {
+		s.data[tx.ns] = previous
+		return err
+	}
+	return nil
+}
+
+// save writes the store to a temporary file and renames it into place,
+// dropping expired entries. Must be called with s.mu held.
+func (s *Store) save(now time.Time) error {
+	for ns, bucket := range s.data {
+		for key, e := range bucket {
+			if e.expired(now) {
+				delete(bucket, key)
+			}
+		}
+		if len(bucket) == 0 {
+			delete(s.data, ns)
+		}
+	}
+
+	raw, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".store-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// lookup returns the current entry for key, seeing the transaction's own writes
+func (tx *Tx) lookup(key string) (*entry, bool) {
+	if e, ok := tx.writes[key]; ok {
+		return e, e != nil
+	}
+	e, ok := tx.store.data[tx.ns][key]
+	if !ok || e.expired(tx.now) {
+		return nil, false
+	}
+	return &e, true
+}
+
+// Get returns the value of key
+func (tx *Tx) Get(key string) ([]byte, bool) {
+	e, ok := tx.lookup(key)
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), e.Value...), true
+}
+
+// Set stores a value that never expires
+func (tx *Tx) Set(key string, value []byte) error {
+	return tx.SetWithTTL(key, value, 0)
+}
+
+// SetWithTTL stores a value that expires after ttl, or never if ttl is 0
+func (tx *Tx) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	if !tx.writable {
+		return ErrReadOnly
+	}
+	if key == "" {
+		return errors.New("key must not be empty")
+	}
+
+	e := &entry{Value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		expiresAt := tx.now.Add(ttl)
+		e.ExpiresAt = &expiresAt
+	}
+	tx.writes[key] = e
+	return nil
+}
+
+// Delete removes key
+func (tx *Tx) Delete(key string) error {
+	if !tx.writable {
+		return ErrReadOnly
+	}
+	tx.writes[key] = nil
+	return nil
+}
+
+// List returns the keys starting with prefix, sorted
+func (tx *Tx) List(prefix string) []string {
+	seen := make(map[string]bool)
+	for key := range tx.store.data[tx.ns] {
+		if strings.HasPrefix(key, prefix) {
+			seen[key] = true
+		}
+	}
+	for key := range tx.writes {
+		if strings.HasPrefix(key, prefix) {
+			seen[key] = true
+		}
+	}
+
+	var keys []string
+	for key := range seen {
+		if _, ok := tx.lookup(key); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,226 @@
+package kvstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// openTemp opens a store in a temporary directory
+func openTemp(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// get reads key from ns in a read-only transaction
+func get(t *testing.T, s *Store, ns, key string) (string, bool) {
+	t.Helper()
+	var value []byte
+	var ok bool
+	if err := s.View(ns, func(tx *Tx) error {
+		value, ok = tx.Get(key)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return string(value), ok
+}
+
+func TestUpdateDiscardsOnError(t *testing.T) {
+	s := openTemp(t)
+	if err := s.Update("ns", func(tx *Tx) error {
+		return tx.Set("a", []byte("1"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("failed")
+	err := s.Update("ns", func(tx *Tx) error {
+		tx.Set("a", []byte("2"))
+		tx.Set("b", []byte("2"))
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("Update returned %v, want %v", err, failed)
+	}
+
+	if value, _ := get(t, s, "ns", "a"); value != "1" {
+		t.Errorf("a = %q after a failed update, want 1", value)
+	}
+	if _, ok := get(t, s, "ns", "b"); ok {
+		t.Error("b was stored by a failed update")
+	}
+}
+
+func TestCommitRestoresOnSaveError(t *testing.T) {
+	s := openTemp(t)
+	if err := s.Update("ns", func(tx *Tx) error {
+		return tx.Set("a", []byte("1"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A non-empty directory in place of the file makes the rename fail
+	os.Remove(s.path)
+	if err := os.MkdirAll(filepath.Join(s.path, "blocker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	err := s.Update("ns", func(tx *Tx) error {
+		tx.Delete("a")
+		return tx.Set("b", []byte("2"))
+	})
+	if err == nil {
+		t.Fatal("Update succeeded although the store can't be saved")
+	}
+
+	if value, ok := get(t, s, "ns", "a"); !ok || value != "1" {
+		t.Errorf("a = %q, %v after a failed save, want 1, true", value, ok)
+	}
+	if _, ok := get(t, s, "ns", "b"); ok {
+		t.Error("b is visible after a failed save")
+	}
+
+	// The same goes for a namespace the failed update created
+	if err := s.Update("other", func(tx *Tx) error {
+		return tx.Set("c", []byte("3"))
+	}); err == nil {
+		t.Fatal("Update succeeded although the store can't be saved")
+	}
+	if _, ok := get(t, s, "other", "c"); ok {
+		t.Error("c is visible after a failed save")
+	}
+}
+
+func TestTTLExpiry(t *testing.T) {
+	s := openTemp(t)
+	past := time.Now().Add(-time.Minute)
+	s.data["ns"] = map[string]entry{
+		"old": {Value: []byte("x"), ExpiresAt: &past},
+	}
+
+	if _, ok := get(t, s, "ns", "old"); ok {
+		t.Error("an expired entry is still returned")
+	}
+
+	if err := s.Update("ns", func(tx *Tx) error {
+		if keys := tx.List(""); len(keys) != 0 {
+			t.Errorf("List() = %v, want the expired entry left out", keys)
+		}
+		return tx.SetWithTTL("new", []byte("y"), time.Hour)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Saving drops expired entries and keeps the expiry of live ones
+	if _, ok := s.data["ns"]["old"]; ok {
+		t.Error("the expired entry was saved")
+	}
+	e, ok := s.data["ns"]["new"]
+	if !ok || e.ExpiresAt == nil || time.Until(*e.ExpiresAt) <= 59*time.Minute {
+		t.Errorf("new = %+v, %v, want an entry expiring in an hour", e, ok)
+	}
+}
+
+func TestTxReadsOwnWrites(t *testing.T) {
+	s := openTemp(t)
+	if err := s.Update("ns", func(tx *Tx) error {
+		return tx.Set("a", []byte("1"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Update("ns", func(tx *Tx) error {
+		tx.Set("a", []byte("2"))
+		if value, ok := tx.Get("a"); !ok || string(value) != "2" {
+			t.Errorf("Get(a) = %q, %v after Set, want 2, true", value, ok)
+		}
+		tx.Delete("a")
+		if _, ok := tx.Get("a"); ok {
+			t.Error("Get(a) found the key after Delete")
+		}
+		tx.Set("b", []byte("3"))
+		if value, ok := tx.Get("b"); !ok || string(value) != "3" {
+			t.Errorf("Get(b) = %q, %v after Set, want 3, true", value, ok)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := get(t, s, "ns", "a"); ok {
+		t.Error("a survived a committed delete")
+	}
+}
+
+func TestListMergesPendingWrites(t *testing.T) {
+	s := openTemp(t)
+	if err := s.Update("ns", func(tx *Tx) error {
+		tx.Set("user.a", nil)
+		tx.Set("user.b", nil)
+		return tx.Set("other", nil)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Update("ns", func(tx *Tx) error {
+		tx.Delete("user.a")
+		tx.Set("user.c", nil)
+		tx.Set("other.d", nil)
+
+		want := []string{"user.b", "user.c"}
+		if keys := tx.List("user."); !reflect.DeepEqual(keys, want) {
+			t.Errorf("List(user.) = %v, want %v", keys, want)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReopenAfterSave(t *testing.T) {
+	s := openTemp(t)
+	if err := s.Update("ns", func(tx *Tx) error {
+		tx.Set("a", []byte("1"))
+		return tx.SetWithTTL("b", []byte("2"), time.Hour)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update("other", func(tx *Tx) error {
+		return tx.Set("a", []byte("3"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ns, key, want string
+	}{
+		{"ns", "a", "1"},
+		{"ns", "b", "2"},
+		{"other", "a", "3"},
+	}
+	for _, tt := range tests {
+		if value, ok := get(t, reopened, tt.ns, tt.key); !ok || value != tt.want {
+			t.Errorf("%s/%s = %q, %v after reopening, want %q", tt.ns, tt.key, value, ok, tt.want)
+		}
+	}
+
+	err = reopened.View("ns", func(tx *Tx) error {
+		return tx.Set("c", nil)
+	})
+	if err != ErrReadOnly {
+		t.Errorf("Set in View returned %v, want %v", err, ErrReadOnly)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -28,8 +29,18 @@ var (
 	// Singleton instance of the chat logger
 	chatLog     *chatLogger
 	chatLogOnce sync.Once
+
+	// echoMessageEnabled is set while the server echoes the bot's own
+	// messages back (IRCv3 echo-message); they are then logged from the echo
+	echoMessageEnabled atomic.Bool
 )
 
+// SetEchoMessageEnabled tells the chat logger whether the bot's own channel
+// messages will be logged from their server echo instead of at send time
+func SetEchoMessageEnabled(enabled bool) {
+	echoMessageEnabled.Store(enabled)
+}
+
 // getChatLogger returns the singleton chat logger instance
 func getChatLogger() *chatLogger {
 	chatLogOnce.Do(func() {
@@ -134,13 +145,19 @@ func (cl *chatLogger) getLogWriter(logType LogType, name string) *os.File {
 
 // LogChannelMessage logs a message in a channel
 func LogChannelMessage(channel, sender, message string) {
+	LogChannelMessageAt(time.Now(), channel, sender, message)
+}
+
+// LogChannelMessageAt logs a message in a channel with the given timestamp
+// (e.g. from the IRCv3 server-time tag)
+func LogChannelMessageAt(t time.Time, channel, sender, message string) {
 	logger := getChatLogger()
 	writer := logger.getLogWriter(ChannelLog, channel)
 	if writer == nil {
 		return
 	}
 	
-	timestamp := time.Now().Format("15:04:05")
+	timestamp := t.Format("15:04:05")
 	logEntry := fmt.Sprintf("[%s] <%s> %s\n", timestamp, sender, message)
 	
 	if _, err := writer.WriteString(logEntry); err != nil {
@@ -150,13 +167,18 @@ func LogChannelMessage(channel, sender, message string) {
 
 // LogChannelAction logs an action in a channel
 func LogChannelAction(channel, sender, action string) {
+	LogChannelActionAt(time.Now(), channel, sender, action)
+}
+
+// LogChannelActionAt logs an action in a channel with the given timestamp
+func LogChannelActionAt(t time.Time, channel, sender, action string) {
 	logger := getChatLogger()
 	writer := logger.getLogWriter(ChannelLog, channel)
 	if writer == nil {
 		return
 	}
 	
-	timestamp := time.Now().Format("15:04:05")
+	timestamp := t.Format("15:04:05")
 	logEntry := fmt.Sprintf("[%s] * %s %s\n", timestamp, sender, action)
 	
 	if _, err := writer.WriteString(logEntry); err != nil {
@@ -166,13 +188,18 @@ func LogChannelAction(channel, sender, action string) {
 
 // LogChannelEvent logs a channel event (join, part, quit, etc.)
 func LogChannelEvent(channel, event string) {
+	LogChannelEventAt(time.Now(), channel, event)
+}
+
+// LogChannelEventAt logs a channel event with the given timestamp
+func LogChannelEventAt(t time.Time, channel, event string) {
 	logger := getChatLogger()
 	writer := logger.getLogWriter(ChannelLog, channel)
 	if writer == nil {
 		return
 	}
 	
-	timestamp := time.Now().Format("15:04:05")
+	timestamp := t.Format("15:04:05")
 	logEntry := fmt.Sprintf("[%s] %s\n", timestamp, event)
 	
 	if _, err := writer.WriteString(logEntry); err != nil {
@@ -182,6 +209,11 @@ func LogChannelEvent(channel, event string) {
 
 // LogBotChannelMessage logs the bot's own messages to the channel log
 func LogBotChannelMessage(channel, botNick, message string) {
+	// With echo-message the server echo is logged instead, with server-time
+	if echoMessageEnabled.Load() && len(channel) > 0 && (channel[0] == '#' || channel[0] == '&') {
+		return
+	}
+
 	logger := getChatLogger()
 	writer := logger.getLogWriter(ChannelLog, channel)
 	if writer == nil {
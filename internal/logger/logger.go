@@ -6,6 +6,7 @@ import (
 	stdlog "log"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 )
 
@@ -27,20 +28,38 @@ var (
 	// Separate AI logger that doesn't write to the error log
 	aiLogger *stdlog.Logger
 	aiLogFile *os.File
+
+	// logDir is where error.log and ai.log are written. The files are only
+	// opened when something is logged to them.
+	logDir     = filepath.Join("data")
+	logsOpened bool
+	logMu      sync.Mutex
 )
 
-func init() {
-	// Set up logs directory
-	dataDir := filepath.Join("data")
-	
+// SetLogDir writes the error and AI logs to another directory, such as a
+// temporary one in tests
+func SetLogDir(dir string) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	closeLogFiles()
+	logDir = dir
+}
+
+// openLogFiles opens the error and AI logs once. Must be called with logMu held.
+func openLogFiles() {
+	if logsOpened {
+		return
+	}
+	logsOpened = true
+
 	// Ensure the directory exists
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
+	if err := os.MkdirAll(logDir, 0755); err != nil {
 		fmt.Printf("Error creating data directory: %v\n", err)
 		return
 	}
 	
 	// Set up error log file
-	logPath := filepath.Join(dataDir, "error.log")
+	logPath := filepath.Join(logDir, "error.log")
 
 	// Create or open error log file
 	var err error
@@ -53,7 +72,7 @@ func init() {
 	}
 	
 	// Set up AI log file (separate from error log)
-	aiLogPath := filepath.Join(dataDir, "ai.log")
+	aiLogPath := filepath.Join(logDir, "ai.log")
 	
 	// Create or open AI log file
 	aiLogFile, err = os.OpenFile(aiLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
@@ -65,8 +84,8 @@ func init() {
 	}
 }
 
-// CloseLogFile should be called during shutdown to properly close all log files
-func CloseLogFile() {
+// closeLogFiles closes the error and AI logs. Must be called with logMu held.
+func closeLogFiles() {
 	if errorLogFile != nil {
 		errorLogFile.Close()
 	}
@@ -74,6 +93,17 @@ func CloseLogFile() {
 	if aiLogFile != nil {
 		aiLogFile.Close()
 	}
+
+	errorLogger, errorLogFile = nil, nil
+	aiLogger, aiLogFile = nil, nil
+	logsOpened = false
+}
+
+// CloseLogFile should be called during shutdown to properly close all log files
+func CloseLogFile() {
+	logMu.Lock()
+	closeLogFiles()
+	logMu.Unlock()
 	
 	fmt.Println("All log files closed")
 }
@@ -124,9 +154,12 @@ func logMessage(level LogLevel, format string, args ...interface{}) {
 	// Log only error and warning messages to error.log
 	// Debug messages should not go to error.log to avoid cluttering it with AI processing
 	if level == LevelError || level == LevelWarning {
+		logMu.Lock()
+		openLogFiles()
 		if errorLogger != nil {
 			errorLogger.Printf("[%s] %s: %s", level, timestamp, message)
 		}
+		logMu.Unlock()
 	}
 }
 
@@ -162,9 +195,12 @@ func AIDebugf(format string, args ...interface{}) {
 	fmt.Println(colorFunc(fmt.Sprintf("[AI-DEBUG] ")) + message)
 	
 	// Log to AI log file instead of error.log
+	logMu.Lock()
+	openLogFiles()
 	if aiLogger != nil {
 		aiLogger.Printf("[DEBUG] %s: %s", timestamp, message)
 	}
+	logMu.Unlock()
 }
 
 func Noticef(format string, args ...interface{}) {
@@ -0,0 +1,314 @@
+// Package nickrecovery picks a free nick while the bot registers and wins the
+// configured nick back once it becomes available. During registration the
+// alternate nicks from the config are tried in order, then "_" is appended.
+// After registration the primary nick is watched with MONITOR (or polled
+// with ISON), or taken back with NickServ REGAIN when a password is set.
+package nickrecovery
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/irc.v4"
+	"ircbot/internal"
+	"ircbot/internal/capabilities"
+	"ircbot/internal/config"
+	"ircbot/internal/logger"
+)
+
+var (
+	// RegainInterval is how often the bot tries to get its primary nick back
+	RegainInterval = 60 * time.Second
+	// settleDelay gives the server time to send RPL_ISUPPORT after 001
+	// before the first regain attempt
+	settleDelay = 5 * time.Second
+)
+
+// session holds the nick state of the current connection
+type session struct {
+	mu         sync.Mutex
+	primary    string
+	alts       []string
+	password   string
+	attempts   int
+	lastTried  string
+	registered bool
+	monitoring bool
+	done       chan struct{}
+}
+
+var (
+	current   *session
+	currentMu sync.Mutex
+)
+
+// Setup prepares nick handling for a new connection. It must be called
+// before client.Run.
+//
+// The IRC library answers ERR_NICKNAMEINUSE during registration by sending
+// its own nick with "_" appended. Those NICK lines are dropped, so the
+// server only sees the candidates HandleNickInUse picks.
+func Setup(client *irc.Client, cfg *config.Config) {
+	s := &session{
+		primary:   cfg.Nick,
+		password:  cfg.Password,
+		lastTried: cfg.Nick,
+		done:      make(chan struct{}),
+	}
+	for _, alt := range cfg.AltNicks {
+		if alt = strings.TrimSpace(alt); alt != "" && !strings.EqualFold(alt, cfg.Nick) {
+			s.alts = append(s.alts, alt)
+		}
+	}
+
+	next := client.Writer.WriteCallback
+	client.Writer.WriteCallback = func(w *irc.Writer, line string) error {
+		if s.unwantedNick(line) {
+			return nil
+		}
+		return next(w, line)
+	}
+
+	currentMu.Lock()
+	previous := current
+	current = s
+	currentMu.Unlock()
+	if previous != nil {
+		previous.stop()
+	}
+}
+
+// get returns the session of the current connection
+func get() *session {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	return current
+}
+
+// stop ends the regain loop of a session
+func (s *session) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+}
+
+// unwantedNick reports whether a line is a NICK sent during registration
+// for a nick other than the candidate the session picked
+func (s *session) unwantedNick(line string) bool {
+	if len(line) < 5 || !strings.EqualFold(line[:5], internal.CMD_NICK+" ") {
+		return false
+	}
+	nick := strings.TrimPrefix(strings.TrimSpace(line[5:]), ":")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.registered || strings.EqualFold(nick, s.lastTried) {
+		return false
+	}
+	logger.Debugf(">> Not sending NICK %s, waiting for %s", nick, s.lastTried)
+	return true
+}
+
+// nextCandidate returns the nick to try after the n-th failed attempt: the
+// alternates in order, then the primary nick with a growing run of "_"
+func (s *session) nextCandidate(n int) string {
+	if n < len(s.alts) {
+		return s.alts[n]
+	}
+	return s.primary + strings.Repeat("_", n-len(s.alts)+1)
+}
+
+// HandleNickInUse handles ERR_NICKNAMEINUSE and ERR_UNAVAILRESOURCE. During
+// registration the next candidate is sent; afterwards the failed attempt is
+// simply retried later.
+func HandleNickInUse(c *irc.Client, m *irc.Message) {
+	s := get()
+	if s == nil {
+		return
+	}
+
+	nick := ""
+	if len(m.Params) > 1 {
+		nick = m.Params[1]
+	}
+
+	s.mu.Lock()
+	if s.registered {
+		s.mu.Unlock()
+		logger.Debugf(">> Nickname %s is still unavailable, will try again later", nick)
+		return
+	}
+	// Only the reply to the nick we last sent moves on to the next one
+	if !strings.EqualFold(nick, s.lastTried) {
+		s.mu.Unlock()
+		return
+	}
+	s.lastTried = s.nextCandidate(s.attempts)
+	s.attempts++
+	tried := s.lastTried
+	s.mu.Unlock()
+
+	logger.Warnf(">> Nickname %s is unavailable, trying %s", nick, tried)
+	if err := c.Writef("%s :%s", internal.CMD_NICK, tried); err != nil {
+		logger.Errorf(">> Error changing nickname to %s: %v", tried, err)
+	}
+}
+
+// HandleWelcome runs on RPL_WELCOME. It identifies with NickServ when SASL
+// was not used, and starts trying to regain the primary nick if the bot had
+// to register with another one.
+func HandleWelcome(c *irc.Client, m *irc.Message) {
+	s := get()
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.registered = true
+	s.mu.Unlock()
+
+	Identify(c)
+
+	if !strings.EqualFold(c.CurrentNick(), s.primary) {
+		logger.Warnf(">> Registered as %s because %s was unavailable", c.CurrentNick(), s.primary)
+	}
+	go s.regainLoop(c)
+}
+
+// Identify identifies with NickServ using the configured password, unless
+// SASL already logged the bot in. When the bot is not on its primary nick
+// the account name is given explicitly.
+func Identify(c *irc.Client) {
+	s := get()
+	if s == nil || s.password == "" || capabilities.SASLAuthenticated() {
+		return
+	}
+
+	var err error
+	if strings.EqualFold(c.CurrentNick(), s.primary) {
+		err = c.Writef("%s NickServ :IDENTIFY %s", internal.CMD_PRIVMSG, s.password)
+	} else {
+		err = c.Writef("%s NickServ :IDENTIFY %s %s", internal.CMD_PRIVMSG, s.primary, s.password)
+	}
+	if err != nil {
+		logger.Errorf(">> Error identifying with NickServ: %v", err)
+		return
+	}
+	logger.Successf(">> Identifying with NickServ...")
+}
+
+// regainLoop periodically tries to get the primary nick back until the
+// connection is replaced
+func (s *session) regainLoop(c *irc.Client) {
+	timer := time.NewTimer(settleDelay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-timer.C:
+		}
+
+		if err := s.tryRegain(c); err != nil {
+			logger.Debugf("Stopping nick recovery: %v", err)
+			return
+		}
+		timer.Reset(RegainInterval)
+	}
+}
+
+// tryRegain makes one attempt at getting the primary nick back
+func (s *session) tryRegain(c *irc.Client) error {
+	if strings.EqualFold(c.CurrentNick(), s.primary) {
+		return nil
+	}
+
+	if s.password != "" {
+		logger.Infof(">> Asking NickServ to regain %s", s.primary)
+		return c.Writef("%s NickServ :REGAIN %s %s", internal.CMD_PRIVMSG, s.primary, s.password)
+	}
+
+	if c.ISupport != nil && c.ISupport.IsEnabled("MONITOR") {
+		s.mu.Lock()
+		monitoring := s.monitoring
+		s.monitoring = true
+		s.mu.Unlock()
+		if monitoring {
+			// The server tells us when the nick goes offline
+			return nil
+		}
+		return c.Writef("%s + %s", internal.CMD_MONITOR, s.primary)
+	}
+
+	return c.Writef("%s %s", internal.CMD_ISON, s.primary)
+}
+
+// HandleIson checks an RPL_ISON reply and takes the primary nick if it is
+// no longer online
+func HandleIson(c *irc.Client, m *irc.Message) {
+	s := get()
+	if s == nil || strings.EqualFold(c.CurrentNick(), s.primary) {
+		return
+	}
+
+	for _, nick := range strings.Fields(m.Trailing()) {
+		if strings.EqualFold(nick, s.primary) {
+			return
+		}
+	}
+	s.takePrimary(c)
+}
+
+// HandleMonitorOffline takes the primary nick when MONITOR reports it
+// went offline
+func HandleMonitorOffline(c *irc.Client, m *irc.Message) {
+	s := get()
+	if s == nil || strings.EqualFold(c.CurrentNick(), s.primary) {
+		return
+	}
+
+	for _, target := range strings.Split(m.Trailing(), ",") {
+		nick := strings.Split(target, "!")[0]
+		if strings.EqualFold(nick, s.primary) {
+			s.takePrimary(c)
+			return
+		}
+	}
+}
+
+// takePrimary tries to switch to the primary nick
+func (s *session) takePrimary(c *irc.Client) {
+	logger.Infof(">> Nickname %s is available, taking it back", s.primary)
+	if err := c.Writef("%s :%s", internal.CMD_NICK, s.primary); err != nil {
+		logger.Errorf(">> Error changing nickname to %s: %v", s.primary, err)
+	}
+}
+
+// HandleNick runs when the bot's own nick changes. On getting the primary
+// nick back it stops monitoring and identifies again.
+func HandleNick(c *irc.Client, m *irc.Message) {
+	s := get()
+	if s == nil || len(m.Params) == 0 || !strings.EqualFold(m.Params[0], s.primary) {
+		return
+	}
+
+	logger.Successf(">> Regained nickname %s", s.primary)
+
+	s.mu.Lock()
+	monitoring := s.monitoring
+	s.monitoring = false
+	s.mu.Unlock()
+	if monitoring {
+		if err := c.Writef("%s - %s", internal.CMD_MONITOR, s.primary); err != nil {
+			logger.Errorf(">> Error removing %s from MONITOR: %v", s.primary, err)
+		}
+	}
+
+	Identify(c)
+}
@@ -0,0 +1,302 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"ircbot/internal/logger"
+)
+
+// BuildTimeout limits a single plugin build
+const BuildTimeout = 2 * time.Minute
+
+// DefaultVersion is used for sources without a Version() literal
+const DefaultVersion = "1.0.0"
+
+var sourceVersion = regexp.MustCompile(`func\s+\(\w+\s+\*?\w+\)\s+Version\(\)\s+string\s+{\s*return\s+"([^"]+)"`)
+
+var (
+	builtMu sync.Mutex
+	// builtSources holds the SHA-256 of the last source built for each plugin
+	builtSources = make(map[string]string)
+	// buildSources holds the SHA-256 of the source of each finished build,
+	// by the absolute path of the build
+	buildSources = make(map[string]string)
+)
+
+// AlreadyLoadedError is returned by LoadBuild for a build of the source the
+// loaded plugin was built from. The Go runtime can't open the same code
+// twice, and there would be nothing to replace anyway.
+type AlreadyLoadedError struct {
+	Name    string
+	Version string
+}
+
+func (e *AlreadyLoadedError) Error() string {
+	return fmt.Sprintf("plugin %s version %s is already loaded from the same source", e.Name, e.Version)
+}
+
+// BuildError is a plugin build that the compiler rejected
+type BuildError struct {
+	Name   string
+	Err    error
+	Output string
+	// workDir is trimmed from the file names in the messages
+	workDir string
+}
+
+func (e *BuildError) Error() string {
+	if lines := e.Lines(); len(lines) > 0 {
+		return fmt.Sprintf("error compiling plugin %s: %s", e.Name, lines[0])
+	}
+	return fmt.Sprintf("error compiling plugin %s: %v", e.Name, e.Err)
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+// Lines returns the compiler messages without the package headers
+func (e *BuildError) Lines() []string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(e.Output), "\n") {
+		if line != "" && !strings.HasPrefix(line, "# ") {
+			if e.workDir != "" {
+				line = strings.ReplaceAll(line, e.workDir+string(filepath.Separator), "")
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// SourceVersion returns the version a plugin source's Version() method
+// returns, or "" if it has no such literal
+func SourceVersion(source []byte) string {
+	if matches := sourceVersion.FindSubmatch(source); len(matches) > 1 {
+		return string(matches[1])
+	}
+	return ""
+}
+
+// SourceHash returns the SHA-256 of a plugin source in hex
+func SourceHash(source []byte) string {
+	sum := sha256.Sum256(source)
+	return hex.EncodeToString(sum[:])
+}
+
+// BuiltSource returns the hash of the last source built for a plugin by
+// BuildGoPlugin, which may still be building
+func BuiltSource(name string) string {
+	builtMu.Lock()
+	defer builtMu.Unlock()
+	return builtSources[name]
+}
+
+// buildSource returns the hash of the source a build was made from, or ""
+// if it wasn't built by BuildGoPlugin
+func buildSource(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	builtMu.Lock()
+	defer builtMu.Unlock()
+	return buildSources[abs]
+}
+
+// BuildGoPlugin compiles the source of a Go plugin the way build_plugins.sh
+// does: with the plugin's name as build tag into dir/name_vX.so, and points
+// dir/name.so at it. Returns the version and the path of the build.
+func BuildGoPlugin(name string, source []byte, dir string) (string, string, error) {
+	builtMu.Lock()
+	builtSources[name] = SourceHash(source)
+	builtMu.Unlock()
+
+	version := SourceVersion(source)
+	if version == "" {
+		version = DefaultVersion
+	}
+
+	tempDir, err := os.MkdirTemp("", "mbot_plugin_")
+	if err != nil {
+		return "", "", fmt.Errorf("error creating temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	content := string(source)
+	if !strings.Contains(content, "//go:build") {
+		// The line directive keeps the compiler's line numbers those of the source
+		content = fmt.Sprintf("//go:build %s\n// +build %s\n\n//line %s.go:1:1\n%s", name, name, name, content)
+	}
+	srcPath := filepath.Join(tempDir, name+".go")
+	if err := os.WriteFile(srcPath, []byte(content), 0644); err != nil {
+		return "", "", fmt.Errorf("error writing plugin source: %w", err)
+	}
+
+	versionedFilename := fmt.Sprintf("%s_v%s.so", name, version)
+	outputPath := filepath.Join(dir, versionedFilename)
+	if err := build(name, outputPath, "-tags", name, "-buildmode=plugin", srcPath); err != nil {
+		var buildErr *BuildError
+		if errors.As(err, &buildErr) {
+			buildErr.workDir = tempDir
+		}
+		return "", "", err
+	}
+	if abs, err := filepath.Abs(outputPath); err == nil {
+		builtMu.Lock()
+		buildSources[abs] = SourceHash(source)
+		builtMu.Unlock()
+	}
+
+	standardPath := filepath.Join(dir, name+".so")
+	if _, err := os.Lstat(standardPath); err == nil {
+		os.Remove(standardPath)
+	}
+	if err := os.Symlink(versionedFilename, standardPath); err != nil {
+		logger.Warnf("Error creating symlink for plugin %s: %v", name, err)
+	}
+	return version, outputPath, nil
+}
+
+// BuildRPCPlugin compiles the package of an out-of-process plugin in srcDir
+// into an executable in dir named after the package directory
+func BuildRPCPlugin(srcDir, dir string) (string, error) {
+	name := filepath.Base(srcDir)
+	outputPath := filepath.Join(dir, name)
+	pkg, err := filepath.Abs(srcDir)
+	if err != nil {
+		return "", err
+	}
+	return outputPath, build(name, outputPath, pkg)
+}
+
+// build runs go build with args and moves the result to outputPath. The
+// output is written next to outputPath first and renamed into place, so a
+// loaded plugin file is never overwritten while it is mapped or running.
+func build(name, outputPath string, args ...string) error {
+	dir := filepath.Dir(outputPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating plugins directory: %w", err)
+	}
+	buildDir, err := os.MkdirTemp(dir, ".build_")
+	if err != nil {
+		return fmt.Errorf("error creating build directory: %w", err)
+	}
+	defer os.RemoveAll(buildDir)
+	tempPath := filepath.Join(buildDir, filepath.Base(outputPath))
+
+	ctx, cancel := context.WithTimeout(context.Background(), BuildTimeout)
+	defer cancel()
+	cmdArgs := append([]string{"build", "-o", tempPath}, args...)
+	output, err := exec.CommandContext(ctx, "go", cmdArgs...).CombinedOutput()
+	if err != nil {
+		logger.Errorf("Error compiling plugin %s: %v\n%s", name, err, output)
+		return &BuildError{Name: name, Err: err, Output: string(output)}
+	}
+	return os.Rename(tempPath, outputPath)
+}
+
+// LoadBuild loads a plugin that was just built, replacing the loaded
+// version even if it has the same version number. A Go plugin is opened
+// from a private copy, because the runtime hands out the plugin it opened
+// before for a path it has seen. A pin is lifted, as the new build was
+// asked for, and old builds are pruned. A build of the source that is
+// already loaded returns an *AlreadyLoadedError.
+func LoadBuild(path string) error {
+	source := buildSource(path)
+	openPath := path
+	if filepath.Ext(path) == ".so" {
+		if name, info, ok := loadedByBase(fileBase(path)); ok && source != "" && info.source == source {
+			return &AlreadyLoadedError{Name: name, Version: info.version}
+		}
+
+		tempDir, err := os.MkdirTemp("", "mbot_load_")
+		if err != nil {
+			return fmt.Errorf("error creating temp directory: %w", err)
+		}
+		// The library stays mapped after its file is removed
+		defer os.RemoveAll(tempDir)
+		openPath = filepath.Join(tempDir, filepath.Base(path))
+		if err := copyFile(path, openPath); err != nil {
+			return fmt.Errorf("error copying plugin %s: %w", path, err)
+		}
+	}
+
+	c, err := openCandidate(openPath)
+	if err != nil {
+		if loaded := alreadyOpened(path, err); loaded != nil {
+			return loaded
+		}
+		return err
+	}
+	c.path = path
+	c.source = source
+	c.replace = true
+	if err := c.load(); err != nil {
+		return err
+	}
+
+	if filepath.Ext(path) == ".so" {
+		base := fileBase(path)
+		if unpinned, err := UnpinVersion(base); unpinned {
+			logger.Infof("Plugin %s is no longer pinned", base)
+		} else if err != nil {
+			logger.Warnf("Error unpinning plugin %s: %v", base, err)
+		}
+		if _, err := PruneVersions(filepath.Dir(path), base); err != nil {
+			logger.Warnf("Error pruning old builds of plugin %s: %v", base, err)
+		}
+	}
+	return nil
+}
+
+// alreadyOpened tells a plugin loaded at startup apart from other code the
+// runtime refuses to open again. The source of a plugin loaded from disk is
+// unknown, but if the runtime has seen the code of a build with the loaded
+// version, that build is what is loaded.
+func alreadyOpened(path string, err error) error {
+	if filepath.Ext(path) != ".so" || !strings.Contains(err.Error(), "plugin already loaded") {
+		return nil
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	name, info, ok := loadedByBase(fileBase(path))
+	if !ok || info.source != "" || info.version != fileVersion(path) {
+		return nil
+	}
+	return &AlreadyLoadedError{Name: name, Version: info.version}
+}
+
+// copyFile copies the file at src to dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// fakeLoaded registers a loaded Go plugin without opening any file
+func fakeLoaded(t *testing.T, name, path, version, source string) {
+	t.Helper()
+	mgr.mu.Lock()
+	mgr.plugins[name] = pluginInfo{version: version, filePath: path, source: source}
+	mgr.mu.Unlock()
+	t.Cleanup(func() {
+		mgr.mu.Lock()
+		delete(mgr.plugins, name)
+		mgr.mu.Unlock()
+	})
+}
+
+// fakeBuild records a build of source at path as BuildGoPlugin does
+func fakeBuild(t *testing.T, path string, source []byte) {
+	t.Helper()
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builtMu.Lock()
+	buildSources[abs] = SourceHash(source)
+	builtMu.Unlock()
+	t.Cleanup(func() {
+		builtMu.Lock()
+		delete(buildSources, abs)
+		builtMu.Unlock()
+	})
+}
+
+func TestLoadBuildOfLoadedSource(t *testing.T) {
+	dir := t.TempDir()
+	source := []byte("package main\n")
+	loadedPath := filepath.Join(dir, "hello_v1.0.0.so")
+	fakeLoaded(t, "Hello", loadedPath, "1.0.0", SourceHash(source))
+
+	// Rebuilding the same source, even under another version, loads nothing
+	rebuilt := filepath.Join(dir, "hello_v1.0.1.so")
+	fakeBuild(t, rebuilt, source)
+	var loaded *AlreadyLoadedError
+	if err := LoadBuild(rebuilt); !errors.As(err, &loaded) || loaded.Name != "Hello" || loaded.Version != "1.0.0" {
+		t.Fatalf("LoadBuild of the loaded source = %v, want an AlreadyLoadedError for Hello 1.0.0", err)
+	}
+
+	// A build of another source is opened
+	changed := filepath.Join(dir, "hello_v1.0.2.so")
+	fakeBuild(t, changed, []byte("package main\n\n// changed\n"))
+	if err := LoadBuild(changed); err == nil || errors.As(err, &loaded) {
+		t.Errorf("LoadBuild of a changed source = %v, want the missing file's error", err)
+	}
+}
+
+func TestAlreadyOpened(t *testing.T) {
+	dir := t.TempDir()
+	runtimeErr := errors.New(`plugin.Open("x"): plugin already loaded`)
+	fakeLoaded(t, "Disk", filepath.Join(dir, "disk_v2.0.0.so"), "2.0.0", "")
+	fakeLoaded(t, "Built", filepath.Join(dir, "built_v1.0.0.so"), "1.0.0", SourceHash([]byte("old")))
+
+	tests := []struct {
+		path string
+		err  error
+		want bool
+	}{
+		// A plugin loaded from disk at startup with the build's version
+		{filepath.Join(dir, "disk_v2.0.0.so"), runtimeErr, true},
+		{filepath.Join(dir, "disk_v1.0.0.so"), runtimeErr, false},
+		{filepath.Join(dir, "disk_v2.0.0.so"), errors.New("no such file"), false},
+		// The loaded build's source is known and isn't this one
+		{filepath.Join(dir, "built_v1.0.0.so"), runtimeErr, false},
+		{filepath.Join(dir, "other_v1.0.0.so"), runtimeErr, false},
+	}
+
+	for _, tt := range tests {
+		if got := alreadyOpened(tt.path, tt.err); (got != nil) != tt.want {
+			t.Errorf("alreadyOpened(%s, %q) = %v, want an error %v", filepath.Base(tt.path), tt.err, got, tt.want)
+		}
+	}
+}
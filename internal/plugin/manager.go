@@ -3,10 +3,14 @@ package plugin
 import (
 	"fmt"
 	"gopkg.in/irc.v4"
+	"ircbot/internal/events"
 	"ircbot/internal/logger"
+	"ircbot/internal/scheduler"
+	"ircbot/pkg/api"
 	"os"
 	"path/filepath"
 	goPlugin "plugin"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -17,6 +21,10 @@ type pluginInfo struct {
 	version       string
 	loadTimestamp time.Time
 	filePath      string
+	metadata      api.PluginMetadata
+	worker        *worker
+	// source is the SHA-256 of the source of a build loaded by LoadBuild
+	source string
 }
 
 type manager struct {
@@ -35,62 +43,163 @@ var knownLegacyCommands = map[string][]string{
 	"EchoPlugin": {"echo"},
 }
 
-// LoadPlugin loads a single plugin from the given .so file.
-func LoadPlugin(path string) error {
+// openGoPlugin opens a Go plugin built as a .so file
+func openGoPlugin(path string) (Plugin, error) {
 	p, err := goPlugin.Open(path)
 	if err != nil {
-		return fmt.Errorf("failed to open plugin %s: %w", path, err)
+		return nil, fmt.Errorf("failed to open plugin %s: %w", path, err)
 	}
 
 	symPlugin, err := p.Lookup("Plugin")
 	if err != nil {
-		return fmt.Errorf("failed to lookup Plugin symbol in %s: %w", path, err)
+		return nil, fmt.Errorf("failed to lookup Plugin symbol in %s: %w", path, err)
 	}
 
 	plug, ok := symPlugin.(Plugin)
 	if !ok {
-		return fmt.Errorf("invalid plugin type in %s", path)
+		return nil, fmt.Errorf("invalid plugin type in %s", path)
+	}
+	return plug, nil
+}
+
+// candidate is a plugin file that has been opened but not loaded yet.
+// RPC plugins are only started when they are loaded, so until then plug is
+// nil and the metadata comes from the sidecar manifest alone.
+type candidate struct {
+	path string
+	plug Plugin
+	meta api.PluginMetadata
+	// replace loads the plugin even if the same version is loaded already
+	replace bool
+	// source is the hash of the source the build was made from, if known
+	source string
+}
+
+// openCandidate opens a plugin file and reads its metadata
+func openCandidate(path string) (*candidate, error) {
+	c := &candidate{path: path}
+	switch {
+	case filepath.Ext(path) == ".so":
+		plug, err := openGoPlugin(path)
+		if err != nil {
+			return nil, err
+		}
+		c.plug = plug
+		if c.meta, err = pluginMetadata(plug, path); err != nil {
+			return nil, err
+		}
+	case IsRPCPluginFile(path):
+		meta, _, err := readManifest(path)
+		if err != nil {
+			return nil, err
+		}
+		c.meta = meta
+	default:
+		return nil, fmt.Errorf("%s is neither a .so file nor an executable", path)
+	}
+	return c, nil
+}
+
+// LoadPlugin loads a single plugin, either a Go plugin from a .so file or
+// an RPC plugin from any other executable file. The plugins it depends on
+// must already be loaded.
+func LoadPlugin(path string) error {
+	c, err := openCandidate(path)
+	if err != nil {
+		return err
+	}
+	return c.load()
+}
+
+// load checks the plugin's requirements and loads it, replacing another
+// version of it if there is one
+func (c *candidate) load() error {
+	if err := checkAPIVersion(c.meta.APIVersion); err != nil {
+		return fmt.Errorf("plugin %s %w", c.path, err)
+	}
+
+	mgr.mu.Lock()
+	missing := missingDependencies(c.meta)
+	mgr.mu.Unlock()
+	if len(missing) > 0 {
+		return fmt.Errorf("plugin %s requires %s", c.path, strings.Join(missing, ", "))
+	}
+
+	plug := c.plug
+	if plug == nil {
+		rp, err := startRPCPlugin(c.path)
+		if err != nil {
+			return err
+		}
+		plug = rp
 	}
 
 	pluginName := plug.Name()
 	pluginVersion := plug.Version()
+	c.meta.Name = pluginName
+	c.meta.Version = pluginVersion
 
 	mgr.mu.Lock()
 	existingInfo, exists := mgr.plugins[pluginName]
 	
 	if exists {
-		if existingInfo.version == pluginVersion {
+		if existingInfo.version == pluginVersion && !c.replace {
 			mgr.mu.Unlock()
+			// The process started for the duplicate is not needed
+			if rp, ok := plug.(*rpcPlugin); ok {
+				rp.OnUnload()
+			}
 			logger.Infof("Plugin %s version %s is already loaded", pluginName, pluginVersion)
 			return nil
 		}
-		
-		if unloader, ok := existingInfo.plugin.(Unloader); ok {
-			if err := unloader.OnUnload(); err != nil {
-				logger.Errorf("OnUnload error for plugin %s: %v", pluginName, err)
+
+		// Don't swap in a version that the plugins depending on this one can't use
+		for dependent, minVersion := range dependentsOf(pluginName) {
+			if !satisfies(pluginVersion, minVersion) {
+				mgr.mu.Unlock()
+				if rp, ok := plug.(*rpcPlugin); ok {
+					rp.OnUnload()
+				}
+				return fmt.Errorf("plugin %s requires %s>=%s, refusing to load version %s",
+					dependent, pluginName, minVersion, pluginVersion)
 			}
 		}
-		logger.Infof("Unloaded previous version of plugin %s (was %s, loading %s)", 
-			pluginName, existingInfo.version, pluginVersion)
 		
+		delete(mgr.plugins, pluginName)
 		delete(mgr.pluginPaths, pluginName)
 	}
 	mgr.mu.Unlock()
 
-	if err := plug.OnLoad(); err != nil {
-		return fmt.Errorf("plugin %s OnLoad error: %w", pluginName, err)
+	if exists {
+		unloadPlugin(pluginName, existingInfo)
+		logger.Infof("Unloaded previous version of plugin %s (was %s, loading %s)", 
+			pluginName, existingInfo.version, pluginVersion)
+	}
+
+	var loadErr error
+	if err := safeCall(func() { loadErr = plug.OnLoad() }); err != nil {
+		loadErr = err
+	}
+	if loadErr != nil {
+		// Drop whatever the plugin set up before failing
+		events.UnsubscribeOwner(pluginName)
+		scheduler.CancelOwner(pluginName)
+		return fmt.Errorf("plugin %s OnLoad error: %w", pluginName, loadErr)
 	}
 
 	info := pluginInfo{
 		plugin:        plug,
 		version:       pluginVersion,
 		loadTimestamp: time.Now(),
-		filePath:      path,
+		filePath:      c.path,
+		metadata:      c.meta,
+		worker:        newWorker(pluginName),
+		source:        c.source,
 	}
 
 	mgr.mu.Lock()
 	mgr.plugins[pluginName] = info
-	mgr.pluginPaths[pluginName] = path
+	mgr.pluginPaths[pluginName] = c.path
 	mgr.mu.Unlock()
 
 	if cmdHandler, ok := plug.(CommandHandler); ok {
@@ -101,11 +210,17 @@ func LoadPlugin(path string) error {
 		}
 	}
 
-	logger.Infof("Plugin %s version %s loaded from %s", pluginName, pluginVersion, path)
+	logger.Infof("Plugin %s version %s loaded from %s", pluginName, pluginVersion, c.path)
+	reportCollisions(plug)
+	events.Publish(events.SourceCore, events.TopicPluginLoaded, events.PluginLoaded{
+		Name:    pluginName,
+		Version: pluginVersion,
+	})
 	return nil
 }
 
-// LoadPluginsFromDir scans a directory for .so files and loads them.
+// LoadPluginsFromDir scans a directory for .so files and RPC plugin
+// executables and loads them, each after the plugins it depends on.
 // Returns the number of successfully loaded plugins and any error encountered during directory reading.
 func LoadPluginsFromDir(dir string) (int, error) {
 	files, err := os.ReadDir(dir)
@@ -113,17 +228,70 @@ func LoadPluginsFromDir(dir string) (int, error) {
 		return 0, fmt.Errorf("failed to read plugin directory %s: %w", dir, err)
 	}
 	
-	loadedCount := 0
+	// Only one build of each Go plugin is opened, see selectGoPlugins
+	var goPlugins, paths []string
 	for _, file := range files {
+		pluginPath := filepath.Join(dir, file.Name())
 		if filepath.Ext(file.Name()) == ".so" {
-			pluginPath := filepath.Join(dir, file.Name())
-			err = LoadPlugin(pluginPath)
-			if err != nil {
-				logger.Errorf("Error loading plugin %s: %v", file.Name(), err)
+			goPlugins = append(goPlugins, file.Name())
+		} else if IsRPCPluginFile(pluginPath) {
+			paths = append(paths, pluginPath)
+		}
+	}
+	paths = append(selectGoPlugins(dir, goPlugins), paths...)
+
+	var pending []*candidate
+	for _, pluginPath := range paths {
+		c, err := openCandidate(pluginPath)
+		if err != nil {
+			logger.Errorf("Error loading plugin %s: %v", filepath.Base(pluginPath), err)
+			continue
+		}
+		pending = append(pending, c)
+	}
+
+	// Load in rounds: every round loads the plugins whose dependencies are
+	// all loaded, until nothing more can be loaded
+	loadedCount := 0
+	for len(pending) > 0 {
+		var waiting []*candidate
+		for _, c := range pending {
+			mgr.mu.Lock()
+			missing := missingDependencies(c.meta)
+			mgr.mu.Unlock()
+			if len(missing) > 0 {
+				waiting = append(waiting, c)
+				continue
+			}
+
+			if err := c.load(); err != nil {
+				logger.Errorf("Error loading plugin %s: %v", filepath.Base(c.path), err)
 			} else {
 				loadedCount++
 			}
 		}
+
+		if len(waiting) == len(pending) {
+			for _, c := range waiting {
+				mgr.mu.Lock()
+				missing := missingDependencies(c.meta)
+				mgr.mu.Unlock()
+				logger.Errorf("Error loading plugin %s: missing dependencies %s",
+					filepath.Base(c.path), strings.Join(missing, ", "))
+			}
+			break
+		}
+		pending = waiting
+	}
+
+	pruned := make(map[string]bool)
+	for _, name := range goPlugins {
+		if base := fileBase(name); !pruned[base] {
+			pruned[base] = true
+			if _, err := PruneVersions(dir, base); err != nil {
+				logger.Warnf("Error pruning old builds of plugin %s: %v", base, err)
+			}
+		}
 	}
 	return loadedCount, nil
 }
@@ -177,6 +345,28 @@ type Unloader interface {
 	OnUnload() error
 }
 
+// ConfigReloader is an optional interface for plugins that want to know
+// when their configuration was changed with !pluginconfig.
+type ConfigReloader interface {
+	OnConfigChange()
+}
+
+// NotifyConfigChange tells a loaded plugin that its configuration changed.
+// Returns false if the plugin is not loaded.
+func NotifyConfigChange(name string) bool {
+	mgr.mu.Lock()
+	info, exists := mgr.plugins[name]
+	mgr.mu.Unlock()
+	if !exists {
+		return false
+	}
+
+	if reloader, ok := info.plugin.(ConfigReloader); ok {
+		Dispatch(info.plugin, "OnConfigChange", reloader.OnConfigChange)
+	}
+	return true
+}
+
 // UnloadPlugin removes a plugin from the registry.
 // If the plugin implements Unloader, its OnUnload method is called.
 func UnloadPlugin(name string) error {
@@ -186,40 +376,69 @@ func UnloadPlugin(name string) error {
 		mgr.mu.Unlock()
 		return fmt.Errorf("plugin %s is not loaded", name)
 	}
-	
-	if unloader, ok := info.plugin.(Unloader); ok {
-		if err := unloader.OnUnload(); err != nil {
-			logger.Errorf("OnUnload error for plugin %s: %v", name, err)
-		}
+
+	if dependents := dependentsOf(name); len(dependents) > 0 {
+		mgr.mu.Unlock()
+		return fmt.Errorf("plugin %s is required by %s", name, strings.Join(sortedKeys(dependents), ", "))
 	}
 	
 	delete(mgr.plugins, name)
 	delete(mgr.pluginPaths, name)
 	mgr.mu.Unlock()
 	
+	unloadPlugin(name, info)
 	logger.Infof("Plugin %s version %s unloaded", name, info.version)
 	return nil
 }
 
+// unloadPlugin stops the worker of a plugin already removed from the
+// registry and runs its OnUnload hook, without holding mgr.mu
+func unloadPlugin(name string, info pluginInfo) {
+	if info.worker != nil {
+		info.worker.stop()
+	}
+	if cancelled := scheduler.CancelOwner(name); cancelled > 0 {
+		logger.Infof("Cancelled %d scheduled tasks of plugin %s", cancelled, name)
+	}
+	events.UnsubscribeOwner(name)
+	if unloader, ok := info.plugin.(Unloader); ok {
+		var unloadErr error
+		if err := safeCall(func() { unloadErr = unloader.OnUnload() }); err != nil {
+			unloadErr = err
+		}
+		if unloadErr != nil {
+			logger.Errorf("OnUnload error for plugin %s: %v", name, unloadErr)
+		}
+	}
+	events.Publish(events.SourceCore, events.TopicPluginUnloaded, events.PluginUnloaded{
+		Name:    name,
+		Version: info.version,
+	})
+}
+
 // HandlePluginCommand attempts to handle a command through plugins.
+// The command runs on the plugin's worker, not on the caller's goroutine.
+// Permissions are checked by the caller, see FindPluginCommand.
 // Returns true if a plugin handled the command, false otherwise.
 func HandlePluginCommand(c *irc.Client, m *irc.Message, cmd string, args []string) bool {
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
 	
-	for _, info := range mgr.plugins {
-		plug := info.plugin
-		
-		if cmdHandler, ok := plug.(CommandHandler); ok {
-			for _, supportedCmd := range cmdHandler.GetCommands() {
-				if supportedCmd == cmd {
-					cmdHandler.HandleCommand(c, m, cmd, args)
-					return true
-				}
-			}
+	if name, info, ok := commandOwner(cmd); ok {
+		cmdHandler := info.plugin.(CommandHandler)
+		queued := info.worker.submit(task{
+			what:     "command " + cmd,
+			deadline: CommandDeadline,
+			f:        func() { cmdHandler.HandleCommand(c, m, cmd, args) },
+		})
+		if !queued {
+			logger.Warnf("Plugin %s could not take command %s", name, cmd)
 		}
-		
-		if cmds, ok := knownLegacyCommands[plug.Name()]; ok {
+		return true
+	}
+	
+	for _, info := range mgr.plugins {
+		if cmds, ok := knownLegacyCommands[info.plugin.Name()]; ok {
 			for _, supportedCmd := range cmds {
 				if supportedCmd == cmd {
 					return true
@@ -231,6 +450,113 @@ func HandlePluginCommand(c *irc.Client, m *irc.Message, cmd string, args []strin
 	return false
 }
 
+// IsBuiltinCommand reports whether a command belongs to the bot itself. It
+// is set by the command system so that plugin commands shadowed by
+// built-in commands can be reported.
+var IsBuiltinCommand func(name string) bool
+
+// providers returns the enabled plugins that provide cmd, earliest loaded
+// first. Must be called with mgr.mu held.
+func providers(cmd string) []string {
+	var names []string
+	for name, info := range mgr.plugins {
+		cmdHandler, ok := info.plugin.(CommandHandler)
+		if !ok || info.worker.disabled() {
+			continue
+		}
+		for _, supportedCmd := range cmdHandler.GetCommands() {
+			if supportedCmd == cmd {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return mgr.plugins[names[i]].loadTimestamp.Before(mgr.plugins[names[j]].loadTimestamp)
+	})
+	return names
+}
+
+// commandOwner returns the plugin that handles cmd. When several plugins
+// provide the same command, the one loaded first keeps it. Must be called
+// with mgr.mu held.
+func commandOwner(cmd string) (string, pluginInfo, bool) {
+	names := providers(cmd)
+	if len(names) == 0 {
+		return "", pluginInfo{}, false
+	}
+	return names[0], mgr.plugins[names[0]], true
+}
+
+// FindPluginCommand returns the plugin command that would handle cmd, with
+// the level it requires
+func FindPluginCommand(cmd string) (CommandInfo, bool) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	name, info, ok := commandOwner(cmd)
+	if !ok {
+		return CommandInfo{}, false
+	}
+	return commandInfo(name, info, cmd), true
+}
+
+// GetCommandCollisions describes the commands provided by more than one
+// plugin, or by a plugin and the bot itself
+func GetCommandCollisions() []string {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var collisions []string
+	for _, info := range mgr.plugins {
+		cmdHandler, ok := info.plugin.(CommandHandler)
+		if !ok {
+			continue
+		}
+		for _, cmd := range cmdHandler.GetCommands() {
+			if seen[cmd] {
+				continue
+			}
+			seen[cmd] = true
+			if collision := describeCollision(cmd); collision != "" {
+				collisions = append(collisions, collision)
+			}
+		}
+	}
+	sort.Strings(collisions)
+	return collisions
+}
+
+// describeCollision explains who provides cmd if more than one does, or
+// returns "". Must be called with mgr.mu held.
+func describeCollision(cmd string) string {
+	names := providers(cmd)
+	switch {
+	case len(names) > 0 && IsBuiltinCommand != nil && IsBuiltinCommand(cmd):
+		return fmt.Sprintf("!%s of %s is shadowed by the built-in command", cmd, strings.Join(names, ", "))
+	case len(names) > 1:
+		return fmt.Sprintf("!%s is provided by %s; %s handles it", cmd, strings.Join(names, ", "), names[0])
+	}
+	return ""
+}
+
+// reportCollisions logs the command collisions involving a plugin
+func reportCollisions(plug Plugin) {
+	cmdHandler, ok := plug.(CommandHandler)
+	if !ok {
+		return
+	}
+
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	for _, cmd := range cmdHandler.GetCommands() {
+		if collision := describeCollision(cmd); collision != "" {
+			logger.Warnf("Command collision: %s", collision)
+		}
+	}
+}
+
 // GetLegacyPluginCommands returns the known commands for a legacy plugin if available.
 func GetLegacyPluginCommands(pluginName string) ([]string, bool) {
 	commands, ok := knownLegacyCommands[pluginName]
@@ -243,19 +569,36 @@ var GetPluginList func() []Plugin = GetPluginObjects
 // Returns the number of successfully loaded plugins and any error encountered.
 func ReloadPluginsFromDir(dir string) (int, error) {
 	unloadedCount := 0
-	mgr.mu.Lock()
-	for name, info := range mgr.plugins {
-		if unloader, ok := info.plugin.(Unloader); ok {
-			if err := unloader.OnUnload(); err != nil {
-				logger.Errorf("OnUnload error for plugin %s: %v", name, err)
+	for {
+		// Unload plugins nothing else depends on until none are left
+		mgr.mu.Lock()
+		var name string
+		for loaded := range mgr.plugins {
+			if len(dependentsOf(loaded)) == 0 {
+				name = loaded
+				break
 			}
 		}
-		logger.Infof("Plugin %s version %s unloaded", name, info.version)
+		if name == "" {
+			// Whatever is left depends on each other
+			for loaded := range mgr.plugins {
+				name = loaded
+				break
+			}
+		}
+		if name == "" {
+			mgr.mu.Unlock()
+			break
+		}
+		info := mgr.plugins[name]
 		delete(mgr.plugins, name)
 		delete(mgr.pluginPaths, name)
+		mgr.mu.Unlock()
+
+		unloadPlugin(name, info)
+		logger.Infof("Plugin %s version %s unloaded", name, info.version)
 		unloadedCount++
 	}
-	mgr.mu.Unlock()
 	
 	logger.Infof("Unloaded %d plugins", unloadedCount)
 	
@@ -0,0 +1,231 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"ircbot/pkg/api"
+)
+
+// MetadataProvider is implemented by plugins that describe themselves:
+// author, description, required API version, dependencies and command help
+type MetadataProvider interface {
+	Metadata() api.PluginMetadata
+}
+
+// CommandInfo describes a plugin command for help listings
+type CommandInfo struct {
+	Plugin      string
+	Command     string
+	Description string
+	Usage       string
+	Level       api.UserLevel
+}
+
+// versionSuffix matches the version that build_plugins.sh adds to file names
+var versionSuffix = regexp.MustCompile(`_v[0-9][0-9.]*$`)
+
+// manifestPaths returns where the sidecar manifest of a plugin file may be:
+// next to it with a .toml extension, with or without the version suffix
+func manifestPaths(path string) []string {
+	base := strings.TrimSuffix(path, ".so")
+	paths := []string{base + ".toml"}
+	if unversioned := versionSuffix.ReplaceAllString(base, ""); unversioned != base {
+		paths = append(paths, unversioned+".toml")
+	}
+	return paths
+}
+
+// readManifest reads the sidecar manifest of a plugin file, if there is one
+func readManifest(path string) (api.PluginMetadata, bool, error) {
+	var meta api.PluginMetadata
+	for _, manifest := range manifestPaths(path) {
+		if _, err := os.Stat(manifest); err != nil {
+			continue
+		}
+		if _, err := toml.DecodeFile(manifest, &meta); err != nil {
+			return meta, false, fmt.Errorf("invalid manifest %s: %w", manifest, err)
+		}
+		return meta, true, nil
+	}
+	return meta, false, nil
+}
+
+// pluginMetadata returns the metadata of a plugin, from its Metadata()
+// method or else its sidecar manifest. Name and version always come from
+// the plugin itself.
+func pluginMetadata(plug Plugin, path string) (api.PluginMetadata, error) {
+	var meta api.PluginMetadata
+	if provider, ok := plug.(MetadataProvider); ok {
+		meta = provider.Metadata()
+	} else {
+		var err error
+		if meta, _, err = readManifest(path); err != nil {
+			return meta, err
+		}
+	}
+	meta.Name = plug.Name()
+	meta.Version = plug.Version()
+	return meta, nil
+}
+
+// checkAPIVersion checks that a plugin written for the given API version
+// can run on this bot
+func checkAPIVersion(required string) error {
+	if required == "" {
+		return nil
+	}
+	wantMajor, wantMinor := splitVersion(required)
+	haveMajor, haveMinor := splitVersion(api.APIVersion)
+	if wantMajor != haveMajor || wantMinor > haveMinor {
+		return fmt.Errorf("requires plugin API %s, bot provides %s", required, api.APIVersion)
+	}
+	return nil
+}
+
+// splitVersion returns the major and minor parts of a version
+func splitVersion(version string) (int, int) {
+	parts := versionParts(version)
+	for len(parts) < 2 {
+		parts = append(parts, 0)
+	}
+	return parts[0], parts[1]
+}
+
+// versionParts splits a version such as "v1.2.3" into its numbers.
+// Anything that is not a number counts as 0.
+func versionParts(version string) []int {
+	var parts []int
+	for _, part := range strings.Split(strings.TrimPrefix(strings.TrimSpace(version), "v"), ".") {
+		n, _ := strconv.Atoi(part)
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+// compareVersions returns -1, 0 or 1 as a is older than, equal to or newer than b
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// parseDependency splits a dependency such as "NotesPlugin>=1.2.0" into
+// the plugin name and the minimum version, which may be empty
+func parseDependency(dep string) (string, string) {
+	name, minVersion, _ := strings.Cut(dep, ">=")
+	return strings.TrimSpace(name), strings.TrimSpace(minVersion)
+}
+
+// satisfies reports whether a loaded version meets a minimum version
+func satisfies(version, minVersion string) bool {
+	return minVersion == "" || compareVersions(version, minVersion) >= 0
+}
+
+// missingDependencies returns the dependencies of meta that are not loaded
+// in a suitable version. Must be called with mgr.mu held.
+func missingDependencies(meta api.PluginMetadata) []string {
+	var missing []string
+	for _, dep := range meta.Dependencies {
+		name, minVersion := parseDependency(dep)
+		info, ok := mgr.plugins[name]
+		if !ok || !satisfies(info.version, minVersion) {
+			missing = append(missing, dep)
+		}
+	}
+	return missing
+}
+
+// dependentsOf returns the loaded plugins that depend on name, and the
+// minimum versions they need. Must be called with mgr.mu held.
+func dependentsOf(name string) map[string]string {
+	dependents := make(map[string]string)
+	for other, info := range mgr.plugins {
+		for _, dep := range info.metadata.Dependencies {
+			if depName, minVersion := parseDependency(dep); depName == name {
+				dependents[other] = minVersion
+			}
+		}
+	}
+	return dependents
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetPluginMetadata returns the metadata of a loaded plugin
+func GetPluginMetadata(name string) (api.PluginMetadata, bool) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	info, ok := mgr.plugins[name]
+	return info.metadata, ok
+}
+
+// GetPluginCommandInfo returns the commands of the loaded plugins with
+// their help and required level. Commands without help in the plugin's
+// metadata are open to Regular users.
+func GetPluginCommandInfo() []CommandInfo {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+
+	var commands []CommandInfo
+	for name, info := range mgr.plugins {
+		cmdHandler, ok := info.plugin.(CommandHandler)
+		if !ok {
+			continue
+		}
+
+		for _, cmd := range cmdHandler.GetCommands() {
+			commands = append(commands, commandInfo(name, info, cmd))
+		}
+	}
+
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Command < commands[j].Command
+	})
+	return commands
+}
+
+// commandInfo describes a command of a plugin from its metadata
+func commandInfo(name string, info pluginInfo, cmd string) CommandInfo {
+	ci := CommandInfo{Plugin: name, Command: cmd, Level: api.Regular}
+	for _, h := range info.metadata.Commands {
+		if h.Command != cmd {
+			continue
+		}
+		ci.Description = h.Description
+		ci.Usage = h.Usage
+		// An unset permission is the zero level, Ignored
+		if h.Permission != api.Ignored {
+			ci.Level = h.Permission
+		}
+		break
+	}
+	return ci
+}
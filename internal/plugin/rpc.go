@@ -0,0 +1,551 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/irc.v4"
+	"ircbot/internal/events"
+	"ircbot/internal/logger"
+	"ircbot/pkg/api"
+	"ircbot/pkg/rpcplugin"
+)
+
+const (
+	// rpcInitTimeout is how long a plugin process has to answer "initialize"
+	rpcInitTimeout = 10 * time.Second
+	// rpcShutdownTimeout is how long a plugin has to answer "shutdown"
+	rpcShutdownTimeout = 5 * time.Second
+	// rpcExitTimeout is how long to wait for the process to exit before killing it
+	rpcExitTimeout = 2 * time.Second
+)
+
+// errNotConnected is returned to plugins calling IRC methods before the
+// bot has seen any traffic
+var errNotConnected = errors.New("bot is not connected")
+
+// rpcPlugin is a plugin running as a separate process, reached over the
+// JSON-RPC protocol of ircbot/pkg/rpcplugin. It implements every handler
+// interface and forwards only the events the plugin asked for.
+type rpcPlugin struct {
+	path     string
+	name     string
+	version  string
+	commands []string
+	events   map[string]bool
+	topics   []string
+
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	conn   *rpcplugin.Conn
+	exited chan struct{}
+
+	mu          sync.Mutex
+	client      *irc.Client
+	initialized bool
+	stopping    bool
+}
+
+// IsRPCPluginFile reports whether path is an executable that can be run as
+// an RPC plugin
+func IsRPCPluginFile(path string) bool {
+	if filepath.Ext(path) == ".so" {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0
+}
+
+// startRPCPlugin runs the plugin executable at path and initializes it
+func startRPCPlugin(path string) (*rpcPlugin, error) {
+	p := &rpcPlugin{
+		path:   path,
+		name:   filepath.Base(path),
+		events: make(map[string]bool),
+		exited: make(chan struct{}),
+	}
+
+	p.cmd = exec.Command(path)
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := p.cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %w", path, err)
+	}
+	p.stdin = stdin
+	p.conn = rpcplugin.NewConn(stdout, stdin, p.handle)
+
+	stderrDone := make(chan struct{})
+	go p.logStderr(stderr, stderrDone)
+	go p.wait(stderrDone)
+
+	var result rpcplugin.InitializeResult
+	err = p.conn.Call(rpcplugin.MethodInitialize, rpcplugin.InitializeParams{
+		ProtocolVersion: rpcplugin.ProtocolVersion,
+	}, &result, rpcInitTimeout)
+	if err == nil && result.Name == "" {
+		err = errors.New("plugin did not report a name")
+	}
+	if err != nil {
+		p.stop(false)
+		return nil, fmt.Errorf("failed to initialize plugin %s: %w", path, err)
+	}
+
+	p.mu.Lock()
+	p.name = result.Name
+	p.version = result.Version
+	p.initialized = true
+	p.mu.Unlock()
+	p.commands = result.Commands
+	for _, event := range result.Events {
+		p.events[event] = true
+	}
+	p.topics = result.Topics
+	return p, nil
+}
+
+// logStderr copies the plugin's stderr to the bot log
+func (p *rpcPlugin) logStderr(r io.Reader, done chan struct{}) {
+	defer close(done)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		logger.Infof("[%s] %s", filepath.Base(p.path), scanner.Text())
+	}
+	// Keep draining so an overlong line can't block the process
+	io.Copy(io.Discard, r)
+}
+
+// wait reaps the process and logs it if it exits on its own
+func (p *rpcPlugin) wait(stderrDone chan struct{}) {
+	<-stderrDone
+	err := p.cmd.Wait()
+	p.conn.Close()
+
+	p.mu.Lock()
+	stopping := p.stopping
+	p.mu.Unlock()
+	if !stopping {
+		logger.Errorf("Plugin %s exited unexpectedly: %v", p.Name(), err)
+	}
+	close(p.exited)
+}
+
+// stop asks the plugin to shut down and makes sure the process is gone.
+// Only plugins that finished initializing are sent "shutdown".
+func (p *rpcPlugin) stop(initialized bool) error {
+	p.mu.Lock()
+	p.stopping = true
+	p.mu.Unlock()
+
+	var err error
+	if initialized {
+		err = p.conn.Call(rpcplugin.MethodShutdown, struct{}{}, nil, rpcShutdownTimeout)
+		if errors.Is(err, rpcplugin.ErrClosed) {
+			err = nil
+		}
+	}
+	p.conn.Close()
+	p.stdin.Close()
+
+	select {
+	case <-p.exited:
+	case <-time.After(rpcExitTimeout):
+		logger.Warnf("Plugin %s did not exit, killing it", p.Name())
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+	return err
+}
+
+func (p *rpcPlugin) Name() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.name
+}
+
+func (p *rpcPlugin) Version() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.version
+}
+
+// OnLoad subscribes the plugin to the event bus topics it asked for; the
+// plugin's own OnLoad ran during "initialize"
+func (p *rpcPlugin) OnLoad() error {
+	for _, topic := range p.topics {
+		if _, err := events.Subscribe(p.Name(), topic, p.busEvent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OnUnload shuts the plugin process down
+func (p *rpcPlugin) OnUnload() error {
+	return p.stop(true)
+}
+
+func (p *rpcPlugin) GetCommands() []string { return p.commands }
+
+func (p *rpcPlugin) HandleCommand(c *irc.Client, m *irc.Message, cmd string, args []string) {
+	p.setClient(c)
+	p.notify(rpcplugin.MethodCommand, rpcplugin.CommandParams{
+		Command: cmd,
+		Args:    args,
+		BotNick: c.CurrentNick(),
+		Message: toRPCMessage(m),
+	})
+}
+
+func (p *rpcPlugin) OnMessage(c *irc.Client, m *irc.Message) {
+	p.forward(c, m, rpcplugin.EventMessage)
+}
+
+func (p *rpcPlugin) OnPrivMsg(c *irc.Client, m *irc.Message) {
+	p.forward(c, m, rpcplugin.EventPrivMsg)
+}
+
+func (p *rpcPlugin) OnNickMention(c *irc.Client, m *irc.Message) {
+	p.forward(c, m, rpcplugin.EventNickMention)
+}
+
+func (p *rpcPlugin) OnKick(c *irc.Client, m *irc.Message) {
+	p.forward(c, m, rpcplugin.EventKick)
+}
+
+func (p *rpcPlugin) OnTopicChange(c *irc.Client, m *irc.Message) {
+	p.forward(c, m, rpcplugin.EventTopicChange)
+}
+
+func (p *rpcPlugin) OnJoin(c *irc.Client, m *irc.Message) {
+	p.forward(c, m, rpcplugin.EventJoin)
+}
+
+func (p *rpcPlugin) OnPart(c *irc.Client, m *irc.Message) {
+	p.forward(c, m, rpcplugin.EventPart)
+}
+
+func (p *rpcPlugin) OnQuit(c *irc.Client, m *irc.Message) {
+	p.forward(c, m, rpcplugin.EventQuit)
+}
+
+func (p *rpcPlugin) OnNickChange(c *irc.Client, m *irc.Message) {
+	p.forward(c, m, rpcplugin.EventNickChange)
+}
+
+func (p *rpcPlugin) OnInvite(c *irc.Client, m *irc.Message) {
+	p.forward(c, m, rpcplugin.EventInvite)
+}
+
+func (p *rpcPlugin) OnNotice(c *irc.Client, m *irc.Message) {
+	p.forward(c, m, rpcplugin.EventNotice)
+}
+
+func (p *rpcPlugin) OnError(c *irc.Client, m *irc.Message) {
+	p.forward(c, m, rpcplugin.EventError)
+}
+
+func (p *rpcPlugin) OnMode(c *irc.Client, m *irc.Message) {
+	p.forward(c, m, rpcplugin.EventMode)
+}
+
+// forward sends an event to the plugin if it subscribed to it
+func (p *rpcPlugin) forward(c *irc.Client, m *irc.Message, event string) {
+	p.setClient(c)
+	if !p.events[event] {
+		return
+	}
+	p.notify(rpcplugin.MethodEvent, rpcplugin.EventParams{
+		Type:    event,
+		BotNick: c.CurrentNick(),
+		Message: toRPCMessage(m),
+	})
+}
+
+// busEvent sends an event from the event bus to the plugin
+func (p *rpcPlugin) busEvent(e events.Event) {
+	var data json.RawMessage
+	switch d := e.Data.(type) {
+	case nil:
+	case json.RawMessage:
+		data = d
+	default:
+		raw, err := json.Marshal(d)
+		if err != nil {
+			logger.Errorf("Can't send %s event to plugin %s: %v", e.Topic, p.Name(), err)
+			return
+		}
+		data = raw
+	}
+	p.notify(rpcplugin.MethodBusEvent, rpcplugin.BusEvent{
+		Topic:  e.Topic,
+		Source: e.Source,
+		Time:   e.Time,
+		Data:   data,
+	})
+}
+
+// notify sends a notification without ever blocking the bot
+func (p *rpcPlugin) notify(method string, params interface{}) {
+	err := p.conn.Notify(method, params)
+	switch {
+	case err == nil, errors.Is(err, rpcplugin.ErrClosed):
+	case errors.Is(err, rpcplugin.ErrBusy):
+		logger.Warnf("Plugin %s is not keeping up, dropped a %s", p.Name(), method)
+	default:
+		logger.Errorf("Error sending %s to plugin %s: %v", method, p.Name(), err)
+	}
+}
+
+// setClient remembers the client to use for the plugin's calls
+func (p *rpcPlugin) setClient(c *irc.Client) {
+	p.mu.Lock()
+	p.client = c
+	p.mu.Unlock()
+}
+
+// getClient returns the client of the most recent event
+func (p *rpcPlugin) getClient() (*irc.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client == nil {
+		return nil, errNotConnected
+	}
+	return p.client, nil
+}
+
+// toRPCMessage converts an IRC message for the plugin
+func toRPCMessage(m *irc.Message) *rpcplugin.Message {
+	msg := &rpcplugin.Message{
+		Command: m.Command,
+		Params:  m.Params,
+	}
+	if len(m.Tags) > 0 {
+		msg.Tags = make(map[string]string, len(m.Tags))
+		for k, v := range m.Tags {
+			msg.Tags[k] = v
+		}
+	}
+	if m.Prefix != nil {
+		msg.Prefix = &rpcplugin.Prefix{Name: m.Prefix.Name, User: m.Prefix.User, Host: m.Prefix.Host}
+	}
+	return msg
+}
+
+// decodeParams unmarshals the params of a call from the plugin
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcplugin.RPCError{Code: rpcplugin.CodeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// checkLine rejects text that would smuggle extra IRC lines
+func checkLine(text string) error {
+	if strings.ContainsAny(text, "\r\n") {
+		return &rpcplugin.RPCError{Code: rpcplugin.CodeInvalidParams, Message: "text must be a single line"}
+	}
+	return nil
+}
+
+// checkDataFile rejects file names that leave the plugin's data directory
+func checkDataFile(name string) error {
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		return &rpcplugin.RPCError{Code: rpcplugin.CodeInvalidParams, Message: "invalid file name " + name}
+	}
+	return nil
+}
+
+// handle answers calls from the plugin process
+func (p *rpcPlugin) handle(method string, params json.RawMessage) (interface{}, error) {
+	p.mu.Lock()
+	name, initialized := p.name, p.initialized
+	p.mu.Unlock()
+
+	switch method {
+	case rpcplugin.MethodLog:
+		var lp rpcplugin.LogParams
+		if err := decodeParams(params, &lp); err != nil {
+			return nil, err
+		}
+		switch lp.Level {
+		case "error":
+			logger.Errorf("[%s] %s", name, lp.Message)
+		case "warn":
+			logger.Warnf("[%s] %s", name, lp.Message)
+		case "success":
+			logger.Successf("[%s] %s", name, lp.Message)
+		case "debug":
+			logger.Debugf("[%s] %s", name, lp.Message)
+		default:
+			logger.Infof("[%s] %s", name, lp.Message)
+		}
+		return nil, nil
+
+	case rpcplugin.MethodSendMessage, rpcplugin.MethodSendNotice, rpcplugin.MethodSendRaw:
+		var tp rpcplugin.TargetParams
+		if err := decodeParams(params, &tp); err != nil {
+			return nil, err
+		}
+		if err := checkLine(tp.Target + tp.Text); err != nil {
+			return nil, err
+		}
+		c, err := p.getClient()
+		if err != nil {
+			return nil, err
+		}
+		switch method {
+		case rpcplugin.MethodSendMessage:
+			return nil, api.SendMessage(c, tp.Target, tp.Text)
+		case rpcplugin.MethodSendNotice:
+			return nil, api.SendNotice(c, tp.Target, tp.Text)
+		default:
+			return nil, c.Write(tp.Text)
+		}
+
+	case rpcplugin.MethodJoinChannel, rpcplugin.MethodPartChannel, rpcplugin.MethodKickUser,
+		rpcplugin.MethodSetMode, rpcplugin.MethodSetTopic:
+		var cp rpcplugin.ChannelParams
+		if err := decodeParams(params, &cp); err != nil {
+			return nil, err
+		}
+		if err := checkLine(cp.Channel + cp.Nick + cp.Reason + cp.Mode + cp.Topic); err != nil {
+			return nil, err
+		}
+		c, err := p.getClient()
+		if err != nil {
+			return nil, err
+		}
+		switch method {
+		case rpcplugin.MethodJoinChannel:
+			return nil, api.JoinChannel(c, cp.Channel)
+		case rpcplugin.MethodPartChannel:
+			return nil, api.PartChannel(c, cp.Channel, cp.Reason)
+		case rpcplugin.MethodKickUser:
+			return nil, api.KickUser(c, cp.Channel, cp.Nick, cp.Reason)
+		case rpcplugin.MethodSetMode:
+			return nil, api.SetMode(c, cp.Channel, cp.Mode)
+		default:
+			return nil, api.SetTopic(c, cp.Channel, cp.Topic)
+		}
+
+	case rpcplugin.MethodGetUserLevel:
+		var pp rpcplugin.PermissionParams
+		if err := decodeParams(params, &pp); err != nil {
+			return nil, err
+		}
+		return int(api.GetUserLevelByHostmask(pp.Hostmask)), nil
+
+	case rpcplugin.MethodCheckUserPermission:
+		var pp rpcplugin.PermissionParams
+		if err := decodeParams(params, &pp); err != nil {
+			return nil, err
+		}
+		return api.CheckUserPermission(pp.Hostmask, api.UserLevel(pp.Level)), nil
+
+	case rpcplugin.MethodGetBotChannels:
+		return api.GetBotChannels(), nil
+
+	case rpcplugin.MethodGetChannelUsers:
+		var cp rpcplugin.ChannelParams
+		if err := decodeParams(params, &cp); err != nil {
+			return nil, err
+		}
+		users := []rpcplugin.ChannelUser{}
+		for _, u := range api.GetChannelUsers(cp.Channel) {
+			users = append(users, rpcplugin.ChannelUser{Nick: u.Nick, Modes: u.Modes, Prefix: u.Prefix})
+		}
+		return users, nil
+
+	case rpcplugin.MethodGetChannelTopic, rpcplugin.MethodIsUserInChannel,
+		rpcplugin.MethodIsChannelOp, rpcplugin.MethodBotHasOp:
+		var cp rpcplugin.ChannelParams
+		if err := decodeParams(params, &cp); err != nil {
+			return nil, err
+		}
+		switch method {
+		case rpcplugin.MethodGetChannelTopic:
+			return api.GetChannelTopic(cp.Channel), nil
+		case rpcplugin.MethodIsUserInChannel:
+			return api.IsUserInChannel(cp.Channel, cp.Nick), nil
+		case rpcplugin.MethodIsChannelOp:
+			return api.IsChannelOp(cp.Channel, cp.Nick), nil
+		default:
+			return api.BotHasOp(cp.Channel), nil
+		}
+
+	case rpcplugin.MethodHasCapability:
+		var tp rpcplugin.TargetParams
+		if err := decodeParams(params, &tp); err != nil {
+			return nil, err
+		}
+		return api.HasCapability(tp.Target), nil
+
+	case rpcplugin.MethodGetCapabilities:
+		return api.GetCapabilities(), nil
+
+	case rpcplugin.MethodIsAIAvailable:
+		return api.IsAIAvailable(), nil
+
+	case rpcplugin.MethodProcessWithAI, rpcplugin.MethodSummarizeWithAI:
+		var ap rpcplugin.AIParams
+		if err := decodeParams(params, &ap); err != nil {
+			return nil, err
+		}
+		if method == rpcplugin.MethodProcessWithAI {
+			return api.ProcessWithAI(ap.Text, ap.User)
+		}
+		return api.SummarizeWithAI(ap.Text, ap.MaxLength)
+
+	case rpcplugin.MethodSavePluginData, rpcplugin.MethodLoadPluginData:
+		var dp rpcplugin.DataParams
+		if err := decodeParams(params, &dp); err != nil {
+			return nil, err
+		}
+		if err := checkDataFile(dp.File); err != nil {
+			return nil, err
+		}
+		// The data directory is named after the plugin, which is only
+		// known once "initialize" has been answered
+		if !initialized {
+			return nil, errors.New("plugin data is not available before initialize completes")
+		}
+		if method == rpcplugin.MethodSavePluginData {
+			return nil, api.SavePluginData(name, dp.File, dp.Data)
+		}
+		return api.LoadPluginData(name, dp.File)
+
+	case rpcplugin.MethodPublish:
+		var pp rpcplugin.PublishParams
+		if err := decodeParams(params, &pp); err != nil {
+			return nil, err
+		}
+		if !initialized {
+			return nil, errors.New("events can't be published before initialize completes")
+		}
+		return nil, events.PublishFrom(name, pp.Topic, pp.Data)
+	}
+
+	return nil, &rpcplugin.RPCError{Code: rpcplugin.CodeMethodNotFound, Message: "unknown method " + method}
+}
@@ -0,0 +1,319 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ircbot/internal/logger"
+)
+
+// PinsPath is where the pinned plugin versions are kept
+var PinsPath = "./data/plugin_pins.json"
+
+// KeepVersions is how many versioned builds of each plugin are kept in the
+// plugin directory; older ones are deleted. Zero or less keeps them all.
+var KeepVersions = 5
+
+var pinsMu sync.Mutex
+
+// VersionFile is a versioned build of a plugin, such as EchoPlugin_v1.2.so
+type VersionFile struct {
+	Version string
+	Path    string
+	Built   time.Time
+	// Current is set for the build that is loaded
+	Current bool
+	Pinned  bool
+	// Latest is set for the build the unversioned name.so points to
+	Latest bool
+}
+
+// fileBase returns the plugin name part of a plugin file name:
+// "plugins/EchoPlugin_v1.2.so" becomes "EchoPlugin"
+func fileBase(path string) string {
+	return versionSuffix.ReplaceAllString(strings.TrimSuffix(filepath.Base(path), ".so"), "")
+}
+
+// fileVersion returns the version in a plugin file name, or "" if it has none
+func fileVersion(path string) string {
+	base := strings.TrimSuffix(filepath.Base(path), ".so")
+	if match := versionSuffix.FindString(base); match != "" {
+		return strings.TrimPrefix(match, "_v")
+	}
+	return ""
+}
+
+// resolveBase returns the file name base of a plugin given its name or
+// base: the base of a loaded plugin's file, or the name itself
+func resolveBase(name string) string {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	if info, ok := mgr.plugins[name]; ok && filepath.Ext(info.filePath) == ".so" {
+		return fileBase(info.filePath)
+	}
+	return strings.TrimSuffix(name, ".so")
+}
+
+// loadedByBase returns the loaded plugin whose file has the given base
+func loadedByBase(base string) (string, pluginInfo, bool) {
+	mgr.mu.Lock()
+	defer mgr.mu.Unlock()
+	for name, info := range mgr.plugins {
+		if filepath.Ext(info.filePath) == ".so" && fileBase(info.filePath) == base {
+			return name, info, true
+		}
+	}
+	return "", pluginInfo{}, false
+}
+
+// ListVersions returns the versioned builds of a plugin in dir, newest
+// first, and the file name base they share
+func ListVersions(dir, name string) (string, []VersionFile, error) {
+	base := resolveBase(name)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return base, nil, fmt.Errorf("failed to read plugin directory %s: %w", dir, err)
+	}
+
+	latest := ""
+	if target, err := os.Readlink(filepath.Join(dir, base+".so")); err == nil {
+		latest = fileVersion(target)
+	}
+	pinned, _ := GetPinnedVersion(base)
+	_, info, loaded := loadedByBase(base)
+
+	var versions []VersionFile
+	for _, file := range files {
+		version := fileVersion(file.Name())
+		if version == "" || filepath.Ext(file.Name()) != ".so" || fileBase(file.Name()) != base {
+			continue
+		}
+		v := VersionFile{
+			Version: version,
+			Path:    filepath.Join(dir, file.Name()),
+			Pinned:  version == pinned,
+			Latest:  version == latest,
+			Current: loaded && info.version == version,
+		}
+		if fi, err := file.Info(); err == nil {
+			v.Built = fi.ModTime()
+		}
+		versions = append(versions, v)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		if c := compareVersions(versions[i].Version, versions[j].Version); c != 0 {
+			return c > 0
+		}
+		return versions[i].Built.After(versions[j].Built)
+	})
+	return base, versions, nil
+}
+
+// loadPins reads the pinned versions. Must be called with pinsMu held.
+func loadPins() (map[string]string, error) {
+	pins := make(map[string]string)
+	data, err := os.ReadFile(PinsPath)
+	if os.IsNotExist(err) {
+		return pins, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return nil, fmt.Errorf("invalid pin file %s: %w", PinsPath, err)
+	}
+	return pins, nil
+}
+
+// savePins writes the pinned versions. Must be called with pinsMu held.
+func savePins(pins map[string]string) error {
+	data, err := json.MarshalIndent(pins, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(PinsPath), 0755); err != nil {
+		return err
+	}
+	tmp := PinsPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, PinsPath)
+}
+
+// GetPinnedVersion returns the version a plugin is pinned to, by file name base
+func GetPinnedVersion(base string) (string, bool) {
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	pins, err := loadPins()
+	if err != nil {
+		logger.Warnf("Error reading pinned plugin versions: %v", err)
+		return "", false
+	}
+	version, ok := pins[base]
+	return version, ok
+}
+
+// PinVersion makes the plugin directory load the given version of a plugin
+// instead of the latest one, also after a restart
+func PinVersion(base, version string) error {
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	pins, err := loadPins()
+	if err != nil {
+		return err
+	}
+	pins[base] = version
+	return savePins(pins)
+}
+
+// UnpinVersion lets the plugin directory load the latest version of a
+// plugin again. Returns false if it was not pinned.
+func UnpinVersion(base string) (bool, error) {
+	pinsMu.Lock()
+	defer pinsMu.Unlock()
+	pins, err := loadPins()
+	if err != nil {
+		return false, err
+	}
+	if _, ok := pins[base]; !ok {
+		return false, nil
+	}
+	delete(pins, base)
+	return true, savePins(pins)
+}
+
+// selectGoPlugins picks the file to load for every Go plugin in dir: the
+// pinned version if there is one, else the unversioned name.so (which
+// points to the latest build), else the newest versioned build
+func selectGoPlugins(dir string, names []string) []string {
+	groups := make(map[string][]string)
+	var bases []string
+	for _, name := range names {
+		base := fileBase(name)
+		if _, ok := groups[base]; !ok {
+			bases = append(bases, base)
+		}
+		groups[base] = append(groups[base], name)
+	}
+
+	var selected []string
+	for _, base := range bases {
+		var plain, newest string
+		pinned, isPinned := GetPinnedVersion(base)
+		pinnedFile := ""
+		for _, name := range groups[base] {
+			version := fileVersion(name)
+			switch {
+			case version == "":
+				plain = name
+			case isPinned && version == pinned:
+				pinnedFile = name
+			}
+			if version != "" && (newest == "" || compareVersions(version, fileVersion(newest)) > 0) {
+				newest = name
+			}
+		}
+
+		if pinnedFile != "" {
+			selected = append(selected, filepath.Join(dir, pinnedFile))
+			continue
+		}
+		if isPinned {
+			logger.Warnf("Plugin %s is pinned to version %s, which is not in %s; loading the latest version", base, pinned, dir)
+		}
+		if plain != "" {
+			selected = append(selected, filepath.Join(dir, plain))
+		} else {
+			selected = append(selected, filepath.Join(dir, newest))
+		}
+	}
+	return selected
+}
+
+// RollbackPlugin loads an earlier build of a plugin and pins it. Without a
+// version the newest build older than the loaded one is used. Returns the
+// versions rolled back from and to.
+func RollbackPlugin(dir, name, version string) (string, string, error) {
+	base, versions, err := ListVersions(dir, name)
+	if err != nil {
+		return "", "", err
+	}
+	current := ""
+	if _, info, ok := loadedByBase(base); ok {
+		current = info.version
+	}
+
+	var target *VersionFile
+	for i := range versions {
+		v := &versions[i]
+		if version != "" {
+			if v.Version == version {
+				target = v
+				break
+			}
+			continue
+		}
+		// Versions are sorted newest first
+		if current == "" || compareVersions(v.Version, current) < 0 {
+			target = v
+			break
+		}
+	}
+	switch {
+	case target == nil && version != "":
+		return current, "", fmt.Errorf("no build of version %s of plugin %s", version, base)
+	case target == nil:
+		return current, "", fmt.Errorf("no build of plugin %s older than version %s", base, current)
+	case target.Version == current:
+		return current, "", fmt.Errorf("plugin %s version %s is already loaded", base, current)
+	}
+
+	if err := LoadPlugin(target.Path); err != nil {
+		return current, "", err
+	}
+	if err := PinVersion(base, target.Version); err != nil {
+		return current, target.Version, fmt.Errorf("loaded version %s but could not pin it: %w", target.Version, err)
+	}
+	logger.Infof("Plugin %s rolled back from version %s to %s and pinned", base, current, target.Version)
+	return current, target.Version, nil
+}
+
+// PruneVersions deletes the oldest builds of a plugin beyond KeepVersions.
+// The loaded, pinned and latest builds are always kept. Returns the
+// deleted files.
+func PruneVersions(dir, name string) ([]string, error) {
+	if KeepVersions <= 0 {
+		return nil, nil
+	}
+	_, versions, err := ListVersions(dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	kept := 0
+	for _, v := range versions {
+		if kept < KeepVersions || v.Current || v.Pinned || v.Latest {
+			kept++
+			continue
+		}
+		if err := os.Remove(v.Path); err != nil {
+			return removed, err
+		}
+		// A manifest for just this build goes with it
+		os.Remove(strings.TrimSuffix(v.Path, ".so") + ".toml")
+		removed = append(removed, v.Path)
+	}
+	if len(removed) > 0 {
+		logger.Infof("Pruned %d old builds of plugin %s", len(removed), fileBase(versions[0].Path))
+	}
+	return removed, nil
+}
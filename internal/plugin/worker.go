@@ -0,0 +1,275 @@
+package plugin
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"ircbot/internal/events"
+	"ircbot/internal/logger"
+	"ircbot/internal/scheduler"
+)
+
+const (
+	// EventDeadline is how long a plugin may take to handle an event
+	EventDeadline = 5 * time.Second
+	// CommandDeadline is how long a plugin may take to handle a command
+	CommandDeadline = 30 * time.Second
+
+	// workerQueueSize is the number of calls that can wait for a plugin
+	// before new ones are dropped
+	workerQueueSize = 100
+	// maxConsecutiveFailures is the number of panics or timeouts in a row
+	// after which a plugin is disabled
+	maxConsecutiveFailures = 5
+)
+
+// Health holds the failure counters of a loaded plugin
+type Health struct {
+	Name                string
+	Calls               int
+	Panics              int
+	Timeouts            int
+	Dropped             int
+	ConsecutiveFailures int
+	LastError           string
+	LastErrorAt         time.Time
+	Disabled            bool
+}
+
+// task is one call into a plugin
+type task struct {
+	what     string
+	deadline time.Duration
+	f        func()
+}
+
+// worker runs the calls into one plugin, one at a time, off the IRC read
+// loop. A call that panics or runs past its deadline counts as a failure.
+type worker struct {
+	name  string
+	tasks chan task
+	quit  chan struct{}
+	once  sync.Once
+
+	mu     sync.Mutex
+	health Health
+}
+
+func newWorker(name string) *worker {
+	w := &worker{
+		name:   name,
+		tasks:  make(chan task, workerQueueSize),
+		quit:   make(chan struct{}),
+		health: Health{Name: name},
+	}
+	go w.run()
+	return w
+}
+
+// stop ends the worker, discarding calls still waiting
+func (w *worker) stop() {
+	w.once.Do(func() { close(w.quit) })
+}
+
+// disabled reports whether the plugin was disabled for failing
+func (w *worker) disabled() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.health.Disabled
+}
+
+// submit queues a call without blocking. Returns false if the call was
+// dropped because the plugin is disabled, unloaded or too far behind.
+func (w *worker) submit(t task) bool {
+	if w.disabled() {
+		return false
+	}
+
+	select {
+	case <-w.quit:
+		return false
+	case w.tasks <- t:
+		return true
+	default:
+	}
+
+	w.mu.Lock()
+	w.health.Dropped++
+	dropped := w.health.Dropped
+	w.mu.Unlock()
+	// Log the first drop of a stall and then every full queue's worth
+	if dropped%workerQueueSize == 1 {
+		logger.Warnf("Plugin %s is not keeping up, dropped %d calls so far", w.name, dropped)
+	}
+	return false
+}
+
+func (w *worker) run() {
+	for {
+		select {
+		case <-w.quit:
+			return
+		case t := <-w.tasks:
+			// Calls queued before the plugin was disabled are discarded
+			if !w.disabled() {
+				w.execute(t)
+			}
+		}
+	}
+}
+
+// execute runs a call and waits for it. A call past its deadline is counted
+// as a failure for every deadline it overruns; the next call only starts
+// once it returns, so a plugin never runs two calls at the same time.
+func (w *worker) execute(t task) {
+	done := make(chan error, 1)
+	go func() {
+		done <- safeCall(t.f)
+	}()
+
+	timer := time.NewTimer(t.deadline)
+	defer timer.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				w.recordFailure(t.what, err, false)
+			} else {
+				w.recordSuccess()
+			}
+			return
+		case <-timer.C:
+			w.recordFailure(t.what, fmt.Errorf("still running after %s", t.deadline), true)
+			if w.disabled() {
+				// Give up on the call; the plugin gets no more work
+				return
+			}
+			timer.Reset(t.deadline)
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+func (w *worker) recordSuccess() {
+	w.mu.Lock()
+	w.health.Calls++
+	w.health.ConsecutiveFailures = 0
+	w.mu.Unlock()
+}
+
+func (w *worker) recordFailure(what string, err error, timeout bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if timeout {
+		w.health.Timeouts++
+	} else {
+		w.health.Calls++
+		w.health.Panics++
+	}
+	w.health.ConsecutiveFailures++
+	w.health.LastError = fmt.Sprintf("%s: %v", what, err)
+	w.health.LastErrorAt = time.Now()
+	logger.Errorf("Plugin %s failed in %s: %v", w.name, what, err)
+
+	if !w.health.Disabled && w.health.ConsecutiveFailures >= maxConsecutiveFailures {
+		w.health.Disabled = true
+		logger.Errorf("Plugin %s disabled after %d consecutive failures", w.name, w.health.ConsecutiveFailures)
+	}
+}
+
+// snapshot returns a copy of the counters
+func (w *worker) snapshot() Health {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.health
+}
+
+// safeCall runs f, turning a panic into an error
+func safeCall(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Debugf("Plugin panic stack:\n%s", debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	f()
+	return nil
+}
+
+// Dispatch queues a call into a loaded plugin on its worker. The call is
+// dropped if the plugin has been unloaded or disabled, or if its queue is
+// full.
+func Dispatch(plug Plugin, what string, f func()) {
+	mgr.mu.Lock()
+	info, ok := mgr.plugins[plug.Name()]
+	mgr.mu.Unlock()
+	if !ok || info.worker == nil {
+		return
+	}
+	info.worker.submit(task{what: what, deadline: EventDeadline, f: f})
+}
+
+func init() {
+	events.Deliver = deliverEvent
+	scheduler.Deliver = deliverTask
+}
+
+// deliverTask runs a scheduled task on its plugin's worker. Tasks may take
+// as long as a command. Tasks of owners that are not loaded plugins run
+// right away.
+func deliverTask(owner, what string, f func()) bool {
+	mgr.mu.Lock()
+	info, ok := mgr.plugins[owner]
+	mgr.mu.Unlock()
+	if ok && info.worker != nil {
+		return info.worker.submit(task{what: what, deadline: CommandDeadline, f: f})
+	}
+
+	if err := safeCall(f); err != nil {
+		logger.Debugf("Scheduled %s of %s failed: %v", what, owner, err)
+	}
+	return true
+}
+
+// deliverEvent runs an event bus handler on its plugin's worker. Handlers
+// of owners that are not loaded plugins, such as a plugin subscribing from
+// its OnLoad, run in a goroutine of their own.
+func deliverEvent(owner, topic string, f func()) {
+	mgr.mu.Lock()
+	info, ok := mgr.plugins[owner]
+	mgr.mu.Unlock()
+	if ok && info.worker != nil {
+		info.worker.submit(task{what: "event " + topic, deadline: EventDeadline, f: f})
+		return
+	}
+
+	go func() {
+		if err := safeCall(f); err != nil {
+			logger.Errorf("Event handler of %s for %s failed: %v", owner, topic, err)
+		}
+	}()
+}
+
+// GetPluginHealth returns the failure counters of the loaded plugins,
+// sorted by name
+func GetPluginHealth() []Health {
+	mgr.mu.Lock()
+	health := make([]Health, 0, len(mgr.plugins))
+	for _, info := range mgr.plugins {
+		if info.worker != nil {
+			health = append(health, info.worker.snapshot())
+		}
+	}
+	mgr.mu.Unlock()
+
+	sort.Slice(health, func(i, j int) bool {
+		return health[i].Name < health[j].Name
+	})
+	return health
+}
@@ -0,0 +1,160 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"ircbot/internal/logger"
+)
+
+// newTestWorker starts a worker whose failures are logged to a temporary
+// directory
+func newTestWorker(t *testing.T) *worker {
+	t.Helper()
+	logger.SetLogDir(t.TempDir())
+	w := newWorker("test")
+	t.Cleanup(w.stop)
+	return w
+}
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// runAndWait submits a call and waits until the worker has finished it
+func runAndWait(t *testing.T, w *worker, tk task) {
+	t.Helper()
+	before := w.snapshot()
+	if !w.submit(tk) {
+		t.Fatalf("submit(%s) was dropped", tk.what)
+	}
+	waitFor(t, tk.what, func() bool {
+		h := w.snapshot()
+		return h.Calls+h.Timeouts > before.Calls+before.Timeouts
+	})
+}
+
+func TestWorkerRecoversFromPanic(t *testing.T) {
+	w := newTestWorker(t)
+
+	runAndWait(t, w, task{what: "PRIVMSG", deadline: time.Second, f: func() { panic("boom") }})
+
+	h := w.snapshot()
+	if h.Panics != 1 || h.ConsecutiveFailures != 1 {
+		t.Fatalf("after a panic: panics = %d, consecutive failures = %d, want 1, 1", h.Panics, h.ConsecutiveFailures)
+	}
+	if !strings.Contains(h.LastError, "PRIVMSG: panic: boom") {
+		t.Errorf("LastError = %q, want the panic", h.LastError)
+	}
+
+	// The worker keeps running, and a success resets the failure streak
+	ran := false
+	runAndWait(t, w, task{what: "JOIN", deadline: time.Second, f: func() { ran = true }})
+	h = w.snapshot()
+	if !ran || h.Calls != 2 || h.ConsecutiveFailures != 0 || h.Disabled {
+		t.Errorf("after a success: ran = %v, calls = %d, consecutive failures = %d, disabled = %v",
+			ran, h.Calls, h.ConsecutiveFailures, h.Disabled)
+	}
+}
+
+func TestWorkerDeadline(t *testing.T) {
+	w := newTestWorker(t)
+
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	if !w.submit(task{what: "slow", deadline: 10 * time.Millisecond, f: func() {
+		<-release
+		close(finished)
+	}}) {
+		t.Fatal("submit was dropped")
+	}
+	waitFor(t, "a timeout", func() bool { return w.snapshot().Timeouts >= 2 })
+
+	h := w.snapshot()
+	if !strings.Contains(h.LastError, "slow: still running after 10ms") {
+		t.Errorf("LastError = %q, want the deadline", h.LastError)
+	}
+
+	// The next call waits for the slow one to return
+	started := make(chan struct{})
+	if !w.submit(task{what: "next", deadline: time.Second, f: func() { close(started) }}) {
+		t.Fatal("submit was dropped")
+	}
+	select {
+	case <-started:
+		t.Fatal("a call started while the previous one was still running")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	<-finished
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("the next call did not run after the slow one returned")
+	}
+}
+
+func TestWorkerDisablesAfterConsecutiveFailures(t *testing.T) {
+	w := newTestWorker(t)
+
+	for i := 0; i < maxConsecutiveFailures; i++ {
+		if w.disabled() {
+			t.Fatalf("disabled after %d failures, want %d", i, maxConsecutiveFailures)
+		}
+		runAndWait(t, w, task{what: "PRIVMSG", deadline: time.Second, f: func() { panic("bad") }})
+	}
+
+	h := w.snapshot()
+	if !h.Disabled || h.ConsecutiveFailures != maxConsecutiveFailures {
+		t.Fatalf("disabled = %v, consecutive failures = %d, want true, %d", h.Disabled, h.ConsecutiveFailures, maxConsecutiveFailures)
+	}
+	if w.submit(task{what: "PRIVMSG", deadline: time.Second, f: func() { t.Error("a disabled plugin was called") }}) {
+		t.Error("a call to a disabled plugin was accepted")
+	}
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestWorkerDropsWhenQueueIsFull(t *testing.T) {
+	w := newTestWorker(t)
+
+	release := make(chan struct{})
+	defer close(release)
+	running := make(chan struct{})
+	if !w.submit(task{what: "blocking", deadline: time.Minute, f: func() {
+		close(running)
+		<-release
+	}}) {
+		t.Fatal("submit was dropped")
+	}
+	<-running
+
+	for i := 0; i < workerQueueSize; i++ {
+		if !w.submit(task{what: "queued", deadline: time.Second, f: func() {}}) {
+			t.Fatalf("call %d was dropped before the queue was full", i)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if w.submit(task{what: "overflow", deadline: time.Second, f: func() {}}) {
+			t.Fatal("a call was accepted with the queue full")
+		}
+	}
+	if dropped := w.snapshot().Dropped; dropped != 3 {
+		t.Errorf("dropped = %d, want 3", dropped)
+	}
+
+	// A stopped worker accepts nothing
+	w.stop()
+	if w.submit(task{what: "late", deadline: time.Second, f: func() {}}) {
+		t.Error("a stopped worker accepted a call")
+	}
+}
@@ -0,0 +1,153 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed five-field cron expression:
+// minute hour day-of-month month day-of-week
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record a "*" day field. When both day fields are
+	// restricted, a day matches if either does, as in cron.
+	domAny, dowAny bool
+}
+
+// cronMacros are the supported shorthands
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCron parses a cron expression such as "*/15 9-17 * * 1-5" or "@daily"
+func parseCron(spec string) (*cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", spec)
+	}
+
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	// Both 0 and 7 mean Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+	return &s, nil
+}
+
+// parseCronField parses a comma-separated list of "*", "n", "a-b", each
+// optionally with a "/step", into a bit set
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("invalid value %q", from)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return 0, fmt.Errorf("invalid value %q", to)
+				}
+			} else if hasStep {
+				// "n/step" runs from n to the end of the range
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+// matchesDay reports whether the day of t is selected
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// next returns the first time after t that matches the schedule, or the
+// zero time if there is none within five years
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
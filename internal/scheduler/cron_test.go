@@ -0,0 +1,154 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	bits := func(values ...int) uint64 {
+		var b uint64
+		for _, v := range values {
+			b |= 1 << uint(v)
+		}
+		return b
+	}
+
+	tests := []struct {
+		field    string
+		min, max int
+		want     uint64
+		wantErr  bool
+	}{
+		{"*", 0, 5, bits(0, 1, 2, 3, 4, 5), false},
+		{"3", 0, 59, bits(3), false},
+		{"1,3,5", 0, 59, bits(1, 3, 5), false},
+		{"2-4", 0, 59, bits(2, 3, 4), false},
+		{"*/15", 0, 59, bits(0, 15, 30, 45), false},
+		{"10-20/5", 0, 59, bits(10, 15, 20), false},
+		{"50/4", 0, 59, bits(50, 54, 58), false},
+		{"1-2,*/10", 0, 30, bits(0, 1, 2, 10, 20, 30), false},
+		{"0", 1, 31, 0, true},
+		{"32", 1, 31, 0, true},
+		{"5-3", 0, 59, 0, true},
+		{"*/0", 0, 59, 0, true},
+		{"*/x", 0, 59, 0, true},
+		{"a", 0, 59, 0, true},
+		{"1-b", 0, 59, 0, true},
+		{"", 0, 59, 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseCronField(tt.field, tt.min, tt.max)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCronField(%q) error = %v, want error %v", tt.field, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("parseCronField(%q) = %b, want %b", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	valid := []string{
+		"* * * * *",
+		"*/15 9-17 * * 1-5",
+		"0 0 1 1 *",
+		"0 12 * * 7",
+		"@daily",
+		"@HOURLY",
+		"  @weekly  ",
+	}
+	for _, spec := range valid {
+		if _, err := parseCron(spec); err != nil {
+			t.Errorf("parseCron(%q) = %v, want no error", spec, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"@reboot",
+	}
+	for _, spec := range invalid {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", spec)
+		}
+	}
+
+	// 7 is Sunday as well as 0
+	s, err := parseCron("0 0 * * 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.dow&1 == 0 {
+		t.Error("day of week 7 does not select Sunday")
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(value string) time.Time {
+		t.Helper()
+		parsed, err := time.Parse("2006-01-02 15:04:05", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		spec  string
+		after string
+		want  string
+	}{
+		// The next whole minute, never the current one
+		{"* * * * *", "2024-03-10 12:00:00", "2024-03-10 12:01:00"},
+		{"* * * * *", "2024-03-10 12:00:59", "2024-03-10 12:01:00"},
+		{"*/15 * * * *", "2024-03-10 12:07:00", "2024-03-10 12:15:00"},
+		{"*/15 * * * *", "2024-03-10 12:45:00", "2024-03-10 13:00:00"},
+		{"30 9 * * *", "2024-03-10 09:30:00", "2024-03-11 09:30:00"},
+		{"@hourly", "2024-03-10 23:10:00", "2024-03-11 00:00:00"},
+		{"@daily", "2024-12-31 08:00:00", "2025-01-01 00:00:00"},
+		{"@monthly", "2024-01-31 00:00:00", "2024-02-01 00:00:00"},
+		{"@yearly", "2024-06-01 00:00:00", "2025-01-01 00:00:00"},
+		// 2024-03-10 is a Sunday
+		{"0 9 * * 1-5", "2024-03-08 10:00:00", "2024-03-11 09:00:00"},
+		{"0 9 * * 0", "2024-03-10 08:59:00", "2024-03-10 09:00:00"},
+		{"0 9 * * 7", "2024-03-10 09:00:00", "2024-03-17 09:00:00"},
+		// With both day fields restricted either may match
+		{"0 0 13 * 5", "2024-09-01 00:00:00", "2024-09-06 00:00:00"},
+		{"0 0 13 * 5", "2024-09-10 00:00:00", "2024-09-13 00:00:00"},
+		// Months without the day are skipped
+		{"0 0 31 * *", "2024-04-01 00:00:00", "2024-05-31 00:00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00:00", "2028-02-29 00:00:00"},
+		// Runs of hours within a day
+		{"0 9-17/4 * * *", "2024-03-10 13:00:00", "2024-03-10 17:00:00"},
+		{"0 9-17/4 * * *", "2024-03-10 17:00:00", "2024-03-11 09:00:00"},
+	}
+
+	for _, tt := range tests {
+		s, err := parseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.spec, err)
+		}
+		if got := s.next(at(tt.after)); !got.Equal(at(tt.want)) {
+			t.Errorf("%q after %s = %s, want %s", tt.spec, tt.after, got.Format("2006-01-02 15:04:05"), tt.want)
+		}
+	}
+
+	// A date that never comes gives the zero time
+	s, err := parseCron("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.next(at("2024-01-01 00:00:00")); !got.IsZero() {
+		t.Errorf("February 30th = %s, want the zero time", got)
+	}
+}
@@ -0,0 +1,280 @@
+// Package scheduler runs tasks at fixed intervals, on cron schedules or once
+// after a delay. Every task belongs to an owner, normally a plugin, so that
+// all of an owner's tasks can be cancelled together when it is unloaded.
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"ircbot/internal/logger"
+)
+
+// MinInterval is the shortest interval a repeating task may use
+const MinInterval = time.Second
+
+// Info describes a scheduled task
+type Info struct {
+	ID        int
+	Owner     string
+	Name      string
+	Schedule  string
+	Next      time.Time
+	LastRun   time.Time
+	LastError string
+	Runs      int
+}
+
+// task is a scheduled task and its run history
+type task struct {
+	id       int
+	owner    string
+	name     string
+	schedule string
+	action   func() error
+	// next returns when to run after the given time, or the zero time to stop
+	next func(after time.Time) time.Time
+	stop chan struct{}
+
+	mu        sync.Mutex
+	nextRun   time.Time
+	lastRun   time.Time
+	lastError string
+	runs      int
+}
+
+var (
+	tasks  = make(map[int]*task)
+	nextID int
+	mu     sync.Mutex
+)
+
+// Deliver runs an action of a task for its owner and reports whether it was
+// accepted. The plugin manager replaces it to run plugin tasks on the
+// plugin's worker, like its handlers; by default the action runs right away.
+var Deliver = func(owner, what string, f func()) bool {
+	func() {
+		// The panic has already been recorded by execute
+		defer func() { recover() }()
+		f()
+	}()
+	return true
+}
+
+// Every runs action every interval until the task is cancelled
+func Every(owner, name string, interval time.Duration, action func() error) (int, error) {
+	if interval < MinInterval {
+		return 0, fmt.Errorf("interval must be at least %s", MinInterval)
+	}
+	return add(owner, name, "every "+interval.String(), action, func(after time.Time) time.Time {
+		return after.Add(interval)
+	})
+}
+
+// Cron runs action whenever the cron expression matches, e.g.
+// "*/15 9-17 * * 1-5" or "@daily". Times are in the bot's local time zone.
+func Cron(owner, name, spec string, action func() error) (int, error) {
+	schedule, err := parseCron(spec)
+	if err != nil {
+		return 0, err
+	}
+	return add(owner, name, "cron "+spec, action, schedule.next)
+}
+
+// After runs action once after delay
+func After(owner, name string, delay time.Duration, action func() error) (int, error) {
+	if delay < 0 {
+		return 0, errors.New("delay must not be negative")
+	}
+	at := time.Now().Add(delay)
+	done := false
+	return add(owner, name, "once", action, func(time.Time) time.Time {
+		if done {
+			return time.Time{}
+		}
+		done = true
+		return at
+	})
+}
+
+// add registers a task and starts it
+func add(owner, name, schedule string, action func() error, next func(time.Time) time.Time) (int, error) {
+	if action == nil {
+		return 0, errors.New("task has no action")
+	}
+
+	mu.Lock()
+	nextID++
+	t := &task{
+		id:       nextID,
+		owner:    owner,
+		name:     name,
+		schedule: schedule,
+		action:   action,
+		next:     next,
+		stop:     make(chan struct{}),
+	}
+	tasks[t.id] = t
+	mu.Unlock()
+
+	go t.run()
+	logger.Debugf("Scheduled task %d %s/%s (%s)", t.id, owner, name, schedule)
+	return t.id, nil
+}
+
+// run waits for each run time and runs the action until the schedule ends
+// or the task is cancelled. Runs of a task never overlap.
+func (t *task) run() {
+	defer remove(t.id)
+
+	after := time.Now()
+	for {
+		at := t.next(after)
+		if at.IsZero() {
+			return
+		}
+
+		t.mu.Lock()
+		t.nextRun = at
+		t.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(at))
+		select {
+		case <-t.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		t.execute()
+		after = time.Now()
+		if after.Before(at) {
+			after = at
+		}
+	}
+}
+
+// execute runs the action once through Deliver and waits for it, recording
+// the result. A panic is recorded and passed on, so the owner's worker
+// counts it like a panic in a handler.
+func (t *task) execute() {
+	done := make(chan error, 1)
+	accepted := Deliver(t.owner, "task "+t.name, func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+				panic(r)
+			}
+		}()
+		done <- t.action()
+	})
+
+	var err error
+	if !accepted {
+		err = errors.New("not run, the owner is disabled or not keeping up")
+	} else {
+		select {
+		case err = <-done:
+		case <-t.stop:
+			return
+		}
+	}
+
+	t.mu.Lock()
+	t.lastRun = time.Now()
+	t.runs++
+	t.lastError = ""
+	if err != nil {
+		t.lastError = err.Error()
+	}
+	t.mu.Unlock()
+
+	if err != nil {
+		logger.Errorf("Scheduled task %s/%s failed: %v", t.owner, t.name, err)
+	}
+}
+
+// remove forgets a task
+func remove(id int) {
+	mu.Lock()
+	delete(tasks, id)
+	mu.Unlock()
+}
+
+// Cancel stops a task. Returns false if there is no such task.
+func Cancel(id int) bool {
+	mu.Lock()
+	t, ok := tasks[id]
+	delete(tasks, id)
+	mu.Unlock()
+
+	if ok {
+		close(t.stop)
+	}
+	return ok
+}
+
+// CancelOwner stops all tasks of an owner and returns how many there were
+func CancelOwner(owner string) int {
+	mu.Lock()
+	var cancelled []*task
+	for id, t := range tasks {
+		if t.owner == owner {
+			cancelled = append(cancelled, t)
+			delete(tasks, id)
+		}
+	}
+	mu.Unlock()
+
+	for _, t := range cancelled {
+		close(t.stop)
+	}
+	return len(cancelled)
+}
+
+// Get returns a task by ID
+func Get(id int) (Info, bool) {
+	mu.Lock()
+	t, ok := tasks[id]
+	mu.Unlock()
+	if !ok {
+		return Info{}, false
+	}
+	return t.info(), true
+}
+
+// List returns all scheduled tasks, the next to run first
+func List() []Info {
+	mu.Lock()
+	infos := make([]Info, 0, len(tasks))
+	for _, t := range tasks {
+		infos = append(infos, t.info())
+	}
+	mu.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Next.Equal(infos[j].Next) {
+			return infos[i].ID < infos[j].ID
+		}
+		return infos[i].Next.Before(infos[j].Next)
+	})
+	return infos
+}
+
+func (t *task) info() Info {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return Info{
+		ID:        t.id,
+		Owner:     t.owner,
+		Name:      t.name,
+		Schedule:  t.schedule,
+		Next:      t.nextRun,
+		LastRun:   t.lastRun,
+		LastError: t.lastError,
+		Runs:      t.runs,
+	}
+}
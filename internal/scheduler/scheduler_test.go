@@ -0,0 +1,179 @@
+package scheduler
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ircbot/internal/logger"
+)
+
+// useTempLogs sends the log of failing tasks to a temporary directory
+func useTempLogs(t *testing.T) {
+	t.Helper()
+	logger.SetLogDir(t.TempDir())
+}
+
+// waitFor polls cond until it holds or three seconds have passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAfterRunsOnce(t *testing.T) {
+	useTempLogs(t)
+
+	var runs atomic.Int32
+	id, err := After("test-once", "once", 0, func() error {
+		runs.Add(1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A one-shot task is forgotten once it has run
+	waitFor(t, "the task to finish", func() bool {
+		_, ok := Get(id)
+		return !ok
+	})
+	time.Sleep(10 * time.Millisecond)
+	if n := runs.Load(); n != 1 {
+		t.Errorf("ran %d times, want 1", n)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	useTempLogs(t)
+
+	ran := make(chan struct{}, 1)
+	id, err := After("test-cancel", "later", time.Hour, func() error {
+		ran <- struct{}{}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, ok := Get(id)
+	if !ok || info.Owner != "test-cancel" || info.Name != "later" || info.Schedule != "once" {
+		t.Fatalf("Get(%d) = %+v, %v", id, info, ok)
+	}
+	waitFor(t, "the next run time", func() bool {
+		info, _ := Get(id)
+		return !info.Next.IsZero()
+	})
+
+	if !Cancel(id) {
+		t.Fatal("Cancel returned false for a scheduled task")
+	}
+	if _, ok := Get(id); ok {
+		t.Error("a cancelled task is still listed")
+	}
+	if Cancel(id) {
+		t.Error("cancelling a task twice returned true")
+	}
+	select {
+	case <-ran:
+		t.Error("a cancelled task ran")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestCancelOwner(t *testing.T) {
+	useTempLogs(t)
+
+	noop := func() error { return nil }
+	var ids []int
+	for _, name := range []string{"a", "b", "c"} {
+		id, err := Every("test-owner", name, time.Hour, noop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	other, err := Cron("test-other", "keep", "@daily", noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Cancel(other)
+
+	if n := CancelOwner("test-owner"); n != 3 {
+		t.Errorf("CancelOwner cancelled %d tasks, want 3", n)
+	}
+	for _, id := range ids {
+		if _, ok := Get(id); ok {
+			t.Errorf("task %d of a cancelled owner is still listed", id)
+		}
+	}
+	if _, ok := Get(other); !ok {
+		t.Error("the task of another owner was cancelled")
+	}
+	if n := CancelOwner("test-owner"); n != 0 {
+		t.Errorf("CancelOwner cancelled %d tasks the second time, want 0", n)
+	}
+
+	for _, info := range List() {
+		if info.Owner == "test-owner" {
+			t.Errorf("List still has %+v", info)
+		}
+	}
+}
+
+func TestTaskErrorsAreRecorded(t *testing.T) {
+	useTempLogs(t)
+
+	calls := 0
+	id, err := Every("test-errors", "failing", MinInterval, func() error {
+		calls++
+		if calls == 1 {
+			return errors.New("first run fails")
+		}
+		panic("second run panics")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Cancel(id)
+
+	waitFor(t, "the first run", func() bool {
+		info, _ := Get(id)
+		return info.Runs == 1
+	})
+	info, _ := Get(id)
+	if info.LastError != "first run fails" || info.LastRun.IsZero() {
+		t.Errorf("after the first run: %+v", info)
+	}
+
+	waitFor(t, "the second run", func() bool {
+		info, _ := Get(id)
+		return info.Runs == 2
+	})
+	info, _ = Get(id)
+	if info.LastError != "panic: second run panics" {
+		t.Errorf("LastError = %q, want the panic", info.LastError)
+	}
+}
+
+func TestInvalidSchedules(t *testing.T) {
+	noop := func() error { return nil }
+	if _, err := Every("test-invalid", "fast", MinInterval/2, noop); err == nil {
+		t.Error("Every accepted an interval below MinInterval")
+	}
+	if _, err := After("test-invalid", "past", -time.Second, noop); err == nil {
+		t.Error("After accepted a negative delay")
+	}
+	if _, err := Cron("test-invalid", "bad", "* * *", noop); err == nil {
+		t.Error("Cron accepted an invalid expression")
+	}
+	if _, err := Every("test-invalid", "nil", time.Hour, nil); err == nil {
+		t.Error("Every accepted a nil action")
+	}
+}
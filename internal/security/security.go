@@ -6,6 +6,8 @@ import (
 	"encoding/base64"
 	"fmt"
 	"golang.org/x/crypto/argon2"
+	"ircbot/internal/events"
+	"strings"
 	"sync"
 	"time"
 )
@@ -196,14 +198,25 @@ func (m *MessageTracker) TrackCommand(hostmask, commandName string) (bool, int)
 	return commandCount > m.maxCommandsPerWindow, commandCount
 }
 
-// AddWarning increments the warning count for a user and returns true if they should be ignored
-func (m *MessageTracker) AddWarning(hostmask string) bool {
+// AddWarning increments the warning count for a user and returns true if they should be ignored.
+// Reaching the threshold publishes a user.auto_ignored event with the reason.
+func (m *MessageTracker) AddWarning(hostmask, reason string) bool {
 	m.mutex.Lock()
-	defer m.mutex.Unlock()
-	
 	m.warningCounts[hostmask]++
-	
-	return m.warningCounts[hostmask] >= m.warningThreshold
+	warnings := m.warningCounts[hostmask]
+	ignore := warnings >= m.warningThreshold
+	m.mutex.Unlock()
+
+	if ignore {
+		nick, _, _ := strings.Cut(hostmask, "!")
+		events.Publish(events.SourceCore, events.TopicUserAutoIgnored, events.UserAutoIgnored{
+			Nick:     nick,
+			Hostmask: hostmask,
+			Reason:   reason,
+			Warnings: warnings,
+		})
+	}
+	return ignore
 }
 
 // GetWarningCount returns the current warning count for a user
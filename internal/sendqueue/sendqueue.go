@@ -0,0 +1,375 @@
+// Package sendqueue is the single outbound path to the IRC server. Every
+// line written through the client is queued here and sent under a
+// token-bucket flood limit, with protocol-critical lines (PONG, CAP, NICK)
+// ahead of moderation (MODE, KICK), which goes ahead of chat. Chat lines are
+// interleaved round-robin between targets so one long reply can't starve
+// other channels. Sent messages are written to the chat log.
+package sendqueue
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/irc.v4"
+	"ircbot/internal"
+	"ircbot/internal/logger"
+)
+
+// Priority decides which lines are sent first
+type Priority int
+
+const (
+	// PriorityCritical lines skip the flood limit (PONG, CAP, NICK, QUIT...)
+	PriorityCritical Priority = iota
+	// PriorityMode is for channel moderation (MODE, KICK, TOPIC, INVITE)
+	PriorityMode
+	// PriorityNormal is for other commands (JOIN, PART, WHO, WHOIS...)
+	PriorityNormal
+	// PriorityChat is for PRIVMSG and NOTICE
+	PriorityChat
+
+	numPriorities
+)
+
+const (
+	// DefaultBurst is the number of lines that can be sent back to back
+	DefaultBurst = 5
+	// DefaultInterval is the time it takes to earn one more line once the burst is used
+	DefaultInterval = 1500 * time.Millisecond
+
+	// MaxChatLines is how many chat lines may wait for one target before
+	// further lines to it are dropped
+	MaxChatLines = 50
+	// MaxNormalLines is how many other commands may wait before further
+	// ones are dropped
+	MaxNormalLines = 200
+)
+
+var (
+	// ErrClosed is returned when writing to a queue whose connection is gone
+	ErrClosed = errors.New("send queue closed")
+	// ErrFull is returned when a line is dropped because too many are waiting
+	ErrFull = errors.New("send queue full")
+)
+
+// lane is a set of per-target FIFO queues served round-robin
+type lane struct {
+	targets []string
+	lines   map[string][]string
+	// dropped counts the lines dropped per target since its queue was last empty
+	dropped map[string]int
+}
+
+// Queue holds the outbound lines for one connection
+type Queue struct {
+	mu     sync.Mutex
+	lanes  [numPriorities]lane
+	closed bool
+	notify chan struct{}
+	done   chan struct{}
+
+	writer *irc.Writer
+	conn   io.Closer
+	nick   func() string
+
+	burst    float64
+	interval time.Duration
+	tokens   float64
+	refilled time.Time
+}
+
+var (
+	current   *Queue
+	currentMu sync.Mutex
+)
+
+// Attach routes every line written through the client into a new send queue
+// and starts sending. Any queue of a previous connection is closed. The
+// connection is closed if a write fails, so the client notices the failure.
+func Attach(client *irc.Client, conn io.Closer, burst int, interval time.Duration) *Queue {
+	if burst <= 0 {
+		burst = DefaultBurst
+	}
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	q := newQueue(client.Writer, conn, client.CurrentNick, burst, interval)
+	client.Writer.WriteCallback = func(_ *irc.Writer, line string) error {
+		return q.Enqueue(line)
+	}
+
+	currentMu.Lock()
+	previous := current
+	current = q
+	currentMu.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+
+	go q.run()
+	return q
+}
+
+// newQueue creates a queue writing to writer with a full burst of tokens
+func newQueue(writer *irc.Writer, conn io.Closer, nick func() string, burst int, interval time.Duration) *Queue {
+	q := &Queue{
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		writer:   writer,
+		conn:     conn,
+		nick:     nick,
+		burst:    float64(burst),
+		interval: interval,
+		tokens:   float64(burst),
+		refilled: time.Now(),
+	}
+	for i := range q.lanes {
+		q.lanes[i].lines = make(map[string][]string)
+		q.lanes[i].dropped = make(map[string]int)
+	}
+	return q
+}
+
+// Close stops the queue, dropping any lines not yet sent
+func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.done)
+}
+
+// Pending returns the number of lines waiting to be sent
+func (q *Queue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	count := 0
+	for i := range q.lanes {
+		for _, lines := range q.lanes[i].lines {
+			count += len(lines)
+		}
+	}
+	return count
+}
+
+// Enqueue adds a raw IRC line to the queue. Chat to a target with
+// MaxChatLines waiting, or a command with MaxNormalLines waiting, is dropped
+// with ErrFull so a runaway reply can't grow the queue without bound.
+func (q *Queue) Enqueue(line string) error {
+	priority, target := classify(line)
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return ErrClosed
+	}
+	l := &q.lanes[priority]
+	if limit := laneLimit(priority); limit > 0 && len(l.lines[target]) >= limit {
+		if l.dropped[target] == 0 {
+			logger.Warnf("Send queue to %s is full (%d lines), dropping further lines", targetName(target), limit)
+		}
+		l.dropped[target]++
+		q.mu.Unlock()
+		return ErrFull
+	}
+	if _, ok := l.lines[target]; !ok {
+		l.targets = append(l.targets, target)
+	}
+	l.lines[target] = append(l.lines[target], line)
+	q.mu.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// laneLimit returns how many lines may wait per target in a lane, 0 for no limit
+func laneLimit(priority Priority) int {
+	switch priority {
+	case PriorityChat:
+		return MaxChatLines
+	case PriorityNormal:
+		return MaxNormalLines
+	default:
+		return 0
+	}
+}
+
+// targetName describes a lane target for the log
+func targetName(target string) string {
+	if target == "" {
+		return "the server"
+	}
+	return target
+}
+
+// classify returns the priority of a line and the target used for fairness.
+// Only chat is interleaved per target, other lanes keep strict FIFO order.
+func classify(line string) (Priority, string) {
+	m, err := irc.ParseMessage(line)
+	if err != nil {
+		return PriorityNormal, ""
+	}
+
+	switch strings.ToUpper(m.Command) {
+	case internal.CMD_PONG, internal.CMD_PING, internal.CMD_CAP, internal.CMD_AUTHENTICATE,
+		internal.CMD_NICK, "USER", "PASS", internal.CMD_QUIT:
+		return PriorityCritical, ""
+	case internal.CMD_MODE, internal.CMD_KICK, internal.CMD_TOPIC, internal.CMD_INVITE:
+		return PriorityMode, ""
+	case internal.CMD_PRIVMSG, internal.CMD_NOTICE:
+		if len(m.Params) > 0 {
+			return PriorityChat, strings.ToLower(m.Params[0])
+		}
+		return PriorityChat, ""
+	default:
+		return PriorityNormal, ""
+	}
+}
+
+// next removes the next line to send, returning false if the queue is empty.
+// Callers must hold mu.
+func (q *Queue) next() (Priority, string, bool) {
+	for priority := range q.lanes {
+		l := &q.lanes[priority]
+		if len(l.targets) == 0 {
+			continue
+		}
+
+		target := l.targets[0]
+		lines := l.lines[target]
+		line := lines[0]
+
+		l.targets = l.targets[1:]
+		if len(lines) > 1 {
+			l.lines[target] = lines[1:]
+			// Rotate the target to the back so others get their turn
+			l.targets = append(l.targets, target)
+		} else {
+			delete(l.lines, target)
+			if dropped := l.dropped[target]; dropped > 0 {
+				delete(l.dropped, target)
+				logger.Warnf("Dropped %d lines to %s while its send queue was full", dropped, targetName(target))
+			}
+		}
+		return Priority(priority), line, true
+	}
+	return PriorityNormal, "", false
+}
+
+// peek returns the priority of the next line. Callers must hold mu.
+func (q *Queue) peek() (Priority, bool) {
+	for priority := range q.lanes {
+		if len(q.lanes[priority].targets) > 0 {
+			return Priority(priority), true
+		}
+	}
+	return PriorityNormal, false
+}
+
+// refill adds the tokens earned since the last refill. Callers must hold mu.
+func (q *Queue) refill() {
+	now := time.Now()
+	q.tokens += float64(now.Sub(q.refilled)) / float64(q.interval)
+	if q.tokens > q.burst {
+		q.tokens = q.burst
+	}
+	q.refilled = now
+}
+
+// run sends queued lines as the flood limit allows
+func (q *Queue) run() {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+		priority, ok := q.peek()
+		wait := time.Duration(0)
+		line := ""
+		if ok {
+			q.refill()
+			if priority == PriorityCritical || q.tokens >= 1 {
+				if priority != PriorityCritical {
+					q.tokens--
+				}
+				_, line, _ = q.next()
+			} else {
+				wait = time.Duration((1 - q.tokens) * float64(q.interval))
+			}
+		}
+		q.mu.Unlock()
+
+		if line != "" {
+			if err := q.send(line); err != nil {
+				logger.Errorf("Failed to send to server: %v", err)
+				q.Close()
+				if q.conn != nil {
+					q.conn.Close()
+				}
+				return
+			}
+			continue
+		}
+
+		var timer <-chan time.Time
+		if wait > 0 {
+			timer = time.After(wait)
+		}
+		select {
+		case <-q.done:
+			return
+		case <-q.notify:
+		case <-timer:
+		}
+	}
+}
+
+// send writes a line to the connection and logs sent messages
+func (q *Queue) send(line string) error {
+	if _, err := q.writer.RawWrite([]byte(line + "\r\n")); err != nil {
+		return err
+	}
+	q.logSent(line)
+	return nil
+}
+
+// logSent writes the bot's own PRIVMSG/NOTICE lines to the chat log
+func (q *Queue) logSent(line string) {
+	m, err := irc.ParseMessage(line)
+	if err != nil || len(m.Params) < 2 {
+		return
+	}
+	if m.Command != internal.CMD_PRIVMSG && m.Command != internal.CMD_NOTICE {
+		return
+	}
+
+	target := m.Params[0]
+	text := m.Trailing()
+
+	// Never log messages to services, they may carry passwords
+	if strings.HasSuffix(strings.ToLower(target), "serv") {
+		return
+	}
+
+	if strings.HasPrefix(target, "#") || strings.HasPrefix(target, "&") {
+		if action, ok := strings.CutPrefix(text, "\x01ACTION "); ok {
+			logger.LogChannelAction(target, q.nick(), strings.TrimSuffix(action, "\x01"))
+			return
+		}
+		logger.LogBotChannelMessage(target, q.nick(), text)
+		return
+	}
+
+	logger.LogPrivateMessage(target, "TO", text)
+}
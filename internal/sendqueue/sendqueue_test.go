@@ -0,0 +1,160 @@
+package sendqueue
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/irc.v4"
+	"ircbot/internal/logger"
+)
+
+// syncBuffer is a bytes.Buffer safe to read while the queue writes to it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// testQueue returns a queue that is not sending yet, writing to out
+func testQueue(t *testing.T, out *syncBuffer, burst int, interval time.Duration) *Queue {
+	t.Helper()
+	logger.SetLogDir(t.TempDir())
+	q := newQueue(irc.NewWriter(out), nil, func() string { return "bot" }, burst, interval)
+	t.Cleanup(q.Close)
+	return q
+}
+
+// enqueue adds lines to the queue, failing the test on an error
+func enqueue(t *testing.T, q *Queue, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if err := q.Enqueue(line); err != nil {
+			t.Fatalf("Enqueue(%q) = %v", line, err)
+		}
+	}
+}
+
+// drain removes every queued line in the order it would be sent
+func drain(q *Queue) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var lines []string
+	for {
+		_, line, ok := q.next()
+		if !ok {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestCriticalBypassesFloodLimit(t *testing.T) {
+	out := &syncBuffer{}
+	q := testQueue(t, out, 1, time.Hour)
+
+	// The single token goes to the first chat line, the second has to wait an hour
+	enqueue(t, q, "PRIVMSG #a :one", "PRIVMSG #a :two", "PONG :token", "NICK other")
+	go q.run()
+
+	want := "PONG :token\r\nNICK other\r\nPRIVMSG #a :one\r\n"
+	deadline := time.Now().Add(3 * time.Second)
+	for out.String() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("sent %q, want %q", out.String(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if got := out.String(); got != want {
+		t.Errorf("sent %q beyond the flood limit, want %q", got, want)
+	}
+	if n := q.Pending(); n != 1 {
+		t.Errorf("Pending() = %d, want 1", n)
+	}
+}
+
+func TestRoundRobinAcrossTargets(t *testing.T) {
+	q := testQueue(t, &syncBuffer{}, DefaultBurst, DefaultInterval)
+
+	enqueue(t, q,
+		"PRIVMSG #a :a1", "PRIVMSG #a :a2", "PRIVMSG #a :a3",
+		"PRIVMSG #B :b1", "NOTICE #b :b2",
+		"PRIVMSG nick :c1",
+		"JOIN #c",
+		"MODE #a +o nick",
+	)
+
+	want := []string{
+		"MODE #a +o nick",
+		"JOIN #c",
+		"PRIVMSG #a :a1", "PRIVMSG #B :b1", "PRIVMSG nick :c1",
+		"PRIVMSG #a :a2", "NOTICE #b :b2",
+		"PRIVMSG #a :a3",
+	}
+	if got := drain(q); !reflect.DeepEqual(got, want) {
+		t.Errorf("send order:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestEnqueueDropsOverLimit(t *testing.T) {
+	q := testQueue(t, &syncBuffer{}, DefaultBurst, DefaultInterval)
+
+	tests := []struct {
+		format  string
+		count   int
+		allowed int
+	}{
+		{"PRIVMSG #a :line %d", MaxChatLines + 5, MaxChatLines},
+		{"PRIVMSG #b :line %d", 3, 3},
+		{"WHO user%d", MaxNormalLines + 1, MaxNormalLines},
+		{"PONG :%d", MaxNormalLines + 1, MaxNormalLines + 1},
+	}
+
+	total := 0
+	for _, tt := range tests {
+		accepted := 0
+		for i := 0; i < tt.count; i++ {
+			switch err := q.Enqueue(fmt.Sprintf(tt.format, i)); err {
+			case nil:
+				accepted++
+			case ErrFull:
+			default:
+				t.Fatalf("Enqueue(%q) = %v", fmt.Sprintf(tt.format, i), err)
+			}
+		}
+		if accepted != tt.allowed {
+			t.Errorf("%q: accepted %d of %d lines, want %d", tt.format, accepted, tt.count, tt.allowed)
+		}
+		total += accepted
+	}
+
+	if n := q.Pending(); n != total {
+		t.Errorf("Pending() = %d, want %d", n, total)
+	}
+
+	// An emptied queue accepts lines again
+	drain(q)
+	enqueue(t, q, "PRIVMSG #a :again")
+	for i := range q.lanes {
+		if len(q.lanes[i].dropped) != 0 {
+			t.Errorf("lane %d still counts dropped lines: %v", i, q.lanes[i].dropped)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package userlevels
+
+import (
+	"strings"
+
+	"ircbot/internal/capabilities"
+)
+
+// AccountPrefix marks a !setlevel target as a services account instead of a hostmask
+const AccountPrefix = "account:"
+
+var (
+	// accountUsers maps lowercased services accounts to their level
+	accountUsers = make(map[string]UserLevel)
+	// nickAccounts maps nicks, folded with ircLower, to the account they are logged in to,
+	// as learned from account-tag, extended-join, account-notify and WHOX
+	nickAccounts = make(map[string]string)
+)
+
+// SetUserLevelByAccount assigns the given level to a services account
+func SetUserLevelByAccount(account string, level UserLevel) {
+	mu.Lock()
+	defer mu.Unlock()
+	accountUsers[strings.ToLower(account)] = level
+}
+
+// GetAccountLevel returns the level granted to a services account, if any
+func GetAccountLevel(account string) (UserLevel, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	level, ok := accountUsers[strings.ToLower(account)]
+	return level, ok
+}
+
+// GetAllAccounts returns all services accounts with a level
+func GetAllAccounts() map[string]UserLevel {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	result := make(map[string]UserLevel, len(accountUsers))
+	for account, level := range accountUsers {
+		result[account] = level
+	}
+	return result
+}
+
+// SetNickAccount records the services account a nick is logged in to.
+// An empty account (or "*") means the nick is not logged in.
+func SetNickAccount(nick, account string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if account == "" || account == "*" {
+		delete(nickAccounts, ircLower(nick))
+		return
+	}
+	nickAccounts[ircLower(nick)] = account
+}
+
+// GetNickAccount returns the services account a nick is logged in to,
+// or an empty string if it isn't known
+func GetNickAccount(nick string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return nickAccounts[ircLower(nick)]
+}
+
+// RenameNick keeps the account association when a user changes nick
+func RenameNick(oldNick, newNick string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if account, ok := nickAccounts[ircLower(oldNick)]; ok {
+		delete(nickAccounts, ircLower(oldNick))
+		nickAccounts[ircLower(newNick)] = account
+	}
+}
+
+// ForgetNick drops the account association of a nick that left the network
+func ForgetNick(nick string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(nickAccounts, ircLower(nick))
+}
+
+// ClearNickAccounts forgets every nick/account association, e.g. on reconnect
+func ClearNickAccounts() {
+	mu.Lock()
+	defer mu.Unlock()
+	nickAccounts = make(map[string]string)
+}
+
+// accountLevelForHostmask returns the level granted to the account the
+// hostmask's nick is logged in to. Callers must hold mu.
+func accountLevelForHostmask(hostmask string) (UserLevel, bool) {
+	nick := strings.Split(hostmask, "!")[0]
+	account, ok := nickAccounts[ircLower(nick)]
+	if !ok {
+		return Regular, false
+	}
+	level, ok := accountUsers[strings.ToLower(account)]
+	return level, ok
+}
+
+// accountTracking reports whether the server tells the bot which account
+// each user is logged in to
+func accountTracking() bool {
+	return capabilities.Enabled("account-tag") || capabilities.Enabled("account-notify")
+}
+
+// isNickMask reports whether a mask names nothing but a nick (nick!*@*)
+func isNickMask(mask string) bool {
+	nick, rest, ok := strings.Cut(mask, "!")
+	return ok && rest == "*@*" && !strings.ContainsAny(nick, "*?")
+}
+
+// nickGrantTrusted reports whether a grant naming only a nick may be used
+// by whoever has that nick now. Where the server reports accounts, the nick
+// must be logged in to the account services registered it to, which is
+// named after the nick. Callers must hold mu.
+func nickGrantTrusted(nick string) bool {
+	if !accountTracking() {
+		return true
+	}
+	account, ok := nickAccounts[ircLower(nick)]
+	return ok && ircLower(account) == ircLower(nick)
+}
+
+// NickGrantNeedsAccount reports whether a mask naming only a nick is limited
+// to a nick logged in to the account named after it, because the server
+// reports accounts. A grouped nick needs an account:<name> grant instead.
+func NickGrantNeedsAccount(mask string) bool {
+	return accountTracking() && isNickMask(mask)
+}
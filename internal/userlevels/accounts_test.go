@@ -0,0 +1,51 @@
+package userlevels
+
+import "testing"
+
+func TestNickAccountCasemapping(t *testing.T) {
+	mu.Lock()
+	savedNicks, savedAccounts := nickAccounts, accountUsers
+	nickAccounts = make(map[string]string)
+	accountUsers = map[string]UserLevel{"alice": Admin}
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		nickAccounts, accountUsers = savedNicks, savedAccounts
+		mu.Unlock()
+	}()
+
+	SetNickAccount("[Alice]", "alice")
+
+	tests := []struct {
+		nick string
+		want string
+	}{
+		{"[Alice]", "alice"},
+		{"{alice}", "alice"},
+		{"{ALICE]", "alice"},
+		{"(alice)", ""},
+	}
+	for _, tt := range tests {
+		if got := GetNickAccount(tt.nick); got != tt.want {
+			t.Errorf("GetNickAccount(%q) = %q, want %q", tt.nick, got, tt.want)
+		}
+	}
+
+	level, matchedBy := MatchUserLevel("{alice}!user@host")
+	if level != Admin || matchedBy != AccountPrefix+"alice" {
+		t.Errorf("MatchUserLevel({alice}) = %v, %q, want %v, %q", level, matchedBy, Admin, AccountPrefix+"alice")
+	}
+
+	RenameNick("{ALICE}", "Al^ce")
+	if got := GetNickAccount("al~ce"); got != "alice" {
+		t.Errorf("after rename GetNickAccount(al~ce) = %q, want alice", got)
+	}
+	if got := GetNickAccount("[alice]"); got != "" {
+		t.Errorf("after rename GetNickAccount([alice]) = %q, want empty", got)
+	}
+
+	ForgetNick("AL~CE")
+	if got := GetNickAccount("al^ce"); got != "" {
+		t.Errorf("after forget GetNickAccount(al^ce) = %q, want empty", got)
+	}
+}
@@ -0,0 +1,129 @@
+package userlevels
+
+import (
+	"strings"
+)
+
+// channelUsers maps lowercased channel names to the masks (or
+// "account:<name>" entries) granted a level within that channel
+var channelUsers = make(map[string]map[string]UserLevel)
+
+// SetChannelUserLevel assigns a level to a mask or "account:<name>" within
+// a single channel
+func SetChannelUserLevel(channel, mask string, level UserLevel) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	key := ircLower(channel)
+	if channelUsers[key] == nil {
+		channelUsers[key] = make(map[string]UserLevel)
+	}
+	channelUsers[key][mask] = level
+}
+
+// RemoveChannelUserLevel removes a channel-scoped grant. Returns false if
+// no such grant existed.
+func RemoveChannelUserLevel(channel, mask string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	key := ircLower(channel)
+	if _, ok := channelUsers[key][mask]; !ok {
+		return false
+	}
+	delete(channelUsers[key], mask)
+	if len(channelUsers[key]) == 0 {
+		delete(channelUsers, key)
+	}
+	return true
+}
+
+// GetChannelHostmasks returns the channel-scoped grants of a channel
+func GetChannelHostmasks(channel string) map[string]UserLevel {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	result := make(map[string]UserLevel)
+	for mask, level := range channelUsers[ircLower(channel)] {
+		result[mask] = level
+	}
+	return result
+}
+
+// matchChannelLevel returns the channel-scoped level of a hostmask and the
+// entry that granted it. Account grants win over masks, and overlapping
+// masks are resolved by specificity. Callers must hold mu.
+func matchChannelLevel(channel, hostmask string) (UserLevel, string, bool) {
+	entries := channelUsers[ircLower(channel)]
+	if len(entries) == 0 {
+		return Regular, "", false
+	}
+
+	nick := strings.Split(hostmask, "!")[0]
+	if account, ok := nickAccounts[ircLower(nick)]; ok {
+		for mask, level := range entries {
+			if strings.EqualFold(mask, AccountPrefix+account) {
+				return level, mask, true
+			}
+		}
+	}
+
+	bestMask := ""
+	bestLevel := Regular
+	bestScore := -1
+	for mask, level := range entries {
+		if strings.HasPrefix(mask, AccountPrefix) || !MatchMask(mask, hostmask) ||
+			(isNickMask(mask) && !nickGrantTrusted(nick)) {
+			continue
+		}
+		score := maskSpecificity(mask)
+		if score > bestScore || (score == bestScore && mask < bestMask) {
+			bestMask, bestLevel, bestScore = mask, level, score
+		}
+	}
+
+	return bestLevel, bestMask, bestScore >= 0
+}
+
+// MatchEffectiveLevel resolves a user's level in a channel along with the
+// entry that granted it. A channel grant overrides the global level within
+// that channel, except that the owner is never demoted and globally ignored
+// users stay ignored. An empty channel resolves the global level only.
+func MatchEffectiveLevel(channel, hostmask string) (UserLevel, string) {
+	level, matchedBy := MatchUserLevel(hostmask)
+	if channel == "" || level == Owner || level == Ignored {
+		return level, matchedBy
+	}
+
+	mu.RLock()
+	channelLevel, channelMatch, ok := matchChannelLevel(channel, hostmask)
+	mu.RUnlock()
+	if !ok {
+		return level, matchedBy
+	}
+
+	return channelLevel, channel + " " + channelMatch
+}
+
+// GetEffectiveLevel returns a user's level in a channel, taking both global
+// and channel-scoped grants into account
+func GetEffectiveLevel(channel, hostmask string) UserLevel {
+	level, _ := MatchEffectiveLevel(channel, hostmask)
+	return level
+}
+
+// HasChannelPermission checks whether a user has at least the required
+// level in a channel. Owner access is never granted by channel scope.
+func HasChannelPermission(channel, hostmask string, required UserLevel) bool {
+	userLevel := GetEffectiveLevel(channel, hostmask)
+
+	if userLevel == Ignored {
+		return false
+	}
+
+	if required == Owner {
+		return IsVerifiedOwner(hostmask)
+	}
+
+	return userLevel >= required
+}
@@ -0,0 +1,130 @@
+package userlevels
+
+import (
+	"strings"
+)
+
+// MatchMask reports whether a hostmask (nick!user@host) matches an IRC glob
+// mask, where * matches any run of characters and ? matches exactly one;
+// \* and \? match a literal * or ?. Comparison uses RFC1459 casemapping.
+func MatchMask(mask, hostmask string) bool {
+	return matchGlob(lowerMask(mask), ircLower(hostmask))
+}
+
+// isEscape reports whether the mask has a backslash escaping a * or ? at i
+func isEscape(mask string, i int) bool {
+	return mask[i] == '\\' && i+1 < len(mask) && (mask[i+1] == '*' || mask[i+1] == '?')
+}
+
+// lowerMask is ircLower for masks, keeping the backslashes that escape a
+// * or ? rather than folding them to |
+func lowerMask(mask string) string {
+	var b strings.Builder
+	for i := 0; i < len(mask); i++ {
+		if isEscape(mask, i) {
+			b.WriteString(mask[i : i+2])
+			i++
+			continue
+		}
+		b.WriteString(ircLower(mask[i : i+1]))
+	}
+	return b.String()
+}
+
+// ircLower lowercases a string using RFC1459 casemapping, where []\~ are
+// the uppercase forms of {}|^
+func ircLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		case r == '[':
+			return '{'
+		case r == ']':
+			return '}'
+		case r == '\\':
+			return '|'
+		case r == '~':
+			return '^'
+		}
+		return r
+	}, s)
+}
+
+// matchGlob matches s against pattern using iterative backtracking on the
+// last seen *, which avoids exponential blow-up on masks like *a*a*a*
+func matchGlob(pattern, s string) bool {
+	p, i := 0, 0
+	star, mark := -1, 0
+
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && isEscape(pattern, p):
+			if pattern[p+1] == s[i] {
+				p += 2
+				i++
+			} else if star != -1 {
+				p = star + 1
+				mark++
+				i = mark
+			} else {
+				return false
+			}
+		case p < len(pattern) && pattern[p] == '*':
+			star = p
+			mark = i
+			p++
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case star != -1:
+			p = star + 1
+			mark++
+			i = mark
+		default:
+			return false
+		}
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// maskSpecificity scores how specific a mask is: the number of literal
+// characters, so nick!user@host beats nick!*@* which beats *!*@*
+func maskSpecificity(mask string) int {
+	score := 0
+	for i := 0; i < len(mask); i++ {
+		if isEscape(mask, i) {
+			i++
+			score++
+		} else if mask[i] != '*' && mask[i] != '?' {
+			score++
+		}
+	}
+	return score
+}
+
+// bestMaskMatch returns the most specific stored mask matching the hostmask.
+// Ties are broken alphabetically so the result is stable. Masks naming only
+// a nick are skipped when the nick can't be trusted. Callers must hold mu.
+func bestMaskMatch(hostmask string) (string, UserLevel, bool) {
+	bestMask := ""
+	bestLevel := Regular
+	bestScore := -1
+
+	nick := strings.Split(hostmask, "!")[0]
+	for mask, level := range hostmaskUsers {
+		if !MatchMask(mask, hostmask) || (isNickMask(mask) && !nickGrantTrusted(nick)) {
+			continue
+		}
+		score := maskSpecificity(mask)
+		if score > bestScore || (score == bestScore && mask < bestMask) {
+			bestMask, bestLevel, bestScore = mask, level, score
+		}
+	}
+
+	return bestMask, bestLevel, bestScore >= 0
+}
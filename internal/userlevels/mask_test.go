@@ -0,0 +1,127 @@
+package userlevels
+
+import "testing"
+
+func TestMatchMask(t *testing.T) {
+	tests := []struct {
+		mask     string
+		hostmask string
+		want     bool
+	}{
+		{"nick!user@host", "nick!user@host", true},
+		{"nick!user@host", "nick!user@other", false},
+		{"nick!*@*", "nick!anyone@anywhere.example", true},
+		{"nick!*@*", "nick2!user@host", false},
+		{"*!*@*", "nick!user@host", true},
+		{"*!*@*.example.com", "nick!user@a.b.example.com", true},
+		{"*!*@*.example.com", "nick!user@example.com", false},
+		{"*!~user@host", "nick!~user@host", true},
+		{"n?ck!*@*", "nick!user@host", true},
+		{"n?ck!*@*", "nck!user@host", false},
+		{"n?ck!*@*", "niick!user@host", false},
+		{"*a*a*a*a*b!*@*", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaa!user@host", false},
+		{"*a*b!*@*", "xaxxbyb!user@host", true},
+
+		// RFC1459 casemapping: A-Z, [ ] \ ~ fold to a-z, { } | ^
+		{"NICK!USER@HOST", "nick!user@host", true},
+		{"[nick]!*@*", "{NICK}!user@host", true},
+		{"{nick}!*@*", "[nick]!user@host", true},
+		{"ni|ck!*@*", "ni\\ck!user@host", true},
+		{"ni\\ck!*@*", "ni|ck!user@host", true},
+		{"nick^!*@*", "nick~!user@host", true},
+		{"nick~!*@*", "nick^!user@host", true},
+
+		// \* and \? only match a literal * or ?
+		{"nick!\\*@*", "nick!*@host", true},
+		{"nick!\\*@*", "nick!user@host", false},
+		{"nick!u\\?er@*", "nick!u?er@host", true},
+		{"nick!u\\?er@*", "nick!user@host", false},
+		{"*\\*!*@*", "abc*!user@host", true},
+		{"*\\*!*@*", "abc!user@host", false},
+		{"a\\b!*@*", "A|B!user@host", true},
+	}
+
+	for _, tt := range tests {
+		if got := MatchMask(tt.mask, tt.hostmask); got != tt.want {
+			t.Errorf("MatchMask(%q, %q) = %v, want %v", tt.mask, tt.hostmask, got, tt.want)
+		}
+	}
+}
+
+func TestIrcLower(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Nick", "nick"},
+		{"[Foo]", "{foo}"},
+		{"A\\B~", "a|b^"},
+		{"{already}|^", "{already}|^"},
+		{"ÄB", "Äb"},
+	}
+
+	for _, tt := range tests {
+		if got := ircLower(tt.in); got != tt.want {
+			t.Errorf("ircLower(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMaskSpecificity(t *testing.T) {
+	tests := []struct {
+		mask string
+		want int
+	}{
+		{"*!*@*", 2},
+		{"nick!*@*", 6},
+		{"nick!user@host", 14},
+		{"n?ck!*@*", 5},
+		{"nick!\\*@*", 7},
+	}
+
+	for _, tt := range tests {
+		if got := maskSpecificity(tt.mask); got != tt.want {
+			t.Errorf("maskSpecificity(%q) = %d, want %d", tt.mask, got, tt.want)
+		}
+	}
+}
+
+func TestBestMaskMatch(t *testing.T) {
+	mu.Lock()
+	saved := hostmaskUsers
+	hostmaskUsers = map[string]UserLevel{
+		"*!*@*.example.com": BadBoy,
+		"nick!*@*":          Admin,
+		"nick!user@host":    Owner,
+		"*!*@host":          Ignored,
+	}
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		hostmaskUsers = saved
+		mu.Unlock()
+	}()
+
+	tests := []struct {
+		hostmask  string
+		wantMask  string
+		wantLevel UserLevel
+		wantOK    bool
+	}{
+		{"nick!user@host", "nick!user@host", Owner, true},
+		{"NICK!user@other", "nick!*@*", Admin, true},
+		{"other!user@host", "*!*@host", Ignored, true},
+		{"other!user@a.example.com", "*!*@*.example.com", BadBoy, true},
+		{"other!user@elsewhere", "", Regular, false},
+	}
+
+	for _, tt := range tests {
+		mu.RLock()
+		mask, level, ok := bestMaskMatch(tt.hostmask)
+		mu.RUnlock()
+		if mask != tt.wantMask || level != tt.wantLevel || ok != tt.wantOK {
+			t.Errorf("bestMaskMatch(%q) = %q, %v, %v, want %q, %v, %v",
+				tt.hostmask, mask, level, ok, tt.wantMask, tt.wantLevel, tt.wantOK)
+		}
+	}
+}
@@ -48,13 +48,22 @@ var (
 	mu            sync.RWMutex
 )
 
-// SetUserLevel assigns the given level to the specified user (legacy method)
+// SetUserLevel assigns the given level to the specified user (legacy method).
+// When the nick is logged in to a services account the account is granted
+// instead, as anyone could take the nick later.
 func SetUserLevel(nick string, level UserLevel) {
 	mu.Lock()
 	defer mu.Unlock()
-	users[nick] = level
 
 	wildcardHostmask := nick + "!*@*"
+	if account, ok := nickAccounts[ircLower(nick)]; ok {
+		accountUsers[strings.ToLower(account)] = level
+		delete(hostmaskUsers, wildcardHostmask)
+		delete(users, nick)
+		return
+	}
+
+	users[nick] = level
 	hostmaskUsers[wildcardHostmask] = level
 }
 
@@ -65,7 +74,7 @@ func SetUserLevelByHostmask(hostmask string, level UserLevel) {
 	hostmaskUsers[hostmask] = level
 
 	parts := strings.Split(hostmask, "!")
-	if len(parts) > 0 {
+	if len(parts) > 0 && !strings.ContainsAny(parts[0], "*?") {
 		nick := parts[0]
 		users[nick] = level
 	}
@@ -77,42 +86,62 @@ func GetUserLevel(nick string) UserLevel {
 	defer mu.RUnlock()
 
 	for storedNick, level := range users {
-		if strings.EqualFold(storedNick, nick) {
+		if ircLower(storedNick) == ircLower(nick) {
 			return level
 		}
 	}
 	return Regular
 }
 
-// GetUserLevelByHostmask returns the user's level by their full hostmask
+// GetUserLevelByHostmask returns the user's level by their full hostmask.
+// A level granted to the services account the user is logged in to takes
+// precedence over hostmask and nick entries.
 func GetUserLevelByHostmask(hostmask string) UserLevel {
+	level, _ := MatchUserLevel(hostmask)
+	return level
+}
+
+// MatchUserLevel returns the user's level along with the entry that granted
+// it: "account:<name>", the matching stored mask, "owner hostmask", or an
+// empty string for the default level.
+// Overlapping masks are resolved by specificity, most literal characters wins.
+func MatchUserLevel(hostmask string) (UserLevel, string) {
 	mu.RLock()
 	defer mu.RUnlock()
 
-	if level, ok := hostmaskUsers[hostmask]; ok {
-		return level
+	if level, ok := accountLevelForHostmask(hostmask); ok {
+		nick := strings.Split(hostmask, "!")[0]
+		return level, AccountPrefix + nickAccounts[ircLower(nick)]
+	}
+
+	mask, level, matched := bestMaskMatch(hostmask)
+	if matched && !strings.ContainsAny(mask, "*?") {
+		return level, mask
 	}
 
 	settings, err := config.LoadSettings()
 	if err == nil && settings.OwnerVerified && settings.OwnerHostmask != "" {
 		if hostmask == settings.OwnerHostmask {
-			return Owner
+			return Owner, "owner hostmask"
 		}
 	}
 
-	parts := strings.Split(hostmask, "!")
-	if len(parts) > 0 {
-		nick := parts[0]
-		if level, ok := users[nick]; ok {
-			return level
-		}
+	if matched {
+		return level, mask
 	}
 
-	return Regular
+	return Regular, ""
 }
 
 // IsVerifiedOwner checks if a user with the given hostmask is the verified owner
 func IsVerifiedOwner(hostmask string) bool {
+	mu.RLock()
+	accountLevel, ok := accountLevelForHostmask(hostmask)
+	mu.RUnlock()
+	if ok && accountLevel == Owner {
+		return true
+	}
+
 	settings, err := config.LoadSettings()
 	if err != nil || !settings.OwnerVerified || settings.OwnerHostmask == "" {
 		mu.RLock()
@@ -124,9 +153,13 @@ func IsVerifiedOwner(hostmask string) bool {
 		}
 		nick := parts[0]
 
+		if !nickGrantTrusted(nick) {
+			return false
+		}
+
 		found := false
 		for storedNick, level := range users {
-			if strings.EqualFold(storedNick, nick) {
+			if ircLower(storedNick) == ircLower(nick) {
 				found = true
 				if level == Owner {
 					return true
@@ -143,6 +176,7 @@ func IsVerifiedOwner(hostmask string) bool {
 }
 
 // HasPermission checks whether a user has at least the required permission level.
+// When the user's services account is known, its level is used first.
 func HasPermission(hostmask string, required UserLevel) bool {
 	userLevel := GetUserLevelByHostmask(hostmask)
 	
@@ -187,20 +221,40 @@ func LoadHostmasks() {
 		return
 	}
 
+	mu.Lock()
+	defer mu.Unlock()
+
+	accountUsers = make(map[string]UserLevel)
+	for _, entry := range settings.Accounts {
+		accountUsers[strings.ToLower(entry.Account)] = UserLevel(entry.Level)
+	}
+	if len(accountUsers) > 0 {
+		logger.Infof("Loaded %d account levels from settings", len(accountUsers))
+	}
+
+	channelUsers = make(map[string]map[string]UserLevel)
+	for _, entry := range settings.ChannelHostmasks {
+		key := ircLower(entry.Channel)
+		if channelUsers[key] == nil {
+			channelUsers[key] = make(map[string]UserLevel)
+		}
+		channelUsers[key][entry.Hostmask] = UserLevel(entry.Level)
+	}
+	if len(settings.ChannelHostmasks) > 0 {
+		logger.Infof("Loaded %d channel user levels from settings", len(settings.ChannelHostmasks))
+	}
+
 	if !settings.HostmaskLevels {
 		return
 	}
 
-	mu.Lock()
-	defer mu.Unlock()
-
 	hostmaskUsers = make(map[string]UserLevel)
 
 	for _, entry := range settings.Hostmasks {
 		hostmaskUsers[entry.Hostmask] = UserLevel(entry.Level)
 
 		parts := strings.Split(entry.Hostmask, "!")
-		if len(parts) > 0 {
+		if len(parts) > 0 && !strings.ContainsAny(parts[0], "*?") {
 			nick := parts[0]
 			users[nick] = UserLevel(entry.Level)
 		}
@@ -221,6 +275,23 @@ func SaveHostmasks() error {
 	for hostmask, level := range hostmaskUsers {
 		tmpHostmaskUsers[hostmask] = level
 	}
+	var accounts []config.AccountEntry
+	for account, level := range accountUsers {
+		accounts = append(accounts, config.AccountEntry{
+			Account: account,
+			Level:   int(level),
+		})
+	}
+	var channelHostmasks []config.ChannelHostmaskEntry
+	for channel, entries := range channelUsers {
+		for mask, level := range entries {
+			channelHostmasks = append(channelHostmasks, config.ChannelHostmaskEntry{
+				Channel:  channel,
+				Hostmask: mask,
+				Level:    int(level),
+			})
+		}
+	}
 	mu.RUnlock()
 
 	var hostmasks []config.HostmaskEntry
@@ -236,10 +307,15 @@ func SaveHostmasks() error {
 		settings = &config.Settings{
 			OwnerVerified:  false,
 			Hostmasks:      hostmasks,
+			Accounts:       accounts,
 			HostmaskLevels: true,
+
+			ChannelHostmasks: channelHostmasks,
 		}
 	} else {
 		settings.Hostmasks = hostmasks
+		settings.Accounts = accounts
+		settings.ChannelHostmasks = channelHostmasks
 		settings.HostmaskLevels = true
 	}
 
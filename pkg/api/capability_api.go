@@ -0,0 +1,31 @@
+package api
+
+import (
+	"time"
+
+	"gopkg.in/irc.v4"
+	"ircbot/internal/capabilities"
+)
+
+// HasCapability reports whether an IRCv3 capability (e.g. "server-time",
+// "account-tag") was negotiated with the server on the current connection
+func HasCapability(name string) bool {
+	return capabilities.Enabled(name)
+}
+
+// GetCapabilities returns all capabilities negotiated with the server
+func GetCapabilities() []string {
+	return capabilities.List()
+}
+
+// GetMessageTime returns when a message was sent according to the
+// server-time tag, or the current time if the server didn't provide one
+func GetMessageTime(m *irc.Message) time.Time {
+	return capabilities.MessageTime(m)
+}
+
+// GetMessageAccount returns the services account of the message sender
+// from the account-tag, or an empty string if they are not logged in
+func GetMessageAccount(m *irc.Message) string {
+	return capabilities.MessageAccount(m)
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"ircbot/internal/channelstate"
+)
+
+// ChannelUser is a member of a channel the bot is in
+type ChannelUser struct {
+	Nick string
+	// Modes holds the user's status modes in the channel, highest first (e.g. "ov")
+	Modes string
+	// Prefix is the symbol of the highest status (e.g. "@"), or empty
+	Prefix string
+}
+
+// GetBotChannels returns the channels the bot is currently in
+func GetBotChannels() []string {
+	return channelstate.GetChannels()
+}
+
+// GetChannelUsers returns the members of a channel the bot is in, sorted by
+// status and then nick. Returns nil if the bot is not in the channel.
+func GetChannelUsers(channel string) []ChannelUser {
+	members := channelstate.GetChannelUsers(channel)
+	if members == nil {
+		return nil
+	}
+
+	users := make([]ChannelUser, 0, len(members))
+	for _, member := range members {
+		users = append(users, ChannelUser{
+			Nick:   member.Nick,
+			Modes:  member.Modes,
+			Prefix: member.Prefix,
+		})
+	}
+	return users
+}
+
+// GetChannelTopic returns the topic of a channel the bot is in
+func GetChannelTopic(channel string) string {
+	info, ok := channelstate.GetChannel(channel)
+	if !ok {
+		return ""
+	}
+	return info.Topic
+}
+
+// IsUserInChannel checks if a nick is in a channel the bot is in
+func IsUserInChannel(channel, nick string) bool {
+	return channelstate.IsOnChannel(channel, nick)
+}
+
+// IsChannelOp checks if a nick has operator status (or higher) in a channel
+func IsChannelOp(channel, nick string) bool {
+	return channelstate.IsOp(channel, nick)
+}
+
+// BotHasOp checks if the bot has operator status in a channel
+func BotHasOp(channel string) bool {
+	return channelstate.BotHasOp(channel)
+}
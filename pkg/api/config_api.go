@@ -1,9 +1,15 @@
 package api
 
 import (
-	"ircbot/internal/config"
+	"bytes"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+
+	"github.com/BurntSushi/toml"
+	"ircbot/internal/config"
 )
 
 // BotConfig provides read-only access to bot configuration
@@ -76,4 +82,131 @@ func GetPluginConfigHelper(pluginName string) *PluginConfigHelper {
 type PluginConfigHelper struct {
 	PluginName string
 	ConfigPath string
+}
+
+// Load reads the plugin's config.toml into cfg, a pointer to a struct that
+// already holds the defaults. Settings missing from the file keep their
+// defaults. If there is no file yet, the defaults are saved to it so they
+// can be edited with !pluginconfig.
+func (h *PluginConfigHelper) Load(cfg interface{}) error {
+	if _, err := os.Stat(h.ConfigPath); os.IsNotExist(err) {
+		return h.Save(cfg)
+	}
+	_, err := toml.DecodeFile(h.ConfigPath, cfg)
+	return err
+}
+
+// Save writes cfg to the plugin's config.toml, replacing the file atomically
+func (h *PluginConfigHelper) Save(cfg interface{}) error {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(cfg); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.ConfigPath), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(h.ConfigPath), ".config-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), h.ConfigPath)
+}
+
+// Values returns the settings in the config file, with the keys of nested
+// tables joined by dots (e.g. "limits.max_items")
+func (h *PluginConfigHelper) Values() (map[string]interface{}, error) {
+	raw, err := h.raw()
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{})
+	flattenConfig("", raw, values)
+	return values, nil
+}
+
+// Set changes one setting, given as text, keeping the type of its current
+// value. Only settings that are already in the file can be set.
+func (h *PluginConfigHelper) Set(key, value string) error {
+	raw, err := h.raw()
+	if err != nil {
+		return err
+	}
+
+	table := raw
+	parts := strings.Split(key, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := table[part].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("unknown setting %s", key)
+		}
+		table = next
+	}
+
+	name := parts[len(parts)-1]
+	current, ok := table[name]
+	if !ok {
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	parsed, err := parseConfigValue(current, value)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+	table[name] = parsed
+	return h.Save(raw)
+}
+
+// raw decodes the config file into nested maps
+func (h *PluginConfigHelper) raw() (map[string]interface{}, error) {
+	raw := make(map[string]interface{})
+	if _, err := toml.DecodeFile(h.ConfigPath, &raw); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("plugin %s has no configuration", h.PluginName)
+		}
+		return nil, err
+	}
+	return raw, nil
+}
+
+// flattenConfig adds the values of a table to values with dotted keys
+func flattenConfig(prefix string, table map[string]interface{}, values map[string]interface{}) {
+	for key, value := range table {
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenConfig(prefix+key+".", nested, values)
+			continue
+		}
+		values[prefix+key] = value
+	}
+}
+
+// parseConfigValue parses text as a value of the same type as current
+func parseConfigValue(current interface{}, text string) (interface{}, error) {
+	switch current.(type) {
+	case bool:
+		return strconv.ParseBool(text)
+	case int64:
+		return strconv.ParseInt(text, 10, 64)
+	case float64:
+		return strconv.ParseFloat(text, 64)
+	case string:
+		return text, nil
+	case []interface{}:
+		// Lists are given comma-separated and stored as strings
+		var items []interface{}
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("settings of type %T can't be changed", current)
 }
\ No newline at end of file
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+
+	"ircbot/internal/events"
+	"ircbot/internal/logger"
+)
+
+// Event is an event on the bot's event bus
+type Event = events.Event
+
+// Payloads of the events published by the bot
+type (
+	UserAutoIgnoredEvent = events.UserAutoIgnored
+	AIResponseEvent      = events.AIResponse
+	NoteSavedEvent       = events.NoteSaved
+	NoteDeletedEvent     = events.NoteDeleted
+	PluginLoadedEvent    = events.PluginLoaded
+	PluginUnloadedEvent  = events.PluginUnloaded
+)
+
+// Topics published by the bot. Plugins can't publish to the "user.", "ai.",
+// "note." and "plugin." namespaces.
+const (
+	TopicUserAutoIgnored = events.TopicUserAutoIgnored
+	TopicAIResponse      = events.TopicAIResponse
+	TopicNoteSaved       = events.TopicNoteSaved
+	TopicNoteDeleted     = events.TopicNoteDeleted
+	TopicPluginLoaded    = events.TopicPluginLoaded
+	TopicPluginUnloaded  = events.TopicPluginUnloaded
+)
+
+// Subscribe calls handler for every event whose topic matches pattern: a
+// topic, a prefix such as "note.*", or "*". Handlers run on the plugin's
+// worker like its IRC event handlers. Subscriptions are removed when the
+// plugin is unloaded. Returns the subscription ID.
+func Subscribe(pluginName, pattern string, handler func(Event)) (int, error) {
+	return events.Subscribe(pluginName, pattern, handler)
+}
+
+// SubscribeTyped is Subscribe for events whose data is a T, such as
+// NoteSavedEvent. Data published by out-of-process plugins arrives as JSON
+// and is decoded into a T; events with other data are skipped.
+func SubscribeTyped[T any](pluginName, pattern string, handler func(T, Event)) (int, error) {
+	return events.Subscribe(pluginName, pattern, func(e Event) {
+		switch data := e.Data.(type) {
+		case T:
+			handler(data, e)
+		case *T:
+			if data != nil {
+				handler(*data, e)
+			}
+		case json.RawMessage:
+			var decoded T
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				logger.Debugf("Skipping %s event for %s: %v", e.Topic, pluginName, err)
+				return
+			}
+			handler(decoded, e)
+		}
+	})
+}
+
+// Unsubscribe removes a subscription
+func Unsubscribe(id int) bool {
+	return events.Unsubscribe(id)
+}
+
+// Publish sends an event from a plugin to all subscribers without waiting
+// for them. Topics are best namespaced by plugin, e.g. "quotes.added".
+func Publish(pluginName, topic string, data interface{}) error {
+	return events.PublishFrom(pluginName, topic, data)
+}
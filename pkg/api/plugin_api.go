@@ -176,12 +176,15 @@ func ParseHostmask(hostmask string) (nick, user, host string) {
 	return nick, user, host
 }
 
-// SendMessage sends an IRC message to the specified target
+// SendMessage sends an IRC message to the specified target. Messages are
+// queued and sent under the bot's flood limit, so long replies are paced
+// automatically and need no delays between lines.
 func SendMessage(client *irc.Client, target, message string) error {
 	return client.Writef("%s %s :%s", CMD_PRIVMSG, target, message)
 }
 
-// SendNotice sends an IRC notice to the specified target
+// SendNotice sends an IRC notice to the specified target through the
+// flood-limited send queue
 func SendNotice(client *irc.Client, target, message string) error {
 	return client.Writef("%s %s :%s", CMD_NOTICE, target, message)
 }
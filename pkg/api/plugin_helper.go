@@ -8,22 +8,33 @@ import (
 	"time"
 )
 
+// APIVersion is the version of the plugin API provided by this bot. Plugins
+// built for the same major version and an equal or lower minor version work
+// with it.
+const APIVersion = "1.0"
+
 // CommandHelp represents documentation for a plugin command
 type CommandHelp struct {
-	Command     string
-	Description string
-	Usage       string
-	Examples    []string
-	Permission  UserLevel
+	Command     string    `toml:"command"`
+	Description string    `toml:"description"`
+	Usage       string    `toml:"usage"`
+	Examples    []string  `toml:"examples"`
+	Permission  UserLevel `toml:"permission"`
 }
 
-// PluginMetadata contains information about a plugin
+// PluginMetadata contains information about a plugin. Plugins provide it
+// with a Metadata() method, or in a TOML file next to the plugin file.
 type PluginMetadata struct {
-	Name        string
-	Version     string
-	Author      string
-	Description string
-	Commands    []CommandHelp
+	Name        string `toml:"name"`
+	Version     string `toml:"version"`
+	Author      string `toml:"author"`
+	Description string `toml:"description"`
+	// APIVersion is the plugin API version the plugin was written for
+	APIVersion string `toml:"api_version"`
+	// Dependencies names the plugins that must be loaded first, optionally
+	// with a minimum version (e.g. "NotesPlugin>=1.2.0")
+	Dependencies []string      `toml:"dependencies"`
+	Commands     []CommandHelp `toml:"commands"`
 }
 
 // EnsurePluginDataDir ensures the data directory for a plugin exists
@@ -57,7 +68,7 @@ type MessageHandler func(client *irc.Client, message *irc.Message)
 // CommandHandler is a function that handles bot commands
 type CommandHandler func(client *irc.Client, message *irc.Message, args []string)
 
-// ScheduledTask represents a task that executes on a schedule
+// ScheduledTask represents a task that executes on a schedule, see ScheduleTask
 type ScheduledTask struct {
 	Name     string
 	Interval time.Duration
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"time"
+
+	"ircbot/internal/scheduler"
+)
+
+// ScheduleTask runs task.Action every task.Interval on behalf of a plugin
+// and updates task.LastRun after each run. Both run on the plugin's worker,
+// so the plugin's handlers can read task.LastRun without a lock. Returns the
+// task ID.
+func ScheduleTask(pluginName string, task *ScheduledTask) (int, error) {
+	return scheduler.Every(pluginName, task.Name, task.Interval, func() error {
+		task.LastRun = time.Now()
+		return task.Action()
+	})
+}
+
+// ScheduleInterval runs action every interval. Tasks are cancelled when
+// the plugin is unloaded. Returns the task ID.
+func ScheduleInterval(pluginName, name string, interval time.Duration, action func() error) (int, error) {
+	return scheduler.Every(pluginName, name, interval, action)
+}
+
+// ScheduleCron runs action on a cron schedule such as "0 9 * * 1-5"
+// (minute hour day-of-month month day-of-week) or "@hourly". Returns the
+// task ID.
+func ScheduleCron(pluginName, name, spec string, action func() error) (int, error) {
+	return scheduler.Cron(pluginName, name, spec, action)
+}
+
+// ScheduleOnce runs action once after delay. Returns the task ID.
+func ScheduleOnce(pluginName, name string, delay time.Duration, action func() error) (int, error) {
+	return scheduler.After(pluginName, name, delay, action)
+}
+
+// CancelTask cancels a scheduled task
+func CancelTask(id int) bool {
+	return scheduler.Cancel(id)
+}
+
+// CancelPluginTasks cancels all scheduled tasks of a plugin
+func CancelPluginTasks(pluginName string) int {
+	return scheduler.CancelOwner(pluginName)
+}
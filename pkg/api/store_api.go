@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+
+	"ircbot/internal/kvstore"
+)
+
+// Store is a plugin's namespace in the bot's persistent key-value store.
+// Every change is saved to disk atomically, and Update groups changes into
+// a transaction, so handlers running at the same time never see or write
+// partial state.
+type Store struct {
+	namespace string
+}
+
+// StoreTx is a transaction on a plugin's store
+type StoreTx struct {
+	tx *kvstore.Tx
+}
+
+// GetPluginStore returns the key-value store of a plugin
+func GetPluginStore(pluginName string) *Store {
+	return &Store{namespace: pluginName}
+}
+
+// Update runs fn in a read-write transaction. The changes are saved if fn
+// returns nil and discarded otherwise.
+func (s *Store) Update(fn func(tx *StoreTx) error) error {
+	store, err := kvstore.Default()
+	if err != nil {
+		return err
+	}
+	return store.Update(s.namespace, func(tx *kvstore.Tx) error {
+		return fn(&StoreTx{tx: tx})
+	})
+}
+
+// View runs fn in a read-only transaction
+func (s *Store) View(fn func(tx *StoreTx) error) error {
+	store, err := kvstore.Default()
+	if err != nil {
+		return err
+	}
+	return store.View(s.namespace, func(tx *kvstore.Tx) error {
+		return fn(&StoreTx{tx: tx})
+	})
+}
+
+// Get returns the value of key and whether it exists
+func (s *Store) Get(key string) ([]byte, bool, error) {
+	var value []byte
+	var found bool
+	err := s.View(func(tx *StoreTx) error {
+		value, found = tx.Get(key)
+		return nil
+	})
+	return value, found, err
+}
+
+// Set stores a value that never expires
+func (s *Store) Set(key string, value []byte) error {
+	return s.SetWithTTL(key, value, 0)
+}
+
+// SetWithTTL stores a value that expires after ttl
+func (s *Store) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	return s.Update(func(tx *StoreTx) error {
+		return tx.SetWithTTL(key, value, ttl)
+	})
+}
+
+// Delete removes key
+func (s *Store) Delete(key string) error {
+	return s.Update(func(tx *StoreTx) error {
+		return tx.Delete(key)
+	})
+}
+
+// List returns the keys starting with prefix, sorted
+func (s *Store) List(prefix string) ([]string, error) {
+	var keys []string
+	err := s.View(func(tx *StoreTx) error {
+		keys = tx.List(prefix)
+		return nil
+	})
+	return keys, err
+}
+
+// GetJSON decodes the JSON value of key into v. Returns false if the key
+// doesn't exist.
+func (s *Store) GetJSON(key string, v interface{}) (bool, error) {
+	var found bool
+	err := s.View(func(tx *StoreTx) error {
+		var err error
+		found, err = tx.GetJSON(key, v)
+		return err
+	})
+	return found, err
+}
+
+// SetJSON stores v as JSON, expiring after ttl unless ttl is 0
+func (s *Store) SetJSON(key string, v interface{}, ttl time.Duration) error {
+	return s.Update(func(tx *StoreTx) error {
+		return tx.SetJSON(key, v, ttl)
+	})
+}
+
+// Get returns the value of key and whether it exists
+func (tx *StoreTx) Get(key string) ([]byte, bool) {
+	return tx.tx.Get(key)
+}
+
+// Set stores a value that never expires
+func (tx *StoreTx) Set(key string, value []byte) error {
+	return tx.tx.Set(key, value)
+}
+
+// SetWithTTL stores a value that expires after ttl
+func (tx *StoreTx) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	return tx.tx.SetWithTTL(key, value, ttl)
+}
+
+// Delete removes key
+func (tx *StoreTx) Delete(key string) error {
+	return tx.tx.Delete(key)
+}
+
+// List returns the keys starting with prefix, sorted
+func (tx *StoreTx) List(prefix string) []string {
+	return tx.tx.List(prefix)
+}
+
+// GetJSON decodes the JSON value of key into v
+func (tx *StoreTx) GetJSON(key string, v interface{}) (bool, error) {
+	raw, found := tx.tx.Get(key)
+	if !found {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// SetJSON stores v as JSON, expiring after ttl unless ttl is 0
+func (tx *StoreTx) SetJSON(key string, v interface{}, ttl time.Duration) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.tx.SetWithTTL(key, raw, ttl)
+}
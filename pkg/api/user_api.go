@@ -1,6 +1,9 @@
 package api
 
 import (
+	"fmt"
+	"strings"
+
 	"ircbot/internal/userlevels"
 )
 
@@ -38,6 +41,18 @@ func GetUserLevelName(level UserLevel) string {
 	}
 }
 
+// UnmarshalText parses a level name such as "Admin", so levels can be
+// written by name in plugin manifests
+func (l *UserLevel) UnmarshalText(text []byte) error {
+	for level := Ignored; level <= Owner; level++ {
+		if strings.EqualFold(string(text), GetUserLevelName(level)) {
+			*l = level
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown user level %q", text)
+}
+
 // CheckUserPermission checks if a user with the given hostmask has at least the required permission level
 func CheckUserPermission(hostmask string, required UserLevel) bool {
 	return userlevels.HasPermission(hostmask, userlevels.UserLevel(required))
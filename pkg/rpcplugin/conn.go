@@ -0,0 +1,319 @@
+package rpcplugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxLineSize is the largest JSON-RPC message accepted
+const maxLineSize = 4 * 1024 * 1024
+
+// outgoingBuffer is the number of messages that can wait to be written
+// before notifications start being dropped
+const outgoingBuffer = 256
+
+// incomingBuffer is the number of notifications that can wait to be handled
+// before new ones are dropped
+const incomingBuffer = 1024
+
+// ErrClosed is returned for calls on a closed connection
+var ErrClosed = errors.New("rpc connection closed")
+
+// ErrBusy is returned when a notification is dropped because the other
+// side is not reading fast enough
+var ErrBusy = errors.New("rpc connection busy, message dropped")
+
+// Handler answers requests and notifications from the other side. The
+// result is ignored for notifications.
+type Handler func(method string, params json.RawMessage) (interface{}, error)
+
+// rpcMessage is a JSON-RPC 2.0 request, notification or response
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *uint64         `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError is the error object of a JSON-RPC response
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// JSON-RPC error codes
+const (
+	CodeParseError     = -32700
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Conn is one end of a bidirectional JSON-RPC connection. Both sides can
+// send requests. Incoming requests are answered by the Handler, each in its
+// own goroutine so a slow call never blocks the connection; notifications
+// are handled one at a time, in the order they arrived.
+type Conn struct {
+	handler       Handler
+	out           chan []byte
+	notifications chan *rpcMessage
+	done          chan struct{}
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan *rpcMessage
+	closed  bool
+	err     error
+}
+
+// NewConn starts serving a connection reading from r and writing to w
+func NewConn(r io.Reader, w io.Writer, handler Handler) *Conn {
+	c := &Conn{
+		handler:       handler,
+		out:           make(chan []byte, outgoingBuffer),
+		notifications: make(chan *rpcMessage, incomingBuffer),
+		done:          make(chan struct{}),
+		pending:       make(map[uint64]chan *rpcMessage),
+	}
+	go c.writeLoop(w)
+	go c.readLoop(r)
+	go c.notifyLoop()
+	return c
+}
+
+// Done is closed once the connection is closed
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection was closed
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close closes the connection, failing any calls still waiting
+func (c *Conn) Close() {
+	c.closeWith(ErrClosed)
+}
+
+func (c *Conn) closeWith(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.err = err
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	close(c.done)
+}
+
+// Call sends a request and waits up to timeout for the result, which is
+// decoded into result unless it is nil
+func (c *Conn) Call(method string, params, result interface{}, timeout time.Duration) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan *rpcMessage, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(&rpcMessage{JSONRPC: "2.0", ID: &id, Method: method, Params: raw}, true); err != nil {
+		return err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return ErrClosed
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result != nil && len(resp.Result) > 0 {
+			return json.Unmarshal(resp.Result, result)
+		}
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("%s timed out after %s", method, timeout)
+	}
+}
+
+// Notify sends a notification without waiting. It returns ErrBusy instead
+// of blocking when the other side is not keeping up.
+func (c *Conn) Notify(method string, params interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.send(&rpcMessage{JSONRPC: "2.0", Method: method, Params: raw}, false)
+}
+
+// send queues a message for writing. Requests and responses wait for room
+// in the queue; notifications are dropped when it is full.
+func (c *Conn) send(msg *rpcMessage, wait bool) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if !wait {
+		select {
+		case <-c.done:
+			return ErrClosed
+		case c.out <- line:
+			return nil
+		default:
+			return ErrBusy
+		}
+	}
+
+	select {
+	case <-c.done:
+		return ErrClosed
+	case c.out <- line:
+		return nil
+	}
+}
+
+func (c *Conn) writeLoop(w io.Writer) {
+	for {
+		select {
+		case <-c.done:
+			return
+		case line := <-c.out:
+			if _, err := w.Write(line); err != nil {
+				c.closeWith(err)
+				return
+			}
+		}
+	}
+}
+
+func (c *Conn) readLoop(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	for scanner.Scan() {
+		var msg rpcMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			c.send(&rpcMessage{JSONRPC: "2.0", Error: &RPCError{Code: CodeParseError, Message: err.Error()}}, true)
+			continue
+		}
+
+		switch {
+		case msg.Method == "":
+			c.deliverResponse(&msg)
+		case msg.ID == nil:
+			select {
+			case c.notifications <- &msg:
+			default:
+				// The handler is stuck; dropping keeps responses flowing
+			}
+		default:
+			go c.handle(&msg)
+		}
+	}
+
+	err := scanner.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	c.closeWith(err)
+}
+
+// notifyLoop handles notifications in order
+func (c *Conn) notifyLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.notifications:
+			c.handle(msg)
+		}
+	}
+}
+
+// deliverResponse hands a response to the call waiting for it
+func (c *Conn) deliverResponse(msg *rpcMessage) {
+	if msg.ID == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ch, ok := c.pending[*msg.ID]; ok {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// handle runs the handler for a request or notification and sends the
+// response for requests
+func (c *Conn) handle(msg *rpcMessage) {
+	result, err := c.safeHandle(msg)
+	if msg.ID == nil {
+		return
+	}
+
+	resp := &rpcMessage{JSONRPC: "2.0", ID: msg.ID}
+	if err != nil {
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &RPCError{Code: CodeInternalError, Message: err.Error()}
+		}
+		resp.Error = rpcErr
+	} else {
+		raw, err := json.Marshal(result)
+		if err != nil {
+			resp.Error = &RPCError{Code: CodeInternalError, Message: err.Error()}
+		} else {
+			resp.Result = raw
+		}
+	}
+	c.send(resp, true)
+}
+
+// safeHandle calls the handler, turning a panic into an error
+func (c *Conn) safeHandle(msg *rpcMessage) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic in %s: %v", msg.Method, r)
+		}
+	}()
+	return c.handler(msg.Method, msg.Params)
+}
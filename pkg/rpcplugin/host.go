@@ -0,0 +1,250 @@
+package rpcplugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CallTimeout is how long a call to the bot waits for an answer
+const CallTimeout = 10 * time.Second
+
+// AITimeout is how long the AI calls wait for an answer
+const AITimeout = 2 * time.Minute
+
+// User levels, matching ircbot/pkg/api
+const (
+	Ignored = iota
+	BadBoy
+	Regular
+	Admin
+	Owner
+)
+
+// Host is the plugin's handle on the bot. Its methods mirror the functions
+// of ircbot/pkg/api and are safe to call from any goroutine.
+type Host struct {
+	conn *Conn
+
+	mu   sync.RWMutex
+	nick string
+}
+
+func (h *Host) setNick(nick string) {
+	if nick == "" {
+		return
+	}
+	h.mu.Lock()
+	h.nick = nick
+	h.mu.Unlock()
+}
+
+// CurrentNick returns the bot's nick as of the last event
+func (h *Host) CurrentNick() string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.nick
+}
+
+// GetReplyTarget returns the channel a message was sent to, or the sender
+// for private messages
+func (h *Host) GetReplyTarget(m *Message) string {
+	if m.IsChannel() {
+		return m.Params[0]
+	}
+	return m.Nick()
+}
+
+// call makes a request with the default timeout
+func (h *Host) call(method string, params, result interface{}) error {
+	return h.conn.Call(method, params, result, CallTimeout)
+}
+
+// log sends a log line to the bot without waiting
+func (h *Host) log(level, format string, args ...interface{}) {
+	h.conn.Notify(MethodLog, LogParams{Level: level, Message: fmt.Sprintf(format, args...)})
+}
+
+// LogInfo logs an informational message in the bot's log
+func (h *Host) LogInfo(format string, args ...interface{}) {
+	h.log("info", format, args...)
+}
+
+// LogError logs an error message in the bot's log
+func (h *Host) LogError(format string, args ...interface{}) {
+	h.log("error", format, args...)
+}
+
+// LogSuccess logs a success message in the bot's log
+func (h *Host) LogSuccess(format string, args ...interface{}) {
+	h.log("success", format, args...)
+}
+
+// LogWarn logs a warning message in the bot's log
+func (h *Host) LogWarn(format string, args ...interface{}) {
+	h.log("warn", format, args...)
+}
+
+// LogDebug logs a debug message in the bot's log
+func (h *Host) LogDebug(format string, args ...interface{}) {
+	h.log("debug", format, args...)
+}
+
+// SendMessage sends a PRIVMSG to a channel or user
+func (h *Host) SendMessage(target, message string) error {
+	return h.call(MethodSendMessage, TargetParams{Target: target, Text: message}, nil)
+}
+
+// SendNotice sends a NOTICE to a channel or user
+func (h *Host) SendNotice(target, message string) error {
+	return h.call(MethodSendNotice, TargetParams{Target: target, Text: message}, nil)
+}
+
+// SendRaw sends a raw IRC line
+func (h *Host) SendRaw(line string) error {
+	return h.call(MethodSendRaw, TargetParams{Text: line}, nil)
+}
+
+// ReplyToMessage replies where a message came from
+func (h *Host) ReplyToMessage(m *Message, reply string) error {
+	return h.SendMessage(h.GetReplyTarget(m), reply)
+}
+
+// JoinChannel joins a channel
+func (h *Host) JoinChannel(channel string) error {
+	return h.call(MethodJoinChannel, ChannelParams{Channel: channel}, nil)
+}
+
+// PartChannel leaves a channel
+func (h *Host) PartChannel(channel, reason string) error {
+	return h.call(MethodPartChannel, ChannelParams{Channel: channel, Reason: reason}, nil)
+}
+
+// KickUser kicks a user from a channel
+func (h *Host) KickUser(channel, nick, reason string) error {
+	return h.call(MethodKickUser, ChannelParams{Channel: channel, Nick: nick, Reason: reason}, nil)
+}
+
+// SetMode sets a mode on a channel or user
+func (h *Host) SetMode(target, mode string) error {
+	return h.call(MethodSetMode, ChannelParams{Channel: target, Mode: mode}, nil)
+}
+
+// SetTopic sets the topic of a channel
+func (h *Host) SetTopic(channel, topic string) error {
+	return h.call(MethodSetTopic, ChannelParams{Channel: channel, Topic: topic}, nil)
+}
+
+// GetUserLevel returns the level of a hostmask, one of Ignored to Owner
+func (h *Host) GetUserLevel(hostmask string) (int, error) {
+	var level int
+	err := h.call(MethodGetUserLevel, PermissionParams{Hostmask: hostmask}, &level)
+	return level, err
+}
+
+// CheckUserPermission checks if a hostmask has at least the given level
+func (h *Host) CheckUserPermission(hostmask string, level int) (bool, error) {
+	var ok bool
+	err := h.call(MethodCheckUserPermission, PermissionParams{Hostmask: hostmask, Level: level}, &ok)
+	return ok, err
+}
+
+// GetBotChannels returns the channels the bot is in
+func (h *Host) GetBotChannels() ([]string, error) {
+	var channels []string
+	err := h.call(MethodGetBotChannels, struct{}{}, &channels)
+	return channels, err
+}
+
+// GetChannelUsers returns the members of a channel the bot is in
+func (h *Host) GetChannelUsers(channel string) ([]ChannelUser, error) {
+	var users []ChannelUser
+	err := h.call(MethodGetChannelUsers, ChannelParams{Channel: channel}, &users)
+	return users, err
+}
+
+// GetChannelTopic returns the topic of a channel the bot is in
+func (h *Host) GetChannelTopic(channel string) (string, error) {
+	var topic string
+	err := h.call(MethodGetChannelTopic, ChannelParams{Channel: channel}, &topic)
+	return topic, err
+}
+
+// IsUserInChannel checks if a nick is in a channel the bot is in
+func (h *Host) IsUserInChannel(channel, nick string) (bool, error) {
+	var ok bool
+	err := h.call(MethodIsUserInChannel, ChannelParams{Channel: channel, Nick: nick}, &ok)
+	return ok, err
+}
+
+// IsChannelOp checks if a nick has operator status in a channel
+func (h *Host) IsChannelOp(channel, nick string) (bool, error) {
+	var ok bool
+	err := h.call(MethodIsChannelOp, ChannelParams{Channel: channel, Nick: nick}, &ok)
+	return ok, err
+}
+
+// BotHasOp checks if the bot has operator status in a channel
+func (h *Host) BotHasOp(channel string) (bool, error) {
+	var ok bool
+	err := h.call(MethodBotHasOp, ChannelParams{Channel: channel}, &ok)
+	return ok, err
+}
+
+// HasCapability checks if an IRCv3 capability is enabled on the connection
+func (h *Host) HasCapability(name string) (bool, error) {
+	var ok bool
+	err := h.call(MethodHasCapability, TargetParams{Target: name}, &ok)
+	return ok, err
+}
+
+// GetCapabilities returns the IRCv3 capabilities enabled on the connection
+func (h *Host) GetCapabilities() ([]string, error) {
+	var caps []string
+	err := h.call(MethodGetCapabilities, struct{}{}, &caps)
+	return caps, err
+}
+
+// IsAIAvailable checks if the bot's AI is configured
+func (h *Host) IsAIAvailable() (bool, error) {
+	var ok bool
+	err := h.call(MethodIsAIAvailable, struct{}{}, &ok)
+	return ok, err
+}
+
+// ProcessWithAI sends a message to the AI on behalf of a user
+func (h *Host) ProcessWithAI(message, user string) (string, error) {
+	var reply string
+	err := h.conn.Call(MethodProcessWithAI, AIParams{Text: message, User: user}, &reply, AITimeout)
+	return reply, err
+}
+
+// SummarizeWithAI summarizes content in at most maxLength characters
+func (h *Host) SummarizeWithAI(content string, maxLength int) (string, error) {
+	var summary string
+	err := h.conn.Call(MethodSummarizeWithAI, AIParams{Text: content, MaxLength: maxLength}, &summary, AITimeout)
+	return summary, err
+}
+
+// SavePluginData saves a file in the plugin's data directory
+func (h *Host) SavePluginData(fileName string, data []byte) error {
+	return h.call(MethodSavePluginData, DataParams{File: fileName, Data: data}, nil)
+}
+
+// LoadPluginData reads a file from the plugin's data directory
+func (h *Host) LoadPluginData(fileName string) ([]byte, error) {
+	var data []byte
+	err := h.call(MethodLoadPluginData, DataParams{File: fileName}, &data)
+	return data, err
+}
+
+// Publish sends an event on the bot's event bus. data is encoded as JSON.
+// Plugins can't publish to the "user.", "ai.", "note." and "plugin." topics.
+func (h *Host) Publish(topic string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return h.call(MethodPublish, PublishParams{Topic: topic, Data: raw}, nil)
+}
@@ -0,0 +1,232 @@
+package rpcplugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// shutdownGrace is how long a plugin waits for the bot to close the
+// connection after "shutdown" before exiting anyway
+const shutdownGrace = 2 * time.Second
+
+// Plugin is implemented by every RPC plugin. It mirrors the bot's
+// plugin.Plugin interface, with the bot reached through the Host instead
+// of an *irc.Client.
+type Plugin interface {
+	Name() string
+	Version() string
+	OnLoad(host *Host) error
+	OnMessage(m *Message)
+}
+
+// CommandHandler is implemented by plugins that provide bot commands
+type CommandHandler interface {
+	GetCommands() []string
+	HandleCommand(m *Message, cmd string, args []string)
+}
+
+// Unloader is implemented by plugins that need a cleanup hook
+type Unloader interface {
+	OnUnload() error
+}
+
+// BusEventHandler is implemented by plugins that subscribe to the bot's
+// event bus. Topics returns the topics to subscribe to: a topic, a prefix
+// such as "note.*", or "*".
+type BusEventHandler interface {
+	Topics() []string
+	OnBusEvent(e *BusEvent)
+}
+
+// The event handlers a plugin can implement, one per event type
+type (
+	NickMentionHandler interface{ OnNickMention(m *Message) }
+	KickHandler        interface{ OnKick(m *Message) }
+	TopicChangeHandler interface{ OnTopicChange(m *Message) }
+	JoinHandler        interface{ OnJoin(m *Message) }
+	PartHandler        interface{ OnPart(m *Message) }
+	QuitHandler        interface{ OnQuit(m *Message) }
+	NickHandler        interface{ OnNickChange(m *Message) }
+	InviteHandler      interface{ OnInvite(m *Message) }
+	NoticeHandler      interface{ OnNotice(m *Message) }
+	PrivMsgHandler     interface{ OnPrivMsg(m *Message) }
+	ErrorHandler       interface{ OnError(m *Message) }
+	ModeHandler        interface{ OnMode(m *Message) }
+)
+
+// eventHandler returns the handler of a plugin for an event type
+func eventHandler(p Plugin, event string) (func(*Message), bool) {
+	switch event {
+	case EventMessage:
+		return p.OnMessage, true
+	case EventNickMention:
+		if h, ok := p.(NickMentionHandler); ok {
+			return h.OnNickMention, true
+		}
+	case EventKick:
+		if h, ok := p.(KickHandler); ok {
+			return h.OnKick, true
+		}
+	case EventTopicChange:
+		if h, ok := p.(TopicChangeHandler); ok {
+			return h.OnTopicChange, true
+		}
+	case EventJoin:
+		if h, ok := p.(JoinHandler); ok {
+			return h.OnJoin, true
+		}
+	case EventPart:
+		if h, ok := p.(PartHandler); ok {
+			return h.OnPart, true
+		}
+	case EventQuit:
+		if h, ok := p.(QuitHandler); ok {
+			return h.OnQuit, true
+		}
+	case EventNickChange:
+		if h, ok := p.(NickHandler); ok {
+			return h.OnNickChange, true
+		}
+	case EventInvite:
+		if h, ok := p.(InviteHandler); ok {
+			return h.OnInvite, true
+		}
+	case EventNotice:
+		if h, ok := p.(NoticeHandler); ok {
+			return h.OnNotice, true
+		}
+	case EventPrivMsg:
+		if h, ok := p.(PrivMsgHandler); ok {
+			return h.OnPrivMsg, true
+		}
+	case EventError:
+		if h, ok := p.(ErrorHandler); ok {
+			return h.OnError, true
+		}
+	case EventMode:
+		if h, ok := p.(ModeHandler); ok {
+			return h.OnMode, true
+		}
+	}
+	return nil, false
+}
+
+// allEvents are the event types a plugin can subscribe to
+var allEvents = []string{
+	EventMessage, EventPrivMsg, EventNickMention, EventKick, EventTopicChange,
+	EventJoin, EventPart, EventQuit, EventNickChange, EventInvite, EventNotice,
+	EventError, EventMode,
+}
+
+// Serve runs the plugin over stdin/stdout until the bot shuts it down or
+// closes the connection. It is meant to be the whole body of main:
+//
+//	func main() {
+//		if err := rpcplugin.Serve(&MyPlugin{}); err != nil {
+//			log.Fatal(err)
+//		}
+//	}
+func Serve(p Plugin) error {
+	host := &Host{}
+	var shutdownOnce sync.Once
+	shutdown := make(chan struct{})
+	ready := make(chan struct{})
+
+	handler := func(method string, params json.RawMessage) (interface{}, error) {
+		// Wait until the host can be used for calls back to the bot
+		<-ready
+
+		switch method {
+		case MethodInitialize:
+			var init InitializeParams
+			if err := json.Unmarshal(params, &init); err != nil {
+				return nil, &RPCError{Code: CodeInvalidParams, Message: err.Error()}
+			}
+			if init.ProtocolVersion != ProtocolVersion {
+				return nil, fmt.Errorf("unsupported protocol version %d (plugin speaks %d)", init.ProtocolVersion, ProtocolVersion)
+			}
+			host.setNick(init.BotNick)
+			if err := p.OnLoad(host); err != nil {
+				return nil, err
+			}
+
+			result := InitializeResult{Name: p.Name(), Version: p.Version()}
+			if ch, ok := p.(CommandHandler); ok {
+				result.Commands = ch.GetCommands()
+			}
+			for _, event := range allEvents {
+				if _, ok := eventHandler(p, event); ok {
+					result.Events = append(result.Events, event)
+				}
+			}
+			if bh, ok := p.(BusEventHandler); ok {
+				result.Topics = bh.Topics()
+			}
+			return result, nil
+
+		case MethodEvent:
+			var ev EventParams
+			if err := json.Unmarshal(params, &ev); err != nil || ev.Message == nil {
+				return nil, &RPCError{Code: CodeInvalidParams, Message: "invalid event"}
+			}
+			host.setNick(ev.BotNick)
+			if h, ok := eventHandler(p, ev.Type); ok {
+				h(ev.Message)
+			}
+			return nil, nil
+
+		case MethodBusEvent:
+			var ev BusEvent
+			if err := json.Unmarshal(params, &ev); err != nil {
+				return nil, &RPCError{Code: CodeInvalidParams, Message: "invalid bus event"}
+			}
+			if bh, ok := p.(BusEventHandler); ok {
+				bh.OnBusEvent(&ev)
+			}
+			return nil, nil
+
+		case MethodCommand:
+			var cmd CommandParams
+			if err := json.Unmarshal(params, &cmd); err != nil || cmd.Message == nil {
+				return nil, &RPCError{Code: CodeInvalidParams, Message: "invalid command"}
+			}
+			host.setNick(cmd.BotNick)
+			if ch, ok := p.(CommandHandler); ok {
+				ch.HandleCommand(cmd.Message, cmd.Command, cmd.Args)
+			}
+			return nil, nil
+
+		case MethodShutdown:
+			var err error
+			if u, ok := p.(Unloader); ok {
+				err = u.OnUnload()
+			}
+			shutdownOnce.Do(func() { close(shutdown) })
+			return nil, err
+		}
+		return nil, &RPCError{Code: CodeMethodNotFound, Message: "unknown method " + method}
+	}
+
+	conn := NewConn(os.Stdin, os.Stdout, handler)
+	host.conn = conn
+	close(ready)
+
+	select {
+	case <-shutdown:
+		// The bot closes the connection once it has the answer to "shutdown"
+		select {
+		case <-conn.Done():
+		case <-time.After(shutdownGrace):
+		}
+		return nil
+	case <-conn.Done():
+		if err := conn.Err(); err != nil && err != ErrClosed && err != io.EOF {
+			return fmt.Errorf("connection to bot lost: %w", err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,226 @@
+// Package rpcplugin lets a plugin run as a separate process that talks to the
+// bot over JSON-RPC 2.0 on its stdin/stdout, one JSON object per line.
+//
+// Unlike Go plugins (.so files) an RPC plugin does not have to be built with
+// the bot's exact toolchain and dependency versions, is really gone once it
+// is unloaded, and cannot take the bot down when it panics.
+//
+// The bot sends the plugin an "initialize" request, then "event" and
+// "command" notifications for the IRC events the plugin handles, and finally
+// a "shutdown" request. The plugin calls back into the bot with requests
+// such as "send_message" that mirror the functions of ircbot/pkg/api.
+// Anything a plugin writes to stderr ends up in the bot's log.
+package rpcplugin
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// ProtocolVersion is the version of the protocol described here. It is sent
+// with "initialize" and only changes when messages change incompatibly.
+const ProtocolVersion = 1
+
+// Methods called by the bot on the plugin
+const (
+	MethodInitialize = "initialize"
+	MethodEvent      = "event"
+	MethodCommand    = "command"
+	MethodBusEvent   = "bus_event"
+	MethodShutdown   = "shutdown"
+)
+
+// Methods called by the plugin on the bot
+const (
+	MethodLog                 = "log"
+	MethodSendMessage         = "send_message"
+	MethodSendNotice          = "send_notice"
+	MethodSendRaw             = "send_raw"
+	MethodJoinChannel         = "join_channel"
+	MethodPartChannel         = "part_channel"
+	MethodKickUser            = "kick_user"
+	MethodSetMode             = "set_mode"
+	MethodSetTopic            = "set_topic"
+	MethodGetUserLevel        = "get_user_level"
+	MethodCheckUserPermission = "check_user_permission"
+	MethodGetBotChannels      = "get_bot_channels"
+	MethodGetChannelUsers     = "get_channel_users"
+	MethodGetChannelTopic     = "get_channel_topic"
+	MethodIsUserInChannel     = "is_user_in_channel"
+	MethodIsChannelOp         = "is_channel_op"
+	MethodBotHasOp            = "bot_has_op"
+	MethodHasCapability       = "has_capability"
+	MethodGetCapabilities     = "get_capabilities"
+	MethodIsAIAvailable       = "is_ai_available"
+	MethodProcessWithAI       = "process_with_ai"
+	MethodSummarizeWithAI     = "summarize_with_ai"
+	MethodSavePluginData      = "save_plugin_data"
+	MethodLoadPluginData      = "load_plugin_data"
+	MethodPublish             = "publish"
+)
+
+// Event types, matching the handler interfaces of the bot's plugin package
+const (
+	EventMessage     = "message"
+	EventPrivMsg     = "privmsg"
+	EventNickMention = "nick_mention"
+	EventKick        = "kick"
+	EventTopicChange = "topic_change"
+	EventJoin        = "join"
+	EventPart        = "part"
+	EventQuit        = "quit"
+	EventNickChange  = "nick_change"
+	EventInvite      = "invite"
+	EventNotice      = "notice"
+	EventError       = "error"
+	EventMode        = "mode"
+)
+
+// Prefix is the source of an IRC message
+type Prefix struct {
+	Name string `json:"name"`
+	User string `json:"user,omitempty"`
+	Host string `json:"host,omitempty"`
+}
+
+// String returns the prefix as nick!user@host
+func (p *Prefix) String() string {
+	if p == nil {
+		return ""
+	}
+	s := p.Name
+	if p.User != "" {
+		s += "!" + p.User
+	}
+	if p.Host != "" {
+		s += "@" + p.Host
+	}
+	return s
+}
+
+// Message is an IRC message
+type Message struct {
+	Tags    map[string]string `json:"tags,omitempty"`
+	Prefix  *Prefix           `json:"prefix,omitempty"`
+	Command string            `json:"command"`
+	Params  []string          `json:"params"`
+}
+
+// Trailing returns the last parameter of the message, or ""
+func (m *Message) Trailing() string {
+	if len(m.Params) == 0 {
+		return ""
+	}
+	return m.Params[len(m.Params)-1]
+}
+
+// Nick returns the nick of the sender, or ""
+func (m *Message) Nick() string {
+	if m.Prefix == nil {
+		return ""
+	}
+	return m.Prefix.Name
+}
+
+// IsChannel reports whether the first parameter is a channel name
+func (m *Message) IsChannel() bool {
+	return len(m.Params) > 0 && m.Params[0] != "" && strings.ContainsRune("#&", rune(m.Params[0][0]))
+}
+
+// InitializeParams is sent by the bot with "initialize"
+type InitializeParams struct {
+	ProtocolVersion int    `json:"protocol_version"`
+	BotNick         string `json:"bot_nick"`
+}
+
+// InitializeResult is the plugin's answer to "initialize"
+type InitializeResult struct {
+	Name     string   `json:"name"`
+	Version  string   `json:"version"`
+	Commands []string `json:"commands,omitempty"`
+	// Events lists the event types the plugin wants; "message" is always sent
+	Events []string `json:"events,omitempty"`
+	// Topics lists the event bus topics the plugin subscribes to
+	Topics []string `json:"topics,omitempty"`
+}
+
+// EventParams is sent with "event"
+type EventParams struct {
+	Type    string   `json:"type"`
+	BotNick string   `json:"bot_nick"`
+	Message *Message `json:"message"`
+}
+
+// BusEvent is an event from the bot's event bus, sent with "bus_event"
+type BusEvent struct {
+	Topic string `json:"topic"`
+	// Source is "core" or the name of the plugin that published the event
+	Source string          `json:"source"`
+	Time   time.Time       `json:"time"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// PublishParams is sent with "publish"
+type PublishParams struct {
+	Topic string          `json:"topic"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// CommandParams is sent with "command"
+type CommandParams struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	BotNick string   `json:"bot_nick"`
+	Message *Message `json:"message"`
+}
+
+// LogParams is sent with "log"
+type LogParams struct {
+	// Level is one of "info", "success", "warn", "error" or "debug"
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// TargetParams names a target and the text to send to it
+type TargetParams struct {
+	Target string `json:"target"`
+	Text   string `json:"text,omitempty"`
+}
+
+// ChannelParams names a channel, optionally with a nick, reason or mode
+type ChannelParams struct {
+	Channel string `json:"channel"`
+	Nick    string `json:"nick,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Mode    string `json:"mode,omitempty"`
+	Topic   string `json:"topic,omitempty"`
+}
+
+// PermissionParams asks about a user's level
+type PermissionParams struct {
+	Hostmask string `json:"hostmask"`
+	Level    int    `json:"level,omitempty"`
+}
+
+// ChannelUser is a member of a channel the bot is in
+type ChannelUser struct {
+	Nick string `json:"nick"`
+	// Modes holds the user's status modes in the channel, highest first (e.g. "ov")
+	Modes string `json:"modes,omitempty"`
+	// Prefix is the symbol of the highest status (e.g. "@"), or empty
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// AIParams is sent with the AI methods
+type AIParams struct {
+	Text      string `json:"text"`
+	User      string `json:"user,omitempty"`
+	MaxLength int    `json:"max_length,omitempty"`
+}
+
+// DataParams reads or writes a file in the plugin's data directory
+type DataParams struct {
+	File string `json:"file"`
+	Data []byte `json:"data,omitempty"`
+}
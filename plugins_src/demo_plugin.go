@@ -392,6 +392,23 @@ func (p *DemoPlugin) OnUnload() error {
 	return nil
 }
 
+// Metadata describes the plugin and its commands for the plugin manager
+func (p *DemoPlugin) Metadata() api.PluginMetadata {
+	return api.PluginMetadata{
+		Author:      "MBot",
+		Description: "Demonstrates the capabilities of the MBot Plugin API",
+		APIVersion:  api.APIVersion,
+		Commands: []api.CommandHelp{
+			{
+				Command:     "demo",
+				Description: "Plugin API demos, see !demo help",
+				Usage:       "!demo <help|info|color|format|stats|ai|admin>",
+				Permission:  api.Regular,
+			},
+		},
+	}
+}
+
 // GetCommands returns the supported commands for this plugin
 func (p *DemoPlugin) GetCommands() []string {
 	return []string{"demo"}
@@ -0,0 +1,66 @@
+// Command rpc_greeter is an example out-of-process plugin. Build it with
+//
+//	go build -o plugins/rpc_greeter ./plugins_src/rpc_greeter
+//
+// and load it with !load rpc_greeter.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"ircbot/pkg/rpcplugin"
+)
+
+type GreeterPlugin struct {
+	host *rpcplugin.Host
+}
+
+func (p *GreeterPlugin) Name() string {
+	return "GreeterPlugin"
+}
+
+func (p *GreeterPlugin) Version() string {
+	return "1.0.0"
+}
+
+func (p *GreeterPlugin) OnLoad(host *rpcplugin.Host) error {
+	p.host = host
+	host.LogSuccess("GreeterPlugin version %s loaded!", p.Version())
+	return nil
+}
+
+func (p *GreeterPlugin) OnMessage(m *rpcplugin.Message) {
+}
+
+func (p *GreeterPlugin) OnJoin(m *rpcplugin.Message) {
+	if len(m.Params) == 0 || m.Nick() == p.host.CurrentNick() {
+		return
+	}
+	p.host.SendMessage(m.Params[0], fmt.Sprintf("Welcome to %s, %s!", m.Params[0], m.Nick()))
+}
+
+func (p *GreeterPlugin) GetCommands() []string {
+	return []string{"greet"}
+}
+
+func (p *GreeterPlugin) HandleCommand(m *rpcplugin.Message, cmd string, args []string) {
+	who := m.Nick()
+	if len(args) > 0 {
+		who = strings.Join(args, " ")
+	}
+	p.host.ReplyToMessage(m, fmt.Sprintf("Hello, %s!", who))
+}
+
+func (p *GreeterPlugin) OnUnload() error {
+	p.host.LogSuccess("GreeterPlugin version %s unloaded!", p.Version())
+	return nil
+}
+
+func main() {
+	if err := rpcplugin.Serve(&GreeterPlugin{}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}